@@ -0,0 +1,247 @@
+// Package client 是访问Light Scheduler RESTful API的Go客户端，使用/v1版本的接口。
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// apiVersionPrefix 是客户端使用的API路径前缀
+const apiVersionPrefix = "/v1"
+
+// Config 是访问API Server的客户端配置
+type Config struct {
+	Address    string        // API Server的地址，如http://127.0.0.1:20516
+	Token      string        // 访问token，API Server未启用认证时为空
+	Retries    int           // 请求因网络错误或服务暂时不可用而失败时的重试次数
+	RetryWait  time.Duration // 第一次重试前等待的时间，之后每次加倍，为0时使用1秒
+	HTTPClient *http.Client  // 自定义的HTTP客户端，用于配置TLS和超时等，为nil时使用http.DefaultClient
+}
+
+// Client 是API Server的客户端，可以被多个goroutine同时使用
+type Client struct {
+	base       string
+	token      string
+	retries    int
+	retryWait  time.Duration
+	httpClient *http.Client
+}
+
+// NewClient 根据配置创建客户端
+func NewClient(conf *Config) *Client {
+	c := &Client{
+		base:       strings.TrimRight(conf.Address, "/") + apiVersionPrefix,
+		token:      conf.Token,
+		retries:    conf.Retries,
+		retryWait:  conf.RetryWait,
+		httpClient: conf.HTTPClient,
+	}
+	if c.retryWait <= 0 {
+		c.retryWait = time.Second
+	}
+	if c.httpClient == nil {
+		c.httpClient = http.DefaultClient
+	}
+	return c
+}
+
+// Error 是API Server返回的错误
+type Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+	Details    interface{}
+	RequestID  string
+}
+
+func (e *Error) Error() string {
+	if len(e.RequestID) > 0 {
+		return fmt.Sprintf("%s (%d %s, request %s)", e.Message, e.StatusCode, e.Code, e.RequestID)
+	}
+	return fmt.Sprintf("%s (%d %s)", e.Message, e.StatusCode, e.Code)
+}
+
+// statusOf 返回错误对应的HTTP状态码，不是API Server返回的错误时返回0
+func statusOf(err error) int {
+	if e, ok := err.(*Error); ok {
+		return e.StatusCode
+	}
+	return 0
+}
+
+// IsNotFound 检查错误是否表示请求的资源不存在
+func IsNotFound(err error) bool {
+	return statusOf(err) == http.StatusNotFound
+}
+
+// IsConflict 检查错误是否表示请求与资源的当前状态冲突
+func IsConflict(err error) bool {
+	return statusOf(err) == http.StatusConflict
+}
+
+// decodeError 从错误应答中解析错误信息
+func decodeError(resp *http.Response) error {
+	content, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	e := &Error{StatusCode: resp.StatusCode, RequestID: resp.Header.Get("X-Request-ID")}
+	var body struct {
+		Error *struct {
+			Code      string      `json:"code"`
+			Message   string      `json:"message"`
+			Details   interface{} `json:"details"`
+			RequestID string      `json:"request_id"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(content, &body); err == nil && body.Error != nil {
+		e.Code = body.Error.Code
+		e.Message = body.Error.Message
+		e.Details = body.Error.Details
+		if len(body.Error.RequestID) > 0 {
+			e.RequestID = body.Error.RequestID
+		}
+	} else {
+		e.Code = strings.ReplaceAll(strings.ToLower(http.StatusText(resp.StatusCode)), " ", "_")
+		e.Message = strings.TrimSpace(string(content))
+	}
+	if len(e.Message) == 0 {
+		e.Message = http.StatusText(resp.StatusCode)
+	}
+	return e
+}
+
+// retryable 检查失败的请求能否重试。POST请求可能已经被处理，只在服务端明确拒绝时重试。
+func retryable(method string, resp *http.Response, err error) bool {
+	idempotent := method != http.MethodPost
+	if err != nil {
+		return idempotent
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true
+	case http.StatusBadGateway, http.StatusGatewayTimeout:
+		return idempotent
+	}
+	return false
+}
+
+// request 描述了一次API请求。body不为nil时每次发送(包括重试)都会调用它生成请求内容。
+type request struct {
+	method      string
+	path        string
+	query       url.Values
+	header      http.Header
+	contentType string
+	body        func() (io.Reader, error)
+}
+
+// send 发送请求并在可以重试时按配置重试。应答状态码不小于400时返回*Error，否则由调用者关闭应答。
+func (c *Client) send(ctx context.Context, r *request) (*http.Response, error) {
+	u := c.base + r.path
+	if len(r.query) > 0 {
+		u += "?" + r.query.Encode()
+	}
+	wait := c.retryWait
+	for attempt := 0; ; attempt++ {
+		var body io.Reader
+		if r.body != nil {
+			var err error
+			if body, err = r.body(); err != nil {
+				return nil, err
+			}
+		}
+		req, err := http.NewRequest(r.method, u, body)
+		if err != nil {
+			return nil, err
+		}
+		req = req.WithContext(ctx)
+		for k, v := range r.header {
+			req.Header[k] = v
+		}
+		if len(r.contentType) > 0 {
+			req.Header.Set("Content-Type", r.contentType)
+		}
+		if len(c.token) > 0 {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
+		resp, err := c.httpClient.Do(req)
+		if err == nil && resp.StatusCode < http.StatusBadRequest {
+			return resp, nil
+		}
+		if ctx.Err() != nil {
+			if resp != nil {
+				resp.Body.Close()
+			}
+			return nil, ctx.Err()
+		}
+		if attempt >= c.retries || !retryable(r.method, resp, err) {
+			if err != nil {
+				return nil, err
+			}
+			defer resp.Body.Close()
+			return nil, decodeError(resp)
+		}
+		delay := wait
+		if resp != nil {
+			if sec, e := strconv.Atoi(resp.Header.Get("Retry-After")); e == nil && sec > 0 {
+				delay = time.Duration(sec) * time.Second
+			}
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+		wait *= 2
+	}
+}
+
+// call 以JSON格式发送in并将应答解析到out中。in为nil时不发送请求内容，out为nil时忽略应答内容。
+func (c *Client) call(ctx context.Context, method string, path string, query url.Values, in interface{}, out interface{}) (http.Header, error) {
+	r := &request{method: method, path: path, query: query}
+	if in != nil {
+		content, err := json.Marshal(in)
+		if err != nil {
+			return nil, err
+		}
+		r.contentType = "application/json"
+		r.body = func() (io.Reader, error) {
+			return bytes.NewReader(content), nil
+		}
+	}
+	resp, err := c.send(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return nil, err
+		}
+	}
+	return resp.Header, nil
+}
+
+// ClusterInfo 是集群的基本信息
+type ClusterInfo struct {
+	ID    string `json:"id"`
+	Cycle int64  `json:"cycle"`
+}
+
+// GetCluster 返回集群的基本信息
+func (c *Client) GetCluster(ctx context.Context) (*ClusterInfo, error) {
+	info := &ClusterInfo{}
+	if _, err := c.call(ctx, http.MethodGet, "/cluster", nil, nil, info); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
@@ -0,0 +1,508 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/qianxiaoming/lightsched/message"
+	"github.com/qianxiaoming/lightsched/model"
+	"github.com/qianxiaoming/lightsched/server"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(ioutil.Discard)
+	os.Exit(m.Run())
+}
+
+// testCluster 是在进程内运行的API Server，RESTful API和节点服务分别使用1个测试HTTP服务
+type testCluster struct {
+	client *Client
+	node   *httptest.Server
+	dir    string
+}
+
+func newTestCluster(t *testing.T) *testCluster {
+	dir, err := ioutil.TempDir("", "lightsched-client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := server.NewAPIServerWithConfig(&server.Config{DataPath: filepath.Join(dir, "cluster")})
+	if err := svc.Init(); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	rest := httptest.NewServer(svc.RestHandler())
+	node := httptest.NewServer(svc.NodeHandler())
+	t.Cleanup(func() {
+		rest.Close()
+		node.Close()
+		svc.Close()
+		os.RemoveAll(dir)
+	})
+	return &testCluster{client: NewClient(&Config{Address: rest.URL}), node: node, dir: dir}
+}
+
+// nodePost 以节点的身份调用节点服务，测试服务未启用节点认证
+func (tc *testCluster) nodePost(t *testing.T, path string, contentType string, body []byte) {
+	resp, err := http.Post(tc.node.URL+path, contentType, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("POST %s = %d", path, resp.StatusCode)
+	}
+}
+
+func (tc *testCluster) registerNode(t *testing.T, name string) {
+	reg := &message.RegisterNode{Name: name, Resources: model.ResourceSet{CPU: model.ResourceCPU{Cores: 4}, Memory: 4096}}
+	b, _ := json.Marshal(reg)
+	tc.nodePost(t, "/nodes", "application/json", b)
+}
+
+func testJobSpec(id string, tasks int) *model.JobSpec {
+	group := &model.TaskGroupSpec{Name: "main", Command: "run"}
+	for i := 0; i < tasks; i++ {
+		group.TaskSpecs = append(group.TaskSpecs, &model.TaskSpec{Name: "t", Labels: map[string]string{"shard": string(rune('a' + i))}})
+	}
+	return &model.JobSpec{ID: id, Name: id, GroupSpecs: []*model.TaskGroupSpec{group}}
+}
+
+func TestJobs(t *testing.T) {
+	tc := newTestCluster(t)
+	c, ctx := tc.client, context.Background()
+
+	list, err := c.ListJobs(ctx, nil)
+	if err != nil || len(list.Jobs) != 0 || list.Total != 0 {
+		t.Fatalf("ListJobs on empty cluster = %+v, %v", list, err)
+	}
+
+	input := filepath.Join(tc.dir, "data.txt")
+	if err := ioutil.WriteFile(input, []byte("input"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if id, err := c.CreateJob(ctx, testJobSpec("job1", 2), map[string]string{"data.txt": input}); err != nil || id != "job1" {
+		t.Fatalf("CreateJob with inputs = %q, %v", id, err)
+	}
+	if id, err := c.CreateJob(ctx, testJobSpec("", 1), nil); err != nil || len(id) == 0 {
+		t.Fatalf("CreateJob = %q, %v", id, err)
+	}
+	if _, err := c.CreateJob(ctx, testJobSpec("job1", 1), nil); !IsConflict(err) {
+		t.Errorf("CreateJob with duplicated id: %v", err)
+	}
+
+	list, err = c.ListJobs(ctx, &JobQuery{Limit: 1, Sort: "submit"})
+	if err != nil || len(list.Jobs) != 1 || list.Total != 2 || len(list.Next) == 0 {
+		t.Fatalf("ListJobs first page = %+v, %v", list, err)
+	}
+	list, err = c.ListJobs(ctx, &JobQuery{Limit: 1, Sort: "submit", Cursor: list.Next})
+	if err != nil || len(list.Jobs) != 1 || len(list.Next) != 0 {
+		t.Fatalf("ListJobs second page = %+v, %v", list, err)
+	}
+	list, err = c.ListJobs(ctx, &JobQuery{Name: "job1", States: []model.JobState{model.JobQueued}})
+	if err != nil || len(list.Jobs) != 1 || list.Jobs[0].ID != "job1" {
+		t.Fatalf("ListJobs by name = %+v, %v", list, err)
+	}
+
+	priority := 5
+	if err := c.ModifyJob(ctx, "job1", &model.JobUpdatableProps{Name: "renamed", Priority: &priority}); err != nil {
+		t.Fatalf("ModifyJob: %v", err)
+	}
+	if err := c.HaltJob(ctx, "job1"); err != nil {
+		t.Fatalf("HaltJob: %v", err)
+	}
+	job, err := c.GetJob(ctx, "job1")
+	if err != nil || job.Name != "renamed" || job.Priority != 5 || job.State != model.JobHalted {
+		t.Fatalf("GetJob = %+v, %v", job, err)
+	}
+	if err := c.ResumeJob(ctx, "job1"); err != nil {
+		t.Fatalf("ResumeJob: %v", err)
+	}
+	if _, err := c.GetJobOutput(ctx, "job1"); err != nil {
+		t.Fatalf("GetJobOutput: %v", err)
+	}
+	resp, err := c.BulkJobs(ctx, &message.BulkJobRequest{Action: "priority", Priority: new(int), Selector: message.JobSelector{IDs: []string{"job1"}}})
+	if err != nil || len(resp.Results) != 1 || !resp.Results[0].OK {
+		t.Fatalf("BulkJobs = %+v, %v", resp, err)
+	}
+
+	if err := c.TerminateJob(ctx, "job1"); err != nil {
+		t.Fatalf("TerminateJob: %v", err)
+	}
+	if err := c.RerunJob(ctx, "job1", true); err != nil {
+		t.Fatalf("RerunJob: %v", err)
+	}
+	if err := c.DeleteJob(ctx, "job1"); err != nil {
+		t.Fatalf("DeleteJob: %v", err)
+	}
+	if _, err := c.GetJob(ctx, "job1"); !IsNotFound(err) {
+		t.Errorf("GetJob of deleted job: %v", err)
+	}
+	if _, err := c.GetJob(ctx, "job/../x"); err == nil {
+		t.Error("GetJob with illegal id succeeds")
+	}
+}
+
+func TestTasks(t *testing.T) {
+	tc := newTestCluster(t)
+	c, ctx := tc.client, context.Background()
+	if _, err := c.CreateJob(ctx, testJobSpec("job", 3), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := c.ListTasks(ctx, "job", &TaskQuery{Limit: 2})
+	if err != nil || len(list.Tasks) != 2 || list.Total != 3 || list.Next != "job.0.1" {
+		t.Fatalf("ListTasks first page = %+v, %v", list, err)
+	}
+	list, err = c.ListTasks(ctx, "job", &TaskQuery{Limit: 2, Cursor: list.Next})
+	if err != nil || len(list.Tasks) != 1 || list.Tasks[0].ID != "job.0.2" || len(list.Next) != 0 {
+		t.Fatalf("ListTasks second page = %+v, %v", list, err)
+	}
+	list, err = c.ListTasks(ctx, "job", &TaskQuery{Labels: "shard=b"})
+	if err != nil || len(list.Tasks) != 1 || list.Tasks[0].ID != "job.0.1" {
+		t.Fatalf("ListTasks by label = %+v, %v", list, err)
+	}
+	summary, err := c.SummarizeTasks(ctx, "job", nil)
+	if err != nil || summary.Total != 3 || summary.States["Queued"] != 3 {
+		t.Fatalf("SummarizeTasks = %+v, %v", summary, err)
+	}
+
+	tasks, err := c.GetTasks(ctx, []string{"job.0.2", "job.0.9", "other.0.0"})
+	if err != nil || len(tasks) != 1 || tasks[0].ID != "job.0.2" {
+		t.Fatalf("GetTasks = %+v, %v", tasks, err)
+	}
+	if tasks, err := c.GetTasks(ctx, []string{"other.0.0"}); err != nil || len(tasks) != 0 {
+		t.Fatalf("GetTasks without any existing task = %+v, %v", tasks, err)
+	}
+	if task, err := c.GetTask(ctx, "job.0.1"); err != nil || task.ID != "job.0.1" {
+		t.Fatalf("GetTask = %+v, %v", task, err)
+	}
+	if status, err := c.GetTaskStatus(ctx, "job.0.0"); err != nil || status.State != model.TaskQueued {
+		t.Fatalf("GetTaskStatus = %+v, %v", status, err)
+	}
+	if _, err := c.GetTask(ctx, "job.0.3"); !IsNotFound(err) {
+		t.Errorf("GetTask out of range: %v", err)
+	}
+	if _, err := c.GetTask(ctx, "job.x.0"); statusOf(err) != http.StatusBadRequest {
+		t.Errorf("GetTask with malformed id: %v", err)
+	}
+	if artifacts, err := c.ListTaskArtifacts(ctx, "job.0.0"); err != nil || len(artifacts) != 0 {
+		t.Errorf("ListTaskArtifacts = %+v, %v", artifacts, err)
+	}
+}
+
+func TestQueues(t *testing.T) {
+	tc := newTestCluster(t)
+	c, ctx := tc.client, context.Background()
+	if _, err := c.CreateJob(ctx, testJobSpec("job", 1), nil); err != nil {
+		t.Fatal(err)
+	}
+	queues, err := c.ListQueues(ctx)
+	if err != nil || len(queues) != 1 || queues[0].Name != "default" || !queues[0].Enabled || queues[0].Jobs != 1 {
+		t.Fatalf("ListQueues = %+v, %v", queues, err)
+	}
+	if err := c.EnableQueue(ctx, "default", false); err != nil {
+		t.Fatalf("EnableQueue: %v", err)
+	}
+	if queues, err := c.ListQueues(ctx); err != nil || queues[0].Enabled {
+		t.Fatalf("queue is not disabled: %+v, %v", queues, err)
+	}
+	if err := c.EnableQueue(ctx, "default", true); err != nil {
+		t.Fatalf("EnableQueue: %v", err)
+	}
+	if err := c.EnableQueue(ctx, "missing", true); !IsNotFound(err) {
+		t.Errorf("EnableQueue of missing queue: %v", err)
+	}
+}
+
+func TestNodes(t *testing.T) {
+	tc := newTestCluster(t)
+	c, ctx := tc.client, context.Background()
+	nodes, err := c.ListNodes(ctx)
+	if err != nil || len(nodes) != 0 {
+		t.Fatalf("ListNodes on empty cluster = %+v, %v", nodes, err)
+	}
+
+	tc.registerNode(t, "node1")
+	nodes, err = c.ListNodes(ctx)
+	if err != nil || len(nodes) != 1 || nodes[0].Name != "node1" {
+		t.Fatalf("ListNodes = %+v, %v", nodes, err)
+	}
+	if node, err := c.GetNode(ctx, "node1"); err != nil || node.Resources.CPU.Cores != 4 {
+		t.Fatalf("GetNode = %+v, %v", node, err)
+	}
+	if tasks, err := c.ListNodeTasks(ctx, "node1"); err != nil || len(tasks) != 0 {
+		t.Fatalf("ListNodeTasks = %+v, %v", tasks, err)
+	}
+	if err := c.OfflineNode(ctx, "node1", false); err != nil {
+		t.Fatalf("OfflineNode: %v", err)
+	}
+	if node, err := c.GetNode(ctx, "node1"); err != nil || node.State != model.NodeOffline {
+		t.Fatalf("node after offline = %+v, %v", node, err)
+	}
+	if err := c.OnlineNode(ctx, "node1"); err != nil {
+		t.Fatalf("OnlineNode: %v", err)
+	}
+	if token, err := c.IssueNodeToken(ctx, "node2"); err != nil || len(token) == 0 {
+		t.Fatalf("IssueNodeToken = %q, %v", token, err)
+	}
+	if err := c.RevokeNodeToken(ctx, "node2"); err != nil {
+		t.Fatalf("RevokeNodeToken: %v", err)
+	}
+	if _, err := c.GetNode(ctx, "missing"); !IsNotFound(err) {
+		t.Errorf("GetNode of missing node: %v", err)
+	}
+}
+
+func TestWaitJob(t *testing.T) {
+	tc := newTestCluster(t)
+	c, ctx := tc.client, context.Background()
+	if _, err := c.CreateJob(ctx, testJobSpec("job", 1), nil); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		c.TerminateJob(ctx, "job")
+	}()
+	var states []model.JobState
+	job, err := c.WaitJob(ctx, "job", 10*time.Millisecond, func(job *message.JobInfo) {
+		states = append(states, job.State)
+	})
+	if err != nil || job.State != model.JobTerminated {
+		t.Fatalf("WaitJob = %+v, %v", job, err)
+	}
+	if len(states) != 2 || states[0] != model.JobQueued || states[1] != model.JobTerminated {
+		t.Errorf("states passed to onChange: %v", states)
+	}
+
+	// ctx被取消时返回最后的作业信息
+	if _, err := c.CreateJob(ctx, testJobSpec("pending", 1), nil); err != nil {
+		t.Fatal(err)
+	}
+	timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	job, err = c.WaitJob(timeout, "pending", 10*time.Millisecond, nil)
+	if err != context.DeadlineExceeded || job == nil || job.State != model.JobQueued {
+		t.Errorf("WaitJob with timeout = %+v, %v", job, err)
+	}
+	if _, err := c.WaitJob(ctx, "missing", 10*time.Millisecond, nil); !IsNotFound(err) {
+		t.Errorf("WaitJob of missing job: %v", err)
+	}
+}
+
+// syncBuffer 是可以被多个goroutine同时访问的缓冲区
+type syncBuffer struct {
+	sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.Lock()
+	defer b.Unlock()
+	return b.buf.String()
+}
+
+// waitFor 等待条件满足，超时时测试失败
+func waitFor(t *testing.T, what string, cond func() bool) {
+	for i := 0; !cond(); i++ {
+		if i == 500 {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTailTaskLog(t *testing.T) {
+	tc := newTestCluster(t)
+	c, ctx := tc.client, context.Background()
+	if _, err := c.CreateJob(ctx, testJobSpec("job", 1), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	out := &syncBuffer{}
+	done := make(chan error, 1)
+	go func() {
+		done <- c.TailTaskLog(ctx, "job.0.0", out, 10*time.Millisecond)
+	}()
+	// 不指定offset上传时覆盖整个日志，客户端只输出新增的部分
+	time.Sleep(30 * time.Millisecond)
+	tc.nodePost(t, "/tasks/job.0.0/log", "text/plain", []byte("line 1\n"))
+	waitFor(t, "first line", func() bool { return out.String() == "line 1\n" })
+	tc.nodePost(t, "/tasks/job.0.0/log", "text/plain", []byte("line 1\nline 2\n"))
+	waitFor(t, "second line", func() bool { return out.String() == "line 1\nline 2\n" })
+
+	tc.nodePost(t, "/tasks/job.0.0/log", "text/plain", []byte("line 1\nline 2\nline 3\n"))
+	if err := c.TerminateJob(ctx, "job"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("TailTaskLog: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("TailTaskLog does not return after the job is terminated")
+	}
+	if s := out.String(); s != "line 1\nline 2\nline 3\n" {
+		t.Errorf("tailed log = %q", s)
+	}
+
+	r, err := c.GetTaskLog(ctx, "job.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := ioutil.ReadAll(r)
+	r.Close()
+	if string(content) != "line 1\nline 2\nline 3\n" {
+		t.Errorf("GetTaskLog = %q", content)
+	}
+	if err := c.TailTaskLog(ctx, "job.0.5", out, 10*time.Millisecond); !IsNotFound(err) {
+		t.Errorf("TailTaskLog of missing task: %v", err)
+	}
+}
+
+// runningTask 模拟节点上正在运行的Task，日志分段追加，最后一段日志在结束状态之前上传
+type runningTask struct {
+	sync.Mutex
+	log   string
+	state model.TaskState
+}
+
+func (task *runningTask) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	task.Lock()
+	defer task.Unlock()
+	switch r.URL.Path {
+	case "/v1/tasks/job.0.0":
+		json.NewEncoder(w).Encode(&message.TaskStatus{ID: "job.0.0", State: task.state})
+	case "/v1/jobs/job":
+		json.NewEncoder(w).Encode(&message.JobInfo{ID: "job", State: model.JobExecuting})
+	case "/v1/tasks/job.0.0/log":
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(task.log))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (task *runningTask) update(log string, state model.TaskState) {
+	task.Lock()
+	defer task.Unlock()
+	task.log += log
+	task.state = state
+}
+
+func TestTailRunningTaskLog(t *testing.T) {
+	task := &runningTask{state: model.TaskExecuting}
+	srv := httptest.NewServer(task)
+	defer srv.Close()
+	c := NewClient(&Config{Address: srv.URL})
+
+	out := &syncBuffer{}
+	done := make(chan error, 1)
+	go func() {
+		done <- c.TailTaskLog(context.Background(), "job.0.0", out, 10*time.Millisecond)
+	}()
+	// 运行期间节点还没有上传日志时继续等待
+	time.Sleep(30 * time.Millisecond)
+	task.update("line 1\n", model.TaskExecuting)
+	waitFor(t, "first part", func() bool { return out.String() == "line 1\n" })
+	task.update("line 2\nline 3\n", model.TaskExecuting)
+	waitFor(t, "second part", func() bool { return out.String() == "line 1\nline 2\nline 3\n" })
+	select {
+	case err := <-done:
+		t.Fatalf("TailTaskLog returns while the task is running: %v", err)
+	default:
+	}
+
+	task.update("line 4\n", model.TaskCompleted)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("TailTaskLog: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("TailTaskLog does not return after the task is completed")
+	}
+	if s := out.String(); s != "line 1\nline 2\nline 3\nline 4\n" {
+		t.Errorf("tailed log = %q", s)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	var calls int32
+	failures := []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(atomic.AddInt32(&calls, 1))
+		if strings.HasSuffix(r.URL.Path, "/down") {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/gateway") {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		if n <= len(failures) {
+			w.WriteHeader(failures[n-1])
+			return
+		}
+		if r.Method == http.MethodPost {
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"id":"job"}`))
+			return
+		}
+		w.Write([]byte(`{"id":"cluster","cycle":7}`))
+	}))
+	defer srv.Close()
+	c := NewClient(&Config{Address: srv.URL, Retries: 3, RetryWait: time.Millisecond})
+	ctx := context.Background()
+
+	// 503和429之后重试成功
+	info, err := c.GetCluster(ctx)
+	if err != nil || info.ID != "cluster" || info.Cycle != 7 || atomic.LoadInt32(&calls) != 3 {
+		t.Fatalf("GetCluster = %+v, %v after %d calls", info, err, calls)
+	}
+	// POST请求在服务端明确拒绝时也会重试
+	atomic.StoreInt32(&calls, 0)
+	if id, err := c.CreateJob(ctx, testJobSpec("job", 1), nil); err != nil || id != "job" || atomic.LoadInt32(&calls) != 3 {
+		t.Fatalf("CreateJob = %q, %v after %d calls", id, err, calls)
+	}
+	// 重试次数用完后返回最后的错误
+	atomic.StoreInt32(&calls, 0)
+	if _, err := c.call(ctx, http.MethodGet, "/down", nil, nil, nil); statusOf(err) != http.StatusServiceUnavailable || atomic.LoadInt32(&calls) != 4 {
+		t.Errorf("GET down = %v after %d calls", err, calls)
+	}
+	// 502只对幂等的请求重试
+	atomic.StoreInt32(&calls, 0)
+	if _, err := c.call(ctx, http.MethodPost, "/gateway", nil, nil, nil); statusOf(err) != http.StatusBadGateway || atomic.LoadInt32(&calls) != 1 {
+		t.Errorf("POST gateway = %v after %d calls", err, calls)
+	}
+	atomic.StoreInt32(&calls, 0)
+	if _, err := c.call(ctx, http.MethodGet, "/gateway", nil, nil, nil); statusOf(err) != http.StatusBadGateway || atomic.LoadInt32(&calls) != 4 {
+		t.Errorf("GET gateway = %v after %d calls", err, calls)
+	}
+	// ctx被取消时停止重试
+	atomic.StoreInt32(&calls, 0)
+	slow := NewClient(&Config{Address: srv.URL, Retries: 10, RetryWait: time.Hour})
+	timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err := slow.call(timeout, http.MethodGet, "/down", nil, nil, nil); err != context.DeadlineExceeded || atomic.LoadInt32(&calls) != 1 {
+		t.Errorf("GET down with timeout = %v after %d calls", err, calls)
+	}
+}
@@ -0,0 +1,253 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/qianxiaoming/lightsched/message"
+	"github.com/qianxiaoming/lightsched/model"
+)
+
+// JobQuery 是查询作业列表的条件，零值的字段不作为查询条件
+type JobQuery struct {
+	States     []model.JobState
+	Queue      string
+	Owner      string
+	Name       string
+	Text       string // 在作业的编号和名称中查找的文本
+	Labels     string // 标签选择器，如"team=cv,!debug"
+	SubmitFrom time.Time
+	SubmitTo   time.Time
+	FinishFrom time.Time
+	FinishTo   time.Time
+	Sort       string // submit或state，为空时使用服务端的默认排序
+	Cursor     string // 上一页返回的游标，只在按提交时间排序时有效
+	Offset     int
+	Limit      int
+}
+
+func (q *JobQuery) values() url.Values {
+	v := url.Values{}
+	if q == nil {
+		return v
+	}
+	if len(q.States) > 0 {
+		states := make([]string, 0, len(q.States))
+		for _, s := range q.States {
+			states = append(states, model.JobStateToString(s))
+		}
+		v.Set("state", strings.Join(states, ","))
+	}
+	strs := map[string]string{"queue": q.Queue, "owner": q.Owner, "name": q.Name, "q": q.Text,
+		"labels": q.Labels, "sort": q.Sort, "cursor": q.Cursor}
+	for k, s := range strs {
+		if len(s) > 0 {
+			v.Set(k, s)
+		}
+	}
+	times := map[string]time.Time{"submit_from": q.SubmitFrom, "submit_to": q.SubmitTo,
+		"finish_from": q.FinishFrom, "finish_to": q.FinishTo}
+	for k, t := range times {
+		if !t.IsZero() {
+			v.Set(k, t.Format(time.RFC3339))
+		}
+	}
+	if q.Offset > 0 {
+		v.Set("offset", strconv.Itoa(q.Offset))
+	}
+	if q.Limit > 0 {
+		v.Set("limit", strconv.Itoa(q.Limit))
+	}
+	return v
+}
+
+// JobList 是一页作业查询结果
+type JobList struct {
+	Jobs  []*message.JobInfo
+	Total int    // 满足条件的作业总数
+	Next  string // 下一页的游标，为空时表示没有下一页
+}
+
+// ListJobs 按条件查询作业，query为nil时返回所有作业
+func (c *Client) ListJobs(ctx context.Context, query *JobQuery) (*JobList, error) {
+	list := &JobList{}
+	header, err := c.call(ctx, http.MethodGet, "/jobs", query.values(), nil, &list.Jobs)
+	if err != nil {
+		return nil, err
+	}
+	list.Total, _ = strconv.Atoi(header.Get("X-Total-Count"))
+	list.Next = header.Get("X-Next-Cursor")
+	return list, nil
+}
+
+// GetJob 返回指定的作业
+func (c *Client) GetJob(ctx context.Context, id string) (*message.JobInfo, error) {
+	job := &message.JobInfo{}
+	if _, err := c.call(ctx, http.MethodGet, "/jobs/"+url.PathEscape(id), nil, nil, job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// GetJobOutput 返回作业中所有Task的输出
+func (c *Client) GetJobOutput(ctx context.Context, id string) (*message.JobOutput, error) {
+	output := &message.JobOutput{}
+	if _, err := c.call(ctx, http.MethodGet, "/jobs/"+url.PathEscape(id)+"/outputs", nil, nil, output); err != nil {
+		return nil, err
+	}
+	return output, nil
+}
+
+// CreateJob 提交作业并返回作业编号。inputs是随作业上传的输入文件，键为作业中引用的文件名，值为本地文件路径。
+func (c *Client) CreateJob(ctx context.Context, spec *model.JobSpec, inputs map[string]string) (string, error) {
+	content, err := json.Marshal(spec)
+	if err != nil {
+		return "", err
+	}
+	r := &request{method: http.MethodPost, path: "/jobs"}
+	if len(inputs) == 0 {
+		r.contentType = "application/json"
+		r.body = func() (io.Reader, error) {
+			return bytes.NewReader(content), nil
+		}
+	} else {
+		// 以multipart表单上传作业和输入文件，文件内容在发送时才读取
+		boundary := multipart.NewWriter(nil).Boundary()
+		r.contentType = "multipart/form-data; boundary=" + boundary
+		r.body = func() (io.Reader, error) {
+			reader, writer := io.Pipe()
+			go func() {
+				writer.CloseWithError(writeJobForm(writer, boundary, content, inputs))
+			}()
+			return reader, nil
+		}
+	}
+	resp, err := c.send(ctx, r)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	result := struct {
+		ID string `json:"id"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	return result.ID, nil
+}
+
+// writeJobForm 写入提交作业的multipart表单
+func writeJobForm(w io.Writer, boundary string, job []byte, inputs map[string]string) error {
+	form := multipart.NewWriter(w)
+	if err := form.SetBoundary(boundary); err != nil {
+		return err
+	}
+	if err := form.WriteField("job", string(job)); err != nil {
+		return err
+	}
+	for name, path := range inputs {
+		part, err := form.CreateFormFile("inputs", name)
+		if err != nil {
+			return err
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(part, file)
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return form.Close()
+}
+
+// ModifyJob 修改作业的属性
+func (c *Client) ModifyJob(ctx context.Context, id string, props *model.JobUpdatableProps) error {
+	_, err := c.call(ctx, http.MethodPut, "/jobs/"+url.PathEscape(id), nil, props, nil)
+	return err
+}
+
+// TerminateJob 终止作业
+func (c *Client) TerminateJob(ctx context.Context, id string) error {
+	_, err := c.call(ctx, http.MethodPut, "/jobs/"+url.PathEscape(id)+"/_terminate", nil, nil, nil)
+	return err
+}
+
+// HaltJob 暂停作业，已经开始执行的Task不受影响
+func (c *Client) HaltJob(ctx context.Context, id string) error {
+	_, err := c.call(ctx, http.MethodPut, "/jobs/"+url.PathEscape(id)+"/_halt", nil, nil, nil)
+	return err
+}
+
+// ResumeJob 恢复暂停的作业
+func (c *Client) ResumeJob(ctx context.Context, id string) error {
+	_, err := c.call(ctx, http.MethodPut, "/jobs/"+url.PathEscape(id)+"/_resume", nil, nil, nil)
+	return err
+}
+
+// RerunJob 重新执行已结束的作业，all为false时只重新执行未成功完成的Task
+func (c *Client) RerunJob(ctx context.Context, id string, all bool) error {
+	tasks := "failed"
+	if all {
+		tasks = "all"
+	}
+	_, err := c.call(ctx, http.MethodPut, "/jobs/"+url.PathEscape(id)+"/_rerun", url.Values{"tasks": {tasks}}, nil, nil)
+	return err
+}
+
+// DeleteJob 删除作业
+func (c *Client) DeleteJob(ctx context.Context, id string) error {
+	_, err := c.call(ctx, http.MethodDelete, "/jobs/"+url.PathEscape(id), nil, nil, nil)
+	return err
+}
+
+// BulkJobs 对选择器选中的作业执行批量操作
+func (c *Client) BulkJobs(ctx context.Context, req *message.BulkJobRequest) (*message.BulkJobResponse, error) {
+	result := &message.BulkJobResponse{}
+	if _, err := c.call(ctx, http.MethodPost, "/jobs/_bulk", nil, req, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// IsJobFinished 检查作业是否已经结束
+func IsJobFinished(state model.JobState) bool {
+	return state == model.JobCompleted || state == model.JobFailed || state == model.JobTerminated
+}
+
+// WaitJob 每隔interval查询一次作业，直到作业结束或ctx被取消，返回作业最后的信息。
+// 作业的状态或进度发生变化时调用onChange，onChange可以为nil。查询失败时会按客户端的配置重试。
+func (c *Client) WaitJob(ctx context.Context, id string, interval time.Duration, onChange func(*message.JobInfo)) (*message.JobInfo, error) {
+	var last *message.JobInfo
+	for {
+		job, err := c.GetJob(ctx, id)
+		if err != nil {
+			return last, err
+		}
+		if last == nil || last.State != job.State || last.Progress != job.Progress {
+			if onChange != nil {
+				onChange(job)
+			}
+		}
+		last = job
+		if IsJobFinished(job.State) {
+			return job, nil
+		}
+		select {
+		case <-ctx.Done():
+			return job, ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
@@ -1,6 +1,11 @@
 #include "httputil.h"
 #include <boost/format.hpp>
 
+#if defined(WIN32) || defined(_WINDOWS)
+#pragma comment(lib,"libssl.lib")
+#pragma comment(lib,"libcrypto.lib")
+#endif
+
 namespace lightsched {
 
 HttpClient::HttpClient()
@@ -9,91 +14,95 @@ HttpClient::HttpClient()
 
 HttpClient::~HttpClient()
 {
+    beast::error_code ec;
+    if (ssl_stream) {
+        ssl_stream->shutdown(ec);
+        beast::get_lowest_layer(*ssl_stream).socket().shutdown(tcp::socket::shutdown_both, ec);
+    }
     if (stream) {
-        beast::error_code ec;
         stream->socket().shutdown(tcp::socket::shutdown_both, ec);
     }
 }
 
-bool HttpClient::Connect(const std::string& server, uint16_t port)
+bool HttpClient::Connect(const std::string& server, uint16_t port, bool secure, const std::string& ca_file)
 {
     try {
         tcp::resolver resolver(ioc);
         auto const results = resolver.resolve(server, boost::str(boost::format("%d") % port));
-        stream.reset(new beast::tcp_stream(ioc));
-        stream->connect(results);
+        if (secure) {
+            if (ca_file.empty())
+                ssl_ctx.set_default_verify_paths();
+            else
+                ssl_ctx.load_verify_file(ca_file);
+            ssl_ctx.set_verify_mode(ssl::verify_peer);
+            ssl_stream.reset(new beast::ssl_stream<beast::tcp_stream>(ioc, ssl_ctx));
+            // 设置SNI并校验服务端证书中的主机名
+            if (!SSL_set_tlsext_host_name(ssl_stream->native_handle(), server.c_str())) {
+                beast::error_code ec{ static_cast<int>(::ERR_get_error()), net::error::get_ssl_category() };
+                throw beast::system_error{ ec };
+            }
+            ssl_stream->set_verify_callback(ssl::host_name_verification(server));
+            beast::get_lowest_layer(*ssl_stream).connect(results);
+            ssl_stream->handshake(ssl::stream_base::client);
+        } else {
+            stream.reset(new beast::tcp_stream(ioc));
+            stream->connect(results);
+        }
         server_host = server;
     }
     catch (std::exception const& e) {
         std::cerr << "Error: " << e.what() << std::endl;
         stream.reset();
+        ssl_stream.reset();
         return false;
     }
     return true;
 }
 
-http::status HttpClient::Get(const std::string& target, std::string& response)
+template<class Stream>
+http::status HttpClient::Send(Stream& s, http::request<http::string_body>& req, std::string& response)
 {
-    http::request<http::string_body> req{ http::verb::get, target, 11 };
-    req.set(http::field::host, server_host);
-    req.set(http::field::user_agent, BOOST_BEAST_VERSION_STRING);
-    http::write(*stream, req);
+    http::write(s, req);
 
     beast::flat_buffer buffer;
     http::response<http::string_body> res;
-    http::read(*stream, buffer, res);
+    http::read(s, buffer, res);
     response = res.body();
     return res.result();
 }
 
-http::status HttpClient::Post(const std::string& target, const std::string& body, std::string& response)
+http::status HttpClient::Request(http::verb method, const std::string& target, const std::string& body, std::string& response)
 {
-    http::request<http::string_body> req{ http::verb::post, target, 11 };
+    http::request<http::string_body> req{ method, target, 11 };
     req.set(http::field::host, server_host);
     req.set(http::field::user_agent, BOOST_BEAST_VERSION_STRING);
     if (!body.empty()) {
         req.body() = body;
         req.prepare_payload();
     }
-    http::write(*stream, req);
+    if (ssl_stream)
+        return Send(*ssl_stream, req, response);
+    return Send(*stream, req, response);
+}
 
-    beast::flat_buffer buffer;
-    http::response<http::string_body> res;
-    http::read(*stream, buffer, res);
-    response = res.body();
-    return res.result();
+http::status HttpClient::Get(const std::string& target, std::string& response)
+{
+    return Request(http::verb::get, target, "", response);
 }
 
-http::status HttpClient::Put(const std::string& target, const std::string& body, std::string& response)
+http::status HttpClient::Post(const std::string& target, const std::string& body, std::string& response)
 {
-    http::request<http::string_body> req{ http::verb::put, target, 11 };
-    req.set(http::field::host, server_host);
-    req.set(http::field::user_agent, BOOST_BEAST_VERSION_STRING);
-    if (!body.empty()) {
-        req.body() = body;
-        req.prepare_payload();
-    }
-    http::write(*stream, req);
+    return Request(http::verb::post, target, body, response);
+}
 
-    beast::flat_buffer buffer;
-    http::response<http::string_body> res;
-    http::read(*stream, buffer, res);
-    response = res.body();
-    return res.result();
+http::status HttpClient::Put(const std::string& target, const std::string& body, std::string& response)
+{
+    return Request(http::verb::put, target, body, response);
 }
 
 http::status HttpClient::Delete(const std::string& target, std::string& response)
 {
-    http::request<http::string_body> req{ http::verb::delete_, target, 11 };
-    req.set(http::field::host, server_host);
-    req.set(http::field::user_agent, BOOST_BEAST_VERSION_STRING);
-    http::write(*stream, req);
-
-    beast::flat_buffer buffer;
-    http::response<http::string_body> res;
-    http::read(*stream, buffer, res);
-    response = res.body();
-    return res.result();
+    return Request(http::verb::delete_, target, "", response);
 }
 
 }
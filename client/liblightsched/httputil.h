@@ -2,12 +2,15 @@
 #include <iostream>
 #include <string>
 #include <boost/beast.hpp>
+#include <boost/beast/ssl.hpp>
 #include <boost/asio/connect.hpp>
 #include <boost/asio/ip/tcp.hpp>
+#include <boost/asio/ssl.hpp>
 
 namespace beast = boost::beast;     // from <boost/beast.hpp>
 namespace http = beast::http;       // from <boost/beast/http.hpp>
 namespace net = boost::asio;        // from <boost/asio.hpp>
+namespace ssl = net::ssl;           // from <boost/asio/ssl.hpp>
 using tcp = net::ip::tcp;           // from <boost/asio/ip/tcp.hpp>
 
 namespace lightsched {
@@ -19,9 +22,10 @@ public:
 
 	~HttpClient();
 
-	bool Connect(const std::string& server, uint16_t port);
+	// secure为true时使用https连接，ca_file为空时使用系统默认的CA证书校验服务端
+	bool Connect(const std::string& server, uint16_t port, bool secure = false, const std::string& ca_file = "");
 
-	bool IsConnected() const { return stream.get() != nullptr; }
+	bool IsConnected() const { return stream.get() != nullptr || ssl_stream.get() != nullptr; }
 
 	http::status Get(const std::string& target, std::string& response);
 
@@ -31,10 +35,18 @@ public:
 
 	http::status Delete(const std::string& target, std::string& response);
 
+private:
+	http::status Request(http::verb method, const std::string& target, const std::string& body, std::string& response);
+
+	template<class Stream>
+	http::status Send(Stream& s, http::request<http::string_body>& req, std::string& response);
+
 private:
 	std::string server_host;
 	boost::asio::io_context ioc;
+	ssl::context ssl_ctx{ ssl::context::tls_client };
 	boost::shared_ptr<boost::beast::tcp_stream> stream;
+	boost::shared_ptr<beast::ssl_stream<beast::tcp_stream>> ssl_stream;
 };
 
 }
\ No newline at end of file
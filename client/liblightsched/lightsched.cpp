@@ -71,11 +71,11 @@ NodeState ToNodeState(const char* state)
 	return NodeState::Unknown;
 }
 
-ComputingCluster::ComputingCluster(std::string server, uint16_t port) : server_addr(server), httpclient(nullptr)
+ComputingCluster::ComputingCluster(std::string server, uint16_t port, bool secure, std::string ca_file) : server_addr(server), httpclient(nullptr)
 {
 	try {
 		httpclient = new HttpClient();
-		if (httpclient->Connect(server_addr, port)) {
+		if (httpclient->Connect(server_addr, port, secure, ca_file)) {
 			std::string result;
 			if (httpclient->Get("/cluster", result) == http::status::ok) {
 				neb::CJsonObject json(result);
@@ -307,7 +307,7 @@ JobList ComputingCluster::QueryJobList(JobState* state, int offset, int limits)
 		if (offset > 0)
 			url += boost::str(boost::format("offset=%d&") % offset);
 		if (limits > 0)
-			url += boost::str(boost::format("limits=%d") % limits);
+			url += boost::str(boost::format("limit=%d") % limits);
 		std::string result;
 		if (httpclient->Get(url, result) != http::status::ok)
 			return jobs;
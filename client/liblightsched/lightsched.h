@@ -44,7 +44,8 @@ typedef std::list<NodeInfo> NodeList;
 class LIGHTSCHED_API ComputingCluster
 {
 public:
-	ComputingCluster(std::string server, uint16_t port = APISERVER_PORT);
+	// secure为true时使用https访问API Server，ca_file指定校验服务端证书的CA文件
+	ComputingCluster(std::string server, uint16_t port = APISERVER_PORT, bool secure = false, std::string ca_file = "");
 
 	~ComputingCluster();
 
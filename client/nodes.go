@@ -0,0 +1,85 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/qianxiaoming/lightsched/message"
+)
+
+// ListQueues 返回所有作业队列，按优先级从高到低排列
+func (c *Client) ListQueues(ctx context.Context) ([]*message.JobQueueInfo, error) {
+	var queues []*message.JobQueueInfo
+	if _, err := c.call(ctx, http.MethodGet, "/queues", nil, nil, &queues); err != nil {
+		return nil, err
+	}
+	return queues, nil
+}
+
+// EnableQueue 启用或禁用作业队列
+func (c *Client) EnableQueue(ctx context.Context, name string, enabled bool) error {
+	_, err := c.call(ctx, http.MethodPut, "/queues/"+url.PathEscape(name), url.Values{"enable": {strconv.FormatBool(enabled)}}, nil, nil)
+	return err
+}
+
+// ListNodes 返回所有计算节点
+func (c *Client) ListNodes(ctx context.Context) ([]*message.NodeInfo, error) {
+	var nodes []*message.NodeInfo
+	if _, err := c.call(ctx, http.MethodGet, "/nodes", nil, nil, &nodes); err != nil {
+		return nil, err
+	}
+	return nodes, nil
+}
+
+// GetNode 返回指定的计算节点
+func (c *Client) GetNode(ctx context.Context, name string) (*message.NodeInfo, error) {
+	node := &message.NodeInfo{}
+	if _, err := c.call(ctx, http.MethodGet, "/nodes/"+url.PathEscape(name), nil, nil, node); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+// ListNodeTasks 返回分配到节点上且未结束的Task
+func (c *Client) ListNodeTasks(ctx context.Context, name string) ([]*message.TaskStatus, error) {
+	var tasks []*message.TaskStatus
+	if _, err := c.call(ctx, http.MethodGet, "/nodes/"+url.PathEscape(name)+"/tasks", nil, nil, &tasks); err != nil {
+		return nil, err
+	}
+	return tasks, nil
+}
+
+// OfflineNode 将节点下线，下线的节点不再接受新的Task
+func (c *Client) OfflineNode(ctx context.Context, name string, kill bool) error {
+	var query url.Values
+	if kill {
+		query = url.Values{"kill": {"yes"}}
+	}
+	_, err := c.call(ctx, http.MethodPut, "/nodes/"+url.PathEscape(name)+"/_offline", query, nil, nil)
+	return err
+}
+
+// OnlineNode 将节点上线
+func (c *Client) OnlineNode(ctx context.Context, name string) error {
+	_, err := c.call(ctx, http.MethodPut, "/nodes/"+url.PathEscape(name)+"/_online", nil, nil, nil)
+	return err
+}
+
+// IssueNodeToken 为节点签发加入集群的token
+func (c *Client) IssueNodeToken(ctx context.Context, name string) (string, error) {
+	result := struct {
+		Token string `json:"token"`
+	}{}
+	if _, err := c.call(ctx, http.MethodPost, "/nodes/"+url.PathEscape(name)+"/_token", nil, nil, &result); err != nil {
+		return "", err
+	}
+	return result.Token, nil
+}
+
+// RevokeNodeToken 撤销节点加入集群的token
+func (c *Client) RevokeNodeToken(ctx context.Context, name string) error {
+	_, err := c.call(ctx, http.MethodDelete, "/nodes/"+url.PathEscape(name)+"/_token", nil, nil, nil)
+	return err
+}
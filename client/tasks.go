@@ -0,0 +1,207 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/qianxiaoming/lightsched/message"
+	"github.com/qianxiaoming/lightsched/model"
+)
+
+// TaskQuery 是查询作业中Task的条件，零值的字段不作为查询条件
+type TaskQuery struct {
+	States   []model.TaskState
+	Group    string
+	Node     string
+	ExitCode *int
+	Labels   string // 标签选择器，如"shard=1,!debug"
+	Cursor   string // 上一页返回的游标
+	Limit    int
+}
+
+func (q *TaskQuery) values(jobid string) url.Values {
+	v := url.Values{"jobid": {jobid}}
+	if q == nil {
+		return v
+	}
+	if len(q.States) > 0 {
+		states := make([]string, 0, len(q.States))
+		for _, s := range q.States {
+			states = append(states, model.TaskStateToString(s))
+		}
+		v.Set("state", strings.Join(states, ","))
+	}
+	strs := map[string]string{"group": q.Group, "node": q.Node, "labels": q.Labels, "cursor": q.Cursor}
+	for k, s := range strs {
+		if len(s) > 0 {
+			v.Set(k, s)
+		}
+	}
+	if q.ExitCode != nil {
+		v.Set("exit_code", strconv.Itoa(*q.ExitCode))
+	}
+	if q.Limit > 0 {
+		v.Set("limit", strconv.Itoa(q.Limit))
+	}
+	return v
+}
+
+// TaskList 是一页Task查询结果
+type TaskList struct {
+	Tasks []*message.TaskStatus
+	Total int    // 满足条件的Task总数
+	Next  string // 下一页的游标，为空时表示没有下一页
+}
+
+// ListTasks 按条件查询作业中的Task，query为nil时返回所有Task
+func (c *Client) ListTasks(ctx context.Context, jobid string, query *TaskQuery) (*TaskList, error) {
+	list := &TaskList{}
+	header, err := c.call(ctx, http.MethodGet, "/tasks", query.values(jobid), nil, &list.Tasks)
+	if err != nil {
+		return nil, err
+	}
+	list.Total, _ = strconv.Atoi(header.Get("X-Total-Count"))
+	list.Next = header.Get("X-Next-Cursor")
+	return list, nil
+}
+
+// SummarizeTasks 按状态和任务组统计作业中满足条件的Task数量，query中的游标和分页参数被忽略
+func (c *Client) SummarizeTasks(ctx context.Context, jobid string, query *TaskQuery) (*message.TaskSummary, error) {
+	v := query.values(jobid)
+	v.Set("summary", "true")
+	summary := &message.TaskSummary{}
+	if _, err := c.call(ctx, http.MethodGet, "/tasks", v, nil, summary); err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
+
+// GetTasks 返回多个Task的状态，不存在的Task被忽略
+func (c *Client) GetTasks(ctx context.Context, ids []string) ([]*message.TaskStatus, error) {
+	var tasks []*message.TaskStatus
+	if _, err := c.call(ctx, http.MethodGet, "/tasks", url.Values{"ids": {strings.Join(ids, ",")}}, nil, &tasks); err != nil {
+		return nil, err
+	}
+	return tasks, nil
+}
+
+// GetTask 返回Task的完整信息
+func (c *Client) GetTask(ctx context.Context, id string) (*message.TaskInfo, error) {
+	task := &message.TaskInfo{}
+	if _, err := c.call(ctx, http.MethodGet, "/tasks/"+url.PathEscape(id), nil, nil, task); err != nil {
+		return nil, err
+	}
+	return task, nil
+}
+
+// GetTaskStatus 返回Task的执行状态
+func (c *Client) GetTaskStatus(ctx context.Context, id string) (*message.TaskStatus, error) {
+	status := &message.TaskStatus{}
+	if _, err := c.call(ctx, http.MethodGet, "/tasks/"+url.PathEscape(id), url.Values{"status": {"true"}}, nil, status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// GetTaskLog 返回Task的日志内容，调用者需要关闭返回的ReadCloser
+func (c *Client) GetTaskLog(ctx context.Context, id string) (io.ReadCloser, error) {
+	resp, err := c.send(ctx, &request{method: http.MethodGet, path: "/tasks/" + url.PathEscape(id) + "/log"})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// readTaskLog 将Task日志中从offset开始的内容写入w，返回写入的字节数。日志还未生成或没有新内容时返回0。
+func (c *Client) readTaskLog(ctx context.Context, id string, offset int64, w io.Writer) (int64, error) {
+	r := &request{method: http.MethodGet, path: "/tasks/" + url.PathEscape(id) + "/log"}
+	if offset > 0 {
+		r.header = http.Header{"Range": {fmt.Sprintf("bytes=%d-", offset)}}
+	}
+	resp, err := c.send(ctx, r)
+	if err != nil {
+		if e, ok := err.(*Error); ok && (e.StatusCode == http.StatusNotFound || e.StatusCode == http.StatusRequestedRangeNotSatisfiable) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK && offset > 0 {
+		// 服务端没有按Range返回时跳过已经读取的内容
+		if _, err := io.CopyN(ioutil.Discard, resp.Body, offset); err != nil {
+			return 0, nil
+		}
+	}
+	return io.Copy(w, resp.Body)
+}
+
+// taskFinished 检查Task是否已经结束。作业被终止时未开始执行的Task保持排队状态，此时也认为Task已经结束。
+func (c *Client) taskFinished(ctx context.Context, id string) (bool, error) {
+	status, err := c.GetTaskStatus(ctx, id)
+	if err != nil {
+		return false, err
+	}
+	if model.IsFinishState(status.State) {
+		return true, nil
+	}
+	taskid, err := model.ParseTaskID(id)
+	if err != nil {
+		return false, err
+	}
+	job, err := c.GetJob(ctx, taskid.Job)
+	if err != nil {
+		return false, err
+	}
+	return IsJobFinished(job.State), nil
+}
+
+// TailTaskLog 每隔interval将Task新增的日志写入w，直到Task结束或ctx被取消。节点在Task运行期间定期上传新增的日志，
+// 并在上报结束状态之前上传剩余的日志，因此运行中的日志会有几秒的延迟，Task结束时已写入完整的日志。
+func (c *Client) TailTaskLog(ctx context.Context, id string, w io.Writer, interval time.Duration) error {
+	var offset int64
+	for {
+		// 先查询状态再读取日志，保证Task结束后读取到的是完整日志
+		finished, err := c.taskFinished(ctx, id)
+		if err != nil {
+			return err
+		}
+		n, err := c.readTaskLog(ctx, id, offset, w)
+		offset += n
+		if err != nil {
+			return err
+		}
+		if finished {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// ListTaskArtifacts 返回Task上传的所有结果文件
+func (c *Client) ListTaskArtifacts(ctx context.Context, id string) ([]*message.ArtifactInfo, error) {
+	var artifacts []*message.ArtifactInfo
+	if _, err := c.call(ctx, http.MethodGet, "/tasks/"+url.PathEscape(id)+"/artifacts", nil, nil, &artifacts); err != nil {
+		return nil, err
+	}
+	return artifacts, nil
+}
+
+// GetTaskArtifact 下载Task的结果文件，调用者需要关闭返回的ReadCloser
+func (c *Client) GetTaskArtifact(ctx context.Context, id string, path string) (io.ReadCloser, error) {
+	resp, err := c.send(ctx, &request{method: http.MethodGet, path: "/tasks/" + url.PathEscape(id) + "/artifacts/" + strings.TrimLeft(path, "/")})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
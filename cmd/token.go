@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/qianxiaoming/lightsched/constant"
+	"github.com/qianxiaoming/lightsched/server"
+	"github.com/spf13/cobra"
+)
+
+var (
+	tokenUser  *string
+	tokenRoles *string
+	tokenTTL   *time.Duration
+)
+
+// tokenCmd represents the token command
+var tokenCmd = &cobra.Command{
+	Use:   "token",
+	Short: "Sign an access token",
+	Long: `Sign an access token for the RESTful API of API Server with the HMAC key in the 
+configuration file of API Server.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		path, err := os.Executable()
+		if err != nil {
+			panic(err)
+		}
+		confPath := filepath.Join(filepath.Dir(path), constant.APISeverConfigFile)
+		b, err := ioutil.ReadFile(confPath)
+		if err != nil {
+			fmt.Printf("Unable to read config file %s: %v\n", confPath, err)
+			os.Exit(1)
+		}
+		conf := &server.Config{}
+		if err := json.Unmarshal(b, conf); err != nil {
+			fmt.Printf("Illegal format of the config file %s: %v\n", confPath, err)
+			os.Exit(1)
+		}
+		if len(conf.Auth.HMACKey) == 0 {
+			fmt.Println("No hmac_key configured for API Server")
+			os.Exit(1)
+		}
+		var roles []string
+		if len(*tokenRoles) > 0 {
+			roles = strings.Split(*tokenRoles, ",")
+		}
+		fmt.Println(server.SignToken(conf.Auth.HMACKey, *tokenUser, roles, *tokenTTL))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(tokenCmd)
+
+	tokenUser = tokenCmd.Flags().StringP("user", "u", "", "User name of the token")
+	tokenRoles = tokenCmd.Flags().StringP("roles", "r", "user", "Roles of the user: \"user,admin\"")
+	tokenTTL = tokenCmd.Flags().DurationP("ttl", "t", 0, "Valid duration of the token, 0 means never expire")
+	tokenCmd.MarkFlagRequired("user")
+}
@@ -11,6 +11,8 @@ const (
 	DefaultNodeGPUMemory = 12
 	// DefaultNodeCUDA 是在无法正确获取GPU信息时节点默认的CUDA版本
 	DefaultNodeCUDA = 1020
+	// DefaultMaxArtifactSize 是单个Task结果文件默认的最大字节数
+	DefaultMaxArtifactSize = 1 << 30
 )
 
 const (
@@ -30,4 +32,6 @@ const (
 	PlatformWindows = "Windows"
 	// PlatformLinux 是Linux平台的代号
 	PlatformLinux = "Linux"
+	// NodeNameHeader 是节点请求中携带节点名字的HTTP头
+	NodeNameHeader = "X-Lightsched-Node"
 )
@@ -0,0 +1,67 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/qianxiaoming/lightsched/model"
+	bolt "go.etcd.io/bbolt"
+)
+
+// accountingKey 生成按结束时间排序的记账信息键
+func accountingKey(t time.Time) string {
+	return fmt.Sprintf("%020d", t.UnixNano())
+}
+
+// AppendAccounting 保存一条Task的记账信息
+func (m *StateStore) AppendAccounting(record *model.AccountingRecord) error {
+	_, err := m.boltDB.putJSON("accounting", accountingKey(record.Time)+"-"+record.Task, record)
+	return err
+}
+
+// QueryAccounting 按时间顺序查询在[from, to)时间范围内结束的Task的记账信息，to为零值时不限制结束时间
+func (m *StateStore) QueryAccounting(from time.Time, to time.Time) []*model.AccountingRecord {
+	records := make([]*model.AccountingRecord, 0, 64)
+	m.boltDB.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte("accounting")).Cursor()
+		end := accountingKey(to)
+		for k, v := c.Seek([]byte(accountingKey(from))); k != nil; k, v = c.Next() {
+			if !to.IsZero() && string(k) >= end {
+				break
+			}
+			record := &model.AccountingRecord{}
+			if err := json.Unmarshal(v, record); err == nil {
+				records = append(records, record)
+			}
+		}
+		return nil
+	})
+	return records
+}
+
+// ArchiveJob 保存被清理作业的摘要信息
+func (m *StateStore) ArchiveJob(archive *model.JobArchive) error {
+	_, err := m.boltDB.putJSON("job_archive", accountingKey(archive.FinishTime)+"-"+archive.ID, archive)
+	return err
+}
+
+// QueryJobArchives 按时间顺序查询在[from, to)时间范围内结束的已清理作业，to为零值时不限制结束时间
+func (m *StateStore) QueryJobArchives(from time.Time, to time.Time) []*model.JobArchive {
+	archives := make([]*model.JobArchive, 0, 64)
+	m.boltDB.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte("job_archive")).Cursor()
+		end := accountingKey(to)
+		for k, v := c.Seek([]byte(accountingKey(from))); k != nil; k, v = c.Next() {
+			if !to.IsZero() && string(k) >= end {
+				break
+			}
+			archive := &model.JobArchive{}
+			if err := json.Unmarshal(v, archive); err == nil {
+				archives = append(archives, archive)
+			}
+		}
+		return nil
+	})
+	return archives
+}
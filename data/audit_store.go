@@ -0,0 +1,58 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/qianxiaoming/lightsched/model"
+	"github.com/qianxiaoming/lightsched/util"
+	bolt "go.etcd.io/bbolt"
+)
+
+// auditKey 生成按时间排序的审计记录键
+func auditKey(t time.Time) string {
+	return fmt.Sprintf("%020d", t.UnixNano())
+}
+
+// AppendAudit 追加一条审计记录。审计记录只能追加不能修改。
+func (m *StateStore) AppendAudit(entry *model.AuditEntry) error {
+	_, err := m.boltDB.putJSON("audit", auditKey(entry.Time)+"-"+util.GenerateUUID()[:8], entry)
+	return err
+}
+
+// AppendAudits 在一个数据库事务中追加多条审计记录
+func (m *StateStore) AppendAudits(entries []*model.AuditEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	index := 0
+	return m.boltDB.putBatchJSON("audit", func() (bool, string, interface{}) {
+		entry := entries[index]
+		index++
+		return index == len(entries), auditKey(entry.Time) + "-" + util.GenerateUUID()[:8], entry
+	})
+}
+
+// QueryAudit 按时间顺序查询指定时间之后的审计记录，object为空时返回所有对象的记录。limit小于等于0时不限制数量。
+func (m *StateStore) QueryAudit(object string, since time.Time, limit int) []*model.AuditEntry {
+	entries := make([]*model.AuditEntry, 0, 64)
+	m.boltDB.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte("audit")).Cursor()
+		for k, v := c.Seek([]byte(auditKey(since))); k != nil; k, v = c.Next() {
+			entry := &model.AuditEntry{}
+			if err := json.Unmarshal(v, entry); err != nil {
+				continue
+			}
+			if len(object) > 0 && entry.Object != object {
+				continue
+			}
+			entries = append(entries, entry)
+			if limit > 0 && len(entries) >= limit {
+				break
+			}
+		}
+		return nil
+	})
+	return entries
+}
@@ -5,7 +5,9 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"time"
 
+	"github.com/qianxiaoming/lightsched/metrics"
 	bolt "go.etcd.io/bbolt"
 )
 
@@ -16,8 +18,25 @@ type BoltDB struct {
 
 var errKeyNotExists error = errors.New("key not found")
 
+// WriteLatency 统计数据库写事务的耗时
+var WriteLatency = metrics.NewHistogram("lightsched_bolt_write_seconds", "Latency of bbolt write transactions in seconds.", metrics.DefaultBuckets)
+
+// update 执行写事务并记录耗时
+func (db *BoltDB) update(fn func(*bolt.Tx) error) error {
+	start := time.Now()
+	defer func() { WriteLatency.Observe(time.Since(start).Seconds()) }()
+	return db.Update(fn)
+}
+
+// batch 执行批量写事务并记录耗时
+func (db *BoltDB) batch(fn func(*bolt.Tx) error) error {
+	start := time.Now()
+	defer func() { WriteLatency.Observe(time.Since(start).Seconds()) }()
+	return db.Batch(fn)
+}
+
 func (db *BoltDB) createBucket(name string) error {
-	return db.Update(func(tx *bolt.Tx) error {
+	return db.update(func(tx *bolt.Tx) error {
 		_, err := tx.CreateBucket([]byte(name))
 		if err != nil {
 			return fmt.Errorf("Failed to create bucket in database: %s", err)
@@ -26,8 +45,15 @@ func (db *BoltDB) createBucket(name string) error {
 	})
 }
 
+func (db *BoltDB) ensureBucket(name string) error {
+	return db.update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(name))
+		return err
+	})
+}
+
 func (db *BoltDB) put(bucket string, key string, value []byte) error {
-	return db.Update(func(tx *bolt.Tx) error {
+	return db.update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(bucket))
 		err := b.Put([]byte(key), value)
 		return err
@@ -43,7 +69,7 @@ func (db *BoltDB) putJSON(bucket string, key string, value interface{}) ([]byte,
 }
 
 func (db *BoltDB) putBatchJSON(bucket string, batch func() (bool, string, interface{})) error {
-	err := db.Batch(func(tx *bolt.Tx) error {
+	err := db.batch(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(bucket))
 		for {
 			eof, key, obj := batch()
@@ -100,7 +126,7 @@ func (db *BoltDB) getBucketJSON(bucket string, create func() interface{}, save f
 }
 
 func (db *BoltDB) delete(bucket string, key string) error {
-	return db.Update(func(tx *bolt.Tx) error {
+	return db.update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(bucket))
 		err := b.Delete([]byte(key))
 		return err
@@ -117,7 +143,7 @@ func (db *BoltDB) deletePrefix(bucket string, prefix string) error {
 		}
 		return nil
 	})
-	err := db.Batch(func(tx *bolt.Tx) error {
+	err := db.batch(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(bucket))
 		for _, k := range ids {
 			if err := b.Delete(k); err != nil {
@@ -128,3 +154,21 @@ func (db *BoltDB) deletePrefix(bucket string, prefix string) error {
 	})
 	return err
 }
+
+// copyTo 将所有bucket的内容复制到另一个数据库中
+func (db *BoltDB) copyTo(dst *bolt.DB) error {
+	return db.View(func(src *bolt.Tx) error {
+		return dst.Update(func(tx *bolt.Tx) error {
+			return src.ForEach(func(name []byte, b *bolt.Bucket) error {
+				target, err := tx.CreateBucketIfNotExists(name)
+				if err != nil {
+					return err
+				}
+				target.FillPercent = 1.0
+				return b.ForEach(func(k, v []byte) error {
+					return target.Put(k, v)
+				})
+			})
+		})
+	})
+}
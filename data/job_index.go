@@ -0,0 +1,106 @@
+package data
+
+import (
+	"sort"
+
+	"github.com/qianxiaoming/lightsched/model"
+)
+
+// jobIndex 是Job的二级索引，用于查询时避免遍历所有Job。按队列的索引由JobQueue.Jobs提供。
+type jobIndex struct {
+	byOwner  map[string]map[string]*model.Job
+	byLabel  map[string]map[string]*model.Job  // 键为"key=value"
+	bySubmit []*model.Job                      // 按提交时间和ID升序排列
+	byNode   map[string]map[string]*model.Task // 已分配到节点且未结束的Task
+}
+
+func newJobIndex() *jobIndex {
+	return &jobIndex{
+		byOwner:  make(map[string]map[string]*model.Job),
+		byLabel:  make(map[string]map[string]*model.Job),
+		bySubmit: make([]*model.Job, 0, 128),
+		byNode:   make(map[string]map[string]*model.Task),
+	}
+}
+
+// submitBefore 判断a是否排在b之前
+func submitBefore(a *model.Job, b *model.Job) bool {
+	if a.SubmitTime.Equal(b.SubmitTime) {
+		return a.ID < b.ID
+	}
+	return a.SubmitTime.Before(b.SubmitTime)
+}
+
+func addToSet(index map[string]map[string]*model.Job, key string, job *model.Job) {
+	set, ok := index[key]
+	if !ok {
+		set = make(map[string]*model.Job)
+		index[key] = set
+	}
+	set[job.ID] = job
+}
+
+func removeFromSet(index map[string]map[string]*model.Job, key string, job *model.Job) {
+	if set, ok := index[key]; ok {
+		delete(set, job.ID)
+		if len(set) == 0 {
+			delete(index, key)
+		}
+	}
+}
+
+func (idx *jobIndex) add(job *model.Job) {
+	addToSet(idx.byOwner, job.Owner, job)
+	for k, v := range job.Labels {
+		addToSet(idx.byLabel, k+"="+v, job)
+	}
+	// 新提交的Job通常排在最后
+	i := sort.Search(len(idx.bySubmit), func(i int) bool { return submitBefore(job, idx.bySubmit[i]) })
+	idx.bySubmit = append(idx.bySubmit, nil)
+	copy(idx.bySubmit[i+1:], idx.bySubmit[i:])
+	idx.bySubmit[i] = job
+}
+
+func (idx *jobIndex) remove(job *model.Job) {
+	for _, g := range job.Groups {
+		for _, t := range g.Tasks {
+			idx.release(t)
+		}
+	}
+	removeFromSet(idx.byOwner, job.Owner, job)
+	for k, v := range job.Labels {
+		removeFromSet(idx.byLabel, k+"="+v, job)
+	}
+	i := sort.Search(len(idx.bySubmit), func(i int) bool { return !submitBefore(idx.bySubmit[i], job) })
+	if i < len(idx.bySubmit) && idx.bySubmit[i] == job {
+		idx.bySubmit = append(idx.bySubmit[:i], idx.bySubmit[i+1:]...)
+	}
+}
+
+func (idx *jobIndex) setLabels(job *model.Job, labels map[string]string) {
+	for k, v := range job.Labels {
+		removeFromSet(idx.byLabel, k+"="+v, job)
+	}
+	job.Labels = labels
+	for k, v := range job.Labels {
+		addToSet(idx.byLabel, k+"="+v, job)
+	}
+}
+
+func (idx *jobIndex) place(task *model.Task) {
+	tasks, ok := idx.byNode[task.NodeName]
+	if !ok {
+		tasks = make(map[string]*model.Task)
+		idx.byNode[task.NodeName] = tasks
+	}
+	tasks[task.ID] = task
+}
+
+func (idx *jobIndex) release(task *model.Task) {
+	if tasks, ok := idx.byNode[task.NodeName]; ok {
+		delete(tasks, task.ID)
+		if len(tasks) == 0 {
+			delete(idx.byNode, task.NodeName)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package data
+
+import (
+	"encoding/base64"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/qianxiaoming/lightsched/model"
+)
+
+// JobQuery 是查询Job的条件。各条件同时满足时Job才被选中，零值表示不限制。
+type JobQuery struct {
+	States     []model.JobState
+	Queue      string
+	Owner      string
+	Name       string              // 名字包含的子串，不区分大小写
+	Text       string              // 在ID、名字、所有者和标签中搜索的子串，不区分大小写
+	Labels     model.LabelSelector // 标签选择器
+	SubmitFrom time.Time
+	SubmitTo   time.Time
+	FinishFrom time.Time
+	FinishTo   time.Time
+	SortBy     model.JobSortField
+	Cursor     string // 上一页返回的游标，按提交时间排序时使用
+	Offset     int
+	Limit      int // 小于等于0时不限制数量
+}
+
+// JobQueryResult 是查询Job的结果
+type JobQueryResult struct {
+	Jobs  []*model.Job
+	Total int    // 满足条件的Job总数
+	Next  string // 下一页的游标，没有更多结果时为空
+}
+
+var errIllegalCursor = errors.New("illegal cursor")
+
+// encodeCursor 将Job在提交时间排序中的位置编码为游标
+func encodeCursor(job *model.Job) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(job.SubmitTime.UnixNano(), 10) + ":" + job.ID))
+}
+
+// decodeCursor 将游标解码为用于比较的Job
+func decodeCursor(cursor string) (*model.Job, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, errIllegalCursor
+	}
+	parts := strings.SplitN(string(b), ":", 2)
+	if len(parts) != 2 {
+		return nil, errIllegalCursor
+	}
+	nanos, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, errIllegalCursor
+	}
+	return &model.Job{ID: parts[1], SubmitTime: time.Unix(0, nanos)}, nil
+}
+
+// candidates 根据索引确定需要检查的Job，返回的集合越小越好。sorted表示结果已按提交时间升序排列。
+func (m *StateStore) candidates(q *JobQuery) (jobs []*model.Job, sorted bool) {
+	var best map[string]*model.Job
+	pick := func(set map[string]*model.Job) {
+		if best == nil || len(set) < len(best) {
+			best = set
+		}
+	}
+	found := true
+	if len(q.Owner) > 0 {
+		set, ok := m.index.byOwner[q.Owner]
+		found = found && ok
+		pick(set)
+	}
+	for k, v := range q.Labels.Equalities() {
+		set, ok := m.index.byLabel[k+"="+v]
+		found = found && ok
+		pick(set)
+	}
+	if !found {
+		return nil, true
+	}
+	if len(q.Queue) > 0 {
+		queue := m.GetJobQueue(q.Queue)
+		if queue == nil {
+			return nil, true
+		}
+		if best == nil || len(queue.Jobs) < len(best) {
+			return queue.Jobs, false
+		}
+	}
+	if best != nil {
+		jobs = make([]*model.Job, 0, len(best))
+		for _, job := range best {
+			jobs = append(jobs, job)
+		}
+		return jobs, false
+	}
+	// 没有可用的索引时按提交时间范围截取
+	jobs = m.index.bySubmit
+	if !q.SubmitFrom.IsZero() {
+		i := sort.Search(len(jobs), func(i int) bool { return !jobs[i].SubmitTime.Before(q.SubmitFrom) })
+		jobs = jobs[i:]
+	}
+	if !q.SubmitTo.IsZero() {
+		i := sort.Search(len(jobs), func(i int) bool { return !jobs[i].SubmitTime.Before(q.SubmitTo) })
+		jobs = jobs[:i]
+	}
+	return jobs, true
+}
+
+// matches 判断Job是否满足查询条件
+func (q *JobQuery) matches(job *model.Job) bool {
+	if len(q.States) > 0 {
+		found := false
+		for _, s := range q.States {
+			if job.State == s {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if (len(q.Queue) > 0 && job.Queue != q.Queue) || (len(q.Owner) > 0 && job.Owner != q.Owner) {
+		return false
+	}
+	if len(q.Name) > 0 && !strings.Contains(strings.ToLower(job.Name), strings.ToLower(q.Name)) {
+		return false
+	}
+	if len(q.Text) > 0 && !jobContainsText(job, strings.ToLower(q.Text)) {
+		return false
+	}
+	if !q.Labels.Matches(job.Labels) {
+		return false
+	}
+	if (!q.SubmitFrom.IsZero() && job.SubmitTime.Before(q.SubmitFrom)) || (!q.SubmitTo.IsZero() && !job.SubmitTime.Before(q.SubmitTo)) {
+		return false
+	}
+	if !q.FinishFrom.IsZero() || !q.FinishTo.IsZero() {
+		if job.FinishTime.IsZero() {
+			return false
+		}
+		if (!q.FinishFrom.IsZero() && job.FinishTime.Before(q.FinishFrom)) || (!q.FinishTo.IsZero() && !job.FinishTime.Before(q.FinishTo)) {
+			return false
+		}
+	}
+	return true
+}
+
+func jobContainsText(job *model.Job, text string) bool {
+	if strings.Contains(strings.ToLower(job.ID), text) || strings.Contains(strings.ToLower(job.Name), text) || strings.Contains(strings.ToLower(job.Owner), text) {
+		return true
+	}
+	for k, v := range job.Labels {
+		if strings.Contains(strings.ToLower(k), text) || strings.Contains(strings.ToLower(v), text) {
+			return true
+		}
+	}
+	return false
+}
+
+// FindJobs 按条件查询Job。按提交时间排序时从新到旧返回，并支持游标分页，新提交的Job不会影响后续页的内容。
+func (m *StateStore) FindJobs(q *JobQuery) (*JobQueryResult, error) {
+	var after *model.Job
+	if len(q.Cursor) > 0 {
+		var err error
+		if after, err = decodeCursor(q.Cursor); err != nil {
+			return nil, err
+		}
+		q.SortBy = model.SortJobBySubmit
+	}
+
+	candidates, sorted := m.candidates(q)
+	jobs := make([]*model.Job, 0, 16)
+	for _, job := range candidates {
+		if q.matches(job) {
+			jobs = append(jobs, job)
+		}
+	}
+	result := &JobQueryResult{Total: len(jobs)}
+	if q.SortBy == model.SortJobBySubmit {
+		if sorted {
+			// 索引中的Job为升序，翻转为从新到旧
+			for i, j := 0, len(jobs)-1; i < j; i, j = i+1, j-1 {
+				jobs[i], jobs[j] = jobs[j], jobs[i]
+			}
+		} else {
+			sort.Slice(jobs, func(i, j int) bool { return submitBefore(jobs[j], jobs[i]) })
+		}
+	} else {
+		sort.Sort(&model.GeneralJobSorter{Jobs: jobs, SortBy: q.SortBy})
+	}
+
+	start := 0
+	if after != nil {
+		start = sort.Search(len(jobs), func(i int) bool { return submitBefore(jobs[i], after) })
+	} else if q.Offset > 0 {
+		start = q.Offset
+	}
+	if start > len(jobs) {
+		start = len(jobs)
+	}
+	jobs = jobs[start:]
+	if q.Limit > 0 && len(jobs) > q.Limit {
+		jobs = jobs[:q.Limit]
+		if q.SortBy == model.SortJobBySubmit {
+			result.Next = encodeCursor(jobs[len(jobs)-1])
+		}
+	}
+	result.Jobs = jobs
+	return result, nil
+}
@@ -22,6 +22,7 @@ type NodePeriodic struct {
 	cpu        float64
 	mem        float64
 	executings int
+	scratch    int64
 }
 
 // NodeBucket 保存要发给节点的消息。多个节点可能会共享同一个NodeBucket。
@@ -90,8 +91,8 @@ func (cache *NodeCache) AddNode(node *model.WorkNode) {
 	}
 }
 
-// AppendNodeMessage 给指定的节点增加一个消息
-func (cache *NodeCache) AppendNodeMessage(name string, kind string, object string, content []byte) {
+// AppendNodeMessage 给指定的节点增加一个消息，返回因此不再发送给节点的调度任务编号
+func (cache *NodeCache) AppendNodeMessage(name string, kind string, object string, content []byte) []string {
 	index := int(sha1.Sum([]byte(name))[0]) % NodeBucketCount
 	cache.buckets[index].Lock()
 	defer cache.buckets[index].Unlock()
@@ -105,6 +106,7 @@ func (cache *NodeCache) AppendNodeMessage(name string, kind string, object strin
 		Content: content,
 	}
 	// 需要根据新消息过滤同一个节点上的其它消息
+	var dropped []string
 	filterMsg := false
 	for _, old := range msgs {
 		if !message.Filter(msg, old) {
@@ -126,16 +128,18 @@ func (cache *NodeCache) AppendNodeMessage(name string, kind string, object strin
 						log.Println("Give back resources of this task")
 						cache.nodeMap[task.NodeName].Available.GiveBack(task.Resources)
 					}
+					dropped = append(dropped, old.Object)
 				}
 			}
 		}
 		msgs = news
 	}
 	cache.buckets[index].messages[name] = append(msgs, msg)
+	return dropped
 }
 
 // PeriodicUpdate 获取指定节点的消息，然后清空它的消息列表。返回false表示未发现该节点的注册信息。
-func (cache *NodeCache) PeriodicUpdate(name string, cpu float64, mem float64, execs int) ([]*message.JSON, bool) {
+func (cache *NodeCache) PeriodicUpdate(name string, cpu float64, mem float64, execs int, scratch int64) ([]*message.JSON, bool) {
 	index := int(sha1.Sum([]byte(name))[0]) % NodeBucketCount
 	cache.buckets[index].Lock()
 	defer cache.buckets[index].Unlock()
@@ -149,6 +153,7 @@ func (cache *NodeCache) PeriodicUpdate(name string, cpu float64, mem float64, ex
 			cpu:        cpu,
 			mem:        mem,
 			executings: execs,
+			scratch:    scratch,
 		}
 		cache.buckets[index].periodics[name] = update
 		log.Printf("Heartbeat for node \"%s\" received for the first time: CPU usage = %.2f, Memory usage = %.2f", name, cpu, mem)
@@ -157,6 +162,7 @@ func (cache *NodeCache) PeriodicUpdate(name string, cpu float64, mem float64, ex
 		update.cpu = cpu
 		update.mem = mem
 		update.executings = execs
+		update.scratch = scratch
 	}
 	// 获取要发送给节点的消息
 	if msgs, ok := cache.buckets[index].messages[name]; ok {
@@ -166,6 +172,17 @@ func (cache *NodeCache) PeriodicUpdate(name string, cpu float64, mem float64, ex
 	return nil, true
 }
 
+// GetScratchUsage 返回节点最近上报的任务临时目录磁盘占用
+func (cache *NodeCache) GetScratchUsage(name string) int64 {
+	index := int(sha1.Sum([]byte(name))[0]) % NodeBucketCount
+	cache.buckets[index].Lock()
+	defer cache.buckets[index].Unlock()
+	if update, ok := cache.buckets[index].periodics[name]; ok {
+		return update.scratch
+	}
+	return 0
+}
+
 // CheckTimeoutNodes 返回所有更新时间超时的节点
 func (cache *NodeCache) CheckTimeoutNodes(seconds int) map[string]*model.WorkNode {
 	now := time.Now()
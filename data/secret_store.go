@@ -0,0 +1,38 @@
+package data
+
+import (
+	"github.com/qianxiaoming/lightsched/model"
+)
+
+// PutSecret 保存密钥，同名的密钥将被替换
+func (m *StateStore) PutSecret(secret *model.Secret) error {
+	_, err := m.boltDB.putJSON("secret", secret.Name, secret)
+	return err
+}
+
+// GetSecret 获取指定名字的密钥，不存在时返回nil
+func (m *StateStore) GetSecret(name string) *model.Secret {
+	secret := &model.Secret{}
+	if ok, err := m.boltDB.getJSON("secret", name, secret); !ok || err != nil {
+		return nil
+	}
+	return secret
+}
+
+// GetSecrets 获取所有的密钥
+func (m *StateStore) GetSecrets() []*model.Secret {
+	secrets := make([]*model.Secret, 0, 8)
+	m.boltDB.getBucketJSON("secret", func() interface{} {
+		return &model.Secret{}
+	}, func(v interface{}) {
+		if secret, ok := v.(*model.Secret); ok {
+			secrets = append(secrets, secret)
+		}
+	})
+	return secrets
+}
+
+// DeleteSecret 删除指定名字的密钥
+func (m *StateStore) DeleteSecret(name string) error {
+	return m.boltDB.delete("secret", name)
+}
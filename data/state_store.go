@@ -1,6 +1,7 @@
 package data
 
 import (
+	"bytes"
 	"fmt"
 	"log"
 	"os"
@@ -21,7 +22,11 @@ var (
 	// queue: 作业队列信息
 	// job: 所有Job信息（包含已经完成的）
 	// task: 所有计算任务信息。计算任务的唯一标识包含所属Job的标识，使用:分隔（便于前缀遍历）
-	DatabaseBuckets = [4]string{"config", "queue", "job", "task"}
+	// secret: 加密保存的密钥
+	// audit: 修改操作的审计记录，键以时间开头以便按时间范围遍历
+	// accounting: Task结束时的记账信息，键以结束时间开头
+	// job_archive: 被自动清理的作业摘要，键以结束时间开头
+	DatabaseBuckets = [8]string{"config", "queue", "job", "task", "secret", "audit", "accounting", "job_archive"}
 )
 
 // StateStore 是API Server的内部状态数据
@@ -32,6 +37,7 @@ type StateStore struct {
 	jobQueues map[string]*model.JobQueue
 	jobMap    map[string]*model.Job
 	jobList   []*model.Job
+	index     *jobIndex
 }
 
 // NewStateStore 创建服务的内部状态数据对象
@@ -40,6 +46,7 @@ func NewStateStore() *StateStore {
 		jobQueues: make(map[string]*model.JobQueue, 1),
 		jobMap:    make(map[string]*model.Job, 128),
 		jobList:   make([]*model.Job, 0, 128),
+		index:     newJobIndex(),
 	}
 }
 
@@ -56,6 +63,42 @@ func (m *StateStore) InitState(path string) error {
 	return nil
 }
 
+// Compact 将数据库复制到新文件以回收已删除数据占用的空间，然后用新文件替换原文件
+func (m *StateStore) Compact() error {
+	tmpPath := m.dbPath + ".compact"
+	os.Remove(tmpPath)
+	dst, err := bolt.Open(tmpPath, 0600, &bolt.Options{Timeout: 3 * time.Second})
+	if err != nil {
+		return err
+	}
+	if err := m.boltDB.copyTo(dst); err != nil {
+		dst.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	before, _ := os.Stat(m.dbPath)
+	if err := m.boltDB.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, m.dbPath); err != nil {
+		log.Printf("Unable to replace database file with the compacted one: %v\n", err)
+		os.Remove(tmpPath)
+	}
+	db, err := bolt.Open(m.dbPath, 0600, &bolt.Options{Timeout: 3 * time.Second})
+	if err != nil {
+		return err
+	}
+	m.boltDB = &BoltDB{db}
+	if after, err := os.Stat(m.dbPath); err == nil && before != nil {
+		log.Printf("Database file compacted from %d to %d bytes", before.Size(), after.Size())
+	}
+	return nil
+}
+
 func (m *StateStore) ClearState() {
 	if m.boltDB != nil {
 		log.Println("Close database file")
@@ -100,6 +143,13 @@ func (m *StateStore) loadFromDatabase() error {
 	}
 	m.boltDB = &BoltDB{db}
 
+	// 旧版本创建的数据库文件中可能缺少新增的bucket
+	for _, name := range DatabaseBuckets {
+		if err := m.boltDB.ensureBucket(name); err != nil {
+			return err
+		}
+	}
+
 	// 加载所有作业队列信息
 	if err := m.boltDB.getBucketJSON("queue", func() interface{} {
 		return &model.JobQueue{}
@@ -126,6 +176,7 @@ func (m *StateStore) loadFromDatabase() error {
 	m.jobList = make([]*model.Job, 0, len(m.jobMap))
 	for _, v := range m.jobMap {
 		m.jobList = append(m.jobList, v)
+		m.index.add(v)
 	}
 	sort.Sort(&model.GeneralJobSorter{Jobs: m.jobList})
 	log.Printf("%d job(s) loaded", len(m.jobList))
@@ -135,9 +186,16 @@ func (m *StateStore) loadFromDatabase() error {
 		return &model.Task{}
 	}, func(v interface{}) {
 		if task, ok := v.(*model.Task); ok {
-			j, g, t := model.ParseTaskID(task.ID)
-			if job, ok := m.jobMap[j]; ok {
-				job.Groups[g].Tasks[t] = task
+			id, err := model.ParseTaskID(task.ID)
+			if err != nil {
+				log.Printf("Ignore task with illegal id \"%s\"\n", task.ID)
+				return
+			}
+			if job, ok := m.jobMap[id.Job]; ok && id.Group < len(job.Groups) && id.Index < len(job.Groups[id.Group].Tasks) {
+				job.Groups[id.Group].Tasks[id.Index] = task
+				if len(task.NodeName) > 0 && !model.IsFinishState(task.State) && task.State != model.TaskQueued {
+					m.index.place(task)
+				}
 			}
 		}
 	}); err != nil {
@@ -156,6 +214,22 @@ func (m *StateStore) GetJobQueue(name string) *model.JobQueue {
 	return nil
 }
 
+// GetJobQueues 返回所有作业队列，按优先级从高到低排列
+func (m *StateStore) GetJobQueues() []*model.JobQueue {
+	queues := make([]*model.JobQueue, 0, len(m.jobQueues))
+	for _, v := range m.jobQueues {
+		queues = append(queues, v)
+	}
+	sort.Sort(model.JobQueueSlice(queues))
+	return queues
+}
+
+// SaveJobQueue 保存作业队列的属性
+func (m *StateStore) SaveJobQueue(queue *model.JobQueue) error {
+	_, err := m.boltDB.putJSON("queue", queue.Name, queue)
+	return err
+}
+
 func (m *StateStore) GetSchedulableQueues() []*model.JobQueue {
 	queues := make([]*model.JobQueue, 0, len(m.jobQueues))
 	for _, v := range m.jobQueues {
@@ -190,50 +264,115 @@ func (m *StateStore) DeleteJob(id string) error {
 	if job.State == model.JobExecuting || job.State == model.JobHalted {
 		return fmt.Errorf("Cannot delete executing or halted jobs")
 	}
-	delete(m.jobMap, id)
+	m.RemoveJob(job)
+	if err := m.boltDB.delete("job", id); err != nil {
+		log.Printf("Failed to delete job in database: %v", err)
+	}
+	// 正确的ID中不会含有.
+	if err := m.boltDB.deletePrefix("task", id+"."); err != nil {
+		log.Printf("Failed to delete task for job %s in database: %v", id, err)
+	}
+
+	return nil
+}
+
+// RemoveJob 从内存中移除Job，不修改数据库
+func (m *StateStore) RemoveJob(job *model.Job) {
+	m.index.remove(job)
+	delete(m.jobMap, job.ID)
 	for i, j := range m.jobList {
-		if j.ID == id {
+		if j.ID == job.ID {
 			m.jobList = append(m.jobList[:i], m.jobList[i+1:]...)
 			break
 		}
 	}
 	if queue, ok := m.jobQueues[job.Queue]; ok {
 		for i, j := range queue.Jobs {
-			if j.ID == id {
+			if j.ID == job.ID {
 				queue.Jobs = append(queue.Jobs[:i], queue.Jobs[i+1:]...)
 				break
 			}
 		}
 	}
-	if err := m.boltDB.delete("job", id); err != nil {
-		log.Printf("Failed to delete job in database: %v", err)
-	}
-	// 正确的ID中不会含有.
-	if err := m.boltDB.deletePrefix("task", id+"."); err != nil {
-		log.Printf("Failed to delete task for job %s in database: %v", id, err)
-	}
-
-	return nil
 }
 
-func (m *StateStore) QueryJobs(filterState *model.JobState, sortField model.JobSortField, offset, limits int) []*model.Job {
-	jobs := make([]*model.Job, 0, 16)
-	for _, j := range m.jobList {
-		if filterState == nil || j.State == *filterState {
-			jobs = append(jobs, j)
-		}
+// MoveJob 将Job移动到另一个作业队列，不修改数据库
+func (m *StateStore) MoveJob(job *model.Job, queueName string) error {
+	target := m.GetJobQueue(queueName)
+	if target == nil {
+		return fmt.Errorf("Invalid queue name \"%s\"", queueName)
 	}
-	sort.Sort(&model.GeneralJobSorter{Jobs: jobs, SortBy: sortField})
-	if offset == 0 && limits == -1 {
-		return jobs
+	if job.Queue == queueName {
+		return nil
 	}
-	if offset+limits > len(jobs) {
-		limits = len(jobs) - offset
+	if queue, ok := m.jobQueues[job.Queue]; ok {
+		for i, j := range queue.Jobs {
+			if j.ID == job.ID {
+				queue.Jobs = append(queue.Jobs[:i], queue.Jobs[i+1:]...)
+				break
+			}
+		}
 	}
-	if offset == 0 && limits == len(jobs) {
-		return jobs
+	target.Jobs = append(target.Jobs, job)
+	job.Queue = queueName
+	return nil
+}
+
+// SaveJobs 在一个批量事务中保存修改的Job，并删除已移除的Job及其Task
+func (m *StateStore) SaveJobs(updated []*model.Job, deleted []string) error {
+	if len(updated) == 0 && len(deleted) == 0 {
+		return nil
 	}
-	return jobs[offset : offset+limits]
+	return m.boltDB.batch(func(tx *bolt.Tx) error {
+		jobs := tx.Bucket([]byte("job"))
+		for _, job := range updated {
+			if err := jobs.Put([]byte(job.ID), job.GetJSON(true)); err != nil {
+				return err
+			}
+		}
+		tasks := tx.Bucket([]byte("task"))
+		for _, id := range deleted {
+			if err := jobs.Delete([]byte(id)); err != nil {
+				return err
+			}
+			// 正确的ID中不会含有.
+			prefix := []byte(id + ".")
+			c := tasks.Cursor()
+			for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Seek(prefix) {
+				if err := tasks.Delete(k); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// PlaceTask 记录Task被分配到的节点
+func (m *StateStore) PlaceTask(task *model.Task, node string) {
+	m.index.release(task)
+	task.NodeName = node
+	m.index.place(task)
+}
+
+// ReleaseTask 将Task从所在节点的记录中移除，Task的NodeName保持不变
+func (m *StateStore) ReleaseTask(task *model.Task) {
+	m.index.release(task)
+}
+
+// GetNodeTasks 返回分配到指定节点且未结束的Task，按ID排序
+func (m *StateStore) GetNodeTasks(node string) []*model.Task {
+	tasks := make([]*model.Task, 0, len(m.index.byNode[node]))
+	for _, t := range m.index.byNode[node] {
+		tasks = append(tasks, t)
+	}
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].ID < tasks[j].ID })
+	return tasks
+}
+
+// SetJobLabels 修改Job的标签并更新索引，不修改数据库
+func (m *StateStore) SetJobLabels(job *model.Job, labels map[string]string) {
+	m.index.setLabels(job, labels)
 }
 
 func (m *StateStore) AddJob(job *model.Job) error {
@@ -260,6 +399,7 @@ func (m *StateStore) AddJob(job *model.Job) error {
 	queue.Jobs = append(queue.Jobs, job)
 	m.jobMap[job.ID] = job
 	m.jobList = append(m.jobList, job)
+	m.index.add(job)
 
 	log.Printf("Job \"%s\"(%s) with %d task(s) has beed added to queue \"%s\"", job.Name, job.ID, job.CountTasks(), job.Queue)
 	return nil
@@ -308,23 +448,43 @@ func (m *StateStore) SaveTasks(tasks []*model.Task) error {
 	return nil
 }
 
-func (m *StateStore) UpdateTaskStatus(id string, state model.TaskState, progress int, exit int, err string) *model.Task {
-	jobid, gindex, tindex := model.ParseTaskID(id)
+func (m *StateStore) UpdateTaskStatus(id string, state model.TaskState, progress int, exit int, err string, output *model.TaskOutput, usage *model.TaskUsage) *model.Task {
+	taskid, e := model.ParseTaskID(id)
+	if e != nil {
+		log.Printf("Illegal task id \"%s\" found while updating task status\n", id)
+		return nil
+	}
+	jobid := taskid.Job
 	if job, ok := m.jobMap[jobid]; !ok {
 		log.Printf("No job identified by \"%s\" found while updating task status\n", jobid)
 		return nil
 	} else {
-		task := job.Groups[gindex].Tasks[tindex]
+		task := job.GetTask(taskid)
+		if task == nil {
+			log.Printf("No task identified by \"%s\" found while updating task status\n", id)
+			return nil
+		}
 		last := task.State
 		task.State = state
 		task.Progress = progress
 		task.ExitCode = exit
 		if len(task.Error) == 0 {
 			task.Error = err
-		} else {
+		} else if len(err) > 0 {
 			task.Error = fmt.Sprintf("%s\n%s", task.Error, err)
 		}
-		if task.State == model.TaskExecuting {
+		if output != nil {
+			// 节点每次上报的都是完整的输出内容，直接替换即可
+			task.Output = output
+		}
+		if usage != nil {
+			// 节点上报的是累计值和峰值，直接替换即可
+			task.Usage = usage
+		}
+		if model.IsFinishState(state) {
+			m.index.release(task)
+		}
+		if task.State == model.TaskExecuting && last != model.TaskExecuting {
 			task.StartTime = time.Now()
 		} else if model.IsFinishState(state) {
 			task.FinishTime = time.Now()
@@ -336,6 +496,9 @@ func (m *StateStore) UpdateTaskStatus(id string, state model.TaskState, progress
 			// 仅在Task的状态发生变化时才保存
 			m.boltDB.putJSON("task", task.ID, task)
 			if model.IsFinishState(task.State) {
+				if err := m.AppendAccounting(model.NewAccountingRecord(job, task)); err != nil {
+					log.Printf("Failed to save accounting record of task %s: %v\n", task.ID, err)
+				}
 				log.Printf("  Task %s is reported as \"%s\" with exit code %d by node %s: %s", id, model.TaskStateToString(task.State), exit, task.NodeName, err)
 			} else {
 				log.Printf("  Task %s is reported as \"%s\" by node %s", id, model.TaskStateToString(task.State), task.NodeName)
@@ -350,3 +513,39 @@ func (m *StateStore) UpdateTaskStatus(id string, state model.TaskState, progress
 		return task
 	}
 }
+
+// GetTask 获取指定的Task，不存在时返回nil
+func (m *StateStore) GetTask(id string) *model.Task {
+	taskid, err := model.ParseTaskID(id)
+	if err != nil {
+		return nil
+	}
+	if job, ok := m.jobMap[taskid.Job]; ok {
+		return job.GetTask(taskid)
+	}
+	return nil
+}
+
+func nodeTokenKey(name string) string {
+	return "node_token:" + name
+}
+
+// SetNodeToken 保存管理员为节点签发的加入token的哈希值
+func (m *StateStore) SetNodeToken(name string, hash string) error {
+	_, err := m.boltDB.putJSON("config", nodeTokenKey(name), hash)
+	return err
+}
+
+// GetNodeToken 返回节点加入token的哈希值，未签发时返回空字符串
+func (m *StateStore) GetNodeToken(name string) string {
+	var hash string
+	if ok, err := m.boltDB.getJSON("config", nodeTokenKey(name), &hash); !ok || err != nil {
+		return ""
+	}
+	return hash
+}
+
+// DeleteNodeToken 撤销为节点签发的加入token
+func (m *StateStore) DeleteNodeToken(name string) error {
+	return m.boltDB.delete("config", nodeTokenKey(name))
+}
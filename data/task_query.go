@@ -0,0 +1,86 @@
+package data
+
+import (
+	"fmt"
+
+	"github.com/qianxiaoming/lightsched/model"
+)
+
+// TaskQuery 是查询作业中Task的条件。各条件同时满足时Task才被选中，零值表示不限制。
+type TaskQuery struct {
+	States   []model.TaskState
+	Group    string // 任务组的名字或编号
+	Node     string
+	ExitCode *int
+	Labels   model.LabelSelector
+	Cursor   string // 上一页最后一个Task的ID
+	Limit    int    // 小于等于0时不限制数量
+}
+
+// TaskQueryResult 是查询Task的结果
+type TaskQueryResult struct {
+	Tasks []*model.Task
+	Total int    // 满足条件的Task总数
+	Next  string // 下一页的游标，没有更多结果时为空
+}
+
+func (q *TaskQuery) matches(group *model.TaskGroup, task *model.Task) bool {
+	if len(q.States) > 0 {
+		found := false
+		for _, s := range q.States {
+			if task.State == s {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(q.Group) > 0 && group.Name != q.Group && group.ID != q.Group {
+		return false
+	}
+	if len(q.Node) > 0 && task.NodeName != q.Node {
+		return false
+	}
+	if q.ExitCode != nil && (!model.IsFinishState(task.State) || task.ExitCode != *q.ExitCode) {
+		return false
+	}
+	return q.Labels.Matches(task.Labels)
+}
+
+// FindJobTasks 按条件查询作业中的Task，结果按任务组和Task的编号排序。游标为Task的ID，因此分页结果是稳定的。
+func (m *StateStore) FindJobTasks(jobid string, q *TaskQuery) (*TaskQueryResult, error) {
+	job, ok := m.jobMap[jobid]
+	if !ok {
+		return nil, nil
+	}
+	cursorGroup, cursorTask := -1, -1
+	if len(q.Cursor) > 0 {
+		id, err := model.ParseTaskID(q.Cursor)
+		if err != nil || id.Job != jobid {
+			return nil, fmt.Errorf("illegal cursor: %s", q.Cursor)
+		}
+		cursorGroup, cursorTask = id.Group, id.Index
+	}
+	result := &TaskQueryResult{Tasks: make([]*model.Task, 0, 64)}
+	for g, group := range job.Groups {
+		for t, task := range group.Tasks {
+			if !q.matches(group, task) {
+				continue
+			}
+			result.Total++
+			if g < cursorGroup || (g == cursorGroup && t <= cursorTask) {
+				continue
+			}
+			if q.Limit > 0 && len(result.Tasks) >= q.Limit {
+				if len(result.Next) == 0 {
+					result.Next = result.Tasks[len(result.Tasks)-1].ID
+				}
+				continue
+			}
+			result.Tasks = append(result.Tasks, task)
+		}
+	}
+	return result, nil
+}
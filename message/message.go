@@ -24,7 +24,7 @@ type JSON struct {
 // Filter 判断消息是否可以共同发送
 func Filter(msg *JSON, other *JSON) bool {
 	if msg.Kind == KindTerminateJob && other.Kind == KindScheduleTask {
-		return !strings.HasPrefix(other.Object, msg.Object)
+		return !strings.HasPrefix(other.Object, msg.Object+".")
 	}
 	return true
 }
@@ -34,16 +34,21 @@ type RegisterNode struct {
 	Name      string             `json:"name"`
 	Platform  model.PlatformInfo `json:"platform"`
 	Labels    map[string]string  `json:"labels,omitempty"`
+	Runtimes  []string           `json:"runtimes,omitempty"`
+	Images    []string           `json:"images,omitempty"`
+	RunAs     []string           `json:"run_as,omitempty"` // 节点允许任务使用的执行用户，不支持指定执行用户的节点为空
 	Resources model.ResourceSet  `json:"resources"`
 }
 
 // TaskReport 是由节点上报的任务执行状态
 type TaskReport struct {
-	ID       string          `json:"id"`
-	State    model.TaskState `json:"state"`
-	Progress int             `json:"progress"`
-	ExitCode int             `json:"exit_code"`
-	Error    string          `json:"error,omitempty"`
+	ID       string            `json:"id"`
+	State    model.TaskState   `json:"state"`
+	Progress int               `json:"progress"`
+	ExitCode int               `json:"exit_code"`
+	Error    string            `json:"error,omitempty"`
+	Output   *model.TaskOutput `json:"output,omitempty"`
+	Usage    *model.TaskUsage  `json:"usage,omitempty"`
 }
 
 // Heartbeat 节点心跳信息
@@ -52,6 +57,7 @@ type Heartbeat struct {
 	CPU        float64       `json:"cpu"`
 	Memory     float64       `json:"memory"`
 	Executings int           `json:"executings"`
+	Scratch    int64         `json:"scratch"` // 任务临时目录占用的磁盘字节数
 	Payload    []*TaskReport `json:"payload,omitempty"`
 }
 
@@ -64,6 +70,8 @@ type JobInfo struct {
 	Labels     map[string]string `json:"labels,omitempty"`
 	Taints     map[string]string `json:"taints,omitempty"`
 	MaxErrors  int               `json:"max_errors"`
+	RunAs      string            `json:"run_as,omitempty"`
+	Owner      string            `json:"owner,omitempty"`
 	Groups     []string          `json:"groups"`
 	SubmitTime string            `json:"submit_time"`
 	ExecTime   string            `json:"exec_time,omitempty"`
@@ -83,6 +91,8 @@ func NewJobInfo(job *model.Job) *JobInfo {
 		Labels:     job.Labels,
 		Taints:     job.Taints,
 		MaxErrors:  job.MaxErrors,
+		RunAs:      job.RunAs,
+		Owner:      job.Owner,
 		Groups:     make([]string, 0, len(job.Groups)),
 		SubmitTime: job.SubmitTime.Local().Format("2006-01-02 15:04:05"),
 		ExecTime:   "",
@@ -112,29 +122,45 @@ type NodeInfo struct {
 	Online    string             `json:"online"`
 	Labels    map[string]string  `json:"labels,omitempty"`
 	Taints    map[string]string  `json:"taints,omitempty"`
+	Runtimes  []string           `json:"runtimes,omitempty"`
+	Images    []string           `json:"images,omitempty"`
+	RunAs     []string           `json:"run_as,omitempty"`
 	Resources *model.ResourceSet `json:"resources,omitempty"`
 	Reserved  *model.ResourceSet `json:"reserved,omitempty"`
 	Available *model.ResourceSet `json:"available,omitempty"`
+	Scratch   int64              `json:"scratch"`
 }
 
 // TaskInfo 返回给客户端的计算任务信息
 type TaskInfo struct {
 	ID         string             `json:"id"`
 	Name       string             `json:"name"`
+	Group      string             `json:"group,omitempty"`
+	Image      string             `json:"image,omitempty"`
+	Runtime    string             `json:"runtime,omitempty"`
 	Envs       []string           `json:"envs,omitempty"`
+	Secrets    map[string]string  `json:"secrets,omitempty"`
+	EnvMode    string             `json:"env_mode,omitempty"`
+	RunAs      string             `json:"run_as,omitempty"`
 	Command    string             `json:"command,omitempty"`
 	Args       string             `json:"args,omitempty"`
+	Argv       []string           `json:"argv,omitempty"`
+	Shell      bool               `json:"shell,omitempty"`
 	WorkDir    string             `json:"workdir,omitempty"`
 	Labels     map[string]string  `json:"labels,omitempty"`
 	Taints     map[string]string  `json:"taints,omitempty"`
+	Outputs    []string           `json:"outputs,omitempty"`
 	Resources  *model.ResourceSet `json:"resources,omitempty"`
 	State      model.TaskState    `json:"state"`
 	NodeName   string             `json:"node,omitempty"`
+	Reason     string             `json:"reason,omitempty"`
 	Progress   int                `json:"progress"`
 	ExitCode   int                `json:"exit_code"`
 	Error      string             `json:"error,omitempty"`
 	StartTime  string             `json:"start_time,omitempty"`
 	FinishTime string             `json:"finish_time,omitempty"`
+	Output     *model.TaskOutput  `json:"output,omitempty"`
+	Usage      *model.TaskUsage   `json:"usage,omitempty"`
 }
 
 // NewTaskInfo 根据Task创建对应的信息体
@@ -142,15 +168,25 @@ func NewTaskInfo(task *model.Task) *TaskInfo {
 	info := &TaskInfo{
 		ID:         task.ID,
 		Name:       task.Name,
+		Group:      task.Group,
+		Image:      task.Image,
+		Runtime:    task.Runtime,
 		Envs:       task.Envs,
+		Secrets:    task.Secrets,
+		EnvMode:    task.EnvMode,
+		RunAs:      task.RunAs,
 		Command:    task.Command,
 		Args:       task.Args,
+		Argv:       task.Argv,
+		Shell:      task.Shell,
 		WorkDir:    task.WorkDir,
 		Labels:     util.CloneMap(task.Labels),
 		Taints:     util.CloneMap(task.Taints),
+		Outputs:    task.Outputs,
 		Resources:  task.Resources.Clone(),
 		State:      task.State,
 		NodeName:   task.NodeName,
+		Reason:     task.Reason,
 		Progress:   task.Progress,
 		ExitCode:   task.ExitCode,
 		Error:      task.Error,
@@ -163,6 +199,10 @@ func NewTaskInfo(task *model.Task) *TaskInfo {
 	if !task.FinishTime.IsZero() {
 		info.FinishTime = task.FinishTime.Local().Format("2006-01-02 15:04:05")
 	}
+	if task.Output != nil {
+		info.Output = task.Output.Clone()
+	}
+	info.Usage = task.Usage.Clone()
 	return info
 }
 
@@ -173,6 +213,7 @@ type TaskStatus struct {
 	Labels     map[string]string `json:"labels,omitempty"`
 	State      model.TaskState   `json:"state"`
 	NodeName   string            `json:"node,omitempty"`
+	Reason     string            `json:"reason,omitempty"`
 	Progress   int               `json:"progress"`
 	ExitCode   int               `json:"exit_code"`
 	Error      string            `json:"error,omitempty"`
@@ -188,6 +229,7 @@ func NewTaskStatus(task *model.Task) *TaskStatus {
 		Labels:     task.Labels,
 		State:      task.State,
 		NodeName:   task.NodeName,
+		Reason:     task.Reason,
 		Progress:   task.Progress,
 		ExitCode:   task.ExitCode,
 		Error:      task.Error,
@@ -203,5 +245,180 @@ func NewTaskStatus(task *model.Task) *TaskStatus {
 	return info
 }
 
+// ArtifactInfo 是任务上传的结果文件信息
+type ArtifactInfo struct {
+	Path    string `json:"path"`
+	Size    int64  `json:"size"`
+	ModTime string `json:"mod_time"`
+}
+
+// MetricSummary 是Job中同名指标在所有Task上的统计值
+type MetricSummary struct {
+	Count int     `json:"count"`
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Sum   float64 `json:"sum"`
+	Mean  float64 `json:"mean"`
+}
+
+// JobOutput 汇总了Job中所有Task上报的结构化输出
+type JobOutput struct {
+	ID      string                       `json:"id"`
+	Metrics map[string]*MetricSummary    `json:"metrics,omitempty"`
+	Tasks   map[string]*model.TaskOutput `json:"tasks,omitempty"`
+}
+
+// NewJobOutput 根据Job中各Task的输出创建汇总信息体
+func NewJobOutput(job *model.Job) *JobOutput {
+	info := &JobOutput{
+		ID:      job.ID,
+		Metrics: make(map[string]*MetricSummary),
+		Tasks:   make(map[string]*model.TaskOutput),
+	}
+	for _, g := range job.Groups {
+		for _, t := range g.Tasks {
+			if t.Output == nil {
+				continue
+			}
+			info.Tasks[t.ID] = t.Output.Clone()
+			for k, v := range t.Output.Metrics {
+				if m, ok := info.Metrics[k]; !ok {
+					info.Metrics[k] = &MetricSummary{Count: 1, Min: v, Max: v, Sum: v}
+				} else {
+					m.Count++
+					m.Sum += v
+					if v < m.Min {
+						m.Min = v
+					}
+					if v > m.Max {
+						m.Max = v
+					}
+				}
+			}
+		}
+	}
+	for _, m := range info.Metrics {
+		m.Mean = m.Sum / float64(m.Count)
+	}
+	return info
+}
+
 // JobQueueInfo 返回给客户端的计算作业队列信息
-type JobQueueInfo model.JobQueue
+type JobQueueInfo struct {
+	Name     string `json:"name"`
+	Enabled  bool   `json:"enabled"`
+	Priority int    `json:"priority"`
+	Jobs     int    `json:"jobs"` // 队列中未结束的作业数
+}
+
+// SecretInfo 返回给客户端的密钥信息，不包含密钥的值
+type SecretInfo struct {
+	Name       string `json:"name"`
+	Owner      string `json:"owner,omitempty"`
+	CreateTime string `json:"create_time"`
+}
+
+// AuditInfo 返回给客户端的审计记录
+type AuditInfo struct {
+	Time     string                 `json:"time"`
+	User     string                 `json:"user,omitempty"`
+	ClientIP string                 `json:"client_ip"`
+	Method   string                 `json:"method"`
+	Endpoint string                 `json:"endpoint"`
+	Kind     string                 `json:"kind"`
+	Object   string                 `json:"object,omitempty"`
+	Status   int                    `json:"status"`
+	Before   map[string]interface{} `json:"before,omitempty"`
+	After    map[string]interface{} `json:"after,omitempty"`
+}
+
+// NewAuditInfo 根据审计记录创建对应的信息体
+func NewAuditInfo(entry *model.AuditEntry) *AuditInfo {
+	return &AuditInfo{
+		Time:     entry.Time.Local().Format("2006-01-02 15:04:05.000"),
+		User:     entry.User,
+		ClientIP: entry.ClientIP,
+		Method:   entry.Method,
+		Endpoint: entry.Endpoint,
+		Kind:     entry.Kind,
+		Object:   entry.Object,
+		Status:   entry.Status,
+		Before:   entry.Before,
+		After:    entry.After,
+	}
+}
+
+// UsageInfo 是按用户、队列或节点汇总的资源使用量
+type UsageInfo struct {
+	Key           string  `json:"key"`
+	Tasks         int     `json:"tasks"`
+	Completed     int     `json:"completed"`
+	Failed        int     `json:"failed"`
+	Terminated    int     `json:"terminated"`
+	WallSeconds   float64 `json:"wall_seconds"`
+	CPUSeconds    float64 `json:"cpu_seconds"`
+	GPUSeconds    float64 `json:"gpu_seconds"`
+	MemorySeconds float64 `json:"memory_seconds"`
+}
+
+// JobSelector 是批量操作选择作业的条件，多个条件同时满足时才选中
+type JobSelector struct {
+	IDs             []string `json:"ids,omitempty"`
+	State           string   `json:"state,omitempty"`
+	Queue           string   `json:"queue,omitempty"`
+	Labels          string   `json:"labels,omitempty"`           // 标签选择器，如"team=cv,!debug"
+	SubmittedBefore string   `json:"submitted_before,omitempty"` // 提交时间早于该时间
+	SubmittedAfter  string   `json:"submitted_after,omitempty"`  // 提交时间晚于该时间
+}
+
+// BulkJobRequest 是批量操作作业的请求
+type BulkJobRequest struct {
+	Selector JobSelector `json:"selector"`
+	Action   string      `json:"action"`             // terminate, halt, resume, delete, priority, queue
+	Priority *int        `json:"priority,omitempty"` // action为priority时设置的优先级
+	Queue    string      `json:"queue,omitempty"`    // action为queue时移动到的队列
+	DryRun   bool        `json:"dry_run,omitempty"`  // 只返回会被影响的作业而不执行操作
+}
+
+// BulkJobResult 是批量操作中单个作业的执行结果
+type BulkJobResult struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	State string `json:"state"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// BulkJobResponse 是批量操作作业的应答
+type BulkJobResponse struct {
+	DryRun  bool             `json:"dry_run"`
+	Action  string           `json:"action"`
+	Results []*BulkJobResult `json:"results"`
+}
+
+// ActionResult 是修改或删除资源成功时的应答
+type ActionResult struct {
+	Kind   string `json:"kind"`   // 资源类型：job、queue、node或secret
+	ID     string `json:"id"`     // 资源的编号或名称
+	Action string `json:"action"` // 执行的操作
+}
+
+// TaskSummary 是按状态和任务组统计的Task数量
+type TaskSummary struct {
+	Total  int                       `json:"total"`
+	States map[string]int            `json:"states"`
+	Groups map[string]map[string]int `json:"groups"`
+}
+
+// ErrorInfo 是RESTful API返回的错误信息
+type ErrorInfo struct {
+	Code      string      `json:"code"`              // 由HTTP状态生成的错误码，如not_found、conflict
+	Message   string      `json:"message"`           // 错误描述
+	Details   interface{} `json:"details,omitempty"` // 与错误相关的附加信息
+	RequestID string      `json:"request_id,omitempty"`
+}
+
+// ErrorResponse 是/v1接口出错时的应答
+type ErrorResponse struct {
+	Error *ErrorInfo `json:"error"`
+}
@@ -0,0 +1,270 @@
+// Package metrics 实现了简单的指标统计，并以Prometheus文本格式输出
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Collector 是可以输出指标数据的对象
+type Collector interface {
+	Collect(w *Writer)
+}
+
+// Registry 保存所有注册的指标
+type Registry struct {
+	sync.Mutex
+	collectors []Collector
+}
+
+// NewRegistry 创建新的指标注册表
+func NewRegistry() *Registry {
+	return &Registry{collectors: make([]Collector, 0, 16)}
+}
+
+// Register 注册指标
+func (r *Registry) Register(collectors ...Collector) {
+	r.Lock()
+	defer r.Unlock()
+	r.collectors = append(r.collectors, collectors...)
+}
+
+// Write 以Prometheus文本格式输出所有指标
+func (r *Registry) Write(out io.Writer) error {
+	r.Lock()
+	collectors := append([]Collector(nil), r.collectors...)
+	r.Unlock()
+	w := &Writer{out: bufio.NewWriter(out)}
+	for _, c := range collectors {
+		c.Collect(w)
+	}
+	return w.out.Flush()
+}
+
+// ServeHTTP 实现http.Handler接口，应答Prometheus的抓取请求
+func (r *Registry) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.Write(rw)
+}
+
+// Writer 负责将指标数据写为Prometheus文本格式
+type Writer struct {
+	out *bufio.Writer
+}
+
+// Family 输出指标的说明和类型
+func (w *Writer) Family(name string, help string, kind string) {
+	fmt.Fprintf(w.out, "# HELP %s %s\n# TYPE %s %s\n", name, strings.ReplaceAll(help, "\n", " "), name, kind)
+}
+
+// Sample 输出一个指标值，labels为标签名和标签值交替排列的列表
+func (w *Writer) Sample(name string, labels []string, value float64) {
+	w.out.WriteString(name)
+	if len(labels) > 0 {
+		w.out.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				w.out.WriteByte(',')
+			}
+			w.out.WriteString(labels[i])
+			w.out.WriteString(`="`)
+			w.out.WriteString(escapeLabel(labels[i+1]))
+			w.out.WriteByte('"')
+		}
+		w.out.WriteByte('}')
+	}
+	w.out.WriteByte(' ')
+	w.out.WriteString(formatValue(value))
+	w.out.WriteByte('\n')
+}
+
+func escapeLabel(v string) string {
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	v = strings.ReplaceAll(v, "\n", `\n`)
+	return strings.ReplaceAll(v, `"`, `\"`)
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// pairLabels 将标签名和标签值合并为交替排列的列表
+func pairLabels(names []string, values []string) []string {
+	labels := make([]string, 0, len(names)*2+2)
+	for i, name := range names {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		labels = append(labels, name, value)
+	}
+	return labels
+}
+
+// seriesKey 返回标签值组合的键
+func seriesKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+// sortedKeys 返回按字典序排列的键，使输出的顺序固定
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Counter 是只增不减的计数器，可以按标签区分多个计数
+type Counter struct {
+	sync.Mutex
+	name   string
+	help   string
+	labels []string
+	series map[string][]string
+	values map[string]float64
+}
+
+// NewCounter 创建计数器
+func NewCounter(name string, help string, labels ...string) *Counter {
+	return &Counter{
+		name:   name,
+		help:   help,
+		labels: labels,
+		series: make(map[string][]string),
+		values: make(map[string]float64),
+	}
+}
+
+// Add 为指定标签值的计数增加v
+func (c *Counter) Add(v float64, labelValues ...string) {
+	key := seriesKey(labelValues)
+	c.Lock()
+	defer c.Unlock()
+	if _, ok := c.series[key]; !ok {
+		c.series[key] = append([]string(nil), labelValues...)
+	}
+	c.values[key] += v
+}
+
+// Inc 为指定标签值的计数加1
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Collect 实现Collector接口
+func (c *Counter) Collect(w *Writer) {
+	c.Lock()
+	defer c.Unlock()
+	w.Family(c.name, c.help, "counter")
+	for _, key := range sortedKeys(c.series) {
+		w.Sample(c.name, pairLabels(c.labels, c.series[key]), c.values[key])
+	}
+}
+
+// histogramValue 是一组标签值对应的直方图数据
+type histogramValue struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// Histogram 是按区间统计观测值分布的直方图
+type Histogram struct {
+	sync.Mutex
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+	series  map[string][]string
+	values  map[string]*histogramValue
+}
+
+// DefaultBuckets 是以秒为单位统计耗时的默认区间
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// NewHistogram 创建直方图，buckets为各区间的上界
+func NewHistogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+	return &Histogram{
+		name:    name,
+		help:    help,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string][]string),
+		values:  make(map[string]*histogramValue),
+	}
+}
+
+// Observe 记录一个观测值
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	key := seriesKey(labelValues)
+	h.Lock()
+	defer h.Unlock()
+	value, ok := h.values[key]
+	if !ok {
+		h.series[key] = append([]string(nil), labelValues...)
+		value = &histogramValue{counts: make([]uint64, len(h.buckets))}
+		h.values[key] = value
+	}
+	for i, upper := range h.buckets {
+		if v <= upper {
+			value.counts[i]++
+		}
+	}
+	value.count++
+	value.sum += v
+}
+
+// Collect 实现Collector接口
+func (h *Histogram) Collect(w *Writer) {
+	h.Lock()
+	defer h.Unlock()
+	w.Family(h.name, h.help, "histogram")
+	for _, key := range sortedKeys(h.series) {
+		labels := pairLabels(h.labels, h.series[key])
+		value := h.values[key]
+		for i, upper := range h.buckets {
+			w.Sample(h.name+"_bucket", append(labels, "le", formatValue(upper)), float64(value.counts[i]))
+		}
+		w.Sample(h.name+"_bucket", append(labels, "le", "+Inf"), float64(value.count))
+		w.Sample(h.name+"_sum", labels, value.sum)
+		w.Sample(h.name+"_count", labels, float64(value.count))
+	}
+}
+
+// GaugeFunc 是在输出时才计算数值的指标
+type GaugeFunc struct {
+	name    string
+	help    string
+	labels  []string
+	collect func(emit func(value float64, labelValues ...string))
+}
+
+// NewGaugeFunc 创建GaugeFunc，collect在每次输出时被调用，通过emit给出各标签值对应的数值
+func NewGaugeFunc(name string, help string, labels []string, collect func(emit func(value float64, labelValues ...string))) *GaugeFunc {
+	return &GaugeFunc{name: name, help: help, labels: labels, collect: collect}
+}
+
+// Collect 实现Collector接口
+func (g *GaugeFunc) Collect(w *Writer) {
+	w.Family(g.name, g.help, "gauge")
+	g.collect(func(value float64, labelValues ...string) {
+		w.Sample(g.name, pairLabels(g.labels, labelValues), value)
+	})
+}
@@ -0,0 +1,86 @@
+package model
+
+import (
+	"time"
+)
+
+// AccountingRecord 是一个Task结束时的资源使用记账信息。记账信息在作业删除后仍然保留。
+type AccountingRecord struct {
+	Time          time.Time `json:"time"` // Task结束的时间
+	Owner         string    `json:"owner,omitempty"`
+	Queue         string    `json:"queue"`
+	Job           string    `json:"job"`
+	Task          string    `json:"task"`
+	Node          string    `json:"node"`
+	WallSeconds   float64   `json:"wall_seconds"`   // Task执行的时长，单位秒
+	CPUSeconds    float64   `json:"cpu_seconds"`    // 分配的CPU核数与执行时长的乘积
+	GPUSeconds    float64   `json:"gpu_seconds"`    // 分配的GPU卡数与执行时长的乘积
+	MemorySeconds float64   `json:"memory_seconds"` // 分配的内存(Mi)与执行时长的乘积
+	State         string    `json:"state"`          // Task结束时的状态
+	ExitCode      int       `json:"exit_code"`
+}
+
+// NewAccountingRecord 根据结束的Task创建记账信息
+func NewAccountingRecord(job *Job, task *Task) *AccountingRecord {
+	wall := task.FinishTime.Sub(task.StartTime).Seconds()
+	if wall < 0 {
+		wall = 0
+	}
+	record := &AccountingRecord{
+		Time:        task.FinishTime,
+		Owner:       job.Owner,
+		Queue:       job.Queue,
+		Job:         job.ID,
+		Task:        task.ID,
+		Node:        task.NodeName,
+		WallSeconds: wall,
+		State:       TaskStateToString(task.State),
+		ExitCode:    task.ExitCode,
+	}
+	if task.Resources != nil {
+		record.CPUSeconds = float64(task.Resources.CPU.Cores) * wall
+		record.GPUSeconds = float64(task.Resources.GPU.Cards) * wall
+		record.MemorySeconds = float64(task.Resources.Memory) * wall
+	}
+	return record
+}
+
+// JobArchive 是被自动清理的作业的摘要信息
+type JobArchive struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	Owner      string    `json:"owner,omitempty"`
+	Queue      string    `json:"queue"`
+	State      string    `json:"state"`
+	Tasks      int       `json:"tasks"`
+	Completed  int       `json:"completed"`
+	Failed     int       `json:"failed"`
+	SubmitTime time.Time `json:"submit_time"`
+	ExecTime   time.Time `json:"exec_time"`
+	FinishTime time.Time `json:"finish_time"`
+}
+
+// NewJobArchive 根据结束的作业创建摘要信息
+func NewJobArchive(job *Job) *JobArchive {
+	archive := &JobArchive{
+		ID:         job.ID,
+		Name:       job.Name,
+		Owner:      job.Owner,
+		Queue:      job.Queue,
+		State:      JobStateToString(job.State),
+		SubmitTime: job.SubmitTime,
+		ExecTime:   job.ExecTime,
+		FinishTime: job.FinishTime,
+	}
+	for _, g := range job.Groups {
+		for _, t := range g.Tasks {
+			archive.Tasks++
+			if t.State == TaskCompleted {
+				archive.Completed++
+			} else if t.State == TaskFailed || t.State == TaskAborted {
+				archive.Failed++
+			}
+		}
+	}
+	return archive
+}
@@ -0,0 +1,19 @@
+package model
+
+import (
+	"time"
+)
+
+// AuditEntry 是一次修改操作的审计记录
+type AuditEntry struct {
+	Time     time.Time              `json:"time"`
+	User     string                 `json:"user,omitempty"`
+	ClientIP string                 `json:"client_ip"`
+	Method   string                 `json:"method"`
+	Endpoint string                 `json:"endpoint"`
+	Kind     string                 `json:"kind"`
+	Object   string                 `json:"object,omitempty"`
+	Status   int                    `json:"status"`
+	Before   map[string]interface{} `json:"before,omitempty"` // 被修改字段修改前的值
+	After    map[string]interface{} `json:"after,omitempty"`  // 被修改字段修改后的值
+}
@@ -71,6 +71,7 @@ type JobSpec struct {
 	Labels     map[string]string `json:"labels,omitempty"`
 	Taints     map[string]string `json:"taints,omitempty"`
 	MaxErrors  int               `json:"max_errors,omitempty"`
+	RunAs      string            `json:"run_as,omitempty"` // 在Linux节点上执行任务的用户名或uid:gid
 	GroupSpecs []*TaskGroupSpec  `json:"groups"`
 }
 
@@ -83,6 +84,8 @@ type Job struct {
 	Labels     map[string]string `json:"labels"`
 	Taints     map[string]string `json:"taints,omitempty"`
 	MaxErrors  int               `json:"max_errors"`
+	RunAs      string            `json:"run_as,omitempty"`
+	Owner      string            `json:"owner,omitempty"` // 提交作业的用户
 	Groups     []*TaskGroup      `json:"groups"`
 	SubmitTime time.Time         `json:"submit_time"`
 	ExecTime   time.Time         `json:"exec_time"`
@@ -104,12 +107,19 @@ func NewJobWithSpec(spec *JobSpec) *Job {
 		Labels:     spec.Labels,
 		Taints:     spec.Taints,
 		MaxErrors:  spec.MaxErrors,
+		RunAs:      spec.RunAs,
 		Groups:     make([]*TaskGroup, len(spec.GroupSpecs)),
 		State:      JobQueued,
 		Progress:   0,
 		TotalTasks: 0}
 	for i, g := range spec.GroupSpecs {
 		job.Groups[i] = NewTaskGroupWithSpec(fmt.Sprintf("%s.%d", job.ID, i), g)
+		// 未指定执行用户的Task使用Job的执行用户
+		for _, t := range job.Groups[i].Tasks {
+			if len(t.RunAs) == 0 {
+				t.RunAs = job.RunAs
+			}
+		}
 	}
 	return job
 }
@@ -146,6 +156,18 @@ func (job *Job) GetTaskGroup(name string) *TaskGroup {
 	return nil
 }
 
+// GetTask 返回Job中指定编号的Task，编号不属于该Job或序号越界时返回nil
+func (job *Job) GetTask(id TaskID) *Task {
+	if id.Job != job.ID || id.Group < 0 || id.Group >= len(job.Groups) {
+		return nil
+	}
+	tasks := job.Groups[id.Group].Tasks
+	if id.Index < 0 || id.Index >= len(tasks) {
+		return nil
+	}
+	return tasks[id.Index]
+}
+
 // GetSchedulableTasks 返回Job中当前可以调度的所有Task
 func (job *Job) GetSchedulableTasks() []*Task {
 	var tasks []*Task = nil
@@ -280,6 +302,7 @@ func (s *GeneralJobSorter) Swap(i, j int) {
 // JobUpdatableProps 包含Job在提交后可以修改的属性
 type JobUpdatableProps struct {
 	Name      string            `json:"name,omitempty"`
+	Queue     string            `json:"queue,omitempty"`
 	Priority  *int              `json:"priority,omitempty"`
 	Labels    map[string]string `json:"labels,omitempty"`
 	Taints    map[string]string `json:"taints,omitempty"`
@@ -14,6 +14,19 @@ const (
 	NodeUnknown
 )
 
+// NodeStateToString 将节点的状态转换为字符串
+func NodeStateToString(state NodeState) string {
+	switch state {
+	case NodeOnline:
+		return "Online"
+	case NodeOffline:
+		return "Offline"
+	case NodeUnknown:
+		return "Unknown"
+	}
+	return ""
+}
+
 // PlatformInfo 包含操作系统相关的信息
 type PlatformInfo struct {
 	Kind    string `json:"kind"`
@@ -31,9 +44,23 @@ type WorkNode struct {
 	Online    time.Time         `json:"online"`
 	Labels    map[string]string `json:"labels,omitempty"`
 	Taints    map[string]string `json:"taints,omitempty"`
-	Resources *ResourceSet      `json:"resources"` // 节点的总资源量
-	Reserved  *ResourceSet      `json:"reserved"`  // 节点保留的资源量（不用于计算任务调度）
-	Available *ResourceSet      `json:"available"` // 在节点刚加入的时候 Available = Resources - Reserved
+	Runtimes  []string          `json:"runtimes,omitempty"` // 节点支持的容器执行器
+	Images    []string          `json:"images,omitempty"`   // 节点本地已有的容器镜像
+	RunAs     []string          `json:"run_as,omitempty"`   // 节点允许任务使用的执行用户，"*"表示任意用户
+	Resources *ResourceSet      `json:"resources"`          // 节点的总资源量
+	Reserved  *ResourceSet      `json:"reserved"`           // 节点保留的资源量（不用于计算任务调度）
+	Available *ResourceSet      `json:"available"`          // 在节点刚加入的时候 Available = Resources - Reserved
+	Secret    string            `json:"-"`                  // 节点注册时分配的会话密钥
+}
+
+// AllowRunAs 判断节点是否允许任务以指定用户执行
+func (node *WorkNode) AllowRunAs(user string) bool {
+	for _, u := range node.RunAs {
+		if u == "*" || u == user {
+			return true
+		}
+	}
+	return false
 }
 
 // NewWorkNode 创建计算节点对象。计算节点默认保留2个CPU和4Gi内存。
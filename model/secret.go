@@ -0,0 +1,13 @@
+package model
+
+import (
+	"time"
+)
+
+// Secret 是保存在API Server上的密钥。Value是加密后的内容，不会返回给客户端。
+type Secret struct {
+	Name       string    `json:"name"`
+	Owner      string    `json:"owner,omitempty"`
+	Value      []byte    `json:"value"`
+	CreateTime time.Time `json:"create_time"`
+}
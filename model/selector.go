@@ -0,0 +1,84 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+)
+
+// labelRequirement 是标签选择器中的一个条件
+type labelRequirement struct {
+	key   string
+	value string
+	op    string // "="表示相等，"!="表示不等，""表示存在该标签，"!"表示不存在该标签
+}
+
+// LabelSelector 是按标签选择对象的条件，所有条件都满足时才匹配
+type LabelSelector []labelRequirement
+
+// ParseLabelSelector 解析标签选择器，格式为逗号分隔的"key=value"、"key!=value"、"key"或"!key"
+func ParseLabelSelector(s string) (LabelSelector, error) {
+	var selector LabelSelector
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if len(item) == 0 {
+			continue
+		}
+		var req labelRequirement
+		if pos := strings.Index(item, "!="); pos != -1 {
+			req = labelRequirement{key: item[:pos], value: item[pos+2:], op: "!="}
+		} else if pos := strings.Index(item, "="); pos != -1 {
+			req = labelRequirement{key: item[:pos], value: strings.TrimPrefix(item[pos+1:], "="), op: "="}
+		} else if strings.HasPrefix(item, "!") {
+			req = labelRequirement{key: item[1:], op: "!"}
+		} else {
+			req = labelRequirement{key: item}
+		}
+		req.key = strings.TrimSpace(req.key)
+		req.value = strings.TrimSpace(req.value)
+		if len(req.key) == 0 {
+			return nil, fmt.Errorf("illegal label selector: %s", item)
+		}
+		selector = append(selector, req)
+	}
+	return selector, nil
+}
+
+// Matches 判断标签是否满足选择器的所有条件
+func (selector LabelSelector) Matches(labels map[string]string) bool {
+	for _, req := range selector {
+		value, ok := labels[req.key]
+		switch req.op {
+		case "=":
+			if !ok || value != req.value {
+				return false
+			}
+		case "!=":
+			if ok && value == req.value {
+				return false
+			}
+		case "!":
+			if ok {
+				return false
+			}
+		default:
+			if !ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Equalities 返回选择器中所有"key=value"形式的条件
+func (selector LabelSelector) Equalities() map[string]string {
+	var result map[string]string
+	for _, req := range selector {
+		if req.op == "=" {
+			if result == nil {
+				result = make(map[string]string)
+			}
+			result[req.key] = req.value
+		}
+	}
+	return result
+}
@@ -1,6 +1,7 @@
 package model
 
 import (
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
@@ -59,14 +60,74 @@ func TaskStateToString(state TaskState) string {
 	return ""
 }
 
+// TaskStateFromString 将文字表达转换为Task状态，不区分大小写。无法识别时返回false。
+func TaskStateFromString(state string) (TaskState, bool) {
+	for s := TaskQueued; s <= TaskTerminated; s++ {
+		if strings.EqualFold(TaskStateToString(s), state) {
+			return s, true
+		}
+	}
+	return TaskQueued, false
+}
+
+const (
+	// CleanupAlways 表示任务结束后总是删除临时目录
+	CleanupAlways = "always"
+	// CleanupOnSuccess 表示仅在任务成功结束后删除临时目录
+	CleanupOnSuccess = "on_success"
+	// CleanupNever 表示任务结束后保留临时目录
+	CleanupNever = "never"
+)
+
+const (
+	// RuntimeDocker 表示使用docker执行容器任务
+	RuntimeDocker = "docker"
+	// RuntimePodman 表示使用podman执行容器任务
+	RuntimePodman = "podman"
+	// RuntimeOCI 表示使用节点配置的其它OCI兼容工具执行容器任务
+	RuntimeOCI = "oci"
+)
+
+// ValidateRuntime 检查任务组的容器执行器和镜像设置。直接执行的任务不能指定镜像，容器任务必须指定镜像。
+func ValidateRuntime(runtime string, image string) error {
+	switch runtime {
+	case "":
+		if len(image) > 0 {
+			return fmt.Errorf("image %s is specified without a runtime", image)
+		}
+	case RuntimeDocker, RuntimePodman, RuntimeOCI:
+		if len(image) == 0 {
+			return fmt.Errorf("runtime %s requires an image", runtime)
+		}
+	default:
+		return fmt.Errorf("unknown runtime %s", runtime)
+	}
+	return nil
+}
+
+const (
+	// EnvInherit 表示任务继承节点的环境变量，并使用指定的环境变量覆盖
+	EnvInherit = "inherit"
+	// EnvClean 表示任务仅使用指定的环境变量
+	EnvClean = "clean"
+)
+
 // TaskSpec 指定任务的执行信息
 type TaskSpec struct {
 	Name          string            `json:"name"`
 	Envs          []string          `json:"envs,omitempty"`
 	Command       string            `json:"command,omitempty"`
 	Args          string            `json:"args,omitempty"`
+	Argv          []string          `json:"argv,omitempty"`  // 参数列表，指定时忽略Args
+	Shell         *bool             `json:"shell,omitempty"` // 是否通过系统shell执行命令，未指定时使用任务组的设置
 	WorkDir       string            `json:"workdir,omitempty"`
 	Labels        map[string]string `json:"labels,omitempty"`
+	Inputs        []*InputFile      `json:"inputs,omitempty"`   // 任务启动前需要下载到节点的输入文件
+	Outputs       []string          `json:"outputs,omitempty"`  // 任务结束后需要上传的结果文件（支持通配符）
+	Cleanup       string            `json:"cleanup,omitempty"`  // 任务临时目录的清理策略
+	EnvMode       string            `json:"env_mode,omitempty"` // 是否继承节点的环境变量
+	RunAs         string            `json:"run_as,omitempty"`   // 在Linux节点上执行任务的用户名或uid:gid
+	Secrets       map[string]string `json:"secrets,omitempty"`  // 环境变量名及引用的密钥名，密钥值在任务启动时获取
 	*ResourceSpec `json:"resources,omitempty"`
 }
 
@@ -74,20 +135,151 @@ type TaskSpec struct {
 type Task struct {
 	ID         string            `json:"id"`
 	Name       string            `json:"name"`
+	Group      string            `json:"group,omitempty"`
+	Image      string            `json:"image,omitempty"`
+	Runtime    string            `json:"runtime,omitempty"`
 	Envs       []string          `json:"envs,omitempty"`
+	Secrets    map[string]string `json:"secrets,omitempty"`
+	EnvMode    string            `json:"env_mode,omitempty"`
+	RunAs      string            `json:"run_as,omitempty"`
 	Command    string            `json:"command,omitempty"`
 	Args       string            `json:"args,omitempty"`
+	Argv       []string          `json:"argv,omitempty"`
+	Shell      bool              `json:"shell,omitempty"`
 	WorkDir    string            `json:"workdir,omitempty"`
 	Labels     map[string]string `json:"labels,omitempty"`
 	Taints     map[string]string `json:"taints,omitempty"`
+	Inputs     []*InputFile      `json:"inputs,omitempty"`
+	Outputs    []string          `json:"outputs,omitempty"`
+	Cleanup    string            `json:"cleanup,omitempty"`
 	Resources  *ResourceSet      `json:"resources,omitempty"`
 	State      TaskState         `json:"state"`
 	NodeName   string            `json:"node,omitempty"`
+	Reason     string            `json:"reason,omitempty"` // 任务处于排队状态的原因
 	Progress   int               `json:"progress"`
 	ExitCode   int               `json:"exit_code"`
 	Error      string            `json:"error"`
 	StartTime  time.Time         `json:"start_time"`
 	FinishTime time.Time         `json:"finish_time"`
+	Output     *TaskOutput       `json:"output,omitempty"`
+	Usage      *TaskUsage        `json:"usage,omitempty"` // 节点采样得到的实际资源使用量
+}
+
+// Reset 将Task恢复为排队状态，清除上次执行的结果以便重新执行
+func (task *Task) Reset() {
+	task.State = TaskQueued
+	task.NodeName = ""
+	task.Reason = ""
+	task.Progress = 0
+	task.ExitCode = 0
+	task.Error = ""
+	task.StartTime = time.Time{}
+	task.FinishTime = time.Time{}
+	task.Output = nil
+	task.Usage = nil
+}
+
+// TaskUsage 是任务进程树实际使用的资源，由节点周期性采样得到
+type TaskUsage struct {
+	CPUSeconds    float64 `json:"cpu_seconds"`               // 累计使用的CPU时间（用户态与内核态之和），单位秒
+	PeakCPU       float64 `json:"peak_cpu"`                  // 两次采样之间平均使用CPU核数的峰值
+	PeakRSS       int64   `json:"peak_rss"`                  // 常驻内存的峰值，单位字节
+	ReadBytes     int64   `json:"read_bytes"`                // 累计读取的字节数
+	WriteBytes    int64   `json:"write_bytes"`               // 累计写入的字节数
+	PeakGPUMemory int64   `json:"peak_gpu_memory,omitempty"` // 显存占用的峰值，单位Mi
+}
+
+// Clone 复制TaskUsage对象
+func (usage *TaskUsage) Clone() *TaskUsage {
+	if usage == nil {
+		return nil
+	}
+	result := *usage
+	return &result
+}
+
+// TaskOutput 是任务程序通过结构化协议上报的输出内容，包括指标、状态、产物和自定义结果
+type TaskOutput struct {
+	Metrics   map[string]float64 `json:"metrics,omitempty"`
+	Status    string             `json:"status,omitempty"`
+	Artifacts []string           `json:"artifacts,omitempty"`
+	Results   map[string]string  `json:"results,omitempty"`
+}
+
+// IsEmpty 判断是否没有任何输出内容
+func (output *TaskOutput) IsEmpty() bool {
+	return len(output.Metrics) == 0 && len(output.Status) == 0 && len(output.Artifacts) == 0 && len(output.Results) == 0
+}
+
+// Merge 将另一个输出内容合并到自身，同名的指标和结果以后者为准
+func (output *TaskOutput) Merge(other *TaskOutput) {
+	if other == nil {
+		return
+	}
+	for k, v := range other.Metrics {
+		if output.Metrics == nil {
+			output.Metrics = make(map[string]float64)
+		}
+		output.Metrics[k] = v
+	}
+	if len(other.Status) > 0 {
+		output.Status = other.Status
+	}
+	output.Artifacts = util.MergeStringSlice(output.Artifacts, other.Artifacts)
+	for k, v := range other.Results {
+		if output.Results == nil {
+			output.Results = make(map[string]string)
+		}
+		output.Results[k] = v
+	}
+}
+
+// Clone 深度复制TaskOutput对象
+func (output *TaskOutput) Clone() *TaskOutput {
+	result := &TaskOutput{Status: output.Status}
+	if output.Metrics != nil {
+		result.Metrics = make(map[string]float64)
+		for k, v := range output.Metrics {
+			result.Metrics[k] = v
+		}
+	}
+	if output.Artifacts != nil {
+		result.Artifacts = append(make([]string, 0, len(output.Artifacts)), output.Artifacts...)
+	}
+	result.Results = util.CloneMap(output.Results)
+	return result
+}
+
+// InputFile 指定任务启动前需要下载到节点的输入文件
+type InputFile struct {
+	Path   string `json:"path"`             // 文件在任务临时目录下的相对路径
+	Source string `json:"source,omitempty"` // 随作业上传的文件名、API Server上的路径或完整的URL，为空时与Path相同
+	Hash   string `json:"hash,omitempty"`   // 文件内容的SHA256值，节点据此缓存文件
+}
+
+// IsUploaded 判断输入文件是否来自随作业一起上传的文件
+func (input *InputFile) IsUploaded() bool {
+	src := input.Source
+	return !strings.HasPrefix(src, "/") && !strings.HasPrefix(src, "http://") && !strings.HasPrefix(src, "https://")
+}
+
+// mergeInputFiles 合并Task和TaskGroup的输入文件，相同路径的文件以Task的为准
+func mergeInputFiles(inputs []*InputFile, groupInputs []*InputFile) []*InputFile {
+	if len(groupInputs) == 0 {
+		return inputs
+	}
+	paths := make(map[string]bool)
+	result := make([]*InputFile, 0, len(inputs)+len(groupInputs))
+	for _, input := range inputs {
+		paths[input.Path] = true
+		result = append(result, input)
+	}
+	for _, input := range groupInputs {
+		if !paths[input.Path] {
+			result = append(result, input)
+		}
+	}
+	return result
 }
 
 // NewTaskWithSpec 根据指定的TaskSpec内容创建对应的Task对象
@@ -95,23 +287,43 @@ func NewTaskWithSpec(group *TaskGroup, id int, spec *TaskSpec) *Task {
 	task := &Task{
 		ID:        fmt.Sprintf("%s.%d", group.ID, id),
 		Name:      spec.Name,
+		Group:     group.Name,
+		Image:     group.Image,
+		Runtime:   group.Runtime,
 		Envs:      spec.Envs,
-		Command:   strings.ReplaceAll(spec.Command, "\\", "/"),
+		Secrets:   spec.Secrets,
+		EnvMode:   spec.EnvMode,
+		RunAs:     spec.RunAs,
+		Command:   spec.Command,
 		Args:      spec.Args,
+		Argv:      spec.Argv,
+		Shell:     group.Shell,
 		WorkDir:   spec.WorkDir,
 		Labels:    spec.Labels,
+		Inputs:    spec.Inputs,
+		Outputs:   spec.Outputs,
+		Cleanup:   spec.Cleanup,
 		Resources: NewResourceSetWithSpec(spec.ResourceSpec),
 		State:     TaskQueued,
 		Progress:  0,
 		ExitCode:  -1,
 	}
 	// 如果Task没有指定一些信息，则将所属TaskGroup的信息赋予它
+	if spec.Shell != nil {
+		task.Shell = *spec.Shell
+	}
 	if len(task.Command) == 0 {
 		task.Command = group.Command
 	}
 	if len(task.WorkDir) == 0 {
 		task.WorkDir = group.WorkDir
 	}
+	if len(task.Cleanup) == 0 {
+		task.Cleanup = group.Cleanup
+	}
+	if len(task.EnvMode) == 0 {
+		task.EnvMode = group.EnvMode
+	}
 	// 如果Task没有指定所需资源，则使用TaskGroup的资源；若都没有指定，使用预定义的默认资源
 	if task.Resources == nil {
 		task.Resources = group.Resources
@@ -127,28 +339,81 @@ func NewTaskWithSpec(group *TaskGroup, id int, spec *TaskSpec) *Task {
 	}
 	// 环境变量和标签采取合并的方式
 	task.Envs = util.MergeStringSlice(task.Envs, group.Envs)
+	task.Secrets = util.MergeStringMap(task.Secrets, group.Secrets)
 	task.Labels = util.MergeStringMap(task.Labels, group.Labels)
+	task.Inputs = mergeInputFiles(task.Inputs, group.Inputs)
+	task.Outputs = util.MergeStringSlice(task.Outputs, group.Outputs)
 	return task
 }
 
-// ParseTaskID 解析Task的完整编号，分别返回Job, TaskGroup和Task的编号
-func ParseTaskID(id string) (string, int, int) {
+// ErrInvalidTaskID 表示Task编号的格式不正确
+var ErrInvalidTaskID = errors.New("invalid task id")
+
+// TaskID 是解析后的Task完整编号，格式为"作业编号.任务组序号.Task序号"
+type TaskID struct {
+	Job   string
+	Group int
+	Index int
+}
+
+func (id TaskID) String() string {
+	return fmt.Sprintf("%s.%d.%d", id.Job, id.Group, id.Index)
+}
+
+// ValidateJobID 检查作业编号能否用于组成Task编号和数据目录名
+func ValidateJobID(id string) error {
+	if len(id) == 0 || len(id) > 128 || strings.ContainsAny(id, "./\\:") {
+		return fmt.Errorf("illegal job id \"%s\"", id)
+	}
+	return nil
+}
+
+// parseTaskIndex 解析Task编号中的序号，只接受不带符号和前导0的十进制数
+func parseTaskIndex(s string) (int, bool) {
+	if len(s) == 0 || len(s) > 9 || (len(s) > 1 && s[0] == '0') {
+		return 0, false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+	}
+	n, err := strconv.Atoi(s)
+	return n, err == nil
+}
+
+// ParseTaskID 解析Task的完整编号，格式不正确时返回ErrInvalidTaskID
+func ParseTaskID(id string) (TaskID, error) {
 	ids := strings.Split(id, ".")
-	if len(ids) != 3 {
-		return "", -1, -1
+	if len(ids) != 3 || ValidateJobID(ids[0]) != nil {
+		return TaskID{}, ErrInvalidTaskID
+	}
+	group, ok := parseTaskIndex(ids[1])
+	if !ok {
+		return TaskID{}, ErrInvalidTaskID
+	}
+	task, ok := parseTaskIndex(ids[2])
+	if !ok {
+		return TaskID{}, ErrInvalidTaskID
 	}
-	group, _ := strconv.Atoi(ids[1])
-	task, _ := strconv.Atoi(ids[2])
-	return ids[0], group, task
+	return TaskID{Job: ids[0], Group: group, Index: task}, nil
 }
 
 // TaskGroupSpec 表示指定任务组的执行信息，其中包含多个任务描述。
 type TaskGroupSpec struct {
 	Name          string            `json:"name"`
 	Command       string            `json:"command,omitempty"`
+	Shell         bool              `json:"shell,omitempty"`
+	Image         string            `json:"image,omitempty"`   // 容器执行时使用的镜像
+	Runtime       string            `json:"runtime,omitempty"` // 容器执行器：docker、podman或oci，为空时直接执行
 	WorkDir       string            `json:"workdir,omitempty"`
 	Envs          []string          `json:"envs,omitempty"`
+	Secrets       map[string]string `json:"secrets,omitempty"`
 	Labels        map[string]string `json:"labels,omitempty"`
+	Inputs        []*InputFile      `json:"inputs,omitempty"`
+	Outputs       []string          `json:"outputs,omitempty"`
+	Cleanup       string            `json:"cleanup,omitempty"`
+	EnvMode       string            `json:"env_mode,omitempty"`
 	TaskSpecs     []*TaskSpec       `json:"tasks"`
 	Dependents    []string          `json:"dependents,omitempty"`
 	*ResourceSpec `json:"resources,omitempty"`
@@ -159,9 +424,17 @@ type TaskGroup struct {
 	ID          string            `json:"id"`
 	Name        string            `json:"name"`
 	Command     string            `json:"command,omitempty"`
+	Shell       bool              `json:"shell,omitempty"`
+	Image       string            `json:"image,omitempty"`
+	Runtime     string            `json:"runtime,omitempty"`
 	WorkDir     string            `json:"workdir,omitempty"`
 	Labels      map[string]string `json:"labels,omitempty"`
 	Envs        []string          `json:"envs,omitempty"`
+	Secrets     map[string]string `json:"secrets,omitempty"`
+	Inputs      []*InputFile      `json:"inputs,omitempty"`
+	Outputs     []string          `json:"outputs,omitempty"`
+	Cleanup     string            `json:"cleanup,omitempty"`
+	EnvMode     string            `json:"env_mode,omitempty"`
 	Dependents  []string          `json:"dependents,omitempty"`
 	Resources   *ResourceSet      `json:"resources,omitempty"`
 	Completions int               `json:"-"`
@@ -173,10 +446,18 @@ func NewTaskGroupWithSpec(id string, spec *TaskGroupSpec) *TaskGroup {
 	group := &TaskGroup{
 		ID:          id,
 		Name:        spec.Name,
-		Command:     strings.ReplaceAll(spec.Command, "\\", "/"),
+		Command:     spec.Command,
+		Shell:       spec.Shell,
+		Image:       spec.Image,
+		Runtime:     spec.Runtime,
 		WorkDir:     spec.WorkDir,
 		Envs:        spec.Envs,
+		Secrets:     spec.Secrets,
 		Labels:      spec.Labels,
+		Inputs:      spec.Inputs,
+		Outputs:     spec.Outputs,
+		Cleanup:     spec.Cleanup,
+		EnvMode:     spec.EnvMode,
 		Dependents:  spec.Dependents,
 		Resources:   NewResourceSetWithSpec(spec.ResourceSpec),
 		Completions: 0,
@@ -0,0 +1,149 @@
+package model
+
+import (
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestNewTaskWithSpecShell(t *testing.T) {
+	yes, no := true, false
+	cases := []struct {
+		group bool
+		task  *bool
+		shell bool
+	}{
+		{false, nil, false},
+		{true, nil, true},
+		{false, &yes, true},
+		{true, &no, false},
+		{true, &yes, true},
+	}
+	for _, c := range cases {
+		group := NewTaskGroupWithSpec("job.0", &TaskGroupSpec{Name: "g", Command: "run", Shell: c.group})
+		task := NewTaskWithSpec(group, 0, &TaskSpec{Name: "t", Shell: c.task})
+		if task.Shell != c.shell {
+			t.Errorf("group shell %v and task shell %v: got %v, want %v", c.group, c.task, task.Shell, c.shell)
+		}
+	}
+}
+
+func TestValidateRuntime(t *testing.T) {
+	cases := []struct {
+		runtime string
+		image   string
+		valid   bool
+	}{
+		{"", "", true},
+		{"", "busybox", false},
+		{RuntimeDocker, "busybox", true},
+		{RuntimePodman, "busybox", true},
+		{RuntimeOCI, "busybox", true},
+		{RuntimeDocker, "", false},
+		{"dokcer", "busybox", false},
+		{"Docker", "busybox", false},
+	}
+	for _, c := range cases {
+		if err := ValidateRuntime(c.runtime, c.image); (err == nil) != c.valid {
+			t.Errorf("ValidateRuntime(%q, %q) = %v, want valid %v", c.runtime, c.image, err, c.valid)
+		}
+	}
+}
+
+func TestParseTaskIndex(t *testing.T) {
+	cases := []struct {
+		s     string
+		n     int
+		valid bool
+	}{
+		{"0", 0, true},
+		{"7", 7, true},
+		{"42", 42, true},
+		{"999999999", 999999999, true},
+		{"", 0, false},
+		{"00", 0, false},
+		{"01", 0, false},
+		{"-1", 0, false},
+		{"+1", 0, false},
+		{" 1", 0, false},
+		{"1 ", 0, false},
+		{"1e3", 0, false},
+		{"0x10", 0, false},
+		{"abc", 0, false},
+		{"1000000000", 0, false},
+		{"99999999999999999999", 0, false},
+		{"\u0661", 0, false},
+	}
+	for _, c := range cases {
+		n, ok := parseTaskIndex(c.s)
+		if ok != c.valid || (ok && n != c.n) {
+			t.Errorf("parseTaskIndex(%q) = %d, %v, want %d, %v", c.s, n, ok, c.n, c.valid)
+		}
+	}
+}
+
+func TestParseTaskID(t *testing.T) {
+	cases := []struct {
+		s     string
+		id    TaskID
+		valid bool
+	}{
+		{"job.0.0", TaskID{"job", 0, 0}, true},
+		{"8f2c-41.2.15", TaskID{"8f2c-41", 2, 15}, true},
+		{"abc.9.9", TaskID{"abc", 9, 9}, true},
+		{"", TaskID{}, false},
+		{"job", TaskID{}, false},
+		{"job.0", TaskID{}, false},
+		{"job.0.0.0", TaskID{}, false},
+		{".0.0", TaskID{}, false},
+		{"job..0", TaskID{}, false},
+		{"job.0.", TaskID{}, false},
+		{"job.-1.0", TaskID{}, false},
+		{"job.0.-1", TaskID{}, false},
+		{"job.01.0", TaskID{}, false},
+		{"job.a.0", TaskID{}, false},
+		{"job.0.99999999999999999999", TaskID{}, false},
+		{"a/b.0.0", TaskID{}, false},
+		{"a\\b.0.0", TaskID{}, false},
+		{"a:b.0.0", TaskID{}, false},
+		{strings.Repeat("j", 129) + ".0.0", TaskID{}, false},
+	}
+	for _, c := range cases {
+		id, err := ParseTaskID(c.s)
+		if (err == nil) != c.valid || id != c.id {
+			t.Errorf("ParseTaskID(%q) = %+v, %v, want %+v, valid %v", c.s, id, err, c.id, c.valid)
+		}
+		if err != nil && err != ErrInvalidTaskID {
+			t.Errorf("ParseTaskID(%q) returns %v, want ErrInvalidTaskID", c.s, err)
+		}
+	}
+}
+
+// TestParseTaskIDRandom 用随机生成的字符串检查ParseTaskID不会崩溃，并且接受的编号格式化后与原字符串相同
+func TestParseTaskIDRandom(t *testing.T) {
+	const alphabet = "0123456789.-+ab/\\:x\x00\xff"
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 100000; i++ {
+		b := make([]byte, rnd.Intn(24))
+		for j := range b {
+			b[j] = alphabet[rnd.Intn(len(alphabet))]
+		}
+		s := string(b)
+		id, err := ParseTaskID(s)
+		if err != nil {
+			continue
+		}
+		if id.String() != s || id.Group < 0 || id.Index < 0 {
+			t.Fatalf("ParseTaskID(%q) = %+v which formats to %q", s, id, id.String())
+		}
+	}
+	// 合法编号的往返转换
+	for i := 0; i < 10000; i++ {
+		id := TaskID{Job: "job" + strconv.Itoa(rnd.Int()), Group: rnd.Intn(1000), Index: rnd.Intn(1000000)}
+		parsed, err := ParseTaskID(id.String())
+		if err != nil || parsed != id {
+			t.Fatalf("ParseTaskID(%q) = %+v, %v", id.String(), parsed, err)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package node
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/qianxiaoming/lightsched/model"
+)
+
+// uploadArtifacts 将工作目录下与Task输出模式匹配的文件上传到API Server
+func (node *NodeServer) uploadArtifacts(task *model.Task, workdir string) {
+	if len(task.Outputs) == 0 || node.state == model.NodeUnknown {
+		return
+	}
+	if len(workdir) == 0 {
+		workdir, _ = os.Getwd()
+	}
+	uploaded := make(map[string]bool)
+	for _, pattern := range task.Outputs {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(workdir, pattern)
+		}
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			log.Printf("Illegal output pattern %s for task %s: %v\n", pattern, task.ID, err)
+			continue
+		}
+		for _, filename := range files {
+			if uploaded[filename] {
+				continue
+			}
+			if info, err := os.Stat(filename); err != nil || info.IsDir() {
+				continue
+			}
+			// 位于工作目录下的文件保留相对路径，其它文件仅保留文件名
+			rel, err := filepath.Rel(workdir, filename)
+			if err != nil || strings.HasPrefix(rel, "..") {
+				rel = filepath.Base(filename)
+			}
+			if err := node.uploadArtifact(task.ID, filepath.ToSlash(rel), filename); err != nil {
+				log.Printf("Unable to upload artifact %s for task %s: %v\n", filename, task.ID, err)
+			} else {
+				uploaded[filename] = true
+			}
+		}
+	}
+	if len(uploaded) > 0 {
+		log.Printf("%d artifact(s) of task %s uploaded\n", len(uploaded), task.ID)
+	}
+}
+
+func (node *NodeServer) uploadArtifact(taskid string, rel string, filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	segments := strings.Split(rel, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	resp, err := node.post(fmt.Sprintf(node.config.OutputURL, taskid, strings.Join(segments, "/")), "application/octet-stream", file)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("server responds with status %d", resp.StatusCode)
+	}
+	return nil
+}
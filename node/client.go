@@ -0,0 +1,99 @@
+package node
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/qianxiaoming/lightsched/constant"
+)
+
+// newHTTPClient 根据配置创建访问API Server的HTTP客户端。配置了服务端证书时只接受该证书，否则使用CA校验服务端。
+func newHTTPClient(conf *Config) (*http.Client, error) {
+	if len(conf.serverScheme()) == len("http://") {
+		return &http.Client{}, nil
+	}
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if len(conf.ServerCert) > 0 {
+		data, err := ioutil.ReadFile(conf.ServerCert)
+		if err != nil {
+			return nil, err
+		}
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("no certificate found in %s", conf.ServerCert)
+		}
+		pinned, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		// 不依赖系统CA和主机名，只比较服务端证书是否与配置的证书完全一致
+		config.InsecureSkipVerify = true
+		config.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) > 0 && bytes.Equal(rawCerts[0], pinned.Raw) {
+				return nil
+			}
+			return errors.New("server certificate does not match the pinned certificate")
+		}
+	} else if len(conf.ServerCA) > 0 {
+		data, err := ioutil.ReadFile(conf.ServerCA)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificate found in %s", conf.ServerCA)
+		}
+	}
+	if len(conf.ClientCert) > 0 {
+		cert, err := tls.LoadX509KeyPair(conf.ClientCert, conf.ClientKey)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: config}}, nil
+}
+
+// serverScheme 返回访问API Server使用的协议
+func (conf *Config) serverScheme() string {
+	if conf.TLS || len(conf.ServerCert) > 0 || len(conf.ServerCA) > 0 {
+		return "https://"
+	}
+	return "http://"
+}
+
+// sendRequest 向API Server发送请求，访问API Server时附带节点的认证信息
+func (node *NodeServer) sendRequest(method string, url string, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if len(contentType) > 0 {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if strings.HasPrefix(url, node.config.ServerURL) {
+		req.Header.Set(constant.NodeNameHeader, node.config.Hostname)
+		if len(node.secret) > 0 {
+			req.Header.Set("Authorization", "Bearer "+node.secret)
+		}
+	}
+	return node.client.Do(req)
+}
+
+// post 向API Server发送POST请求
+func (node *NodeServer) post(url string, contentType string, body io.Reader) (*http.Response, error) {
+	return node.sendRequest(http.MethodPost, url, contentType, body)
+}
+
+// get 向API Server发送GET请求
+func (node *NodeServer) get(url string) (*http.Response, error) {
+	return node.sendRequest(http.MethodGet, url, "", nil)
+}
@@ -0,0 +1,57 @@
+package node
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+
+	"github.com/qianxiaoming/lightsched/model"
+)
+
+// buildTaskEnv 生成任务指定的环境变量及调度器提供的上下文变量，不包含节点自身的环境变量。
+// 是否继承节点的环境变量由具体的执行器决定。
+func (node *NodeServer) buildTaskEnv(task *model.Task) []string {
+	id, _ := model.ParseTaskID(task.ID)
+	context := []string{
+		"LIGHTSCHED_JOB_ID=" + id.Job,
+		"LIGHTSCHED_TASK_ID=" + task.ID,
+		"LIGHTSCHED_GROUP=" + task.Group,
+		fmt.Sprintf("LIGHTSCHED_GROUP_INDEX=%d", id.Group),
+		fmt.Sprintf("LIGHTSCHED_TASK_INDEX=%d", id.Index),
+		"LIGHTSCHED_NODE=" + node.config.Hostname,
+		"LIGHTSCHED_APISERVER=" + node.restAddress(),
+	}
+	if task.Resources != nil {
+		context = append(context,
+			fmt.Sprintf("LIGHTSCHED_CPU_CORES=%g", task.Resources.CPU.Cores),
+			fmt.Sprintf("LIGHTSCHED_MEMORY=%d", task.Resources.Memory),
+			fmt.Sprintf("LIGHTSCHED_GPU_CARDS=%d", task.Resources.GPU.Cards))
+	}
+	return mergeEnv(append([]string(nil), task.Envs...), context)
+}
+
+// mergeEnv 使用overrides中的变量覆盖env中的同名变量。Windows上变量名不区分大小写。
+func mergeEnv(env []string, overrides []string) []string {
+	key := func(kv string) string {
+		k := kv
+		if pos := strings.Index(kv, "="); pos > 0 {
+			k = kv[:pos]
+		}
+		if runtime.GOOS == "windows" {
+			k = strings.ToUpper(k)
+		}
+		return k
+	}
+	index := make(map[string]int, len(env)+len(overrides))
+	result := make([]string, 0, len(env)+len(overrides))
+	for _, kv := range append(env, overrides...) {
+		k := key(kv)
+		if i, ok := index[k]; ok {
+			result[i] = kv
+		} else {
+			index[k] = len(result)
+			result = append(result, kv)
+		}
+	}
+	return result
+}
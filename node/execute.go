@@ -0,0 +1,235 @@
+package node
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/qianxiaoming/lightsched/message"
+	"github.com/qianxiaoming/lightsched/model"
+	"github.com/qianxiaoming/lightsched/util"
+)
+
+func (node *NodeServer) runExecuteTask(msg *message.JSON) {
+	task := &model.Task{}
+	if err := json.Unmarshal(msg.Content, task); err != nil {
+		log.Printf("NOTICE: Unable to unmarshal task json and just ignore it now: %v\n", err)
+	} else {
+		runtime, ok := node.runtimes[task.Runtime]
+		if !ok {
+			log.Printf("Runtime %s of task(%s) is not available on this node\n", task.Runtime, task.ID)
+			node.notifyTaskStatus(task.ID, model.TaskAborted, nil, 0, 0, "Runtime is not available", nil)
+			return
+		}
+		if len(task.RunAs) > 0 && !node.allowRunAs(task.RunAs) {
+			log.Printf("Task(%s) requires running as %s which is not allowed\n", task.ID, task.RunAs)
+			node.notifyTaskStatus(task.ID, model.TaskAborted, nil, 0, 0, fmt.Sprintf("User %s is not allowed on this node", task.RunAs), nil)
+			return
+		}
+		// 为本次执行创建独立的临时目录，未指定工作目录时在临时目录中运行
+		scratch, err := node.createScratch(task)
+		if err != nil {
+			log.Printf("Cannot create scratch directory for task(%s): %v\n", task.ID, err)
+			node.notifyTaskStatus(task.ID, model.TaskAborted, nil, 0, 0, err.Error(), nil)
+			return
+		}
+		success := false
+		defer func() { node.cleanupScratch(task, scratch, success) }()
+		// 容器任务的工作目录是容器内的路径，结果文件总是从临时目录中获取
+		workdir := task.WorkDir
+		if len(workdir) == 0 || len(task.Runtime) > 0 {
+			workdir = scratch
+		}
+		// 下载任务的输入文件到临时目录中
+		if len(task.Inputs) > 0 {
+			if err := node.stageInputs(task, scratch); err != nil {
+				log.Printf("Cannot stage input files for task(%s): %v\n", task.ID, err)
+				node.notifyTaskStatus(task.ID, model.TaskAborted, nil, 0, 0, err.Error(), nil)
+				return
+			}
+		}
+		// 任务程序可以向该文件写入JSON-lines格式的结构化输出
+		ctx := &ExecContext{
+			Scratch:    scratch,
+			WorkDir:    task.WorkDir,
+			OutputFile: filepath.Join(os.TempDir(), fmt.Sprintf("lightsched-%s.jsonl", filepath.Base(scratch))),
+			Inputs:     len(task.Inputs) > 0,
+			Env:        node.buildTaskEnv(task),
+		}
+		if len(task.Secrets) > 0 {
+			if ctx.Secrets, err = node.fetchSecrets(task); err != nil {
+				log.Printf("Cannot get secrets for task(%s): %v\n", task.ID, err)
+				node.notifyTaskStatus(task.ID, model.TaskAborted, nil, 0, 0, err.Error(), nil)
+				return
+			}
+		}
+		// 以指定用户执行时需要先将临时目录交给该用户
+		if len(task.RunAs) > 0 {
+			if ctx.Credential, err = node.resolveRunAs(task.RunAs, scratch); err != nil {
+				log.Printf("Cannot run task(%s) as %s: %v\n", task.ID, task.RunAs, err)
+				node.notifyTaskStatus(task.ID, model.TaskAborted, nil, 0, 0, err.Error(), nil)
+				return
+			}
+		}
+		outputFile := ctx.OutputFile
+		defer os.Remove(outputFile)
+		cmd, err := runtime.Command(task, ctx)
+		if err != nil {
+			log.Printf("Cannot create command for task(%s): %v\n", task.ID, err)
+			node.notifyTaskStatus(task.ID, model.TaskAborted, nil, 0, 0, err.Error(), nil)
+			return
+		}
+		if ctx.Stop != nil {
+			node.stoppers.Store(task.ID, ctx.Stop)
+			defer node.stoppers.Delete(task.ID)
+		}
+		if ctx.Inspect != nil {
+			node.inspectors.Store(task.ID, ctx.Inspect)
+			defer node.inspectors.Delete(task.ID)
+		}
+		log.Printf("Execute task(%s) program: %s %q\n", task.ID, cmd.Path, cmd.Args[1:])
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			log.Printf("Cannot get standard output pipe for task(%s): %v\n", task.ID, err)
+			node.notifyTaskStatus(task.ID, model.TaskAborted, nil, 0, 0, err.Error(), nil)
+			return
+		}
+		cmd.Stderr = cmd.Stdout
+		if err := cmd.Start(); err != nil {
+			log.Printf("Cannot start program for task(%s): %v\n", task.ID, err)
+			node.notifyTaskStatus(task.ID, model.TaskAborted, nil, 0, 0, err.Error(), nil)
+			return
+		}
+		node.notifyTaskStatus(task.ID, model.TaskExecuting, cmd.Process, 0, 0, "", nil)
+
+		// 任务运行期间定期上传新增的日志
+		logs := node.newTaskLog(task.ID, ctx.Secrets)
+		logs.start(logUploadInterval)
+		progress := 0
+		output := &model.TaskOutput{}
+		reader := bufio.NewReader(stdout)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil || io.EOF == err {
+				break
+			}
+			// 记录任务程序的输出
+			if strings.HasPrefix(line, "[PROGRESS]") {
+				cur, str := parseProgress(line)
+				if cur != -1 && cur != progress {
+					progress = cur
+					node.notifyTaskStatus(task.ID, model.TaskExecuting, cmd.Process, progress, 0, "", nil)
+				}
+				if len(str) > 0 {
+					logs.WriteString(str)
+				}
+			} else if strings.HasPrefix(line, "[ERROR]") {
+				s := strings.Index(line, "]")
+				if s < len(line)-1 {
+					line = strings.Trim(line[s+1:], " ")
+					logs.WriteString(line)
+					line = strings.Trim(line, "\r\n")
+					node.notifyTaskStatus(task.ID, model.TaskExecuting, cmd.Process, progress, 0, maskSecrets(line, ctx.Secrets), nil)
+				}
+			} else if ok, updated := parseOutputLine(line, output); ok {
+				logs.WriteString(line)
+				if updated {
+					node.notifyTaskStatus(task.ID, model.TaskExecuting, cmd.Process, progress, 0, "", maskOutput(output, ctx.Secrets))
+				}
+			} else {
+				logs.WriteString(line)
+			}
+		}
+		err = cmd.Wait()
+		// 进程结束时的统计包含已回收的子进程，容器任务的统计只有容器命令行工具本身
+		cpuTime := 0.0
+		if cmd.ProcessState != nil {
+			cpuTime = (cmd.ProcessState.UserTime() + cmd.ProcessState.SystemTime()).Seconds()
+		}
+		// 上报结束状态之前上传剩余的日志，客户端在Task结束后读取到的是完整日志
+		logs.close()
+		readOutputFile(outputFile, output)
+		// 在上报结束状态之前上传任务的结果文件
+		node.uploadArtifacts(task, workdir)
+		// 结构化输出会通过API返回，与日志一样不能包含密钥值
+		var final *model.TaskOutput
+		if !output.IsEmpty() {
+			final = maskOutput(output, ctx.Secrets)
+		}
+		if err != nil {
+			if exit, ok := err.(*exec.ExitError); ok {
+				if exit.Success() {
+					success = true
+					log.Printf("Task(%s) program exit successfully\n", task.ID)
+					node.notifyTaskFinished(task.ID, model.TaskCompleted, nil, progress, 0, "", final, cpuTime)
+				} else {
+					log.Printf("Task(%s) program exit error: %v\n", task.ID, err)
+					node.notifyTaskFinished(task.ID, model.TaskFailed, nil, progress, exit.ExitCode(), exit.Error(), final, cpuTime)
+				}
+			}
+		} else {
+			success = true
+			log.Printf("Task(%s) program exit successfully\n", task.ID)
+			node.notifyTaskFinished(task.ID, model.TaskCompleted, nil, progress, 0, "", final, cpuTime)
+		}
+	}
+}
+
+// resolveCommand 根据任务的程序和参数创建要执行的进程。使用shell模式时由系统shell解释整个命令行。
+func resolveCommand(task *model.Task) (*exec.Cmd, error) {
+	if len(task.Command) == 0 {
+		return nil, fmt.Errorf("no command specified")
+	}
+	if task.Shell {
+		// shell模式下Command是完整的命令行，其中可能包含URL等参数，不能当作路径规范化
+		line := task.Command
+		if len(task.Argv) > 0 {
+			line = line + " " + joinCommandLine(task.Argv)
+		} else if len(task.Args) > 0 {
+			line = line + " " + task.Args
+		}
+		return shellCommand(line), nil
+	}
+
+	command := util.NativePath(task.Command)
+	if !util.PathExists(command) {
+		command = filepath.Join(filepath.Dir(util.GetCurrentPath()), command)
+		if !util.PathExists(command) {
+			return nil, fmt.Errorf("program %s does not exist", task.Command)
+		}
+	}
+	args := task.Argv
+	if len(args) == 0 {
+		var err error
+		if args, err = splitCommandLine(task.Args); err != nil {
+			return nil, err
+		}
+	}
+	cmd := exec.Command(command, args...)
+	setProcAttr(cmd)
+	return cmd, nil
+}
+
+func parseProgress(str string) (int, string) {
+	s := strings.Index(str, " ")
+	e := strings.Index(str, "%")
+	if s != -1 && e != -1 {
+		if p, err := strconv.Atoi(str[s+1 : e]); err == nil {
+			if p > 100 {
+				p = 100
+			}
+			if e < len(str)-2 {
+				return p, str[e+2:]
+			}
+			return p, ""
+		}
+	}
+	return -1, ""
+}
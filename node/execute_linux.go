@@ -1,56 +1,79 @@
 package node
 
 import (
-	"bufio"
-	"encoding/json"
-	"io"
-	"log"
+	"fmt"
+	"os"
 	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
 	"syscall"
 
-	"github.com/qianxiaoming/lightsched/message"
-	"github.com/qianxiaoming/lightsched/model"
+	"github.com/qianxiaoming/lightsched/util"
 )
 
-func (node *NodeServer) runExecuteTask(msg message.JSON) {
-	task := &model.Task{}
-	if err := json.Unmarshal(msg.Content, task); err != nil {
-		// TODO 这里应该加入对API Server的回应：Task执行失败
-		log.Printf("Unable to unmarshal task json: %v", err)
-	} else {
-		log.Printf("Execute task(%s) program: %s %s", task.ID, task.Command, task.Args)
-		cmd := exec.Command(task.Command, task.Args)
-		cmd.SysProcAttr = &syscall.SysProcAttr{HideWindow: true, Setpgid: true}
-		// for linux TODO
-		// cmd.SysProcAttr = &syscall.SysProcAttr{HideWindow: true, Setpgid: true}
-
-		if len(task.WorkDir) > 0 {
-			cmd.Dir = task.WorkDir
-		}
-		if len(task.Envs) > 0 {
-			cmd.Env = task.Envs
-		}
-		stdout, err := cmd.StdoutPipe()
-		if err != nil {
-			log.Printf("Cannot get standard output pipe: %v", err)
-			// TODO
-		}
-		cmd.Stderr = cmd.Stdout
-		if err := cmd.Start(); err != nil {
-			log.Printf("Cannot start program for task(%s): %v", task.ID, err)
-			// TODO
-		}
+// runAsSupported 表示节点是否支持指定任务的执行用户
+const runAsSupported = true
 
-		reader := bufio.NewReader(stdout)
-		for {
-			line, err := reader.ReadString('\n')
-			if err != nil || io.EOF == err {
-				break
-			}
-			log.Println(">>>>", line)
-		}
-		if err := cmd.Wait(); err != nil {
+// setProcAttr 设置任务进程的平台相关属性
+func setProcAttr(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// killProcessTree 结束任务进程所在的进程组。shell模式下任务的实际程序是/bin/sh的子进程，
+// 只结束/bin/sh会使其继续运行并占用输出管道。
+func killProcessTree(p *os.Process) error {
+	if err := syscall.Kill(-p.Pid, syscall.SIGKILL); err != nil {
+		return p.Kill()
+	}
+	return nil
+}
+
+// shellCommand 创建通过/bin/sh执行命令行的进程
+func shellCommand(line string) *exec.Cmd {
+	cmd := exec.Command("/bin/sh", "-c", line)
+	setProcAttr(cmd)
+	return cmd
+}
+
+// splitCommandLine 按照POSIX shell的引号规则拆分参数字符串
+func splitCommandLine(line string) ([]string, error) {
+	return util.SplitPosixArgs(line)
+}
+
+// joinCommandLine 按照POSIX shell的引号规则拼接参数列表
+func joinCommandLine(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = util.QuotePosixArg(arg)
+	}
+	return strings.Join(quoted, " ")
+}
 
+// lookupCredential 将用户名或uid:gid解析为进程的执行用户
+func lookupCredential(name string) (*Credential, error) {
+	var uid, gid string
+	if i := strings.Index(name, ":"); i != -1 {
+		uid, gid = name[:i], name[i+1:]
+	} else {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return nil, err
 		}
+		uid, gid = u.Uid, u.Gid
+	}
+	id, err := strconv.ParseUint(uid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid uid in %s", name)
 	}
+	group, err := strconv.ParseUint(gid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gid in %s", name)
+	}
+	return &Credential{Uid: uint32(id), Gid: uint32(group)}, nil
+}
+
+// setCredential 设置任务进程的执行用户
+func setCredential(cmd *exec.Cmd, cred *Credential) {
+	cmd.SysProcAttr.Credential = &syscall.Credential{Uid: cred.Uid, Gid: cred.Gid}
 }
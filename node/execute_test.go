@@ -0,0 +1,68 @@
+//go:build !windows
+// +build !windows
+
+package node
+
+import (
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/qianxiaoming/lightsched/model"
+)
+
+func TestResolveCommandShell(t *testing.T) {
+	cases := []struct {
+		task *model.Task
+		line string
+	}{
+		{&model.Task{Shell: true, Command: "curl -o out http://host/f"}, "curl -o out http://host/f"},
+		{&model.Task{Shell: true, Command: "ls a//b ./c/../d"}, "ls a//b ./c/../d"},
+		{&model.Task{Shell: true, Command: "echo", Argv: []string{"http://host", "a b"}}, "echo http://host 'a b'"},
+	}
+	for _, c := range cases {
+		cmd, err := resolveCommand(c.task)
+		if err != nil {
+			t.Fatalf("resolveCommand(%q): %v", c.task.Command, err)
+		}
+		if want := []string{"/bin/sh", "-c", c.line}; !reflect.DeepEqual(cmd.Args, want) {
+			t.Errorf("resolveCommand(%q) = %q, want %q", c.task.Command, cmd.Args, want)
+		}
+	}
+	// 非shell模式下仍然规范化程序的路径
+	cmd, err := resolveCommand(&model.Task{Command: "/bin//./sh", Argv: []string{"http://host"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"/bin/sh", "http://host"}; cmd.Path != "/bin/sh" || !reflect.DeepEqual(cmd.Args, want) {
+		t.Errorf("resolveCommand in argv mode = %s %q", cmd.Path, cmd.Args)
+	}
+}
+
+func TestKillProcessTree(t *testing.T) {
+	// shell的子进程继承了输出管道，只结束shell时读取输出不会结束
+	cmd := shellCommand("sleep 30; echo done")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if err := killProcessTree(cmd.Process); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		ioutil.ReadAll(stdout)
+		cmd.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("output of the killed task is still open")
+	}
+}
@@ -1,131 +1,62 @@
 package node
 
 import (
-	"bufio"
-	"bytes"
-	"encoding/json"
-	"fmt"
-	"io"
-	"log"
-	"net/http"
+	"errors"
+	"os"
 	"os/exec"
-	"path/filepath"
 	"strconv"
 	"strings"
 	"syscall"
 
-	"github.com/qianxiaoming/lightsched/message"
-	"github.com/qianxiaoming/lightsched/model"
 	"github.com/qianxiaoming/lightsched/util"
 )
 
-func (node *NodeServer) runExecuteTask(msg *message.JSON) {
-	task := &model.Task{}
-	if err := json.Unmarshal(msg.Content, task); err != nil {
-		log.Printf("NOTICE: Unable to unmarshal task json and just ignore it now: %v\n", err)
-	} else {
-		command := task.Command
-		workdir := task.WorkDir
-		if !util.PathExists(command) {
-			command = filepath.Join(filepath.Dir(util.GetCurrentPath()), command)
-			if !util.PathExists(command) {
-				log.Printf("Cannot find program for task(%s): %s does not exist\n", task.ID, task.Command)
-				node.notifyTaskStatus(task.ID, model.TaskAborted, nil, 0, 0, "Program does not exist")
-				return
-			}
-			workdir = filepath.Dir(command)
-		}
-		log.Printf("Execute task(%s) program: %s %s\n", task.ID, command, task.Args)
-		cmd := exec.Command(command, strings.Split(task.Args, " ")...)
-		cmd.SysProcAttr = &syscall.SysProcAttr{HideWindow: true}
+// runAsSupported 表示节点是否支持指定任务的执行用户
+const runAsSupported = false
 
-		if len(workdir) > 0 {
-			cmd.Dir = workdir
-		}
-		if len(task.Envs) > 0 {
-			cmd.Env = task.Envs
-		}
-		stdout, err := cmd.StdoutPipe()
-		if err != nil {
-			log.Printf("Cannot get standard output pipe for task(%s): %v\n", task.ID, err)
-			node.notifyTaskStatus(task.ID, model.TaskAborted, nil, 0, 0, err.Error())
-			return
-		}
-		cmd.Stderr = cmd.Stdout
-		if err := cmd.Start(); err != nil {
-			log.Printf("Cannot start program for task(%s): %v\n", task.ID, err)
-			node.notifyTaskStatus(task.ID, model.TaskAborted, nil, 0, 0, err.Error())
-			return
-		}
-		node.notifyTaskStatus(task.ID, model.TaskExecuting, cmd.Process, 0, 0, "")
+// setProcAttr 设置任务进程的平台相关属性
+func setProcAttr(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{HideWindow: true}
+}
 
-		var logs strings.Builder
-		progress := 0
-		reader := bufio.NewReader(stdout)
-		for {
-			line, err := reader.ReadString('\n')
-			if err != nil || io.EOF == err {
-				break
-			}
-			// 记录任务程序的输出
-			if strings.HasPrefix(line, "[PROGRESS]") {
-				cur, str := parseProgress(line)
-				if cur != -1 && cur != progress {
-					progress = cur
-					node.notifyTaskStatus(task.ID, model.TaskExecuting, cmd.Process, progress, 0, "")
-				}
-				if len(str) > 0 {
-					logs.WriteString(str)
-				}
-			} else if strings.HasPrefix(line, "[ERROR]") {
-				s := strings.Index(line, "]")
-				if s < len(line)-1 {
-					line = strings.Trim(line[s+1:], " ")
-					logs.WriteString(line)
-					line = strings.Trim(line, "\r\n")
-					node.notifyTaskStatus(task.ID, model.TaskExecuting, cmd.Process, progress, 0, line)
-				}
-			} else {
-				logs.WriteString(line)
-			}
-		}
-		if err := cmd.Wait(); err != nil {
-			if exit, ok := err.(*exec.ExitError); ok {
-				if exit.Success() {
-					log.Printf("Task(%s) program exit successfully\n", task.ID)
-					node.notifyTaskStatus(task.ID, model.TaskCompleted, nil, progress, 0, "")
-				} else {
-					log.Printf("Task(%s) program exit error: %v\n", task.ID, err)
-					node.notifyTaskStatus(task.ID, model.TaskFailed, nil, progress, exit.ExitCode(), exit.Error())
-				}
-			}
-		} else {
-			log.Printf("Task(%s) program exit successfully\n", task.ID)
-			node.notifyTaskStatus(task.ID, model.TaskCompleted, nil, progress, 0, "")
-		}
-		// 将日志发送给API Server
-		if logs.Len() > 0 && node.state != model.NodeUnknown {
-			url := fmt.Sprintf(node.config.LogURL, task.ID)
-			if _, err := http.Post(url, "text/plain", bytes.NewReader([]byte(logs.String()))); err != nil {
-				log.Printf("Unable to post logs for task %s: %v\n", task.ID, err)
-			}
-		}
+// killProcessTree 使用taskkill结束任务进程及其所有子进程，失败时只结束任务进程本身
+func killProcessTree(p *os.Process) error {
+	kill := exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(p.Pid))
+	setProcAttr(kill)
+	if err := kill.Run(); err != nil {
+		return p.Kill()
 	}
+	return nil
+}
+
+// shellCommand 创建通过cmd执行命令行的进程。cmd不能识别Go对参数的转义方式，因此直接设置完整的命令行，
+// 使用/s时cmd只去掉最外层的一对引号，命令行中的其它引号保持不变。
+func shellCommand(line string) *exec.Cmd {
+	cmd := exec.Command("cmd")
+	setProcAttr(cmd)
+	cmd.SysProcAttr.CmdLine = `cmd /s /c "` + line + `"`
+	return cmd
+}
+
+// splitCommandLine 按照Windows命令行规则拆分参数字符串
+func splitCommandLine(line string) ([]string, error) {
+	return util.SplitWindowsArgs(line), nil
 }
 
-func parseProgress(str string) (int, string) {
-	s := strings.Index(str, " ")
-	e := strings.Index(str, "%")
-	if s != -1 && e != -1 {
-		if p, err := strconv.Atoi(str[s+1 : e]); err == nil {
-			if p > 100 {
-				p = 100
-			}
-			if e < len(str)-2 {
-				return p, str[e+2:]
-			}
-			return p, ""
-		}
+// joinCommandLine 按照Windows命令行规则拼接参数列表
+func joinCommandLine(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = syscall.EscapeArg(arg)
 	}
-	return -1, ""
+	return strings.Join(quoted, " ")
+}
+
+// lookupCredential 在Windows节点上不支持指定任务的执行用户
+func lookupCredential(name string) (*Credential, error) {
+	return nil, errors.New("run_as is not supported on Windows nodes")
+}
+
+// setCredential 在Windows节点上不做任何处理
+func setCredential(cmd *exec.Cmd, cred *Credential) {
 }
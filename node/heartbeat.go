@@ -19,6 +19,7 @@ var errNodeNotRegistered error = errors.New("node not registered")
 func (node *NodeServer) sendHeartbeat() error {
 	cpu, _ := cpu.Percent(0, false)
 	mem, _ := mem.VirtualMemory()
+	node.sampleUsage()
 	var payload []*message.TaskReport
 	if len(node.heartbeat.payload) > 0 {
 		payload = make([]*message.TaskReport, 0, len(node.heartbeat.payload))
@@ -32,6 +33,7 @@ func (node *NodeServer) sendHeartbeat() error {
 		CPU:        cpu[0],
 		Memory:     mem.UsedPercent,
 		Executings: len(node.executings),
+		Scratch:    node.scratchUsage(),
 		Payload:    payload,
 	}
 	if request, err := json.Marshal(hb); err != nil {
@@ -39,7 +41,7 @@ func (node *NodeServer) sendHeartbeat() error {
 		log.Printf("%v\n", hb)
 		return err
 	} else {
-		if resp, err := http.Post(node.heartbeat.url, "application/json", bytes.NewReader(request)); err != nil {
+		if resp, err := node.post(node.heartbeat.url, "application/json", bytes.NewReader(request)); err != nil {
 			log.Printf("Send heartbeat failed with body length %d: %T %+v\n", len(request), err, err)
 			log.Printf("%s\n", string(request))
 			// 心跳发送失败时需要恢复原来的待发送信息
@@ -58,7 +60,7 @@ func (node *NodeServer) sendHeartbeat() error {
 					node.runServerMessages(msgs)
 				}
 			}
-			if resp.StatusCode == http.StatusNotFound {
+			if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusUnauthorized {
 				// 节点需要重新注册自己
 				log.Println("Not found this node in API Server, register self now")
 				return errNodeNotRegistered
@@ -77,14 +79,16 @@ func (node *NodeServer) runServerMessages(msgs []*message.JSON) {
 			jobid := msg.Object
 			log.Printf("Terminating job %s...\n", jobid)
 			for id, proc := range node.executings {
-				if strings.HasPrefix(id, jobid) && proc.process != nil {
+				if strings.HasPrefix(id, jobid+".") && proc.process != nil {
 					log.Printf("  Killing task(%s) process %d...\n", id, proc.process.Pid)
-					if err := proc.process.Kill(); err != nil {
+					if err := killProcessTree(proc.process); err != nil {
 						log.Printf("Cannot kill the task process of Job(%s): %v\n", jobid, err)
 					} else {
-						node.executings[id] = TaskProcess{proc.process, true}
+						proc.killed = true
+						node.executings[id] = proc
 						log.Printf("  Process %d killed\n", proc.process.Pid)
 					}
+					node.stopTask(id)
 				}
 			}
 		}
@@ -0,0 +1,114 @@
+package node
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/qianxiaoming/lightsched/model"
+	"github.com/qianxiaoming/lightsched/util"
+)
+
+const (
+	// inputDirEnv 是传递给任务程序的输入文件目录的环境变量名
+	inputDirEnv = "LIGHTSCHED_INPUT_DIR"
+)
+
+// stageInputs 将Task的输入文件下载到指定目录。文件按照内容的SHA256值缓存在节点上，不同的Task可以共享。
+func (node *NodeServer) stageInputs(task *model.Task, dir string) error {
+	if len(task.Inputs) == 0 {
+		return nil
+	}
+	cacheDir := filepath.Join(node.config.WorkPath, "cache")
+	if err := util.MakeDirAll(cacheDir); err != nil {
+		return err
+	}
+	for _, input := range task.Inputs {
+		target, err := util.SafeJoin(dir, input.Path)
+		if err != nil {
+			return fmt.Errorf("illegal input path %s: %v", input.Path, err)
+		}
+		cached := ""
+		if len(input.Hash) > 0 {
+			cached = filepath.Join(cacheDir, input.Hash)
+		}
+		if len(cached) == 0 || !util.PathExists(cached) {
+			if cached, err = node.downloadInput(input, cacheDir); err != nil {
+				return fmt.Errorf("unable to download input %s: %v", input.Source, err)
+			}
+		} else {
+			log.Printf("Input %s of task %s found in cache\n", input.Path, task.ID)
+		}
+		if err := util.MakeDirAll(filepath.Dir(target)); err != nil {
+			return err
+		}
+		if err := copyFile(cached, target); err != nil {
+			return fmt.Errorf("unable to stage input %s: %v", input.Path, err)
+		}
+	}
+	log.Printf("%d input file(s) of task %s staged in %s\n", len(task.Inputs), task.ID, dir)
+	return nil
+}
+
+// downloadInput 下载输入文件到缓存目录，返回缓存文件的路径
+func (node *NodeServer) downloadInput(input *model.InputFile, cacheDir string) (string, error) {
+	url := input.Source
+	if strings.HasPrefix(url, "/") {
+		url = node.config.ServerURL + url
+	}
+	resp, err := node.get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("server responds with status %d", resp.StatusCode)
+	}
+
+	tmp, err := os.OpenFile(filepath.Join(cacheDir, util.GenerateUUID()+".tmp"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmp, h), resp.Body)
+	tmp.Close()
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	hash := hex.EncodeToString(h.Sum(nil))
+	if len(input.Hash) > 0 && input.Hash != hash {
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("content hash mismatched")
+	}
+	cached := filepath.Join(cacheDir, hash)
+	if err := os.Rename(tmp.Name(), cached); err != nil {
+		os.Remove(tmp.Name())
+		if !util.PathExists(cached) {
+			return "", err
+		}
+	}
+	return cached, nil
+}
+
+// copyFile 将缓存的文件复制到目标位置。不使用硬链接是为了避免任务程序修改缓存内容。
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0666)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	_, err = io.Copy(out, in)
+	return err
+}
@@ -0,0 +1,78 @@
+package node
+
+import (
+	"log"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/qianxiaoming/lightsched/metrics"
+	"github.com/shirou/gopsutil/process"
+)
+
+// nodeMetrics 包含节点统计的各项指标
+type nodeMetrics struct {
+	registry *metrics.Registry
+	logBytes *metrics.Counter
+	tasks    atomic.Value // 正在运行的Task及其进程号，类型为map[string]int32
+}
+
+// newNodeMetrics 创建并注册节点的所有指标
+func newNodeMetrics() *nodeMetrics {
+	m := &nodeMetrics{
+		registry: metrics.NewRegistry(),
+		logBytes: metrics.NewCounter("lightsched_node_log_bytes_uploaded_total", "Bytes of task logs uploaded to the API server."),
+	}
+	m.tasks.Store(map[string]int32{})
+	m.registry.Register(
+		metrics.NewGaugeFunc("lightsched_node_running_tasks", "Number of tasks running on this node.", nil, func(emit func(float64, ...string)) {
+			emit(float64(len(m.runningTasks())))
+		}),
+		metrics.NewGaugeFunc("lightsched_node_task_cpu_percent", "CPU usage of running task processes in percent.", []string{"task"}, func(emit func(float64, ...string)) {
+			for id, pid := range m.runningTasks() {
+				if proc, err := process.NewProcess(pid); err == nil {
+					if percent, err := proc.CPUPercent(); err == nil {
+						emit(percent, id)
+					}
+				}
+			}
+		}),
+		metrics.NewGaugeFunc("lightsched_node_task_memory_bytes", "Resident memory of running task processes in bytes.", []string{"task"}, func(emit func(float64, ...string)) {
+			for id, pid := range m.runningTasks() {
+				if proc, err := process.NewProcess(pid); err == nil {
+					if info, err := proc.MemoryInfo(); err == nil {
+						emit(float64(info.RSS), id)
+					}
+				}
+			}
+		}),
+		m.logBytes,
+	)
+	return m
+}
+
+func (m *nodeMetrics) runningTasks() map[string]int32 {
+	return m.tasks.Load().(map[string]int32)
+}
+
+// updateTasks 记录正在运行的Task进程，只能在节点的主循环中调用
+func (m *nodeMetrics) updateTasks(executings map[string]TaskProcess) {
+	tasks := make(map[string]int32, len(executings))
+	for id, proc := range executings {
+		if proc.process != nil {
+			tasks[id] = int32(proc.process.Pid)
+		}
+	}
+	m.tasks.Store(tasks)
+}
+
+// serveMetrics 在配置的地址上提供Prometheus格式的指标
+func (node *NodeServer) serveMetrics() {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", node.metrics.registry)
+	go func() {
+		log.Printf("Start metrics service on \"%s\"\n", node.config.Metrics)
+		if err := http.ListenAndServe(node.config.Metrics, mux); err != nil {
+			log.Printf("Cannot serve metrics on %s: %v\n", node.config.Metrics, err)
+		}
+	}()
+}
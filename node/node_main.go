@@ -7,6 +7,7 @@ import (
 	"io"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/exec"
@@ -14,6 +15,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -28,16 +30,30 @@ import (
 
 // Config 是Node Server的配置信息
 type Config struct {
-	Apiserver string        `json:"server"`
-	Hostname  string        `json:"hostname"`
-	Heartbeat time.Duration `json:"-"`
-	LogPath   string        `json:"log_path"`
-	LogURL    string        `json:"-"`
+	Apiserver  string            `json:"server"`
+	Hostname   string            `json:"hostname"`
+	Heartbeat  time.Duration     `json:"-"`
+	LogPath    string            `json:"log_path"`
+	WorkPath   string            `json:"work_path"`
+	Cleanup    string            `json:"cleanup"`
+	Runtimes   map[string]string `json:"runtimes,omitempty"`    // 容器执行器名字及对应的命令行工具路径
+	RunAs      []string          `json:"run_as,omitempty"`      // 允许任务使用的执行用户，"*"表示任意用户
+	JoinToken  string            `json:"join_token,omitempty"`  // 加入集群使用的token
+	TLS        bool              `json:"tls,omitempty"`         // 是否使用HTTPS访问API Server
+	ServerCert string            `json:"server_cert,omitempty"` // API Server节点服务端口的证书，指定时使用TLS并固定该证书
+	ServerCA   string            `json:"server_ca,omitempty"`   // 校验API Server证书的CA，为空时使用系统CA
+	ClientCert string            `json:"client_cert,omitempty"` // 节点的客户端证书，API Server要求客户端证书时使用
+	ClientKey  string            `json:"client_key,omitempty"`  // 节点客户端证书的私钥
+	Metrics    string            `json:"metrics,omitempty"`     // 提供Prometheus指标的监听地址，为空时不提供
+	ServerURL  string            `json:"-"`
+	LogURL     string            `json:"-"`
+	OutputURL  string            `json:"-"`
 }
 
 type TaskUpdate struct {
 	status  *message.TaskReport
 	process *os.Process
+	cpuTime float64 // 结束的任务进程及其已回收的子进程使用的CPU时间
 }
 
 type Heartbeat struct {
@@ -47,8 +63,9 @@ type Heartbeat struct {
 }
 
 type TaskProcess struct {
-	process *os.Process
-	killed  bool
+	process  *os.Process
+	killed   bool
+	progress int // 最近上报的执行进度
 }
 
 // NodeServer 是集群的工作节点服务。每个执行任务的节点上部署1个NodeServer。
@@ -60,7 +77,16 @@ type NodeServer struct {
 	state       model.NodeState
 	registering bool
 	heartbeat   Heartbeat
-	executings  map[string]TaskProcess // 正在运行的Task信息
+	executings  map[string]TaskProcess   // 正在运行的Task信息
+	usages      map[string]*usageSampler // 正在运行的Task的资源使用采样
+	scratch     ScratchUsage             // 任务临时目录的磁盘占用
+	restPort    int                      // API Server对外的RESTful API端口
+	runtimes    map[string]TaskRuntime   // 节点可用的任务执行器
+	stoppers    sync.Map                 // 需要额外操作才能结束的任务（如容器）
+	inspectors  sync.Map                 // 容器任务获取容器主进程的函数，用于采样资源使用量
+	client      *http.Client             // 访问API Server的HTTP客户端
+	secret      string                   // 注册时API Server分配的会话密钥
+	metrics     *nodeMetrics             // 节点的统计指标
 	update      chan *TaskUpdate
 }
 
@@ -82,18 +108,27 @@ func NewNodeServer(confPath string, apiserver string, hostname string, heartbeat
 			if len(conf.Hostname) == 0 {
 				conf.Hostname, _ = os.Hostname()
 			}
+			if len(conf.WorkPath) == 0 {
+				conf.WorkPath, _ = filepath.Abs("work")
+			}
+			if len(conf.Cleanup) == 0 {
+				conf.Cleanup = model.CleanupAlways
+			}
 		}
 	} else {
 		log.Println("No configuration file found and default setting will be used")
 	}
 	if conf == nil {
 		logPath, _ := filepath.Abs("log")
+		workPath, _ := filepath.Abs("work")
 		name, _ := os.Hostname()
 		conf = &Config{
 			Apiserver: os.Getenv("LIGHTSCHED_APISERVER"),
 			Hostname:  name,
 			Heartbeat: time.Second * 2,
 			LogPath:   logPath,
+			WorkPath:  workPath,
+			Cleanup:   model.CleanupAlways,
 		}
 		if len(conf.Apiserver) == 0 {
 			conf.Apiserver = fmt.Sprintf("127.0.0.1:%d", constant.DefaultNodePort)
@@ -106,7 +141,9 @@ func NewNodeServer(confPath string, apiserver string, hostname string, heartbeat
 	if len(apiserver) != 0 {
 		conf.Apiserver = apiserver
 	}
-	conf.LogURL = "http://" + conf.Apiserver + "/tasks/%s/log"
+	conf.ServerURL = conf.serverScheme() + conf.Apiserver
+	conf.LogURL = conf.ServerURL + "/tasks/%s/log"
+	conf.OutputURL = conf.ServerURL + "/tasks/%s/artifacts/%s"
 	if len(hostname) != 0 {
 		conf.Hostname = hostname
 	}
@@ -129,16 +166,24 @@ func NewNodeServer(confPath string, apiserver string, hostname string, heartbeat
 		}
 	}
 
+	client, err := newHTTPClient(conf)
+	if err != nil {
+		log.Printf("Unable to load TLS certificates: %v\n", err)
+		return nil
+	}
 	return &NodeServer{
 		config:      *conf,
+		client:      client,
 		state:       model.NodeUnknown,
 		registering: false,
 		heartbeat: Heartbeat{
 			errors:  0,
-			url:     "http://" + conf.Apiserver + "/heartbeat",
+			url:     conf.ServerURL + "/heartbeat",
 			payload: make(map[string]*message.TaskReport),
 		},
 		executings: make(map[string]TaskProcess),
+		usages:     make(map[string]*usageSampler),
+		metrics:    newNodeMetrics(),
 		update:     make(chan *TaskUpdate, 32),
 	}
 }
@@ -149,7 +194,15 @@ func (node *NodeServer) Run(cpustr string, gpustr string, memorystr string, labe
 	log.Printf("    API Server:    %s", node.config.Apiserver)
 	log.Printf("    Host Name:     %s", node.config.Hostname)
 	log.Printf("    Log Path:      %s", node.config.LogPath)
+	log.Printf("    Work Path:     %s", node.config.WorkPath)
+	log.Printf("    Cleanup:       %s", node.config.Cleanup)
 	log.Printf("    Heartbeat:     %s", node.config.Heartbeat)
+	if len(node.config.RunAs) > 0 {
+		log.Printf("    Run As:        %s", strings.Join(node.config.RunAs, ","))
+		if !runAsSupported {
+			log.Println("    run_as is not supported on this platform, tasks with run_as will not be scheduled here")
+		}
+	}
 
 	// 记录传入的label信息
 	if len(labelstr) > 0 {
@@ -169,9 +222,16 @@ func (node *NodeServer) Run(cpustr string, gpustr string, memorystr string, labe
 		return 1
 	}
 
+	// 确定节点可用的容器执行器
+	node.detectRuntimes()
+
+	if len(node.config.Metrics) > 0 {
+		node.serveMetrics()
+	}
+
 	// 启动定时器并等待系统中断信号
 	timer := time.NewTimer(node.config.Heartbeat)
-	quit := make(chan os.Signal)
+	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
 
 	stopped := false
@@ -181,8 +241,17 @@ func (node *NodeServer) Run(cpustr string, gpustr string, memorystr string, labe
 			stopped = true
 		case update := <-node.update:
 			// 更新Task的最新状态。状态信息将在心跳中统一发给服务端。
+			if model.IsFinishState(update.status.State) {
+				update.status.Usage = node.finishUsage(update.status.ID, update.cpuTime)
+			}
 			if last, ok := node.heartbeat.payload[update.status.ID]; ok && last.State == update.status.State {
 				last.Progress = update.status.Progress
+				if update.status.Output != nil {
+					last.Output = update.status.Output
+				}
+				if update.status.Usage != nil {
+					last.Usage = update.status.Usage
+				}
 				if len(update.status.Error) > 0 {
 					if len(last.Error) == 0 {
 						last.Error = update.status.Error
@@ -198,6 +267,9 @@ func (node *NodeServer) Run(cpustr string, gpustr string, memorystr string, labe
 						update.status.Error = last.Error + ";" + update.status.Error
 					}
 				}
+				if ok && update.status.Output == nil {
+					update.status.Output = last.Output
+				}
 				node.heartbeat.payload[update.status.ID] = update.status
 			}
 			// 检查是否在“正在运行任务”列表中
@@ -207,9 +279,12 @@ func (node *NodeServer) Run(cpustr string, gpustr string, memorystr string, labe
 						node.heartbeat.payload[update.status.ID].State = model.TaskTerminated
 					}
 					delete(node.executings, update.status.ID)
+				} else {
+					proc.progress = update.status.Progress
+					node.executings[update.status.ID] = proc
 				}
 			} else if update.status.State == model.TaskExecuting && update.process != nil {
-				node.executings[update.status.ID] = TaskProcess{update.process, false}
+				node.executings[update.status.ID] = TaskProcess{process: update.process, progress: update.status.Progress}
 			}
 		case <-timer.C:
 			timeout := node.config.Heartbeat
@@ -237,14 +312,16 @@ func (node *NodeServer) Run(cpustr string, gpustr string, memorystr string, labe
 							for id, proc := range node.executings {
 								if proc.process != nil {
 									log.Printf("  Killing task(%s) process %d...\n", id, proc.process.Pid)
-									if err := proc.process.Kill(); err != nil {
+									if err := killProcessTree(proc.process); err != nil {
 										log.Printf("Cannot kill the task process: %v\n", err)
 									} else {
 										log.Printf("  Process %d killed\n", proc.process.Pid)
 									}
+									node.stopTask(id)
 								}
 							}
 							node.executings = make(map[string]TaskProcess)
+							node.usages = make(map[string]*usageSampler)
 							node.heartbeat.payload = make(map[string]*message.TaskReport)
 						} else {
 							timeout = node.config.Heartbeat * 2
@@ -254,12 +331,25 @@ func (node *NodeServer) Run(cpustr string, gpustr string, memorystr string, labe
 			}
 			timer.Reset(timeout)
 		}
+		node.metrics.updateTasks(node.executings)
 	}
 	log.Println("Server exited")
 	return 0
 }
 
-func (node *NodeServer) notifyTaskStatus(id string, state model.TaskState, process *os.Process, progress, exit int, err string) {
+// stopTask 执行任务额外的结束操作，例如停止任务所在的容器
+func (node *NodeServer) stopTask(id string) {
+	if stop, ok := node.stoppers.Load(id); ok {
+		stop.(func())()
+	}
+}
+
+func (node *NodeServer) notifyTaskStatus(id string, state model.TaskState, process *os.Process, progress, exit int, err string, output *model.TaskOutput) {
+	node.notifyTaskFinished(id, state, process, progress, exit, err, output, 0)
+}
+
+// notifyTaskFinished 与notifyTaskStatus相同，同时上报任务进程结束时统计的CPU时间
+func (node *NodeServer) notifyTaskFinished(id string, state model.TaskState, process *os.Process, progress, exit int, err string, output *model.TaskOutput, cpuTime float64) {
 	if node.state == model.NodeUnknown {
 		return
 	}
@@ -270,8 +360,10 @@ func (node *NodeServer) notifyTaskStatus(id string, state model.TaskState, proce
 			Progress: progress,
 			ExitCode: exit,
 			Error:    err,
+			Output:   output,
 		},
 		process: process,
+		cpuTime: cpuTime,
 	}
 }
 
@@ -390,6 +482,18 @@ func (node *NodeServer) collectSystemResources(cpustr string, gpustr string, mem
 	return nil
 }
 
+// restAddress 返回API Server对外的RESTful API地址。未知时返回节点连接的地址。
+func (node *NodeServer) restAddress() string {
+	if node.restPort == 0 {
+		return node.config.Apiserver
+	}
+	host, _, err := net.SplitHostPort(node.config.Apiserver)
+	if err != nil {
+		return node.config.Apiserver
+	}
+	return net.JoinHostPort(host, strconv.Itoa(node.restPort))
+}
+
 func (node *NodeServer) registerSelf() error {
 	if node.state == model.NodeOffline {
 		return nil
@@ -398,18 +502,37 @@ func (node *NodeServer) registerSelf() error {
 		log.Printf("Register node to API Server %s as %s...\n", node.config.Apiserver, node.config.Hostname)
 		node.registering = true
 	}
+	runtimes, images := node.runtimeCapabilities()
 	msg := &message.RegisterNode{
 		Name:      node.config.Hostname,
 		Platform:  node.platform,
 		Labels:    node.labels,
+		Runtimes:  runtimes,
+		Images:    images,
+		RunAs:     node.reportRunAs(),
 		Resources: node.resources,
 	}
 	content, _ := json.Marshal(msg)
-	if resp, err := http.Post("http://"+node.config.Apiserver+"/nodes", "application/json", bytes.NewReader(content)); err == nil {
+	req, _ := http.NewRequest(http.MethodPost, node.config.ServerURL+"/nodes", bytes.NewReader(content))
+	req.Header.Set("Content-Type", "application/json")
+	if len(node.config.JoinToken) > 0 {
+		req.Header.Set("Authorization", "Bearer "+node.config.JoinToken)
+	}
+	if resp, err := node.client.Do(req); err == nil {
 		defer resp.Body.Close()
 		body, _ := ioutil.ReadAll(resp.Body)
 		if resp.StatusCode == http.StatusOK {
-			log.Printf("Node registered: %s\n", string(body))
+			// 注册结果中包含节点的会话密钥，日志中只输出集群和端口
+			reply := struct {
+				Cluster string `json:"cluster"`
+				Rest    int    `json:"rest"`
+				Secret  string `json:"secret"`
+			}{}
+			if err := json.Unmarshal(body, &reply); err == nil {
+				node.restPort = reply.Rest
+				node.secret = reply.Secret
+			}
+			log.Printf("Node registered to cluster %s with REST port %d\n", reply.Cluster, reply.Rest)
 			node.state = model.NodeOnline
 			node.heartbeat.errors = 0
 			node.registering = false
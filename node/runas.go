@@ -0,0 +1,48 @@
+package node
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// Credential 是任务进程的执行用户
+type Credential struct {
+	Uid uint32
+	Gid uint32
+}
+
+// allowRunAs 检查节点配置是否允许任务以指定用户执行
+func (node *NodeServer) allowRunAs(user string) bool {
+	for _, u := range node.config.RunAs {
+		if u == "*" || u == user {
+			return true
+		}
+	}
+	return false
+}
+
+// reportRunAs 返回注册时上报的允许执行用户，不支持指定执行用户的节点不上报
+func (node *NodeServer) reportRunAs() []string {
+	if !runAsSupported {
+		return nil
+	}
+	return node.config.RunAs
+}
+
+// resolveRunAs 解析Task指定的执行用户，同时将临时目录交给该用户
+func (node *NodeServer) resolveRunAs(user string, scratch string) (*Credential, error) {
+	cred, err := lookupCredential(user)
+	if err != nil {
+		return nil, err
+	}
+	err = filepath.Walk(scratch, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Lchown(path, int(cred.Uid), int(cred.Gid))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cred, nil
+}
@@ -0,0 +1,239 @@
+package node
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/qianxiaoming/lightsched/model"
+	"github.com/qianxiaoming/lightsched/util"
+)
+
+const (
+	// containerScratch 是任务临时目录在容器中的挂载路径
+	containerScratch = "/lightsched/scratch"
+	// containerOutput 是结构化输出文件在容器中的挂载路径
+	containerOutput = "/lightsched/output.jsonl"
+)
+
+// ExecContext 是启动任务进程时需要的上下文信息
+type ExecContext struct {
+	Scratch    string                // 任务在节点上的临时目录
+	WorkDir    string                // 任务指定的工作目录，为空时使用临时目录
+	OutputFile string                // 结构化输出文件在节点上的路径
+	Inputs     bool                  // 临时目录中是否有输入文件
+	Env        []string              // 任务指定的环境变量及调度器上下文变量，不含节点的环境变量
+	Credential *Credential           // 任务的执行用户，为空时使用节点程序的用户
+	Secrets    []string              // 任务引用的密钥，不能出现在命令行和日志中
+	Stop       func()                // 由执行器设置，在强制结束任务时调用
+	Inspect    func() (int32, error) // 由容器执行器设置，返回容器主进程在节点上的PID
+}
+
+// TaskRuntime 是启动任务进程的执行器接口
+type TaskRuntime interface {
+	// Name 返回执行器的名字，与TaskGroupSpec中的runtime对应
+	Name() string
+	// Command 根据任务信息创建要执行的命令
+	Command(task *model.Task, ctx *ExecContext) (*exec.Cmd, error)
+}
+
+// processRuntime 直接在节点上启动任务程序
+type processRuntime struct{}
+
+func (rt processRuntime) Name() string {
+	return ""
+}
+
+func (rt processRuntime) Command(task *model.Task, ctx *ExecContext) (*exec.Cmd, error) {
+	cmd, err := resolveCommand(task)
+	if err != nil {
+		return nil, err
+	}
+	if ctx.Credential != nil {
+		setCredential(cmd, ctx.Credential)
+	}
+	cmd.Dir = ctx.Scratch
+	if len(ctx.WorkDir) > 0 {
+		cmd.Dir = ctx.WorkDir
+	}
+	var env []string
+	if task.EnvMode != model.EnvClean {
+		env = os.Environ()
+	}
+	env = mergeEnv(env, ctx.Env)
+	env = mergeEnv(env, ctx.Secrets)
+	env = append(env, fmt.Sprintf("%s=%s", scratchDirEnv, ctx.Scratch), fmt.Sprintf("%s=%s", outputFileEnv, ctx.OutputFile))
+	if ctx.Inputs {
+		env = append(env, fmt.Sprintf("%s=%s", inputDirEnv, ctx.Scratch))
+	}
+	cmd.Env = env
+	return cmd, nil
+}
+
+// containerRuntime 通过docker兼容的容器命令行工具启动任务
+type containerRuntime struct {
+	name   string
+	binary string
+}
+
+func (rt *containerRuntime) Name() string {
+	return rt.name
+}
+
+func (rt *containerRuntime) Command(task *model.Task, ctx *ExecContext) (*exec.Cmd, error) {
+	if len(task.Image) == 0 {
+		return nil, fmt.Errorf("no image specified for runtime %s", rt.name)
+	}
+	// 预先创建输出文件，否则容器引擎会将挂载点创建为目录
+	if file, err := os.OpenFile(ctx.OutputFile, os.O_CREATE|os.O_WRONLY, 0666); err == nil {
+		file.Close()
+	} else {
+		return nil, err
+	}
+	if ctx.Credential != nil {
+		if err := os.Chown(ctx.OutputFile, int(ctx.Credential.Uid), int(ctx.Credential.Gid)); err != nil {
+			return nil, err
+		}
+	}
+	container := "lightsched-" + filepath.Base(ctx.Scratch)
+	args := []string{"run", "--rm", "--name", container,
+		"-v", ctx.Scratch + ":" + containerScratch,
+		"-v", ctx.OutputFile + ":" + containerOutput,
+		"-w", containerScratch}
+	if len(ctx.WorkDir) > 0 {
+		args[len(args)-1] = ctx.WorkDir
+	}
+	if ctx.Credential != nil {
+		args = append(args, "--user", fmt.Sprintf("%d:%d", ctx.Credential.Uid, ctx.Credential.Gid))
+	}
+	// 将分配给任务的资源转换为容器的资源限制
+	if task.Resources != nil {
+		if task.Resources.CPU.Cores > 0 {
+			args = append(args, fmt.Sprintf("--cpus=%g", task.Resources.CPU.Cores))
+		}
+		if task.Resources.Memory > 0 {
+			args = append(args, fmt.Sprintf("--memory=%dm", task.Resources.Memory))
+		}
+		if task.Resources.GPU.Cards > 0 {
+			args = append(args, "--gpus", fmt.Sprintf("%d", task.Resources.GPU.Cards))
+		}
+	}
+	env := append(ctx.Env, scratchDirEnv+"="+containerScratch, outputFileEnv+"="+containerOutput)
+	if ctx.Inputs {
+		env = append(env, inputDirEnv+"="+containerScratch)
+	}
+	for _, kv := range env {
+		args = append(args, "-e", kv)
+	}
+	// 密钥只传递变量名，由容器命令行工具从自身的环境变量中读取值
+	for _, kv := range ctx.Secrets {
+		args = append(args, "-e", kv[:strings.Index(kv, "=")])
+	}
+	args = append(args, task.Image)
+
+	// 容器中总是按照POSIX shell的规则处理命令行
+	if task.Shell {
+		line := task.Command
+		if len(task.Argv) > 0 {
+			quoted := make([]string, len(task.Argv))
+			for i, arg := range task.Argv {
+				quoted[i] = util.QuotePosixArg(arg)
+			}
+			line = line + " " + strings.Join(quoted, " ")
+		} else if len(task.Args) > 0 {
+			line = line + " " + task.Args
+		}
+		args = append(args, "/bin/sh", "-c", line)
+	} else {
+		if len(task.Command) > 0 {
+			args = append(args, task.Command)
+		}
+		if len(task.Argv) > 0 {
+			args = append(args, task.Argv...)
+		} else {
+			argv, err := util.SplitPosixArgs(task.Args)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, argv...)
+		}
+	}
+	cmd := exec.Command(rt.binary, args...)
+	setProcAttr(cmd)
+	if len(ctx.Secrets) > 0 {
+		cmd.Env = append(os.Environ(), ctx.Secrets...)
+	}
+	ctx.Stop = func() {
+		if err := exec.Command(rt.binary, "kill", container).Run(); err != nil {
+			log.Printf("Unable to kill container %s: %v\n", container, err)
+		}
+	}
+	ctx.Inspect = func() (int32, error) {
+		output, err := exec.Command(rt.binary, "inspect", "--format", "{{.State.Pid}}", container).Output()
+		if err != nil {
+			return 0, err
+		}
+		pid, err := strconv.ParseInt(strings.TrimSpace(string(output)), 10, 32)
+		if err != nil {
+			return 0, err
+		}
+		if pid == 0 {
+			return 0, fmt.Errorf("container %s is not running", container)
+		}
+		return int32(pid), nil
+	}
+	return cmd, nil
+}
+
+// images 返回容器引擎在本地已有的镜像
+func (rt *containerRuntime) images() []string {
+	output, err := exec.Command(rt.binary, "images", "--format", "{{.Repository}}:{{.Tag}}").Output()
+	if err != nil {
+		log.Printf("Unable to list images of runtime %s: %v\n", rt.name, err)
+		return nil
+	}
+	images := make([]string, 0, 8)
+	for _, line := range strings.Split(string(output), "\n") {
+		line = strings.Trim(line, " \r\n")
+		if len(line) > 0 && !strings.Contains(line, "<none>") {
+			images = append(images, line)
+		}
+	}
+	return images
+}
+
+// detectRuntimes 根据配置确定节点上可用的容器执行器。未配置时尝试查找docker和podman。
+func (node *NodeServer) detectRuntimes() {
+	node.runtimes = map[string]TaskRuntime{"": processRuntime{}}
+	binaries := node.config.Runtimes
+	if len(binaries) == 0 {
+		binaries = map[string]string{model.RuntimeDocker: "docker", model.RuntimePodman: "podman"}
+	}
+	for name, binary := range binaries {
+		path, err := exec.LookPath(binary)
+		if err != nil {
+			continue
+		}
+		node.runtimes[name] = &containerRuntime{name: name, binary: path}
+		log.Printf("    Runtime:       %s (%s)", name, path)
+	}
+}
+
+// runtimeCapabilities 返回节点支持的容器执行器名字和本地镜像，用于节点注册
+func (node *NodeServer) runtimeCapabilities() ([]string, []string) {
+	var names, images []string
+	for name, rt := range node.runtimes {
+		if c, ok := rt.(*containerRuntime); ok {
+			names = append(names, name)
+			images = util.MergeStringSlice(images, c.images())
+		}
+	}
+	sort.Strings(names)
+	sort.Strings(images)
+	return names, images
+}
@@ -0,0 +1,189 @@
+//go:build !windows
+// +build !windows
+
+package node
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/qianxiaoming/lightsched/model"
+)
+
+// newFakeRuntime 创建一个记录命令行参数的容器命令行工具，images命令输出固定的镜像列表，
+// inspect命令输出固定的容器主进程PID
+func newFakeRuntime(t *testing.T, dir string) (*containerRuntime, string) {
+	record := filepath.Join(dir, "args.txt")
+	script := "#!/bin/sh\n" +
+		"if [ \"$1\" = images ]; then\n" +
+		"  printf 'busybox:latest\\n<none>:<none>\\nalpine:3.12\\n'\n" +
+		"  exit 0\n" +
+		"fi\n" +
+		"if [ \"$1\" = inspect ]; then\n" +
+		"  echo 4242\n" +
+		"  exit 0\n" +
+		"fi\n" +
+		"printf '%s\\n' \"$@\" >> " + record + "\n" +
+		"printf 'secret=%s\\n' \"$API_TOKEN\" >> " + record + "\n"
+	binary := filepath.Join(dir, "fake-docker")
+	if err := ioutil.WriteFile(binary, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return &containerRuntime{name: model.RuntimeDocker, binary: binary}, record
+}
+
+func readRecord(t *testing.T, record string) []string {
+	content, err := ioutil.ReadFile(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(record)
+	return strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+}
+
+func TestContainerRuntimeCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lightsched-runtime")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	rt, record := newFakeRuntime(t, dir)
+	scratch := filepath.Join(dir, "job.0.0-1")
+	if err := os.Mkdir(scratch, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	task := &model.Task{
+		ID:        "job.0.0",
+		Image:     "busybox:latest",
+		Runtime:   model.RuntimeDocker,
+		Command:   "/bin/echo",
+		Args:      `hello "big world"`,
+		Resources: &model.ResourceSet{CPU: model.ResourceCPU{Cores: 1.5}, Memory: 512, GPU: model.ResourceGPU{Cards: 1}},
+	}
+	ctx := &ExecContext{
+		Scratch:    scratch,
+		OutputFile: filepath.Join(dir, "output.jsonl"),
+		Inputs:     true,
+		Env:        []string{"LIGHTSCHED_JOB_ID=job"},
+		Secrets:    []string{"API_TOKEN=s3cr3t"},
+	}
+	cmd, err := rt.Command(task, ctx)
+	if err != nil {
+		t.Fatalf("Command returns error: %v", err)
+	}
+	if _, err := os.Stat(ctx.OutputFile); err != nil {
+		t.Errorf("output file is not created before the container starts: %v", err)
+	}
+	for _, arg := range cmd.Args {
+		if strings.Contains(arg, "s3cr3t") {
+			t.Errorf("secret value appears in the command line: %q", cmd.Args)
+		}
+	}
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("fake runtime fails: %v", err)
+	}
+	want := []string{"run", "--rm", "--name", "lightsched-job.0.0-1",
+		"-v", scratch + ":" + containerScratch,
+		"-v", ctx.OutputFile + ":" + containerOutput,
+		"-w", containerScratch,
+		"--cpus=1.5", "--memory=512m", "--gpus", "1",
+		"-e", "LIGHTSCHED_JOB_ID=job",
+		"-e", scratchDirEnv + "=" + containerScratch,
+		"-e", outputFileEnv + "=" + containerOutput,
+		"-e", inputDirEnv + "=" + containerScratch,
+		"-e", "API_TOKEN",
+		"busybox:latest", "/bin/echo", "hello", "big world",
+		"secret=s3cr3t"}
+	if args := readRecord(t, record); !reflect.DeepEqual(args, want) {
+		t.Errorf("run arguments:\n got %q\nwant %q", args, want)
+	}
+
+	// 采样资源使用量时查询容器主进程
+	if pid, err := ctx.Inspect(); err != nil || pid != 4242 {
+		t.Errorf("Inspect() = %d, %v", pid, err)
+	}
+	// 停止任务时通过命令行工具结束容器
+	ctx.Stop()
+	if args := readRecord(t, record); !reflect.DeepEqual(args, []string{"kill", "lightsched-job.0.0-1", "secret="}) {
+		t.Errorf("kill arguments: %q", args)
+	}
+}
+
+func TestContainerRuntimeShell(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lightsched-runtime")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	rt, record := newFakeRuntime(t, dir)
+
+	task := &model.Task{
+		ID:      "job.0.1",
+		Image:   "alpine:3.12",
+		Runtime: model.RuntimeDocker,
+		Command: "echo",
+		Argv:    []string{"it's", "$HOME"},
+		Shell:   true,
+	}
+	ctx := &ExecContext{Scratch: filepath.Join(dir, "job.0.1-1"), WorkDir: "/work", OutputFile: filepath.Join(dir, "output.jsonl")}
+	cmd, err := rt.Command(task, ctx)
+	if err != nil {
+		t.Fatalf("Command returns error: %v", err)
+	}
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("fake runtime fails: %v", err)
+	}
+	args := readRecord(t, record)
+	if i := indexOf(args, "-w"); i == -1 || args[i+1] != "/work" {
+		t.Errorf("working directory is not passed: %q", args)
+	}
+	tail := args[len(args)-5:]
+	want := []string{"alpine:3.12", "/bin/sh", "-c", `echo 'it'\''s' '$HOME'`, "secret="}
+	if !reflect.DeepEqual(tail, want) {
+		t.Errorf("shell arguments:\n got %q\nwant %q", tail, want)
+	}
+}
+
+func TestContainerRuntimeErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lightsched-runtime")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	rt, _ := newFakeRuntime(t, dir)
+	ctx := &ExecContext{Scratch: dir, OutputFile: filepath.Join(dir, "output.jsonl")}
+
+	if _, err := rt.Command(&model.Task{ID: "job.0.0", Runtime: model.RuntimeDocker, Command: "true"}, ctx); err == nil {
+		t.Error("task without image is accepted")
+	}
+	task := &model.Task{ID: "job.0.0", Image: "busybox", Runtime: model.RuntimeDocker, Command: "echo", Args: `"unterminated`}
+	if _, err := rt.Command(task, ctx); err == nil {
+		t.Error("malformed arguments are accepted")
+	}
+}
+
+func TestContainerRuntimeImages(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lightsched-runtime")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	rt, _ := newFakeRuntime(t, dir)
+	if images := rt.images(); !reflect.DeepEqual(images, []string{"busybox:latest", "alpine:3.12"}) {
+		t.Errorf("images: %q", images)
+	}
+}
+
+func indexOf(args []string, s string) int {
+	for i, a := range args {
+		if a == s {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,70 @@
+package node
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/qianxiaoming/lightsched/model"
+	"github.com/qianxiaoming/lightsched/util"
+)
+
+const (
+	// scratchDirEnv 是传递给任务程序的临时目录的环境变量名
+	scratchDirEnv = "LIGHTSCHED_SCRATCH"
+	// scratchScanInterval 是重新统计临时目录磁盘占用的最小间隔
+	scratchScanInterval = 30 * time.Second
+)
+
+// ScratchUsage 缓存了所有任务临时目录的磁盘占用
+type ScratchUsage struct {
+	sync.Mutex
+	bytes    int64
+	scanTime time.Time
+}
+
+// createScratch 为Task的本次执行创建独立的临时目录
+func (node *NodeServer) createScratch(task *model.Task) (string, error) {
+	dir := filepath.Join(node.config.WorkPath, "tasks", fmt.Sprintf("%s-%s", task.ID, util.GenerateUUID()[:8]))
+	if err := util.MakeDirAll(dir); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// cleanupScratch 根据Task的清理策略删除临时目录。未指定策略时使用节点的默认策略。
+func (node *NodeServer) cleanupScratch(task *model.Task, dir string, success bool) {
+	policy := task.Cleanup
+	if policy != model.CleanupAlways && policy != model.CleanupOnSuccess && policy != model.CleanupNever {
+		policy = node.config.Cleanup
+	}
+	if policy == model.CleanupNever || (policy == model.CleanupOnSuccess && !success) {
+		log.Printf("Scratch directory of task(%s) is kept in %s\n", task.ID, dir)
+		return
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		log.Printf("Unable to remove scratch directory %s: %v\n", dir, err)
+	}
+}
+
+// scratchUsage 返回所有任务临时目录占用的磁盘字节数
+func (node *NodeServer) scratchUsage() int64 {
+	node.scratch.Lock()
+	defer node.scratch.Unlock()
+	if time.Since(node.scratch.scanTime) < scratchScanInterval {
+		return node.scratch.bytes
+	}
+	var total int64
+	filepath.Walk(filepath.Join(node.config.WorkPath, "tasks"), func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			total += info.Size()
+		}
+		return nil
+	})
+	node.scratch.bytes = total
+	node.scratch.scanTime = time.Now()
+	return total
+}
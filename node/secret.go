@@ -0,0 +1,72 @@
+package node
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/qianxiaoming/lightsched/model"
+)
+
+// secretMask 是日志中替换密钥值的字符串
+const secretMask = "******"
+
+// fetchSecrets 在任务启动时从API Server获取引用的密钥值，返回环境变量列表。密钥值只保存在内存中。
+func (node *NodeServer) fetchSecrets(task *model.Task) ([]string, error) {
+	secrets := make([]string, 0, len(task.Secrets))
+	for env, name := range task.Secrets {
+		resp, err := node.get(fmt.Sprintf("%s/tasks/%s/secrets/%s", node.config.ServerURL, task.ID, url.PathEscape(name)))
+		if err != nil {
+			return nil, err
+		}
+		value, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("unable to get secret %s: server responds with status %d", name, resp.StatusCode)
+		}
+		secrets = append(secrets, env+"="+string(value))
+	}
+	return secrets, nil
+}
+
+// maskSecrets 将文本中出现的密钥值替换为掩码
+func maskSecrets(text string, secrets []string) string {
+	for _, kv := range secrets {
+		if pos := strings.Index(kv, "="); pos != -1 && pos < len(kv)-1 {
+			text = strings.Replace(text, kv[pos+1:], secretMask, -1)
+		}
+	}
+	return text
+}
+
+// maskOutput 返回结构化输出的副本，其中所有字符串中出现的密钥值都被替换为掩码
+func maskOutput(output *model.TaskOutput, secrets []string) *model.TaskOutput {
+	masked := output.Clone()
+	if len(secrets) == 0 {
+		return masked
+	}
+	masked.Status = maskSecrets(masked.Status, secrets)
+	if len(masked.Metrics) > 0 {
+		metrics := make(map[string]float64, len(masked.Metrics))
+		for k, v := range masked.Metrics {
+			metrics[maskSecrets(k, secrets)] = v
+		}
+		masked.Metrics = metrics
+	}
+	if len(masked.Results) > 0 {
+		results := make(map[string]string, len(masked.Results))
+		for k, v := range masked.Results {
+			results[maskSecrets(k, secrets)] = maskSecrets(v, secrets)
+		}
+		masked.Results = results
+	}
+	for i, a := range masked.Artifacts {
+		masked.Artifacts[i] = maskSecrets(a, secrets)
+	}
+	return masked
+}
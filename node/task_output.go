@@ -0,0 +1,94 @@
+package node
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/qianxiaoming/lightsched/model"
+)
+
+const (
+	// outputFileEnv 是传递给任务程序的JSON-lines输出文件路径的环境变量名
+	outputFileEnv = "LIGHTSCHED_OUTPUT_FILE"
+)
+
+// parseOutputLine 解析任务程序输出中的结构化行，返回是否为结构化行及是否更新了输出内容。支持的格式为：
+//
+//	[METRIC] name=value
+//	[STATUS] message
+//	[RESULT] key=value
+//	[ARTIFACT] path
+func parseOutputLine(line string, output *model.TaskOutput) (bool, bool) {
+	var kind string
+	for _, prefix := range []string{"[METRIC]", "[STATUS]", "[RESULT]", "[ARTIFACT]"} {
+		if strings.HasPrefix(line, prefix) {
+			kind = prefix
+			break
+		}
+	}
+	if len(kind) == 0 {
+		return false, false
+	}
+	content := strings.Trim(line[len(kind):], " \r\n")
+	if len(content) == 0 {
+		return true, false
+	}
+	update := &model.TaskOutput{}
+	switch kind {
+	case "[METRIC]":
+		kv := strings.SplitN(content, "=", 2)
+		if len(kv) != 2 {
+			return true, false
+		}
+		v, err := strconv.ParseFloat(strings.Trim(kv[1], " "), 64)
+		if err != nil {
+			return true, false
+		}
+		update.Metrics = map[string]float64{strings.Trim(kv[0], " "): v}
+	case "[STATUS]":
+		update.Status = content
+	case "[RESULT]":
+		kv := strings.SplitN(content, "=", 2)
+		if len(kv) != 2 {
+			return true, false
+		}
+		update.Results = map[string]string{strings.Trim(kv[0], " "): strings.Trim(kv[1], " ")}
+	case "[ARTIFACT]":
+		update.Artifacts = []string{content}
+	}
+	output.Merge(update)
+	return true, true
+}
+
+// readOutputFile 读取任务程序写入的JSON-lines输出文件，每行都是1个TaskOutput对象
+func readOutputFile(filename string, output *model.TaskOutput) bool {
+	file, err := os.Open(filename)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Unable to open output file %s: %v\n", filename, err)
+		}
+		return false
+	}
+	defer file.Close()
+
+	updated := false
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.Trim(scanner.Text(), " \r\n")
+		if len(line) == 0 {
+			continue
+		}
+		update := &model.TaskOutput{}
+		if err := json.Unmarshal([]byte(line), update); err != nil {
+			log.Printf("Illegal line in output file %s: %v\n", filename, err)
+			continue
+		}
+		output.Merge(update)
+		updated = true
+	}
+	return updated
+}
@@ -0,0 +1,97 @@
+package node
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/qianxiaoming/lightsched/model"
+)
+
+// logUploadInterval 是任务运行期间向API Server上传新增日志的间隔
+const logUploadInterval = 5 * time.Second
+
+// taskLog 缓存任务程序的输出，并定期将新增的内容追加到API Server上的日志中，使运行中的任务也能查看日志
+type taskLog struct {
+	sync.Mutex
+	node    *NodeServer
+	id      string
+	secrets []string
+	pending bytes.Buffer // 还未上传的日志内容
+	offset  int64        // 已经上传到API Server的字节数
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+func (node *NodeServer) newTaskLog(id string, secrets []string) *taskLog {
+	return &taskLog{node: node, id: id, secrets: secrets}
+}
+
+// WriteString 记录任务程序输出的一行内容。按行替换密钥值，保证密钥值不会被分割到两次上传中。
+func (l *taskLog) WriteString(s string) (int, error) {
+	l.Lock()
+	defer l.Unlock()
+	return l.pending.WriteString(maskSecrets(s, l.secrets))
+}
+
+// start 开始定期上传新增的日志，直到close被调用
+func (l *taskLog) start(interval time.Duration) {
+	l.stop, l.done = make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(l.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-l.stop:
+				return
+			case <-ticker.C:
+				if err := l.upload(); err != nil {
+					log.Printf("Unable to post logs for task %s: %v\n", l.id, err)
+				}
+			}
+		}
+	}()
+}
+
+// close 停止定期上传并上传剩余的日志。必须在上报Task的结束状态之前调用，客户端在Task结束后读取到的是完整日志。
+func (l *taskLog) close() {
+	if l.stop != nil {
+		close(l.stop)
+		<-l.done
+	}
+	if err := l.upload(); err != nil {
+		log.Printf("Unable to post logs for task %s: %v\n", l.id, err)
+	}
+}
+
+// upload 将缓存的内容追加到API Server上的日志中。上传时不持有锁，避免阻塞读取任务程序的输出；
+// 上传失败时保留缓存的内容，下次从相同的位置重新上传。
+func (l *taskLog) upload() error {
+	l.Lock()
+	content := l.pending.String()
+	offset := l.offset
+	l.Unlock()
+	if len(content) == 0 || l.node.state == model.NodeUnknown {
+		return nil
+	}
+	url := fmt.Sprintf(l.node.config.LogURL, l.id) + fmt.Sprintf("?offset=%d", offset)
+	resp, err := l.node.post(url, "text/plain", strings.NewReader(content))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("server returns %s", resp.Status)
+	}
+	l.Lock()
+	l.pending.Next(len(content))
+	l.offset += int64(len(content))
+	l.Unlock()
+	l.node.metrics.logBytes.Add(float64(len(content)))
+	return nil
+}
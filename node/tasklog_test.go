@@ -0,0 +1,86 @@
+package node
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// logServer 按offset参数保存分段上传的日志，failures不为0时拒绝相应次数的上传
+type logServer struct {
+	sync.Mutex
+	content  string
+	uploads  int
+	failures int
+}
+
+func (s *logServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+	body, _ := ioutil.ReadAll(r.Body)
+	if s.failures > 0 {
+		s.failures--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	offset, err := strconv.Atoi(r.URL.Query().Get("offset"))
+	if err != nil || offset > len(s.content) {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	s.content = s.content[:offset] + string(body)
+	s.uploads++
+}
+
+func (s *logServer) get() (string, int) {
+	s.Lock()
+	defer s.Unlock()
+	return s.content, s.uploads
+}
+
+func TestTaskLogUpload(t *testing.T) {
+	srv := &logServer{}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+	node := &NodeServer{client: &http.Client{}, metrics: newNodeMetrics()}
+	node.config.ServerURL = ts.URL
+	node.config.LogURL = ts.URL + "/tasks/%s/log"
+
+	logs := node.newTaskLog("job.0.0", []string{"TOKEN=s3cr3t"})
+	logs.start(10 * time.Millisecond)
+	logs.WriteString("line 1 s3cr3t\n")
+	// 任务运行期间上传新增的日志，其中的密钥值被替换
+	for i := 0; ; i++ {
+		if content, _ := srv.get(); content == "line 1 "+secretMask+"\n" {
+			break
+		}
+		if i == 500 {
+			t.Fatal("log of the running task is not uploaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	_, uploads := srv.get()
+	time.Sleep(50 * time.Millisecond)
+	if _, n := srv.get(); n != uploads {
+		t.Errorf("log without new content is uploaded again: %d uploads", n-uploads)
+	}
+
+	// 上传失败时保留内容，结束时上传剩余的日志
+	srv.Lock()
+	srv.failures = 1000
+	srv.Unlock()
+	logs.WriteString("line 2\n")
+	time.Sleep(50 * time.Millisecond)
+	srv.Lock()
+	srv.failures = 0
+	srv.Unlock()
+	logs.WriteString("line 3\n")
+	logs.close()
+	if content, _ := srv.get(); content != "line 1 "+secretMask+"\nline 2\nline 3\n" {
+		t.Errorf("uploaded log = %q", content)
+	}
+}
@@ -0,0 +1,183 @@
+package node
+
+import (
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/qianxiaoming/lightsched/constant"
+	"github.com/qianxiaoming/lightsched/message"
+	"github.com/qianxiaoming/lightsched/model"
+	"github.com/shirou/gopsutil/process"
+)
+
+// usageSampler 记录Task进程树的资源使用采样结果
+type usageSampler struct {
+	usage     model.TaskUsage
+	cpuTime   float64   // 上次采样时进程树的CPU时间
+	sampleAt  time.Time // 上次采样的时间
+	container int32     // 容器任务的容器主进程在节点上的PID，为0时还未获得
+}
+
+// processSample 是对进程树的一次采样
+type processSample struct {
+	cpuTime    float64
+	rss        int64
+	readBytes  int64
+	writeBytes int64
+	gpuMemory  int64
+}
+
+// sampleProcessTree 采样进程及其所有子进程的资源使用量。CPU时间包括已经退出并被回收的子进程。
+func sampleProcessTree(pid int32, gpuMemory map[int32]int64) *processSample {
+	root, err := process.NewProcess(pid)
+	if err != nil {
+		return nil
+	}
+	sample := &processSample{}
+	procs := []*process.Process{root}
+	for len(procs) > 0 {
+		proc := procs[len(procs)-1]
+		procs = procs[:len(procs)-1]
+		if times, err := proc.Times(); err == nil {
+			sample.cpuTime += times.User + times.System + childrenCPUTime(proc.Pid)
+		}
+		if mem, err := proc.MemoryInfo(); err == nil {
+			sample.rss += int64(mem.RSS)
+		}
+		if io, err := proc.IOCounters(); err == nil {
+			sample.readBytes += int64(io.ReadBytes)
+			sample.writeBytes += int64(io.WriteBytes)
+		}
+		sample.gpuMemory += gpuMemory[proc.Pid]
+		if children, err := proc.Children(); err == nil {
+			procs = append(procs, children...)
+		}
+	}
+	return sample
+}
+
+// gpuProcessMemory 通过nvidia-smi获取各进程占用的显存，单位Mi。节点没有GPU或无法查询时返回nil。
+func (node *NodeServer) gpuProcessMemory() map[int32]int64 {
+	if node.resources.GPU.Cards == 0 {
+		return nil
+	}
+	smiName := "nvidia-smi"
+	if node.platform.Kind == constant.PlatformWindows {
+		smiName = "nvidia-smi.exe"
+	}
+	smi, err := exec.LookPath(smiName)
+	if err != nil {
+		return nil
+	}
+	output, err := exec.Command(smi, "--query-compute-apps=pid,used_memory", "--format=csv,noheader,nounits").Output()
+	if err != nil {
+		return nil
+	}
+	result := make(map[int32]int64)
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Split(line, ",")
+		if len(fields) != 2 {
+			continue
+		}
+		pid, err1 := strconv.Atoi(strings.TrimSpace(fields[0]))
+		mem, err2 := strconv.ParseInt(strings.TrimSpace(fields[1]), 10, 64)
+		if err1 == nil && err2 == nil {
+			result[int32(pid)] += mem
+		}
+	}
+	return result
+}
+
+// sampleUsage 采样所有正在运行的Task的资源使用量，并加入心跳的上报信息中。只能在节点的主循环中调用。
+func (node *NodeServer) sampleUsage() {
+	if len(node.executings) == 0 {
+		return
+	}
+	gpuMemory := node.gpuProcessMemory()
+	now := time.Now()
+	for id, proc := range node.executings {
+		if proc.process == nil {
+			continue
+		}
+		s, ok := node.usages[id]
+		if !ok {
+			s = &usageSampler{}
+			node.usages[id] = s
+		}
+		// 容器任务的进程是容器命令行工具，实际的任务进程需要从容器主进程开始采样
+		pid := int32(proc.process.Pid)
+		if inspect, ok := node.inspectors.Load(id); ok {
+			if s.container == 0 {
+				s.container, _ = inspect.(func() (int32, error))()
+			}
+			if s.container == 0 {
+				continue
+			}
+			pid = s.container
+		}
+		sample := sampleProcessTree(pid, gpuMemory)
+		if sample == nil {
+			continue
+		}
+		if s.container != 0 {
+			if cpuTime, ok := cgroupCPUTime(pid); ok {
+				sample.cpuTime = cpuTime
+			}
+		}
+		if !s.sampleAt.IsZero() {
+			if elapsed := now.Sub(s.sampleAt).Seconds(); elapsed > 0 {
+				if cores := (sample.cpuTime - s.cpuTime) / elapsed; cores > s.usage.PeakCPU {
+					s.usage.PeakCPU = cores
+				}
+			}
+		}
+		s.cpuTime = sample.cpuTime
+		s.sampleAt = now
+		// 进程退出后其内存和IO不再计入进程树，因此累计值只增不减
+		if sample.cpuTime > s.usage.CPUSeconds {
+			s.usage.CPUSeconds = sample.cpuTime
+		}
+		if sample.rss > s.usage.PeakRSS {
+			s.usage.PeakRSS = sample.rss
+		}
+		if sample.readBytes > s.usage.ReadBytes {
+			s.usage.ReadBytes = sample.readBytes
+		}
+		if sample.writeBytes > s.usage.WriteBytes {
+			s.usage.WriteBytes = sample.writeBytes
+		}
+		if sample.gpuMemory > s.usage.PeakGPUMemory {
+			s.usage.PeakGPUMemory = sample.gpuMemory
+		}
+
+		if report, ok := node.heartbeat.payload[id]; ok {
+			report.Usage = s.usage.Clone()
+		} else {
+			node.heartbeat.payload[id] = &message.TaskReport{
+				ID:       id,
+				State:    model.TaskExecuting,
+				Progress: proc.progress,
+				Usage:    s.usage.Clone(),
+			}
+		}
+	}
+}
+
+// finishUsage 返回结束的Task最终的资源使用量并停止对它的采样。cpuTime是任务进程结束时统计的
+// 自身及已回收子进程的CPU时间，可以补上最后一次采样之后的使用量。
+func (node *NodeServer) finishUsage(id string, cpuTime float64) *model.TaskUsage {
+	s, ok := node.usages[id]
+	if !ok {
+		if cpuTime > 0 {
+			return &model.TaskUsage{CPUSeconds: cpuTime}
+		}
+		return nil
+	}
+	delete(node.usages, id)
+	if cpuTime > s.usage.CPUSeconds {
+		s.usage.CPUSeconds = cpuTime
+	}
+	return s.usage.Clone()
+}
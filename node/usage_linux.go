@@ -0,0 +1,77 @@
+package node
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// clockTicks 是/proc中CPU时间的单位，Linux上总是100
+const clockTicks = 100
+
+// childrenCPUTime 返回进程已经回收的子进程使用的CPU时间，即/proc/<pid>/stat中的cutime和cstime。
+// 退出的子进程不再出现在进程树中，它们的CPU时间只能从父进程获得。
+func childrenCPUTime(pid int32) float64 {
+	content, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0
+	}
+	// 进程名中可能有空格，从最后一个右括号之后开始解析，第1个字段是进程状态
+	stat := string(content)
+	fields := strings.Fields(stat[strings.LastIndex(stat, ")")+1:])
+	if len(fields) < 15 {
+		return 0
+	}
+	cutime, err1 := strconv.ParseFloat(fields[13], 64)
+	cstime, err2 := strconv.ParseFloat(fields[14], 64)
+	if err1 != nil || err2 != nil {
+		return 0
+	}
+	return (cutime + cstime) / clockTicks
+}
+
+// cgroupCPUTime 返回进程所在cgroup中所有进程(包括已经退出的)使用的CPU时间，用于采样容器任务。
+// 同时支持cgroup v2的cpu.stat和cgroup v1的cpuacct.usage。
+func cgroupCPUTime(pid int32) (float64, bool) {
+	content, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/cgroup", pid))
+	if err != nil {
+		return 0, false
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		if parts[0] == "0" && len(parts[1]) == 0 {
+			stat, err := ioutil.ReadFile(filepath.Join("/sys/fs/cgroup", parts[2], "cpu.stat"))
+			if err != nil {
+				continue
+			}
+			for _, l := range strings.Split(string(stat), "\n") {
+				if f := strings.Fields(l); len(f) == 2 && f[0] == "usage_usec" {
+					if usec, err := strconv.ParseFloat(f[1], 64); err == nil {
+						return usec / 1e6, true
+					}
+				}
+			}
+			continue
+		}
+		for _, controller := range strings.Split(parts[1], ",") {
+			if controller != "cpuacct" {
+				continue
+			}
+			for _, mount := range []string{parts[1], "cpuacct", "cpu,cpuacct"} {
+				usage, err := ioutil.ReadFile(filepath.Join("/sys/fs/cgroup", mount, parts[2], "cpuacct.usage"))
+				if err != nil {
+					continue
+				}
+				if nsec, err := strconv.ParseFloat(strings.TrimSpace(string(usage)), 64); err == nil {
+					return nsec / 1e9, true
+				}
+			}
+		}
+	}
+	return 0, false
+}
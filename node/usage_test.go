@@ -0,0 +1,90 @@
+//go:build !windows
+// +build !windows
+
+package node
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/qianxiaoming/lightsched/message"
+)
+
+// startBusyShell 启动一个shell，其子进程消耗一段CPU时间后退出，然后shell等待被结束
+func startBusyShell(t *testing.T) *exec.Cmd {
+	dir, err := ioutil.TempDir("", "lightsched-usage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	done := filepath.Join(dir, "done")
+	cmd := shellCommand(`sh -c 'i=0; while [ $i -lt 300000 ]; do i=$((i+1)); done'; touch ` + done + `; sleep 30`)
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		killProcessTree(cmd.Process)
+		cmd.Wait()
+	})
+	for i := 0; ; i++ {
+		if _, err := os.Stat(done); err == nil {
+			return cmd
+		}
+		if i == 1000 {
+			t.Fatal("busy child does not finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSampleReapedChildren(t *testing.T) {
+	cmd := startBusyShell(t)
+	// 消耗CPU的子进程已经退出，只能从shell的cutime和cstime中获得它的CPU时间
+	sample := sampleProcessTree(int32(cmd.Process.Pid), nil)
+	if sample == nil || sample.cpuTime < 0.05 {
+		t.Fatalf("CPU time of reaped children is lost: %+v", sample)
+	}
+}
+
+func TestSampleContainerUsage(t *testing.T) {
+	container := startBusyShell(t)
+	// 容器任务的进程是容器命令行工具，采样时应该从Inspect返回的容器主进程开始
+	client := exec.Command("sleep", "30")
+	if err := client.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		client.Process.Kill()
+		client.Wait()
+	}()
+	node := &NodeServer{
+		executings: map[string]TaskProcess{"job.0.0": {process: client.Process}},
+		usages:     make(map[string]*usageSampler),
+		heartbeat:  Heartbeat{payload: make(map[string]*message.TaskReport)},
+	}
+	node.inspectors.Store("job.0.0", func() (int32, error) { return int32(container.Process.Pid), nil })
+	node.sampleUsage()
+	report := node.heartbeat.payload["job.0.0"]
+	if report == nil || report.Usage == nil || report.Usage.CPUSeconds < 0.05 {
+		t.Fatalf("usage of container task: %+v", report)
+	}
+	if s := node.usages["job.0.0"]; s.container != int32(container.Process.Pid) {
+		t.Errorf("container pid is not cached: %d", s.container)
+	}
+
+	// 进程结束时的统计大于最后一次采样时以前者为准，没有采样过的任务也上报CPU时间
+	sampled := report.Usage.CPUSeconds
+	if usage := node.finishUsage("job.0.0", sampled+1); usage == nil || usage.CPUSeconds != sampled+1 {
+		t.Errorf("final usage: %+v", usage)
+	}
+	if usage := node.finishUsage("job.0.1", 2); usage == nil || usage.CPUSeconds != 2 {
+		t.Errorf("final usage of task never sampled: %+v", usage)
+	}
+	if usage := node.finishUsage("job.0.1", 0); usage != nil {
+		t.Errorf("final usage without any statistics: %+v", usage)
+	}
+}
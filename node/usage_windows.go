@@ -0,0 +1,11 @@
+package node
+
+// childrenCPUTime 在Windows上无法获得已经退出的子进程的CPU时间，任务结束时以进程自身的统计为准
+func childrenCPUTime(pid int32) float64 {
+	return 0
+}
+
+// cgroupCPUTime 在Windows节点上不支持容器的cgroup
+func cgroupCPUTime(pid int32) (float64, bool) {
+	return 0, false
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/qianxiaoming/lightsched/message"
+	"github.com/qianxiaoming/lightsched/model"
+)
+
+// PermReadAccounting 允许查询资源使用的记账信息
+const PermReadAccounting = "read_accounting"
+
+// accountingGroupKey 返回记账信息按指定方式分组时的键
+func accountingGroupKey(record *model.AccountingRecord, groupBy string) (string, error) {
+	switch groupBy {
+	case "user":
+		return record.Owner, nil
+	case "queue":
+		return record.Queue, nil
+	case "node":
+		return record.Node, nil
+	}
+	return "", fmt.Errorf("illegal group_by value: %s", groupBy)
+}
+
+func (svc *APIServer) requestAccountingUsage(groupBy string, from, to time.Time) ([]*message.UsageInfo, error) {
+	svc.state.RLock()
+	records := svc.state.QueryAccounting(from, to)
+	svc.state.RUnlock()
+
+	usages := make(map[string]*message.UsageInfo)
+	for _, r := range records {
+		key, err := accountingGroupKey(r, groupBy)
+		if err != nil {
+			return nil, err
+		}
+		usage, ok := usages[key]
+		if !ok {
+			usage = &message.UsageInfo{Key: key}
+			usages[key] = usage
+		}
+		usage.Tasks++
+		switch r.State {
+		case model.TaskStateToString(model.TaskCompleted):
+			usage.Completed++
+		case model.TaskStateToString(model.TaskTerminated):
+			usage.Terminated++
+		default:
+			usage.Failed++
+		}
+		usage.WallSeconds += r.WallSeconds
+		usage.CPUSeconds += r.CPUSeconds
+		usage.GPUSeconds += r.GPUSeconds
+		usage.MemorySeconds += r.MemorySeconds
+	}
+	result := make([]*message.UsageInfo, 0, len(usages))
+	for _, u := range usages {
+		result = append(result, u)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Key < result[j].Key })
+	return result, nil
+}
+
+// writeUsageCSV 以CSV格式输出资源使用量
+func writeUsageCSV(c *gin.Context, groupBy string, usages []*message.UsageInfo) {
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=usage-by-%s.csv", groupBy))
+	c.Status(http.StatusOK)
+	w := csv.NewWriter(c.Writer)
+	w.Write([]string{groupBy, "tasks", "completed", "failed", "terminated", "wall_seconds", "cpu_seconds", "gpu_seconds", "memory_seconds"})
+	format := func(v float64) string { return strconv.FormatFloat(v, 'f', 1, 64) }
+	for _, u := range usages {
+		w.Write([]string{u.Key, strconv.Itoa(u.Tasks), strconv.Itoa(u.Completed), strconv.Itoa(u.Failed), strconv.Itoa(u.Terminated),
+			format(u.WallSeconds), format(u.CPUSeconds), format(u.GPUSeconds), format(u.MemorySeconds)})
+	}
+	w.Flush()
+}
+
+// AccountingEndpoint 是资源使用记账信息的RESTful API实现接口
+type AccountingEndpoint struct{}
+
+// parseTimeRange 解析查询参数中的from和to时间
+func parseTimeRange(c *gin.Context) (from time.Time, to time.Time, err error) {
+	if v := c.Query("from"); len(v) > 0 {
+		if from, err = parseTime(v); err != nil {
+			return
+		}
+	}
+	if v := c.Query("to"); len(v) > 0 {
+		to, err = parseTime(v)
+	}
+	return
+}
+
+func (e AccountingEndpoint) registerRoute() {
+	apiserver.restRouter.GET(e.restPrefix()+"/usage", authorize(PermReadAccounting), func(c *gin.Context) {
+		from, to, err := parseTimeRange(c)
+		if err != nil {
+			responseError(http.StatusBadRequest, "Invalid query: %v", err, c)
+			return
+		}
+		groupBy := c.DefaultQuery("group_by", "user")
+		usages, err := apiserver.requestAccountingUsage(groupBy, from, to)
+		if err != nil {
+			responseError(http.StatusBadRequest, "Invalid query: %v", err, c)
+			return
+		}
+		if c.Query("format") == "csv" || strings.Contains(c.GetHeader("Accept"), "text/csv") {
+			writeUsageCSV(c, groupBy, usages)
+		} else {
+			c.JSON(http.StatusOK, usages)
+		}
+	})
+	apiserver.restRouter.GET(e.restPrefix()+"/jobs", authorize(PermReadAccounting), func(c *gin.Context) {
+		from, to, err := parseTimeRange(c)
+		if err != nil {
+			responseError(http.StatusBadRequest, "Invalid query: %v", err, c)
+			return
+		}
+		apiserver.state.RLock()
+		archives := apiserver.state.QueryJobArchives(from, to)
+		apiserver.state.RUnlock()
+		c.JSON(http.StatusOK, archives)
+	})
+}
+
+func (e AccountingEndpoint) restPrefix() string {
+	return "/accounting"
+}
@@ -0,0 +1,194 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/qianxiaoming/lightsched/message"
+	"github.com/qianxiaoming/lightsched/model"
+)
+
+// auditObjectKey 是处理函数设置被操作对象在gin.Context中的键，用于创建对象时请求路径中没有对象标识的情况
+const auditObjectKey = "lightsched.audit.object"
+
+// PermReadAudit 允许查询审计记录
+const PermReadAudit = "read_audit"
+
+// auditSnapshot 返回对象中需要审计的字段，对象不存在时返回nil
+type auditSnapshot func(object string) map[string]interface{}
+
+// audited 返回记录修改操作审计信息的中间件。param是请求路径中对象标识的参数名，snapshot用于比较操作前后的字段。
+func audited(kind string, param string, snapshot auditSnapshot) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		object := c.Param(param)
+		var before map[string]interface{}
+		if len(object) > 0 && snapshot != nil {
+			before = snapshot(object)
+		}
+		c.Next()
+
+		if v, ok := c.Get(auditObjectKey); ok {
+			object = v.(string)
+		}
+		var after map[string]interface{}
+		if len(object) > 0 && snapshot != nil {
+			after = snapshot(object)
+		}
+		entry := &model.AuditEntry{
+			Time:     time.Now(),
+			User:     requestUser(c),
+			ClientIP: c.ClientIP(),
+			Method:   c.Request.Method,
+			Endpoint: c.Request.URL.RequestURI(),
+			Kind:     kind,
+			Object:   object,
+			Status:   c.Writer.Status(),
+		}
+		entry.Before, entry.After = diffFields(before, after)
+		apiserver.requestAppendAudit(entry)
+	}
+}
+
+// diffFields 返回两组字段中值不同的部分
+func diffFields(before, after map[string]interface{}) (map[string]interface{}, map[string]interface{}) {
+	b := make(map[string]interface{})
+	a := make(map[string]interface{})
+	for k, v := range before {
+		if w, ok := after[k]; !ok || !sameValue(v, w) {
+			b[k] = v
+		}
+	}
+	for k, v := range after {
+		if w, ok := before[k]; !ok || !sameValue(v, w) {
+			a[k] = v
+		}
+	}
+	if len(b) == 0 {
+		b = nil
+	}
+	if len(a) == 0 {
+		a = nil
+	}
+	return b, a
+}
+
+func sameValue(v1, v2 interface{}) bool {
+	b1, _ := json.Marshal(v1)
+	b2, _ := json.Marshal(v2)
+	return string(b1) == string(b2)
+}
+
+// jobSnapshot 返回Job中可被修改的字段
+func jobSnapshot(id string) map[string]interface{} {
+	apiserver.state.RLock()
+	defer apiserver.state.RUnlock()
+	job := apiserver.state.GetJob(id)
+	if job == nil {
+		return nil
+	}
+	return map[string]interface{}{
+		"name":       job.Name,
+		"queue":      job.Queue,
+		"priority":   job.Priority,
+		"max_errors": job.MaxErrors,
+		"labels":     job.Labels,
+		"taints":     job.Taints,
+		"state":      model.JobStateToString(job.State),
+	}
+}
+
+// nodeSnapshot 返回节点中可被修改的字段
+func nodeSnapshot(name string) map[string]interface{} {
+	apiserver.nodes.RLock()
+	defer apiserver.nodes.RUnlock()
+	node := apiserver.nodes.GetNode(name)
+	if node == nil {
+		return nil
+	}
+	return map[string]interface{}{
+		"state":  model.NodeStateToString(node.State),
+		"labels": node.Labels,
+		"taints": node.Taints,
+	}
+}
+
+// queueSnapshot 返回作业队列中可被修改的字段
+func queueSnapshot(name string) map[string]interface{} {
+	apiserver.state.RLock()
+	defer apiserver.state.RUnlock()
+	queue := apiserver.state.GetJobQueue(name)
+	if queue == nil {
+		return nil
+	}
+	return map[string]interface{}{
+		"enabled":  queue.Enabled,
+		"priority": queue.Priority,
+	}
+}
+
+func (svc *APIServer) requestAppendAudit(entry *model.AuditEntry) {
+	svc.state.Lock()
+	defer svc.state.Unlock()
+	if err := svc.state.AppendAudit(entry); err != nil {
+		log.Printf("Unable to save audit entry of %s %s: %v\n", entry.Method, entry.Endpoint, err)
+	}
+}
+
+func (svc *APIServer) requestQueryAudit(object string, since time.Time, limit int) []*message.AuditInfo {
+	svc.state.RLock()
+	defer svc.state.RUnlock()
+
+	entries := svc.state.QueryAudit(object, since, limit)
+	infos := make([]*message.AuditInfo, 0, len(entries))
+	for _, e := range entries {
+		infos = append(infos, message.NewAuditInfo(e))
+	}
+	return infos
+}
+
+// parseTime 解析查询参数中的时间，支持RFC3339格式、本地时间格式和Unix秒数
+func parseTime(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, v, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	if sec, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	return time.Time{}, fmt.Errorf("illegal time format: %s", v)
+}
+
+// AuditEndpoint 是审计记录的RESTful API实现接口
+type AuditEndpoint struct{}
+
+func (e AuditEndpoint) registerRoute() {
+	apiserver.restRouter.GET(e.restPrefix(), authorize(PermReadAudit), func(c *gin.Context) {
+		var since time.Time
+		if v := c.Query("since"); len(v) > 0 {
+			t, err := parseTime(v)
+			if err != nil {
+				responseError(http.StatusBadRequest, "Invalid query: %v", err, c)
+				return
+			}
+			since = t
+		}
+		limit := 0
+		if v := c.Query("limit"); len(v) > 0 {
+			limit, _ = strconv.Atoi(v)
+		}
+		c.JSON(http.StatusOK, apiserver.requestQueryAudit(c.Query("object"), since, limit))
+	})
+}
+
+func (e AuditEndpoint) restPrefix() string {
+	return "/audit"
+}
@@ -0,0 +1,352 @@
+package server
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/qianxiaoming/lightsched/constant"
+	"github.com/qianxiaoming/lightsched/model"
+)
+
+const (
+	// PermRead 允许查询作业、任务、队列和节点信息
+	PermRead = "read"
+	// PermSubmit 允许提交作业并管理自己的作业
+	PermSubmit = "submit"
+	// PermModifyAll 允许修改其他用户的作业
+	PermModifyAll = "modify_all"
+	// PermManageNodes 允许将节点上线或下线
+	PermManageNodes = "manage_nodes"
+	// PermManageQueues 允许管理作业队列
+	PermManageQueues = "manage_queues"
+)
+
+// identityKey 是请求者身份在gin.Context中的键
+const identityKey = "lightsched.identity"
+
+var errNoCredential = errors.New("no credential provided")
+
+// AuthConfig 是RESTful API认证和授权的配置。未配置任何认证方式时不进行认证。
+type AuthConfig struct {
+	TokenFile string     `json:"token_file,omitempty"` // 静态token文件，每行为"token 用户名 角色1,角色2"
+	HMACKey   string     `json:"hmac_key,omitempty"`   // 签名token使用的密钥
+	CertAuth  bool       `json:"cert_auth,omitempty"`  // 是否接受客户端证书，证书的CN为用户名，OU为角色
+	Rules     []AuthRule `json:"rules,omitempty"`      // 角色的权限规则，为空时使用默认规则
+}
+
+// AuthRule 定义了一个角色拥有的权限
+type AuthRule struct {
+	Role        string   `json:"role"`
+	Permissions []string `json:"permissions"`
+	Queues      []string `json:"queues,omitempty"` // 允许提交作业的队列，为空表示所有队列
+}
+
+// defaultAuthRules 是未配置规则时使用的角色权限
+var defaultAuthRules = []AuthRule{
+	{Role: "admin", Permissions: []string{PermRead, PermSubmit, PermModifyAll, PermManageNodes, PermManageQueues, PermReadAudit, PermReadAccounting}},
+	{Role: "user", Permissions: []string{PermRead, PermSubmit}},
+}
+
+// Identity 是通过认证的请求者身份
+type Identity struct {
+	User  string   `json:"user"`
+	Roles []string `json:"roles"`
+	Exp   int64    `json:"exp,omitempty"`
+}
+
+// Authenticator 是从HTTP请求中识别请求者身份的接口。请求中没有对应凭据时返回errNoCredential。
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Identity, error)
+}
+
+// bearerToken 返回请求中Authorization头携带的token
+func bearerToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
+
+// staticTokenAuth 使用token文件中的静态token进行认证
+type staticTokenAuth struct {
+	tokens map[string]*Identity
+}
+
+func loadTokenFile(path string) (*staticTokenAuth, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	auth := &staticTokenAuth{tokens: make(map[string]*Identity)}
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if len(text) == 0 || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("illegal token at line %d of %s", line, path)
+		}
+		id := &Identity{User: fields[1]}
+		if len(fields) > 2 {
+			id.Roles = strings.Split(fields[2], ",")
+		}
+		auth.tokens[fields[0]] = id
+	}
+	return auth, scanner.Err()
+}
+
+func (a *staticTokenAuth) Authenticate(r *http.Request) (*Identity, error) {
+	token := bearerToken(r)
+	if len(token) == 0 {
+		return nil, errNoCredential
+	}
+	for t, id := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			return id, nil
+		}
+	}
+	return nil, errNoCredential
+}
+
+// hmacTokenAuth 使用HMAC-SHA256签名的token进行认证。token格式为base64(身份JSON).base64(签名)。
+type hmacTokenAuth struct {
+	key []byte
+}
+
+// SignToken 使用指定密钥为用户生成签名token，ttl为0时token不过期
+func SignToken(key string, user string, roles []string, ttl time.Duration) string {
+	id := &Identity{User: user, Roles: roles}
+	if ttl > 0 {
+		id.Exp = time.Now().Add(ttl).Unix()
+	}
+	payload, _ := json.Marshal(id)
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write(payload)
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (a *hmacTokenAuth) Authenticate(r *http.Request) (*Identity, error) {
+	token := bearerToken(r)
+	dot := strings.Index(token, ".")
+	if dot == -1 {
+		return nil, errNoCredential
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(token[:dot])
+	if err != nil {
+		return nil, errNoCredential
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(token[dot+1:])
+	if err != nil {
+		return nil, errNoCredential
+	}
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write(payload)
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return nil, errors.New("invalid token signature")
+	}
+	id := &Identity{}
+	if err := json.Unmarshal(payload, id); err != nil || len(id.User) == 0 {
+		return nil, errors.New("invalid token payload")
+	}
+	if id.Exp != 0 && time.Now().Unix() > id.Exp {
+		return nil, errors.New("token expired")
+	}
+	return id, nil
+}
+
+// certAuth 使用已验证的TLS客户端证书进行认证
+type certAuth struct{}
+
+func (a certAuth) Authenticate(r *http.Request) (*Identity, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil, errNoCredential
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	if len(cert.Subject.CommonName) == 0 {
+		return nil, errNoCredential
+	}
+	return &Identity{User: cert.Subject.CommonName, Roles: cert.Subject.OrganizationalUnit}, nil
+}
+
+// Authorizer 负责对RESTful API请求进行认证和授权
+type Authorizer struct {
+	authenticators []Authenticator
+	rules          map[string]*AuthRule
+}
+
+// NewAuthorizer 根据配置创建Authorizer。未配置任何认证方式时返回nil，表示不进行认证。
+func NewAuthorizer(conf *AuthConfig) (*Authorizer, error) {
+	auth := &Authorizer{rules: make(map[string]*AuthRule)}
+	if len(conf.TokenFile) > 0 {
+		tokens, err := loadTokenFile(conf.TokenFile)
+		if err != nil {
+			return nil, err
+		}
+		auth.authenticators = append(auth.authenticators, tokens)
+	}
+	if len(conf.HMACKey) > 0 {
+		auth.authenticators = append(auth.authenticators, &hmacTokenAuth{key: []byte(conf.HMACKey)})
+	}
+	if conf.CertAuth {
+		auth.authenticators = append(auth.authenticators, certAuth{})
+	}
+	if len(auth.authenticators) == 0 {
+		return nil, nil
+	}
+	rules := conf.Rules
+	if len(rules) == 0 {
+		rules = defaultAuthRules
+	}
+	for i := range rules {
+		auth.rules[rules[i].Role] = &rules[i]
+	}
+	return auth, nil
+}
+
+// Authenticate 依次尝试各种认证方式，返回请求者的身份
+func (auth *Authorizer) Authenticate(r *http.Request) (*Identity, error) {
+	for _, a := range auth.authenticators {
+		id, err := a.Authenticate(r)
+		if err == errNoCredential {
+			continue
+		}
+		return id, err
+	}
+	return nil, errNoCredential
+}
+
+// Permitted 检查身份是否拥有指定的权限
+func (auth *Authorizer) Permitted(id *Identity, perm string) bool {
+	for _, role := range id.Roles {
+		if rule, ok := auth.rules[role]; ok {
+			for _, p := range rule.Permissions {
+				if p == perm {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// PermittedQueue 检查身份是否可以向指定队列提交作业
+func (auth *Authorizer) PermittedQueue(id *Identity, queue string) bool {
+	for _, role := range id.Roles {
+		if rule, ok := auth.rules[role]; ok && containsString(rule.Permissions, PermSubmit) {
+			if len(rule.Queues) == 0 || containsString(rule.Queues, queue) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// authenticate 是RESTful API的认证中间件。认证通过后将身份保存在请求上下文中。
+func (svc *APIServer) authenticate(c *gin.Context) {
+	if svc.auth == nil {
+		return
+	}
+	id, err := svc.auth.Authenticate(c.Request)
+	if err != nil {
+		c.Header("WWW-Authenticate", "Bearer")
+		abortError(c, http.StatusUnauthorized, err.Error())
+		return
+	}
+	c.Set(identityKey, id)
+}
+
+// requestIdentity 返回请求者的身份，未启用认证时返回nil
+func requestIdentity(c *gin.Context) *Identity {
+	if v, ok := c.Get(identityKey); ok {
+		return v.(*Identity)
+	}
+	return nil
+}
+
+// requestUser 返回请求者的用户名，未启用认证时返回空字符串
+func requestUser(c *gin.Context) string {
+	if id := requestIdentity(c); id != nil {
+		return id.User
+	}
+	return ""
+}
+
+// hasPermission 检查请求者是否拥有指定权限，未启用认证时总是允许
+func hasPermission(c *gin.Context, perm string) bool {
+	id := requestIdentity(c)
+	return id == nil || apiserver.auth.Permitted(id, perm)
+}
+
+// authorize 返回检查请求者权限的中间件
+func authorize(perm string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !hasPermission(c, perm) {
+			abortError(c, http.StatusForbidden, fmt.Sprintf("permission %s required", perm))
+		}
+	}
+}
+
+// authorizeJob 是检查请求者能否修改指定作业的中间件。作业的所有者或拥有modify_all权限的用户可以修改作业。
+func authorizeJob(c *gin.Context) {
+	id := requestIdentity(c)
+	if id == nil || apiserver.auth.Permitted(id, PermModifyAll) {
+		return
+	}
+	if !apiserver.auth.Permitted(id, PermSubmit) {
+		abortError(c, http.StatusForbidden, fmt.Sprintf("permission %s required", PermSubmit))
+		return
+	}
+	if job := apiserver.requestGetJob(c.Params.ByName("id")); job != nil && job.Owner != id.User {
+		abortError(c, http.StatusForbidden, "job is owned by another user")
+	}
+}
+
+// authorizeSubmit 检查请求者能否提交指定的作业。普通用户只能向允许的队列提交，且只能以自己的身份执行任务：
+// 未指定执行用户的任务被设置为以提交者的身份执行，否则任务会以节点程序的用户(通常是root)执行。
+// 这些任务只会被调度到允许该用户执行的节点上。
+func authorizeSubmit(c *gin.Context, spec *model.JobSpec) error {
+	id := requestIdentity(c)
+	if id == nil {
+		return nil
+	}
+	queue := spec.Queue
+	if len(queue) == 0 {
+		queue = constant.DefaultQueueName
+	}
+	if !apiserver.auth.PermittedQueue(id, queue) {
+		return fmt.Errorf("user %s is not allowed to submit jobs to queue %s", id.User, queue)
+	}
+	if apiserver.auth.Permitted(id, PermModifyAll) {
+		return nil
+	}
+	if len(spec.RunAs) == 0 {
+		spec.RunAs = id.User
+	} else if spec.RunAs != id.User {
+		return fmt.Errorf("user %s cannot run tasks as %s", id.User, spec.RunAs)
+	}
+	for _, g := range spec.GroupSpecs {
+		for _, t := range g.TaskSpecs {
+			if len(t.RunAs) == 0 {
+				t.RunAs = id.User
+			} else if t.RunAs != id.User {
+				return fmt.Errorf("user %s cannot run tasks as %s", id.User, t.RunAs)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/qianxiaoming/lightsched/message"
+	"github.com/qianxiaoming/lightsched/model"
+)
+
+// newAuthServer 创建使用静态token认证的API Server，alice是普通用户，root是管理员
+func newAuthServer(t *testing.T) *APIServer {
+	dir, err := ioutil.TempDir("", "lightsched-auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	tokens := filepath.Join(dir, "tokens")
+	if err := ioutil.WriteFile(tokens, []byte("alice-token alice user\nroot-token root admin\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return newTestServer(t, &Config{Auth: AuthConfig{TokenFile: tokens}})
+}
+
+// submitAs 以token对应的用户提交作业
+func submitAs(svc *APIServer, token string, spec *model.JobSpec) int {
+	b, _ := json.Marshal(spec)
+	req := httptest.NewRequest("POST", "/v1/jobs", bytes.NewReader(b))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	svc.RestHandler().ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestSubmitRunAs(t *testing.T) {
+	svc := newAuthServer(t)
+	spec := func(id string, runAs string, taskRunAs string) *model.JobSpec {
+		return &model.JobSpec{ID: id, Name: id, RunAs: runAs, GroupSpecs: []*model.TaskGroupSpec{{
+			Name: "g", Command: "run", TaskSpecs: []*model.TaskSpec{{Name: "a", RunAs: taskRunAs}, {Name: "b"}},
+		}}}
+	}
+	cases := []struct {
+		token string
+		spec  *model.JobSpec
+		code  int
+		runAs string // 作业中所有Task的执行用户
+	}{
+		{"alice-token", spec("empty", "", ""), http.StatusCreated, "alice"},
+		{"alice-token", spec("self", "alice", "alice"), http.StatusCreated, "alice"},
+		{"alice-token", spec("job-root", "root", ""), http.StatusForbidden, ""},
+		{"alice-token", spec("task-root", "", "root"), http.StatusForbidden, ""},
+		{"alice-token", spec("task-uid", "alice", "0:0"), http.StatusForbidden, ""},
+		{"root-token", spec("admin-empty", "", ""), http.StatusCreated, ""},
+		{"root-token", spec("admin-other", "bob", ""), http.StatusCreated, "bob"},
+		{"wrong-token", spec("anonymous", "", ""), http.StatusUnauthorized, ""},
+	}
+	for _, c := range cases {
+		if code := submitAs(svc, c.token, c.spec); code != c.code {
+			t.Errorf("submit %s = %d, want %d", c.spec.ID, code, c.code)
+			continue
+		}
+		svc.state.RLock()
+		job := svc.state.GetJob(c.spec.ID)
+		if c.code != http.StatusCreated {
+			if job != nil {
+				t.Errorf("rejected job %s is saved", c.spec.ID)
+			}
+		} else {
+			for _, task := range job.Groups[0].Tasks {
+				if task.RunAs != c.runAs {
+					t.Errorf("task %s of %s runs as %q, want %q", task.ID, c.spec.ID, task.RunAs, c.runAs)
+				}
+			}
+		}
+		svc.state.RUnlock()
+	}
+}
+
+func TestScheduleRunAs(t *testing.T) {
+	svc := newAuthServer(t)
+	resources := model.ResourceSet{CPU: model.ResourceCPU{Cores: 8, Frequency: 24000, MinFreq: 3000}, Memory: 16384}
+	// windows节点不支持指定执行用户，注册时不上报run_as
+	nodes := []*message.RegisterNode{
+		{Name: "windows", Resources: resources},
+		{Name: "others", RunAs: []string{"bob"}, Resources: resources},
+		{Name: "alice", RunAs: []string{"alice"}, Resources: resources},
+	}
+	for _, reg := range nodes {
+		if w := serve(svc.NodeHandler(), "POST", "/nodes", reg); w.Code != http.StatusOK {
+			t.Fatalf("register node %s: %d %s", reg.Name, w.Code, w.Body.String())
+		}
+	}
+	spec := &model.JobSpec{ID: "job", Name: "job", GroupSpecs: []*model.TaskGroupSpec{{
+		Name: "g", Command: "run", TaskSpecs: []*model.TaskSpec{{Name: "a"}, {Name: "b"}, {Name: "c"}},
+	}}}
+	if code := submitAs(svc, "alice-token", spec); code != http.StatusCreated {
+		t.Fatalf("submit job = %d", code)
+	}
+	svc.setScheduleFlag()
+	svc.runScheduleCycle()
+
+	svc.state.RLock()
+	defer svc.state.RUnlock()
+	for _, task := range svc.state.GetJob("job").Groups[0].Tasks {
+		if task.State != model.TaskScheduled || task.NodeName != "alice" {
+			t.Errorf("task %s of user alice: %v on %q", task.ID, task.State, task.NodeName)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/qianxiaoming/lightsched/message"
+	"github.com/qianxiaoming/lightsched/model"
+)
+
+const (
+	bulkTerminate = "terminate"
+	bulkHalt      = "halt"
+	bulkResume    = "resume"
+	bulkDelete    = "delete"
+	bulkPriority  = "priority"
+	bulkQueue     = "queue"
+)
+
+// jobMatcher 是解析后的作业选择条件
+type jobMatcher struct {
+	ids    map[string]bool
+	state  *model.JobState
+	queue  string
+	labels model.LabelSelector
+	before time.Time
+	after  time.Time
+	owner  string // 不为空时只选择该用户的作业
+}
+
+func newJobMatcher(sel *message.JobSelector, owner string) (*jobMatcher, error) {
+	m := &jobMatcher{queue: sel.Queue, owner: owner}
+	if len(sel.IDs) > 0 {
+		m.ids = make(map[string]bool)
+		for _, id := range sel.IDs {
+			m.ids[id] = true
+		}
+	}
+	if len(sel.State) > 0 {
+		state := model.JobStateFromString(sel.State)
+		if !strings.EqualFold(model.JobStateToString(state), sel.State) {
+			return nil, fmt.Errorf("illegal job state: %s", sel.State)
+		}
+		m.state = &state
+	}
+	var err error
+	if m.labels, err = model.ParseLabelSelector(sel.Labels); err != nil {
+		return nil, err
+	}
+	if len(sel.SubmittedBefore) > 0 {
+		if m.before, err = parseTime(sel.SubmittedBefore); err != nil {
+			return nil, err
+		}
+	}
+	if len(sel.SubmittedAfter) > 0 {
+		if m.after, err = parseTime(sel.SubmittedAfter); err != nil {
+			return nil, err
+		}
+	}
+	if m.ids == nil && m.state == nil && len(m.queue) == 0 && len(m.labels) == 0 && m.before.IsZero() && m.after.IsZero() {
+		return nil, errors.New("empty selector is not allowed")
+	}
+	return m, nil
+}
+
+func (m *jobMatcher) matches(job *model.Job) bool {
+	return (m.ids == nil || m.ids[job.ID]) &&
+		(m.state == nil || job.State == *m.state) &&
+		(len(m.queue) == 0 || job.Queue == m.queue) &&
+		(len(m.owner) == 0 || job.Owner == m.owner) &&
+		m.labels.Matches(job.Labels) &&
+		(m.before.IsZero() || job.SubmitTime.Before(m.before)) &&
+		(m.after.IsZero() || job.SubmitTime.After(m.after))
+}
+
+// checkBulkAction 检查能否对作业执行指定的操作
+func checkBulkAction(job *model.Job, req *message.BulkJobRequest) error {
+	finished := isJobFinished(job)
+	switch req.Action {
+	case bulkTerminate:
+		if finished {
+			return errors.New("job is already finished")
+		}
+	case bulkHalt:
+		if finished || job.State == model.JobHalted {
+			return fmt.Errorf("cannot halt %s job", model.JobStateToString(job.State))
+		}
+	case bulkResume:
+		if job.State != model.JobHalted {
+			return fmt.Errorf("cannot resume %s job", model.JobStateToString(job.State))
+		}
+	case bulkDelete:
+		if job.State == model.JobExecuting || job.State == model.JobHalted {
+			return errors.New("cannot delete executing or halted jobs")
+		}
+	case bulkPriority, bulkQueue:
+		if finished {
+			return errors.New("job is already finished")
+		}
+	}
+	return nil
+}
+
+// bulkAuditFields 返回批量操作可能修改的作业字段，作业被删除时返回nil
+func bulkAuditFields(job *model.Job) map[string]interface{} {
+	if job == nil {
+		return nil
+	}
+	return map[string]interface{}{
+		"state":    model.JobStateToString(job.State),
+		"priority": job.Priority,
+		"queue":    job.Queue,
+	}
+}
+
+// requestBulkJobs 对选中的作业执行批量操作。所有修改在一次加锁中完成，并在一个数据库批量事务中保存。
+// audit不为nil时以它为模板为每个被修改的作业记录一条审计信息。
+func (svc *APIServer) requestBulkJobs(req *message.BulkJobRequest, owner string, audit *model.AuditEntry) ([]*message.BulkJobResult, error) {
+	switch req.Action {
+	case bulkTerminate, bulkHalt, bulkResume, bulkDelete:
+	case bulkPriority:
+		if req.Priority == nil {
+			return nil, errors.New("priority is required")
+		}
+	case bulkQueue:
+		if len(req.Queue) == 0 {
+			return nil, errors.New("queue is required")
+		}
+	default:
+		return nil, fmt.Errorf("illegal action: %s", req.Action)
+	}
+	matcher, err := newJobMatcher(&req.Selector, owner)
+	if err != nil {
+		return nil, err
+	}
+
+	svc.state.Lock()
+	defer svc.state.Unlock()
+	if req.Action == bulkQueue && svc.state.GetJobQueue(req.Queue) == nil {
+		return nil, fmt.Errorf("queue %s not found", req.Queue)
+	}
+
+	selected := make([]*model.Job, 0, 16)
+	for _, job := range svc.state.GetAllJobs() {
+		if matcher.matches(job) {
+			selected = append(selected, job)
+		}
+	}
+	results := make([]*message.BulkJobResult, 0, len(selected))
+	updated := make([]*model.Job, 0, len(selected))
+	deleted := make([]string, 0)
+	entries := make([]*model.AuditEntry, 0, len(selected))
+	nodes := make(map[string][]string)
+	for _, job := range selected {
+		result := &message.BulkJobResult{ID: job.ID, Name: job.Name, State: model.JobStateToString(job.State), OK: true}
+		results = append(results, result)
+		err := checkBulkAction(job, req)
+		if err == nil && req.Action == bulkQueue {
+			err = svc.checkMoveLimits(job, req.Queue)
+		}
+		if err != nil {
+			result.OK = false
+			result.Error = err.Error()
+			continue
+		}
+		if req.DryRun {
+			continue
+		}
+		if audit != nil {
+			entry := *audit
+			entry.Object = job.ID
+			entry.Before = bulkAuditFields(job)
+			entries = append(entries, &entry)
+		}
+		switch req.Action {
+		case bulkTerminate:
+			job.State = model.JobTerminated
+			job.FinishTime = time.Now()
+			for _, g := range job.Groups {
+				for _, t := range g.Tasks {
+					if t.State == model.TaskExecuting || t.State == model.TaskDispatching || t.State == model.TaskScheduled {
+						nodes[t.NodeName] = append(nodes[t.NodeName], job.ID)
+					}
+				}
+			}
+		case bulkHalt:
+			job.State = model.JobHalted
+		case bulkResume:
+			job.State = model.JobQueued
+			job.RefreshState()
+		case bulkDelete:
+			svc.state.RemoveJob(job)
+			svc.usage.untrackJob(job.ID)
+			deleted = append(deleted, job.ID)
+			continue
+		case bulkPriority:
+			job.Priority = *req.Priority
+		case bulkQueue:
+			svc.state.MoveJob(job, req.Queue)
+		}
+		if req.Action != bulkPriority {
+			svc.usage.trackJob(job)
+		}
+		result.State = model.JobStateToString(job.State)
+		updated = append(updated, job)
+		if audit != nil {
+			entry := entries[len(entries)-1]
+			entry.Before, entry.After = diffFields(entry.Before, bulkAuditFields(job))
+		}
+	}
+	if req.DryRun {
+		return results, nil
+	}
+
+	if err := svc.state.SaveJobs(updated, deleted); err != nil {
+		log.Printf("Unable to save jobs of bulk %s: %v\n", req.Action, err)
+		return nil, err
+	}
+	if err := svc.state.AppendAudits(entries); err != nil {
+		log.Printf("Unable to save audit entries of bulk %s: %v\n", req.Action, err)
+	}
+	for _, id := range deleted {
+		if err := svc.deleteJobFiles(id); err != nil {
+			log.Printf("Unable to delete files of job %s: %v\n", id, err)
+		}
+	}
+	if len(nodes) > 0 {
+		svc.nodes.Lock()
+		for name, jobs := range nodes {
+			sent := make(map[string]bool)
+			for _, id := range jobs {
+				if !sent[id] {
+					svc.terminateDroppedTasks(svc.nodes.AppendNodeMessage(name, message.KindTerminateJob, id, nil))
+					sent[id] = true
+				}
+			}
+		}
+		svc.nodes.Unlock()
+	}
+	if req.Action == bulkResume || req.Action == bulkPriority || req.Action == bulkQueue {
+		svc.setScheduleFlag()
+	}
+	log.Printf("Bulk %s applied to %d job(s)\n", req.Action, len(updated)+len(deleted))
+	return results, nil
+}
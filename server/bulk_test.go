@@ -0,0 +1,68 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/qianxiaoming/lightsched/message"
+)
+
+// auditOf 返回指定对象的所有审计记录
+func auditOf(t *testing.T, svc *APIServer, object string) []*message.AuditInfo {
+	w := serve(svc.RestHandler(), "GET", "/v1/audit?object="+object, nil)
+	var infos []*message.AuditInfo
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &infos) != nil {
+		t.Fatalf("query audit of %s = %d %s", object, w.Code, w.Body.String())
+	}
+	return infos
+}
+
+func TestBulkAudit(t *testing.T) {
+	svc := newTestServer(t, nil)
+	for _, id := range []string{"job1", "job2"} {
+		submitTestJob(t, svc, id, 1)
+	}
+	h := svc.RestHandler()
+	bulk := func(req *message.BulkJobRequest) {
+		if w := serve(h, "POST", "/v1/jobs/_bulk", req); w.Code != http.StatusOK {
+			t.Fatalf("bulk %s = %d %s", req.Action, w.Code, w.Body.String())
+		}
+	}
+	ids := message.JobSelector{IDs: []string{"job1", "job2"}}
+	priority := 9
+
+	// 预演不修改作业，也不记录作业的审计信息
+	bulk(&message.BulkJobRequest{Action: bulkPriority, Selector: ids, Priority: &priority, DryRun: true})
+	if infos := auditOf(t, svc, "job1"); len(infos) != 1 {
+		t.Fatalf("audit entries of job1 after dry run: %d", len(infos))
+	}
+
+	bulk(&message.BulkJobRequest{Action: bulkPriority, Selector: ids, Priority: &priority})
+	bulk(&message.BulkJobRequest{Action: bulkTerminate, Selector: ids})
+	bulk(&message.BulkJobRequest{Action: bulkDelete, Selector: ids})
+	for _, id := range ids.IDs {
+		infos := auditOf(t, svc, id)
+		if len(infos) != 4 {
+			t.Fatalf("audit entries of %s: %d, want 4", id, len(infos))
+		}
+		expected := []struct {
+			before map[string]interface{}
+			after  map[string]interface{}
+		}{
+			{map[string]interface{}{"priority": 0.0}, map[string]interface{}{"priority": 9.0}},
+			{map[string]interface{}{"state": "Queued"}, map[string]interface{}{"state": "Terminated"}},
+			{map[string]interface{}{"state": "Terminated", "priority": 9.0, "queue": "default"}, nil},
+		}
+		for i, e := range expected {
+			info := infos[i+1]
+			if info.Endpoint != "/v1/jobs/_bulk" || info.Kind != "job" || info.Status != http.StatusOK {
+				t.Errorf("audit entry %d of %s: %+v", i, id, info)
+			}
+			if !reflect.DeepEqual(info.Before, e.before) || !reflect.DeepEqual(info.After, e.after) {
+				t.Errorf("audit entry %d of %s: before %v after %v, want %v %v", i, id, info.Before, info.After, e.before, e.after)
+			}
+		}
+	}
+}
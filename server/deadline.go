@@ -0,0 +1,49 @@
+package server
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// requestReadTimeout 是普通请求读取请求内容的超时时间
+	requestReadTimeout = 5 * time.Second
+	// requestWriteTimeout 是普通请求写入应答的超时时间
+	requestWriteTimeout = 10 * time.Second
+	// transferTimeout 是上传下载文件的请求读写的超时时间
+	transferTimeout = time.Hour
+)
+
+type connContextKey struct{}
+
+// saveConn 将连接保存到请求的上下文中，以便按照接口设置连接的读写超时
+func saveConn(ctx context.Context, conn net.Conn) context.Context {
+	return context.WithValue(ctx, connContextKey{}, conn)
+}
+
+// setDeadline 设置请求所在连接的读写超时。HTTP/2的多个请求共用一个连接，不能按请求修改，
+// 此时由HTTP服务自身的超时设置控制。
+func setDeadline(c *gin.Context, read time.Duration, write time.Duration) {
+	if c.Request.ProtoMajor != 1 {
+		return
+	}
+	if conn, ok := c.Request.Context().Value(connContextKey{}).(net.Conn); ok {
+		now := time.Now()
+		conn.SetReadDeadline(now.Add(read))
+		conn.SetWriteDeadline(now.Add(write))
+	}
+}
+
+// requestDeadline 是设置普通请求读写超时的中间件。HTTP服务本身只限制读取请求头的时间，
+// 以免上传下载文件的请求被整体的超时中断。
+func requestDeadline(c *gin.Context) {
+	setDeadline(c, requestReadTimeout, requestWriteTimeout)
+}
+
+// transferDeadline 是上传下载文件的接口使用的中间件，放宽请求的读写超时
+func transferDeadline(c *gin.Context) {
+	setDeadline(c, transferTimeout, transferTimeout)
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// slowBody 先发送一部分内容，等待一段时间后再发送其余内容
+type slowBody struct {
+	parts [][]byte
+	delay time.Duration
+}
+
+func (b *slowBody) Read(p []byte) (int, error) {
+	if len(b.parts) == 0 {
+		return 0, io.EOF
+	}
+	if len(b.parts) == 1 {
+		time.Sleep(b.delay)
+	}
+	n := copy(p, b.parts[0])
+	b.parts = b.parts[1:]
+	return n, nil
+}
+
+func TestRequestDeadline(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+	engine := gin.New()
+	short := func(c *gin.Context) { setDeadline(c, 100*time.Millisecond, time.Second) }
+	long := func(c *gin.Context) { setDeadline(c, 5*time.Second, 5*time.Second) }
+	upload := func(c *gin.Context) {
+		if _, err := ioutil.ReadAll(c.Request.Body); err != nil {
+			c.Status(http.StatusRequestTimeout)
+			return
+		}
+		c.Status(http.StatusOK)
+	}
+	engine.POST("/short", short, upload)
+	engine.POST("/long", short, long, upload)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: engine, ReadHeaderTimeout: time.Second, ConnContext: saveConn}
+	go srv.Serve(listener)
+	defer srv.Close()
+
+	post := func(path string) (int, error) {
+		body := &slowBody{parts: [][]byte{[]byte("first"), []byte("second")}, delay: 300 * time.Millisecond}
+		req, _ := http.NewRequest("POST", "http://"+listener.Addr().String()+path, body)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+		return resp.StatusCode, nil
+	}
+	// 超过读取超时的请求失败，放宽超时的接口可以读取完整的请求内容
+	if code, err := post("/short"); err == nil && code == http.StatusOK {
+		t.Error("slow request is not interrupted by the read deadline")
+	}
+	if code, err := post("/long"); err != nil || code != http.StatusOK {
+		t.Errorf("slow request with extended deadline = %d %v", code, err)
+	}
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/qianxiaoming/lightsched/message"
+	"github.com/qianxiaoming/lightsched/util"
+)
+
+// apiVersionPrefix 是带版本的RESTful API路径前缀。不带前缀的旧路径仍然可用，但出错时只返回纯文本。
+const apiVersionPrefix = "/v1"
+
+// requestIDKey 是请求编号在gin.Context中的键
+const requestIDKey = "lightsched.request_id"
+
+// statusError 是带有HTTP状态码的请求错误，responseError会使用其中的状态码应答
+type statusError struct {
+	status  int
+	msg     string
+	details interface{}
+}
+
+func (e *statusError) Error() string {
+	return e.msg
+}
+
+// errNotFound 返回请求的资源不存在的错误(404)
+func errNotFound(format string, args ...interface{}) error {
+	return &statusError{status: http.StatusNotFound, msg: fmt.Sprintf(format, args...)}
+}
+
+// errConflict 返回请求与资源当前状态冲突的错误(409)
+func errConflict(format string, args ...interface{}) error {
+	return &statusError{status: http.StatusConflict, msg: fmt.Sprintf(format, args...)}
+}
+
+// errInvalid 返回请求格式正确但内容无法处理的错误(422)
+func errInvalid(format string, args ...interface{}) error {
+	return &statusError{status: http.StatusUnprocessableEntity, msg: fmt.Sprintf(format, args...)}
+}
+
+// errorCode 根据HTTP状态码生成错误码，如404对应not_found
+func errorCode(status int) string {
+	text := http.StatusText(status)
+	if len(text) == 0 {
+		return "error"
+	}
+	return strings.ReplaceAll(strings.ToLower(strings.ReplaceAll(text, "-", " ")), " ", "_")
+}
+
+// isVersioned 检查请求是否使用了带版本的API路径
+func isVersioned(c *gin.Context) bool {
+	return strings.HasPrefix(c.Request.URL.Path, apiVersionPrefix+"/")
+}
+
+// newErrorResponse 生成/v1接口的错误应答
+func newErrorResponse(c *gin.Context, status int, msg string, details interface{}) *message.ErrorResponse {
+	return &message.ErrorResponse{Error: &message.ErrorInfo{
+		Code:      errorCode(status),
+		Message:   msg,
+		Details:   details,
+		RequestID: c.GetString(requestIDKey),
+	}}
+}
+
+// abortError 以指定状态码结束请求。/v1接口返回统一的错误应答，旧接口返回{"error": msg}。
+func abortError(c *gin.Context, status int, msg string) {
+	if isVersioned(c) {
+		c.AbortWithStatusJSON(status, newErrorResponse(c, status, msg, nil))
+	} else {
+		c.AbortWithStatusJSON(status, gin.H{"error": msg})
+	}
+}
+
+// responseError 记录并应答请求错误。err为statusError时使用其中的状态码，code为0时只写入错误内容。
+// /v1接口返回统一的错误应答，旧接口返回纯文本。
+func responseError(code int, format string, err error, c *gin.Context) {
+	var details interface{}
+	if se, ok := err.(*statusError); ok && code != 0 {
+		code = se.status
+		details = se.details
+	}
+	str := fmt.Sprintf(format, err)
+	log.Printf(str)
+	if code == 0 {
+		c.Writer.WriteString(str)
+	} else if isVersioned(c) {
+		c.AbortWithStatusJSON(code, newErrorResponse(c, code, str, details))
+	} else {
+		c.String(code, str)
+	}
+}
+
+// requestID 是为每个请求分配编号的中间件。请求中已携带X-Request-ID时沿用该编号。
+func requestID(c *gin.Context) {
+	id := c.GetHeader("X-Request-ID")
+	if len(id) == 0 || len(id) > 64 {
+		id = util.GenerateUUID()
+	}
+	c.Set(requestIDKey, id)
+	c.Header("X-Request-ID", id)
+}
+
+// completeError 是/v1接口的中间件，为没有应答内容的错误状态补充统一的错误应答
+func completeError(c *gin.Context) {
+	c.Next()
+	if status := c.Writer.Status(); status >= http.StatusBadRequest && !c.Writer.Written() {
+		c.JSON(status, newErrorResponse(c, status, http.StatusText(status), nil))
+	}
+}
@@ -0,0 +1,244 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/qianxiaoming/lightsched/model"
+)
+
+// LimitSpec 是对用户或队列的资源使用限制，值为0时表示不限制
+type LimitSpec struct {
+	MaxRunningTasks int `json:"max_running_tasks,omitempty"` // 同时执行的任务数
+	MaxGPUs         int `json:"max_gpus,omitempty"`          // 同时使用的GPU卡数
+	MaxPendingJobs  int `json:"max_pending_jobs,omitempty"`  // 未结束的作业数
+	MaxQueuedTasks  int `json:"max_queued_tasks,omitempty"`  // 等待调度的任务数
+}
+
+// LimitConfig 是按用户和队列限制作业提交和任务执行的配置
+type LimitConfig struct {
+	User   LimitSpec            `json:"user"`             // 每个用户的默认限制
+	Users  map[string]LimitSpec `json:"users,omitempty"`  // 指定用户的限制，覆盖默认限制
+	Queues map[string]LimitSpec `json:"queues,omitempty"` // 每个队列的限制
+}
+
+// limitReason 是任务因为达到限制而未被调度时记录的原因
+const limitReason = "limit reached"
+
+// limitUsage 统计了用户或队列当前的使用量
+type limitUsage struct {
+	runningTasks int
+	gpus         int
+	pendingJobs  int
+	queuedTasks  int
+}
+
+// add 将另一个使用量的n倍累加到自身，n为-1时表示减去
+func (u *limitUsage) add(other *limitUsage, n int) {
+	u.runningTasks += n * other.runningTasks
+	u.gpus += n * other.gpus
+	u.pendingJobs += n * other.pendingJobs
+	u.queuedTasks += n * other.queuedTasks
+}
+
+// addTask 将处于指定状态的Task的n倍计入使用量
+func (u *limitUsage) addTask(task *model.Task, state model.TaskState, n int) {
+	if state == model.TaskQueued {
+		u.queuedTasks += n
+	} else if isRunningState(state) {
+		u.runningTasks += n
+		u.gpus += n * task.Resources.GPU.Cards
+	}
+}
+
+// jobUsage 是1个未结束作业计入其用户和队列的使用量
+type jobUsage struct {
+	queue string
+	owner string
+	usage limitUsage
+}
+
+// usageCounter 统计所有未结束作业按用户和队列的使用量。作业提交、结束、删除、重新执行或移动队列时重新统计该作业，
+// Task调度和状态变化时增量更新，因此检查限制时不需要遍历所有作业。所有方法的调用者都需要持有state的锁。
+type usageCounter struct {
+	users  map[string]*limitUsage
+	queues map[string]*limitUsage
+	jobs   map[string]*jobUsage
+}
+
+func isJobFinished(job *model.Job) bool {
+	return job.State == model.JobCompleted || job.State == model.JobFailed || job.State == model.JobTerminated
+}
+
+func isRunningState(state model.TaskState) bool {
+	return state == model.TaskScheduled || state == model.TaskDispatching || state == model.TaskExecuting
+}
+
+// runningTask 返回作业中还在节点上运行的任意一个Task。终止的作业在节点上报之前仍然可能有运行的Task。
+func runningTask(job *model.Job) *model.Task {
+	for _, g := range job.Groups {
+		for _, t := range g.Tasks {
+			if isRunningState(t.State) {
+				return t
+			}
+		}
+	}
+	return nil
+}
+
+func newUsageCounter() *usageCounter {
+	return &usageCounter{
+		users:  make(map[string]*limitUsage),
+		queues: make(map[string]*limitUsage),
+		jobs:   make(map[string]*jobUsage),
+	}
+}
+
+// countUsage 遍历所有作业统计使用量，只在服务启动时调用，之后由usageCounter增量更新
+func (svc *APIServer) countUsage() *usageCounter {
+	counter := newUsageCounter()
+	for _, job := range svc.state.GetAllJobs() {
+		counter.trackJob(job)
+	}
+	return counter
+}
+
+// usagesOf 返回队列和用户的使用量。未启用认证时作业没有所有者，不统计用户的使用量。
+func (counter *usageCounter) usagesOf(queue string, owner string) []*limitUsage {
+	get := func(m map[string]*limitUsage, key string) *limitUsage {
+		u, ok := m[key]
+		if !ok {
+			u = &limitUsage{}
+			m[key] = u
+		}
+		return u
+	}
+	usages := []*limitUsage{get(counter.queues, queue)}
+	if len(owner) > 0 {
+		usages = append(usages, get(counter.users, owner))
+	}
+	return usages
+}
+
+// trackJob 重新统计作业的使用量，在作业提交、结束、重新执行或移动队列后调用。已结束的作业不计入使用量。
+func (counter *usageCounter) trackJob(job *model.Job) {
+	counter.untrackJob(job.ID)
+	if isJobFinished(job) {
+		return
+	}
+	ju := &jobUsage{queue: job.Queue, owner: job.Owner, usage: limitUsage{pendingJobs: 1}}
+	for _, g := range job.Groups {
+		for _, t := range g.Tasks {
+			ju.usage.addTask(t, t.State, 1)
+		}
+	}
+	counter.jobs[job.ID] = ju
+	for _, u := range counter.usagesOf(ju.queue, ju.owner) {
+		u.add(&ju.usage, 1)
+	}
+}
+
+// untrackJob 从用户和队列的使用量中减去作业的使用量，在作业被删除时调用
+func (counter *usageCounter) untrackJob(id string) {
+	if ju, ok := counter.jobs[id]; ok {
+		for _, u := range counter.usagesOf(ju.queue, ju.owner) {
+			u.add(&ju.usage, -1)
+		}
+		delete(counter.jobs, id)
+	}
+}
+
+// taskChanged 在Task的状态从from变为to时更新使用量
+func (counter *usageCounter) taskChanged(job *model.Job, task *model.Task, from model.TaskState, to model.TaskState) {
+	ju, ok := counter.jobs[job.ID]
+	if !ok || from == to {
+		return
+	}
+	delta := &limitUsage{}
+	delta.addTask(task, from, -1)
+	delta.addTask(task, to, 1)
+	ju.usage.add(delta, 1)
+	for _, u := range counter.usagesOf(ju.queue, ju.owner) {
+		u.add(delta, 1)
+	}
+}
+
+// taskUpdated 在节点上报Task状态后更新使用量。Task的变化使作业结束时重新统计整个作业。
+func (counter *usageCounter) taskUpdated(job *model.Job, task *model.Task, from model.TaskState, wasFinished bool) {
+	if isJobFinished(job) != wasFinished {
+		counter.trackJob(job)
+	} else {
+		counter.taskChanged(job, task, from, task.State)
+	}
+}
+
+// pendingJobs 返回队列中未结束的作业数
+func (counter *usageCounter) pendingJobs(queue string) int {
+	if u, ok := counter.queues[queue]; ok {
+		return u.pendingJobs
+	}
+	return 0
+}
+
+// userLimit 返回用户的限制
+func (svc *APIServer) userLimit(user string) LimitSpec {
+	if l, ok := svc.config.Limits.Users[user]; ok {
+		return l
+	}
+	return svc.config.Limits.User
+}
+
+// checkPendingLimit 检查向使用量中加入1个有tasks个等待调度任务的作业后是否超过限制
+func checkPendingLimit(kind, name string, limit LimitSpec, usage *limitUsage, tasks int) error {
+	if limit.MaxQueuedTasks > 0 && tasks > limit.MaxQueuedTasks {
+		return &statusError{http.StatusForbidden, fmt.Sprintf("job has %d tasks which exceeds the limit %d of %s %s", tasks, limit.MaxQueuedTasks, kind, name), map[string]string{"kind": kind, "name": name}}
+	}
+	if limit.MaxPendingJobs > 0 && usage.pendingJobs+1 > limit.MaxPendingJobs {
+		return &statusError{http.StatusTooManyRequests, fmt.Sprintf("%s %s has reached the limit of %d pending jobs", kind, name, limit.MaxPendingJobs), map[string]string{"kind": kind, "name": name}}
+	}
+	if limit.MaxQueuedTasks > 0 && usage.queuedTasks+tasks > limit.MaxQueuedTasks {
+		return &statusError{http.StatusTooManyRequests, fmt.Sprintf("%s %s has %d queued tasks and the limit is %d", kind, name, usage.queuedTasks, limit.MaxQueuedTasks), map[string]string{"kind": kind, "name": name}}
+	}
+	return nil
+}
+
+// checkSubmitLimits 检查提交的作业是否超过用户和队列的提交限制，调用者需要持有state的锁
+func (svc *APIServer) checkSubmitLimits(job *model.Job) error {
+	tasks := job.CountTasks()
+	usages := svc.usage.usagesOf(job.Queue, job.Owner)
+	if err := checkPendingLimit("queue", job.Queue, svc.config.Limits.Queues[job.Queue], usages[0], tasks); err != nil {
+		return err
+	}
+	if len(usages) > 1 {
+		return checkPendingLimit("user", job.Owner, svc.userLimit(job.Owner), usages[1], tasks)
+	}
+	return nil
+}
+
+// checkMoveLimits 检查将未结束的作业移动到指定队列后是否超过该队列的提交限制，调用者需要持有state的锁。
+// 作业的所有者不变，因此不需要检查用户的限制。
+func (svc *APIServer) checkMoveLimits(job *model.Job, queue string) error {
+	if job.Queue == queue {
+		return nil
+	}
+	tasks := 0
+	if ju, ok := svc.usage.jobs[job.ID]; ok {
+		tasks = ju.usage.queuedTasks
+	}
+	return checkPendingLimit("queue", queue, svc.config.Limits.Queues[queue], svc.usage.usagesOf(queue, "")[0], tasks)
+}
+
+// runLimitReached 检查执行Task是否会超过用户或队列的执行限制，调用者需要持有state的锁
+func (svc *APIServer) runLimitReached(job *model.Job, task *model.Task) bool {
+	exceeded := func(limit LimitSpec, usage *limitUsage) bool {
+		if limit.MaxRunningTasks > 0 && usage.runningTasks+1 > limit.MaxRunningTasks {
+			return true
+		}
+		return limit.MaxGPUs > 0 && task.Resources.GPU.Cards > 0 && usage.gpus+task.Resources.GPU.Cards > limit.MaxGPUs
+	}
+	usages := svc.usage.usagesOf(job.Queue, job.Owner)
+	if exceeded(svc.config.Limits.Queues[job.Queue], usages[0]) {
+		return true
+	}
+	return len(usages) > 1 && exceeded(svc.userLimit(job.Owner), usages[1])
+}
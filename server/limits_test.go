@@ -0,0 +1,177 @@
+package server
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/qianxiaoming/lightsched/model"
+)
+
+// newUsageJob 创建包含指定数量Task的作业，每个Task使用gpus张GPU卡
+func newUsageJob(id string, queue string, owner string, tasks int, gpus int) *model.Job {
+	group := &model.TaskGroupSpec{Name: "g", Command: "run"}
+	for i := 0; i < tasks; i++ {
+		group.TaskSpecs = append(group.TaskSpecs, &model.TaskSpec{Name: fmt.Sprintf("t%d", i)})
+	}
+	job := model.NewJobWithSpec(&model.JobSpec{ID: id, Name: id, Queue: queue, GroupSpecs: []*model.TaskGroupSpec{group}})
+	job.Owner = owner
+	for _, t := range job.Groups[0].Tasks {
+		t.Resources = &model.ResourceSet{GPU: model.ResourceGPU{Cards: gpus}}
+	}
+	return job
+}
+
+// recount 从头统计所有作业的使用量，用于校验增量统计的结果
+func recount(jobs map[string]*model.Job) *usageCounter {
+	counter := newUsageCounter()
+	for _, job := range jobs {
+		counter.trackJob(job)
+	}
+	return counter
+}
+
+// nonZero 返回去掉了零值项的使用量，增量统计会保留已经归零的用户和队列
+func nonZero(m map[string]*limitUsage) map[string]limitUsage {
+	result := make(map[string]limitUsage)
+	for k, u := range m {
+		if *u != (limitUsage{}) {
+			result[k] = *u
+		}
+	}
+	return result
+}
+
+func TestUsageCounterIncremental(t *testing.T) {
+	counter := newUsageCounter()
+	jobs := make(map[string]*model.Job)
+	states := []model.TaskState{model.TaskQueued, model.TaskScheduled, model.TaskDispatching, model.TaskExecuting,
+		model.TaskCompleted, model.TaskFailed, model.TaskAborted, model.TaskTerminated}
+	queues := []string{"default", "gpu"}
+	owners := []string{"", "alice", "bob"}
+	rnd := rand.New(rand.NewSource(1))
+	next := 0
+	for step := 0; step < 5000; step++ {
+		var job *model.Job
+		for _, j := range jobs {
+			job = j
+			break
+		}
+		switch op := rnd.Intn(10); {
+		case op == 0 || job == nil:
+			// 提交作业
+			next++
+			job = newUsageJob(fmt.Sprintf("job%d", next), queues[rnd.Intn(len(queues))], owners[rnd.Intn(len(owners))], 1+rnd.Intn(5), rnd.Intn(3))
+			jobs[job.ID] = job
+			counter.trackJob(job)
+		case op == 1:
+			// 删除作业
+			delete(jobs, job.ID)
+			counter.untrackJob(job.ID)
+		case op == 2:
+			// 终止作业
+			job.State = model.JobTerminated
+			counter.trackJob(job)
+		case op == 3:
+			// 移动到其它队列
+			job.Queue = queues[rnd.Intn(len(queues))]
+			counter.trackJob(job)
+		case op == 4:
+			// 重新执行作业
+			for _, task := range job.Groups[0].Tasks {
+				if rnd.Intn(2) == 0 {
+					task.Reset()
+				}
+			}
+			job.State = model.JobQueued
+			job.RefreshState()
+			counter.trackJob(job)
+		default:
+			// 节点上报或调度器修改Task的状态
+			task := job.Groups[0].Tasks[rnd.Intn(len(job.Groups[0].Tasks))]
+			from, finished := task.State, isJobFinished(job)
+			task.State = states[rnd.Intn(len(states))]
+			if job.State != model.JobTerminated {
+				job.RefreshState()
+			}
+			counter.taskUpdated(job, task, from, finished)
+		}
+
+		expected := recount(jobs)
+		if !reflect.DeepEqual(nonZero(counter.queues), nonZero(expected.queues)) {
+			t.Fatalf("step %d: queue usage %v, want %v", step, nonZero(counter.queues), nonZero(expected.queues))
+		}
+		if !reflect.DeepEqual(nonZero(counter.users), nonZero(expected.users)) {
+			t.Fatalf("step %d: user usage %v, want %v", step, nonZero(counter.users), nonZero(expected.users))
+		}
+		if len(counter.jobs) != len(expected.jobs) {
+			t.Fatalf("step %d: %d jobs tracked, want %d", step, len(counter.jobs), len(expected.jobs))
+		}
+	}
+}
+
+func TestUsageCounterFinishedJob(t *testing.T) {
+	counter := newUsageCounter()
+	job := newUsageJob("job", "default", "alice", 2, 1)
+	counter.trackJob(job)
+	if u := *counter.users["alice"]; u != (limitUsage{pendingJobs: 1, queuedTasks: 2}) {
+		t.Fatalf("usage after submit: %+v", u)
+	}
+	task := job.Groups[0].Tasks[0]
+	counter.taskChanged(job, task, model.TaskQueued, model.TaskScheduled)
+	task.State = model.TaskScheduled
+	if u := *counter.queues["default"]; u != (limitUsage{pendingJobs: 1, queuedTasks: 1, runningTasks: 1, gpus: 1}) {
+		t.Fatalf("usage after schedule: %+v", u)
+	}
+	// 终止后的作业不再计入使用量，之后Task上报的状态也不会改变使用量
+	job.State = model.JobTerminated
+	counter.trackJob(job)
+	task.State = model.TaskTerminated
+	counter.taskUpdated(job, task, model.TaskScheduled, true)
+	if u := *counter.users["alice"]; u != (limitUsage{}) {
+		t.Fatalf("usage after terminate: %+v", u)
+	}
+	if counter.pendingJobs("default") != 0 {
+		t.Fatalf("pending jobs after terminate: %d", counter.pendingJobs("default"))
+	}
+}
+
+func TestCheckMoveLimits(t *testing.T) {
+	svc := &APIServer{usage: newUsageCounter()}
+	svc.config.Limits.Queues = map[string]LimitSpec{"gpu": {MaxPendingJobs: 2, MaxQueuedTasks: 5}}
+	first := newUsageJob("first", "default", "alice", 3, 0)
+	second := newUsageJob("second", "default", "alice", 3, 0)
+	large := newUsageJob("large", "default", "alice", 6, 0)
+	for _, job := range []*model.Job{first, second, large} {
+		svc.usage.trackJob(job)
+	}
+	if err := svc.checkMoveLimits(first, "gpu"); err != nil {
+		t.Fatalf("first move is rejected: %v", err)
+	}
+	first.Queue = "gpu"
+	svc.usage.trackJob(first)
+	// 目标队列中已有3个等待调度的任务，再移入3个会超过限制
+	if err := svc.checkMoveLimits(second, "gpu"); err == nil || err.(*statusError).status != 429 {
+		t.Errorf("move over queued task limit: %v", err)
+	}
+	if err := svc.checkMoveLimits(large, "gpu"); err == nil || err.(*statusError).status != 403 {
+		t.Errorf("move of job larger than the limit: %v", err)
+	}
+	// 已开始执行的Task不计入等待调度的任务数
+	for _, task := range second.Groups[0].Tasks[:2] {
+		svc.usage.taskChanged(second, task, task.State, model.TaskExecuting)
+		task.State = model.TaskExecuting
+	}
+	if err := svc.checkMoveLimits(second, "gpu"); err != nil {
+		t.Errorf("move within limits is rejected: %v", err)
+	}
+	second.Queue = "gpu"
+	svc.usage.trackJob(second)
+	if err := svc.checkMoveLimits(large, "gpu"); err == nil {
+		t.Error("move over pending job limit is accepted")
+	}
+	if err := svc.checkMoveLimits(first, "gpu"); err != nil {
+		t.Errorf("job already in the queue is rejected: %v", err)
+	}
+}
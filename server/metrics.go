@@ -0,0 +1,136 @@
+package server
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/qianxiaoming/lightsched/data"
+	"github.com/qianxiaoming/lightsched/metrics"
+	"github.com/qianxiaoming/lightsched/model"
+)
+
+// serverMetrics 包含API Server统计的各项指标
+type serverMetrics struct {
+	registry       *metrics.Registry
+	cycleSeconds   *metrics.Histogram
+	cyclePlaced    *metrics.Histogram
+	queueWait      *metrics.Histogram
+	heartbeatDelay *metrics.Histogram
+	missedBeats    *metrics.Counter
+	httpRequests   *metrics.Counter
+
+	sync.Mutex
+	lastHeartbeat map[string]time.Time
+}
+
+// newServerMetrics 创建并注册API Server的所有指标
+func newServerMetrics(svc *APIServer) *serverMetrics {
+	m := &serverMetrics{
+		registry:       metrics.NewRegistry(),
+		cycleSeconds:   metrics.NewHistogram("lightsched_schedule_cycle_seconds", "Duration of scheduling cycles in seconds.", metrics.DefaultBuckets),
+		cyclePlaced:    metrics.NewHistogram("lightsched_schedule_tasks_placed", "Number of tasks placed per scheduling cycle.", []float64{0, 1, 2, 5, 10, 20, 50, 100, 200, 500}),
+		queueWait:      metrics.NewHistogram("lightsched_queue_wait_seconds", "Time from job submission until its task is scheduled in seconds.", []float64{1, 5, 15, 30, 60, 300, 900, 1800, 3600, 7200, 21600, 86400}, "queue"),
+		heartbeatDelay: metrics.NewHistogram("lightsched_heartbeat_interval_seconds", "Interval between consecutive heartbeats of a node in seconds.", []float64{1, 2, 3, 5, 8, 13, 21, 34, 55}),
+		missedBeats:    metrics.NewCounter("lightsched_missed_heartbeats_total", "Number of times a node missed its heartbeat deadline.", "node"),
+		httpRequests:   metrics.NewCounter("lightsched_http_requests_total", "Number of HTTP requests by listener, route and status code.", "listener", "method", "route", "code"),
+		lastHeartbeat:  make(map[string]time.Time),
+	}
+	m.registry.Register(
+		metrics.NewGaugeFunc("lightsched_jobs", "Number of jobs by state and queue.", []string{"state", "queue"}, svc.collectJobs),
+		metrics.NewGaugeFunc("lightsched_tasks", "Number of tasks by state and queue.", []string{"state", "queue"}, svc.collectTasks),
+		metrics.NewGaugeFunc("lightsched_node_cpu_cores", "Allocated and available CPU cores of nodes.", []string{"node", "kind"}, svc.collectNodes(func(r *model.ResourceSet) float64 { return float64(r.CPU.Cores) })),
+		metrics.NewGaugeFunc("lightsched_node_gpu_cards", "Allocated and available GPU cards of nodes.", []string{"node", "kind"}, svc.collectNodes(func(r *model.ResourceSet) float64 { return float64(r.GPU.Cards) })),
+		metrics.NewGaugeFunc("lightsched_node_memory_mebibytes", "Allocated and available memory of nodes in MiB.", []string{"node", "kind"}, svc.collectNodes(func(r *model.ResourceSet) float64 { return float64(r.Memory) })),
+		m.cycleSeconds,
+		m.cyclePlaced,
+		m.queueWait,
+		m.heartbeatDelay,
+		m.missedBeats,
+		data.WriteLatency,
+		m.httpRequests,
+	)
+	return m
+}
+
+// observeHeartbeat 记录节点距上次心跳的间隔
+func (m *serverMetrics) observeHeartbeat(node string) {
+	now := time.Now()
+	m.Lock()
+	last, ok := m.lastHeartbeat[node]
+	m.lastHeartbeat[node] = now
+	m.Unlock()
+	if ok {
+		m.heartbeatDelay.Observe(now.Sub(last).Seconds())
+	}
+}
+
+// instrument 返回按路径统计HTTP请求数的中间件
+func (m *serverMetrics) instrument(listener string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+		route := c.FullPath()
+		if len(route) == 0 {
+			route = "unmatched"
+		}
+		m.httpRequests.Inc(listener, c.Request.Method, route, strconv.Itoa(c.Writer.Status()))
+	}
+}
+
+// stateQueueCounter 按状态和队列计数
+type stateQueueCounter map[[2]string]int
+
+func (counter stateQueueCounter) emit(emit func(value float64, labelValues ...string)) {
+	for k, v := range counter {
+		emit(float64(v), k[0], k[1])
+	}
+}
+
+func (svc *APIServer) collectJobs(emit func(value float64, labelValues ...string)) {
+	counter := make(stateQueueCounter)
+	svc.state.Lock()
+	for _, job := range svc.state.GetAllJobs() {
+		counter[[2]string{model.JobStateToString(job.State), job.Queue}]++
+	}
+	svc.state.Unlock()
+	counter.emit(emit)
+}
+
+func (svc *APIServer) collectTasks(emit func(value float64, labelValues ...string)) {
+	counter := make(stateQueueCounter)
+	svc.state.Lock()
+	for _, job := range svc.state.GetAllJobs() {
+		for _, g := range job.Groups {
+			for _, t := range g.Tasks {
+				counter[[2]string{model.TaskStateToString(t.State), job.Queue}]++
+			}
+		}
+	}
+	svc.state.Unlock()
+	counter.emit(emit)
+}
+
+// collectNodes 返回输出节点某项资源已分配量和可用量的函数。已分配量为节点总资源减去保留资源和可用资源。
+func (svc *APIServer) collectNodes(value func(r *model.ResourceSet) float64) func(emit func(value float64, labelValues ...string)) {
+	return func(emit func(value float64, labelValues ...string)) {
+		svc.nodes.Lock()
+		defer svc.nodes.Unlock()
+		for _, n := range svc.nodes.GetNodes() {
+			available := value(n.Available)
+			emit(value(n.Resources)-value(n.Reserved)-available, n.Name, "allocated")
+			emit(available, n.Name, "available")
+		}
+	}
+}
+
+// MetricsEndpoint 是输出Prometheus格式指标的接口
+type MetricsEndpoint struct{}
+
+func (e MetricsEndpoint) registerRoute() {
+	apiserver.restRouter.GET(e.restPrefix(), authorize(PermRead), gin.WrapH(apiserver.metrics.registry))
+}
+
+func (e MetricsEndpoint) restPrefix() string {
+	return "/metrics"
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/qianxiaoming/lightsched/constant"
+)
+
+// nodeIdentityKey 是已认证的节点名字在gin.Context中的键
+const nodeIdentityKey = "lightsched.node"
+
+// nodeAuthEnabled 返回是否需要对节点进行认证
+func (svc *APIServer) nodeAuthEnabled() bool {
+	return svc.config.NodeAuth || len(svc.config.JoinToken) > 0
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// verifyJoinToken 检查节点注册时提供的加入token，issued表示使用的是为节点单独签发的token。
+// 为节点单独签发的token优先于共享token。
+func (svc *APIServer) verifyJoinToken(name string, token string) (issued bool, valid bool) {
+	if len(token) == 0 {
+		return false, false
+	}
+	svc.state.RLock()
+	hash := svc.state.GetNodeToken(name)
+	svc.state.RUnlock()
+	if len(hash) > 0 {
+		return true, subtle.ConstantTimeCompare([]byte(hash), []byte(hashToken(token))) == 1
+	}
+	return false, len(svc.config.JoinToken) > 0 && subtle.ConstantTimeCompare([]byte(svc.config.JoinToken), []byte(token)) == 1
+}
+
+// authenticateNode 是节点接口的认证中间件，检查请求携带的节点会话密钥
+func authenticateNode(c *gin.Context) {
+	if !apiserver.nodeAuthEnabled() {
+		return
+	}
+	name := c.GetHeader(constant.NodeNameHeader)
+	secret := bearerToken(c.Request)
+	apiserver.nodes.RLock()
+	node := apiserver.nodes.GetNode(name)
+	valid := node != nil && len(secret) > 0 && subtle.ConstantTimeCompare([]byte(node.Secret), []byte(secret)) == 1
+	apiserver.nodes.RUnlock()
+	if node == nil {
+		// 节点需要重新注册
+		c.AbortWithStatus(http.StatusNotFound)
+	} else if !valid {
+		c.AbortWithStatus(http.StatusUnauthorized)
+	} else {
+		c.Set(nodeIdentityKey, name)
+	}
+}
+
+// requestNode 返回已认证的节点名字，未启用节点认证时返回空字符串
+func requestNode(c *gin.Context) string {
+	return c.GetString(nodeIdentityKey)
+}
+
+// nodeOwnsTask 检查发起请求的节点是否是Task的执行节点
+func nodeOwnsTask(c *gin.Context, taskid string) bool {
+	name := requestNode(c)
+	if len(name) == 0 {
+		return true
+	}
+	apiserver.state.RLock()
+	defer apiserver.state.RUnlock()
+	task := apiserver.state.GetTask(taskid)
+	return task != nil && task.NodeName == name
+}
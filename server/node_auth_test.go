@@ -0,0 +1,91 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/qianxiaoming/lightsched/constant"
+	"github.com/qianxiaoming/lightsched/message"
+	"github.com/qianxiaoming/lightsched/model"
+)
+
+// nodeRequest 以节点的身份发送请求，token是加入token或会话密钥
+func nodeRequest(svc *APIServer, path string, name string, token string, body interface{}) *httptest.ResponseRecorder {
+	b, _ := json.Marshal(body)
+	req := httptest.NewRequest("POST", path, bytes.NewReader(b))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(constant.NodeNameHeader, name)
+	if len(token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	svc.NodeHandler().ServeHTTP(w, req)
+	return w
+}
+
+// registerAs 使用token注册节点，返回状态码和分配的会话密钥
+func registerAs(svc *APIServer, name string, token string) (int, string) {
+	reg := &message.RegisterNode{Name: name, Resources: model.ResourceSet{CPU: model.ResourceCPU{Cores: 4}, Memory: 4096}}
+	w := nodeRequest(svc, "/nodes", name, token, reg)
+	result := struct {
+		Secret string `json:"secret"`
+	}{}
+	json.Unmarshal(w.Body.Bytes(), &result)
+	return w.Code, result.Secret
+}
+
+func TestRegisterWithJoinToken(t *testing.T) {
+	svc := newTestServer(t, &Config{JoinToken: "shared"})
+	heartbeat := func(secret string) int {
+		return nodeRequest(svc, "/heartbeat", "node1", secret, &message.Heartbeat{Name: "node1"}).Code
+	}
+
+	if code, _ := registerAs(svc, "node1", "wrong"); code != http.StatusUnauthorized {
+		t.Errorf("register with wrong token = %d, want 401", code)
+	}
+	code, first := registerAs(svc, "node1", "shared")
+	if code != http.StatusOK || len(first) == 0 {
+		t.Fatalf("register with shared token = %d, secret %q", code, first)
+	}
+	// 共享token不能替换仍然在线的同名节点
+	if code, _ := registerAs(svc, "node1", "shared"); code != http.StatusConflict {
+		t.Errorf("register online node again with shared token = %d, want 409", code)
+	}
+	if code := heartbeat(first); code != http.StatusOK {
+		t.Errorf("heartbeat of the registered node = %d", code)
+	}
+
+	// 单独签发的token可以替换在线的节点，之前的会话密钥失效
+	token, err := svc.requestIssueNodeToken("node1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, second := registerAs(svc, "node1", token)
+	if code != http.StatusOK || len(second) == 0 || second == first {
+		t.Fatalf("register with issued token = %d, secret %q", code, second)
+	}
+	if code := heartbeat(first); code != http.StatusUnauthorized {
+		t.Errorf("heartbeat with replaced secret = %d, want 401", code)
+	}
+	if code := heartbeat(second); code != http.StatusOK {
+		t.Errorf("heartbeat with new secret = %d", code)
+	}
+	// 签发token后共享token不再对该节点有效
+	if code, _ := registerAs(svc, "node1", "shared"); code != http.StatusUnauthorized {
+		t.Errorf("register with shared token after issuing = %d, want 401", code)
+	}
+
+	// 节点超时后可以使用共享token重新注册
+	if code, _ := registerAs(svc, "node2", "shared"); code != http.StatusOK {
+		t.Fatalf("register node2 = %d", code)
+	}
+	svc.nodes.Lock()
+	svc.nodes.GetNode("node2").State = model.NodeUnknown
+	svc.nodes.Unlock()
+	if code, _ := registerAs(svc, "node2", "shared"); code != http.StatusOK {
+		t.Errorf("register timed out node with shared token = %d, want 200", code)
+	}
+}
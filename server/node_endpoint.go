@@ -6,11 +6,11 @@ import (
 	"log"
 	"net/http"
 	"os"
-	"path/filepath"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"github.com/qianxiaoming/lightsched/message"
-	"github.com/qianxiaoming/lightsched/model"
+	"github.com/qianxiaoming/lightsched/util"
 )
 
 // NodeRegisterEndpoint 是计算节点向主节点注册的接口Node
@@ -27,9 +27,22 @@ func (e NodeRegisterEndpoint) registerRoute() {
 			log.Printf("    CPU Info: %d cores %dMHz", int(reg.Resources.CPU.Cores), reg.Resources.CPU.MinFreq)
 			log.Printf("    Mem Info: %dGi", reg.Resources.Memory/1024)
 			log.Printf("    GPU Info: %d card(s) %dGi with CUDA %.1f", int(reg.Resources.GPU.Cards), reg.Resources.GPU.Memory, float32(reg.Resources.GPU.CUDA)/100.0)
-			err = apiserver.requestRegisterNode(ip, reg)
+			// 节点需要提供有效的加入token，注册成功后使用分配的会话密钥访问其他接口。
+			// 共享token不能证明节点的身份，因此使用共享token时不能替换仍然在线的同名节点。
+			secret := ""
+			replace := true
+			if apiserver.nodeAuthEnabled() {
+				issued, valid := apiserver.verifyJoinToken(reg.Name, bearerToken(c.Request))
+				if !valid {
+					responseError(http.StatusUnauthorized, "Node register denied: %v", fmt.Errorf("invalid join token for %s", reg.Name), c)
+					return
+				}
+				secret = util.GenerateUUID()
+				replace = issued
+			}
+			err = apiserver.requestRegisterNode(ip, reg, secret, replace)
 			if err == nil {
-				c.JSON(http.StatusOK, gin.H{"cluster": apiserver.config.Cluster})
+				c.JSON(http.StatusOK, gin.H{"cluster": apiserver.config.Cluster, "rest": apiserver.config.RestPort, "secret": secret})
 				log.Println("Node registered")
 			} else {
 				responseError(http.StatusNotAcceptable, "%v", err, c)
@@ -48,10 +61,15 @@ func (e NodeRegisterEndpoint) restPrefix() string {
 type HeartbeatEndpoint struct{}
 
 func (e HeartbeatEndpoint) registerRoute() {
-	apiserver.nodeRouter.POST(e.restPrefix(), func(c *gin.Context) {
+	apiserver.nodeRouter.POST(e.restPrefix(), authenticateNode, func(c *gin.Context) {
 		hb := &message.Heartbeat{}
 		if err := c.BindJSON(hb); err == nil {
-			msgs, found := apiserver.nodes.PeriodicUpdate(hb.Name, hb.CPU, hb.Memory, hb.Executings)
+			if name := requestNode(c); len(name) > 0 && name != hb.Name {
+				responseError(http.StatusForbidden, "Heartbeat denied: %v", fmt.Errorf("node %s cannot send heartbeat for %s", name, hb.Name), c)
+				return
+			}
+			apiserver.metrics.observeHeartbeat(hb.Name)
+			msgs, found := apiserver.nodes.PeriodicUpdate(hb.Name, hb.CPU, hb.Memory, hb.Executings, hb.Scratch)
 			status := http.StatusOK
 			if !found {
 				status = http.StatusNotFound
@@ -63,7 +81,7 @@ func (e HeartbeatEndpoint) registerRoute() {
 			}
 			// 更新上报的Task状态
 			if len(hb.Payload) != 0 {
-				go apiserver.requestUpdateTasks(hb.Payload)
+				go apiserver.requestUpdateTasks(hb.Name, hb.Payload)
 			}
 		} else {
 			log.Printf("Invalid heartbeat from %s: %v\n", c.ClientIP(), err)
@@ -80,15 +98,51 @@ func (e HeartbeatEndpoint) restPrefix() string {
 type TaskLogEndpoint struct{}
 
 func (e TaskLogEndpoint) registerRoute() {
-	apiserver.nodeRouter.POST(e.restPrefix(), func(c *gin.Context) {
-		jobid, gindex, tindex := model.ParseTaskID(c.Param("taskid"))
-		filename := filepath.Join(apiserver.config.DataPath, jobid, fmt.Sprintf("%d.%d.log", gindex, tindex))
-		file, err := os.OpenFile(filename, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0666)
+	apiserver.nodeRouter.POST(e.restPrefix(), transferDeadline, authenticateNode, func(c *gin.Context) {
+		taskid, ok := parseTaskParam(c, "taskid")
+		if !ok {
+			return
+		}
+		if !nodeOwnsTask(c, c.Param("taskid")) {
+			c.Status(http.StatusForbidden)
+			return
+		}
+		// 节点在任务运行期间分段上传日志，offset是本段内容在日志中的位置，没有指定时覆盖整个日志
+		var offset int64
+		if s := c.Query("offset"); len(s) > 0 {
+			var err error
+			if offset, err = strconv.ParseInt(s, 10, 64); err != nil || offset < 0 {
+				responseError(http.StatusBadRequest, "Failed to save task log: %v", fmt.Errorf("invalid offset %s", s), c)
+				return
+			}
+		}
+		filename := apiserver.taskLogPath(taskid)
+		flag := os.O_WRONLY | os.O_CREATE
+		if offset == 0 {
+			flag |= os.O_TRUNC
+		}
+		file, err := os.OpenFile(filename, flag, 0666)
 		if err != nil {
 			log.Printf("Unable to create log file %s: %v\n", c.Param("taskid"), err)
 			responseError(http.StatusInternalServerError, "Failed to save task log: %v", err, c)
+			return
 		}
 		defer file.Close()
+		if offset > 0 {
+			// 重新上传的内容从offset处覆盖，上次中断时写入的部分内容被丢弃
+			if info, err := file.Stat(); err == nil && info.Size() < offset {
+				responseError(http.StatusConflict, "Failed to save task log: %v", fmt.Errorf("offset %d is beyond the end of the log", offset), c)
+				return
+			}
+			if err := file.Truncate(offset); err != nil {
+				responseError(http.StatusInternalServerError, "Failed to save task log: %v", err, c)
+				return
+			}
+			if _, err := file.Seek(offset, io.SeekStart); err != nil {
+				responseError(http.StatusInternalServerError, "Failed to save task log: %v", err, c)
+				return
+			}
+		}
 		if _, err := io.Copy(file, c.Request.Body); err == nil {
 			c.Status(http.StatusOK)
 		} else {
@@ -100,3 +154,95 @@ func (e TaskLogEndpoint) registerRoute() {
 func (e TaskLogEndpoint) restPrefix() string {
 	return "/tasks/:taskid/log"
 }
+
+// TaskArtifactEndpoint 是计算节点向主节点上传和下载Task结果文件的接口
+type TaskArtifactEndpoint struct{}
+
+func (e TaskArtifactEndpoint) registerRoute() {
+	apiserver.nodeRouter.GET(e.restPrefix(), transferDeadline, authenticateNode, func(c *gin.Context) {
+		taskid, ok := parseTaskParam(c, "taskid")
+		if !ok {
+			return
+		}
+		filename := apiserver.requestGetTaskArtifact(taskid, c.Param("path"))
+		if len(filename) == 0 {
+			c.Status(http.StatusNotFound)
+		} else {
+			c.File(filename)
+		}
+	})
+	apiserver.nodeRouter.POST(e.restPrefix(), transferDeadline, authenticateNode, func(c *gin.Context) {
+		taskid, ok := parseTaskParam(c, "taskid")
+		if !ok {
+			return
+		}
+		if !nodeOwnsTask(c, c.Param("taskid")) {
+			c.Status(http.StatusForbidden)
+			return
+		}
+		limit := apiserver.config.MaxArtifactSize
+		if c.Request.ContentLength > limit {
+			responseError(http.StatusRequestEntityTooLarge, "Failed to save task artifact: %v",
+				fmt.Errorf("size %d exceeds the limit %d", c.Request.ContentLength, limit), c)
+			return
+		}
+		body := http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+		if err := apiserver.requestSaveTaskArtifact(taskid, c.Param("path"), body); err == nil {
+			c.Status(http.StatusOK)
+		} else if isBodyTooLarge(err) {
+			responseError(http.StatusRequestEntityTooLarge, "Failed to save task artifact: %v", err, c)
+		} else {
+			responseError(http.StatusInternalServerError, "Failed to save task artifact: %v", err, c)
+		}
+	})
+}
+
+// isBodyTooLarge 检查错误是否由http.MaxBytesReader在请求内容超过限制时返回。Go 1.14没有对应的错误类型，只能比较错误信息。
+func isBodyTooLarge(err error) bool {
+	return err != nil && err.Error() == "http: request body too large"
+}
+
+func (e TaskArtifactEndpoint) restPrefix() string {
+	return "/tasks/:taskid/artifacts/*path"
+}
+
+// JobInputEndpoint 是计算节点从主节点下载随作业上传的输入文件的接口
+type JobInputEndpoint struct{}
+
+func (e JobInputEndpoint) registerRoute() {
+	apiserver.nodeRouter.GET(e.restPrefix(), transferDeadline, authenticateNode, func(c *gin.Context) {
+		filename := apiserver.requestGetJobInput(c.Param("id"), c.Param("path"))
+		if len(filename) == 0 {
+			c.Status(http.StatusNotFound)
+		} else {
+			c.File(filename)
+		}
+	})
+}
+
+func (e JobInputEndpoint) restPrefix() string {
+	return "/jobs/:id/inputs/*path"
+}
+
+// TaskSecretEndpoint 是计算节点在启动Task时获取引用的密钥值的接口，仅在启用节点认证时可用
+type TaskSecretEndpoint struct{}
+
+func (e TaskSecretEndpoint) registerRoute() {
+	apiserver.nodeRouter.GET(e.restPrefix(), authenticateNode, func(c *gin.Context) {
+		name := requestNode(c)
+		if len(name) == 0 {
+			responseError(http.StatusForbidden, "Unable to get secret: %v", fmt.Errorf("node authentication is required"), c)
+			return
+		}
+		value, err := apiserver.requestGetTaskSecret(c.Param("taskid"), c.Param("name"), name)
+		if err == nil {
+			c.String(http.StatusOK, value)
+		} else {
+			responseError(http.StatusForbidden, "Unable to get secret: %v", err, c)
+		}
+	})
+}
+
+func (e TaskSecretEndpoint) restPrefix() string {
+	return "/tasks/:taskid/secrets/:name"
+}
@@ -0,0 +1,309 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/qianxiaoming/lightsched/message"
+	"github.com/qianxiaoming/lightsched/model"
+)
+
+// mediaType 表示非JSON格式的请求或应答内容
+type mediaType string
+
+// apiOperation 描述了一个RESTful API操作，用于生成OpenAPI文档
+type apiOperation struct {
+	id       string      // operationId，生成客户端时作为方法名
+	summary  string      // 操作说明
+	query    []string    // 查询参数，"名称:类型"，类型默认为string
+	request  interface{} // 请求体的类型，为mediaType时表示非JSON内容
+	response interface{} // 成功应答体的类型，nil表示没有应答体
+	status   int         // 成功时的状态码，默认为200
+}
+
+type createJobResponse struct {
+	ID string `json:"id"`
+}
+
+type nodeTokenResponse struct {
+	Token string `json:"token"`
+}
+
+type clusterInfo struct {
+	ID    string `json:"id"`
+	Cycle int64  `json:"cycle"`
+}
+
+type secretValue struct {
+	Value string `json:"value"`
+}
+
+var (
+	jobQueryParams  = []string{"state", "queue", "owner", "name", "q", "labels", "submit_from", "submit_to", "finish_from", "finish_to", "sort", "cursor", "offset:integer", "limit:integer"}
+	taskQueryParams = []string{"jobid", "ids", "state", "group", "node", "exit_code:integer", "labels", "cursor", "limit:integer", "summary:boolean"}
+)
+
+// apiOperations 是所有RESTful API的描述，键为"方法 路径"，路径不含版本前缀。
+// 新增路由时需要同时在这里添加描述，否则启动时会输出警告。
+var apiOperations = map[string]*apiOperation{
+	"GET /cluster":                   {id: "getCluster", summary: "Get cluster information", response: clusterInfo{}},
+	"GET /jobs":                      {id: "listJobs", summary: "List jobs. The total count is returned in X-Total-Count and the next cursor in X-Next-Cursor", query: jobQueryParams, response: []*message.JobInfo{}},
+	"GET /jobs/:id":                  {id: "getJob", summary: "Get a job", response: message.JobInfo{}},
+	"GET /jobs/:id/outputs":          {id: "getJobOutput", summary: "Get outputs of all tasks in a job", response: message.JobOutput{}},
+	"POST /jobs":                     {id: "createJob", summary: "Submit a job. Input files can be uploaded with a multipart form whose job field is the job JSON", request: model.JobSpec{}, response: createJobResponse{}, status: http.StatusCreated},
+	"POST /jobs/_bulk":               {id: "bulkJobs", summary: "Apply an action to the jobs matching a selector", request: message.BulkJobRequest{}, response: message.BulkJobResponse{}},
+	"PUT /jobs/:id":                  {id: "modifyJob", summary: "Modify properties of a job", request: model.JobUpdatableProps{}, response: message.ActionResult{}},
+	"PUT /jobs/:id/_terminate":       {id: "terminateJob", summary: "Terminate a job", response: message.ActionResult{}, status: http.StatusAccepted},
+	"PUT /jobs/:id/_halt":            {id: "haltJob", summary: "Halt a job", response: message.ActionResult{}},
+	"PUT /jobs/:id/_resume":          {id: "resumeJob", summary: "Resume a halted job", response: message.ActionResult{}},
+	"PUT /jobs/:id/_rerun":           {id: "rerunJob", summary: "Rerun failed (tasks=failed) or all (tasks=all) tasks of a finished job", query: []string{"tasks"}, response: message.ActionResult{}},
+	"DELETE /jobs/:id":               {id: "deleteJob", summary: "Delete a job", response: message.ActionResult{}},
+	"GET /tasks":                     {id: "listTasks", summary: "List tasks of a job, or get tasks by ids. A summary is returned instead when summary=true", query: taskQueryParams, response: []*message.TaskStatus{}},
+	"GET /tasks/:id":                 {id: "getTask", summary: "Get a task. Only the status is returned when status is set", query: []string{"status"}, response: message.TaskInfo{}},
+	"GET /tasks/:id/log":             {id: "getTaskLog", summary: "Get the log of a task", response: mediaType("text/plain")},
+	"GET /tasks/:id/artifacts":       {id: "listTaskArtifacts", summary: "List artifacts of a task", response: []*message.ArtifactInfo{}},
+	"GET /tasks/:id/artifacts/*path": {id: "getTaskArtifact", summary: "Download an artifact of a task", response: mediaType("application/octet-stream")},
+	"GET /queues":                    {id: "listQueues", summary: "List job queues", response: []*message.JobQueueInfo{}},
+	"PUT /queues/:name":              {id: "enableQueue", summary: "Enable (enable=true) or disable a job queue", query: []string{"enable"}, response: message.ActionResult{}},
+	"GET /nodes":                     {id: "listNodes", summary: "List nodes. The total count is returned in X-Total-Count", response: []*message.NodeInfo{}},
+	"GET /nodes/:name":               {id: "getNode", summary: "Get a node", response: message.NodeInfo{}},
+	"GET /nodes/:name/tasks":         {id: "listNodeTasks", summary: "List unfinished tasks placed on a node", response: []*message.TaskStatus{}},
+	"PUT /nodes/:name/_offline":      {id: "offlineNode", summary: "Take a node offline", query: []string{"kill"}, response: message.ActionResult{}},
+	"PUT /nodes/:name/_online":       {id: "onlineNode", summary: "Bring a node online", response: message.ActionResult{}},
+	"POST /nodes/:name/_token":       {id: "issueNodeToken", summary: "Issue a join token for a node", response: nodeTokenResponse{}, status: http.StatusCreated},
+	"DELETE /nodes/:name/_token":     {id: "revokeNodeToken", summary: "Revoke the join token of a node", response: message.ActionResult{}},
+	"GET /secrets":                   {id: "listSecrets", summary: "List secrets", response: []*message.SecretInfo{}},
+	"PUT /secrets/:name":             {id: "putSecret", summary: "Create or update a secret", request: secretValue{}, response: message.ActionResult{}},
+	"DELETE /secrets/:name":          {id: "deleteSecret", summary: "Delete a secret", response: message.ActionResult{}},
+	"GET /audit":                     {id: "listAudit", summary: "Query audit records", query: []string{"object", "since", "limit:integer"}, response: []*message.AuditInfo{}},
+	"GET /accounting/usage":          {id: "getUsage", summary: "Report resource usage grouped by user, queue or node. CSV is returned when format=csv", query: []string{"group_by", "from", "to", "format"}, response: []*message.UsageInfo{}},
+	"GET /accounting/jobs":           {id: "listJobArchives", summary: "Query summaries of swept jobs", query: []string{"from", "to"}, response: []*model.JobArchive{}},
+	"GET /metrics":                   {id: "getMetrics", summary: "Get metrics in Prometheus text format", response: mediaType("text/plain")},
+	"GET /openapi.json":              {id: "getOpenAPI", summary: "Get this document", response: mediaType("application/json")},
+}
+
+// schemaBuilder 通过反射生成Go类型对应的JSON Schema，结构体类型放在components中引用
+type schemaBuilder struct {
+	schemas map[string]interface{}
+	types   map[string]reflect.Type
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func (b *schemaBuilder) schemaOf(t reflect.Type) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return b.schemaOf(t.Elem())
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": b.schemaOf(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": b.schemaOf(t.Elem())}
+	case reflect.Struct:
+		if t == timeType {
+			return map[string]interface{}{"type": "string", "format": "date-time"}
+		}
+		if len(t.Name()) == 0 {
+			return b.objectOf(t)
+		}
+		name := strings.Title(t.Name())
+		if other, ok := b.types[name]; ok && other != t {
+			name = strings.Title(t.PkgPath()[strings.LastIndex(t.PkgPath(), "/")+1:]) + name
+		}
+		if _, ok := b.types[name]; !ok {
+			b.types[name] = t
+			b.schemas[name] = b.objectOf(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]interface{}{}
+}
+
+// objectOf 按encoding/json的规则生成结构体的属性，匿名嵌入且没有指定名称的结构体字段会被展开
+func (b *schemaBuilder) objectOf(t reflect.Type) map[string]interface{} {
+	props := make(map[string]interface{})
+	var collect func(t reflect.Type)
+	collect = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag := f.Tag.Get("json")
+			if tag == "-" {
+				continue
+			}
+			name := strings.Split(tag, ",")[0]
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if f.Anonymous && len(name) == 0 && ft.Kind() == reflect.Struct {
+				collect(ft)
+				continue
+			}
+			if len(f.PkgPath) > 0 {
+				continue
+			}
+			if len(name) == 0 {
+				name = f.Name
+			}
+			props[name] = b.schemaOf(f.Type)
+		}
+	}
+	collect(t)
+	return map[string]interface{}{"type": "object", "properties": props}
+}
+
+// content 生成请求或应答的内容描述
+func (b *schemaBuilder) content(v interface{}) map[string]interface{} {
+	if media, ok := v.(mediaType); ok {
+		return map[string]interface{}{string(media): map[string]interface{}{}}
+	}
+	return map[string]interface{}{"application/json": map[string]interface{}{"schema": b.schemaOf(reflect.TypeOf(v))}}
+}
+
+// openAPIPath 将gin的路由路径转换为OpenAPI路径，并返回其中的路径参数
+func openAPIPath(path string) (string, []string) {
+	segments := strings.Split(path, "/")
+	params := make([]string, 0, 2)
+	for i, s := range segments {
+		if len(s) > 1 && (s[0] == ':' || s[0] == '*') {
+			params = append(params, s[1:])
+			segments[i] = "{" + s[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/"), params
+}
+
+// buildOpenAPI 根据注册的/v1路由和apiOperations生成OpenAPI文档。
+// 返回没有描述的路由以及没有对应路由的描述，用于检查两者是否一致。
+func buildOpenAPI(routes gin.RoutesInfo, secured bool) (map[string]interface{}, []string, []string) {
+	b := &schemaBuilder{schemas: make(map[string]interface{}), types: make(map[string]reflect.Type)}
+	errorResponse := map[string]interface{}{
+		"description": "Error",
+		"content":     b.content(message.ErrorResponse{}),
+	}
+	paths := make(map[string]interface{})
+	registered := make(map[string]bool)
+	undocumented := make([]string, 0)
+	for _, r := range routes {
+		if !strings.HasPrefix(r.Path, apiVersionPrefix+"/") {
+			continue
+		}
+		key := r.Method + " " + strings.TrimPrefix(r.Path, apiVersionPrefix)
+		registered[key] = true
+		op, ok := apiOperations[key]
+		if !ok {
+			undocumented = append(undocumented, key)
+			continue
+		}
+		path, names := openAPIPath(strings.TrimPrefix(r.Path, apiVersionPrefix))
+		params := make([]interface{}, 0, len(names)+len(op.query))
+		for _, name := range names {
+			params = append(params, map[string]interface{}{
+				"name": name, "in": "path", "required": true, "schema": map[string]interface{}{"type": "string"},
+			})
+		}
+		for _, q := range op.query {
+			name, typ := q, "string"
+			if i := strings.Index(q, ":"); i != -1 {
+				name, typ = q[:i], q[i+1:]
+			}
+			params = append(params, map[string]interface{}{
+				"name": name, "in": "query", "schema": map[string]interface{}{"type": typ},
+			})
+		}
+		status := op.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		success := map[string]interface{}{"description": http.StatusText(status)}
+		if op.response != nil {
+			success["content"] = b.content(op.response)
+		}
+		operation := map[string]interface{}{
+			"operationId": op.id,
+			"summary":     op.summary,
+			"tags":        []string{strings.Split(path, "/")[1]},
+			"responses": map[string]interface{}{
+				strconv.Itoa(status): success,
+				"default":            errorResponse,
+			},
+		}
+		if len(params) > 0 {
+			operation["parameters"] = params
+		}
+		if op.request != nil {
+			operation["requestBody"] = map[string]interface{}{"required": true, "content": b.content(op.request)}
+		}
+		item, ok := paths[path].(map[string]interface{})
+		if !ok {
+			item = make(map[string]interface{})
+			paths[path] = item
+		}
+		item[strings.ToLower(r.Method)] = operation
+	}
+	stale := make([]string, 0)
+	for key := range apiOperations {
+		if !registered[key] {
+			stale = append(stale, key)
+		}
+	}
+	sort.Strings(undocumented)
+	sort.Strings(stale)
+
+	doc := map[string]interface{}{
+		"openapi": "3.0.3",
+		"info":    map[string]interface{}{"title": "Light Scheduler API", "version": strings.TrimPrefix(apiVersionPrefix, "/")},
+		"servers": []interface{}{map[string]interface{}{"url": apiVersionPrefix}},
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"schemas": b.schemas,
+		},
+	}
+	if secured {
+		doc["components"].(map[string]interface{})["securitySchemes"] = map[string]interface{}{
+			"bearer": map[string]interface{}{"type": "http", "scheme": "bearer"},
+		}
+		doc["security"] = []interface{}{map[string]interface{}{"bearer": []string{}}}
+	}
+	return doc, undocumented, stale
+}
+
+// loadOpenAPI 生成OpenAPI文档并检查与注册的路由是否一致
+func (svc *APIServer) loadOpenAPI(routes gin.RoutesInfo) {
+	doc, undocumented, stale := buildOpenAPI(routes, svc.auth != nil)
+	for _, key := range undocumented {
+		log.Printf("Route \"%s\" is not described in the OpenAPI document\n", key)
+	}
+	for _, key := range stale {
+		log.Printf("OpenAPI operation \"%s\" has no route\n", key)
+	}
+	content, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		log.Printf("Unable to generate OpenAPI document: %v\n", err)
+		return
+	}
+	svc.openAPI = content
+}
+
+// serveOpenAPI 返回生成的OpenAPI文档
+func (svc *APIServer) serveOpenAPI(c *gin.Context) {
+	c.Data(http.StatusOK, "application/json; charset=utf-8", svc.openAPI)
+}
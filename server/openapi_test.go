@@ -0,0 +1,45 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestOpenAPIRoutes(t *testing.T) {
+	svc := newTestServer(t, nil)
+	_, undocumented, stale := buildOpenAPI(svc.restEngine.Routes(), false)
+	if len(undocumented) > 0 {
+		t.Errorf("routes not described in apiOperations: %q", undocumented)
+	}
+	if len(stale) > 0 {
+		t.Errorf("operations without routes: %q", stale)
+	}
+	// 修改和删除操作需要描述应答内容
+	for key, op := range apiOperations {
+		if (strings.HasPrefix(key, "PUT ") || strings.HasPrefix(key, "DELETE ")) && op.response == nil {
+			t.Errorf("operation %s has no response body", key)
+		}
+	}
+
+	w := serve(svc.RestHandler(), "GET", "/v1/openapi.json", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET openapi.json = %d", w.Code)
+	}
+	var doc struct {
+		Paths map[string]map[string]struct {
+			OperationID string `json:"operationId"`
+		} `json:"paths"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	count := 0
+	for _, item := range doc.Paths {
+		count += len(item)
+	}
+	if count != len(apiOperations) {
+		t.Errorf("document has %d operations, want %d", count, len(apiOperations))
+	}
+}
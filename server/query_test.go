@@ -0,0 +1,264 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/qianxiaoming/lightsched/data"
+	"github.com/qianxiaoming/lightsched/message"
+	"github.com/qianxiaoming/lightsched/model"
+)
+
+// createOwnedJob 以指定用户的身份提交带标签的作业
+func createOwnedJob(t *testing.T, svc *APIServer, id string, owner string, labels map[string]string, tasks int) {
+	group := &model.TaskGroupSpec{Name: "main", Command: "run"}
+	for i := 0; i < tasks; i++ {
+		group.TaskSpecs = append(group.TaskSpecs, &model.TaskSpec{Name: "t"})
+	}
+	spec := &model.JobSpec{ID: id, Name: id, Labels: labels, GroupSpecs: []*model.TaskGroupSpec{group}}
+	if err := svc.requestCreateJob(spec, owner, nil); err != nil {
+		t.Fatalf("create job %s: %v", id, err)
+	}
+}
+
+// findJobIDs 返回满足条件的所有Job的ID
+func findJobIDs(t *testing.T, svc *APIServer, q data.JobQuery) []string {
+	svc.state.RLock()
+	defer svc.state.RUnlock()
+	result, err := svc.state.FindJobs(&q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]string, 0, len(result.Jobs))
+	for _, job := range result.Jobs {
+		ids = append(ids, job.ID)
+	}
+	return ids
+}
+
+func TestFindJobsCursor(t *testing.T) {
+	svc := newTestServer(t, nil)
+	for i := 0; i < 7; i++ {
+		owner, labels := "alice", map[string]string{"team": "cv"}
+		if i%2 == 1 {
+			owner, labels = "bob", map[string]string{"team": "nlp"}
+		}
+		createOwnedJob(t, svc, fmt.Sprintf("job%d", i), owner, labels, 1)
+	}
+	selector, _ := model.ParseLabelSelector("team=cv")
+	queries := map[string]data.JobQuery{
+		"all":   {},
+		"owner": {Owner: "alice"},
+		"label": {Labels: selector},
+		"queue": {Queue: "default", States: []model.JobState{model.JobQueued}},
+	}
+	added := 0
+	for name, q := range queries {
+		q.SortBy = model.SortJobBySubmit
+		expected := findJobIDs(t, svc, q)
+		q.Limit = 2
+		// 逐页查询，每页之后提交满足条件的新作业，新作业不会出现在后续页中，已有作业既不重复也不遗漏
+		var ids []string
+		for page := 0; ; page++ {
+			if page > len(expected) {
+				t.Fatalf("%s: too many pages", name)
+			}
+			svc.state.RLock()
+			result, err := svc.state.FindJobs(&q)
+			svc.state.RUnlock()
+			if err != nil {
+				t.Fatalf("%s: page %d: %v", name, page, err)
+			}
+			if page == 0 && result.Total != len(expected) {
+				t.Errorf("%s: total %d, want %d", name, result.Total, len(expected))
+			}
+			for _, job := range result.Jobs {
+				ids = append(ids, job.ID)
+			}
+			if len(result.Next) == 0 {
+				break
+			}
+			q.Cursor = result.Next
+			createOwnedJob(t, svc, fmt.Sprintf("new%d", added), "alice", map[string]string{"team": "cv"}, 1)
+			added++
+		}
+		if !reflect.DeepEqual(ids, expected) {
+			t.Errorf("%s: paged jobs %v, want %v", name, ids, expected)
+		}
+	}
+
+	svc.state.RLock()
+	defer svc.state.RUnlock()
+	if _, err := svc.state.FindJobs(&data.JobQuery{Cursor: "not a cursor"}); err == nil {
+		t.Error("illegal cursor is accepted")
+	}
+}
+
+func TestFindJobTasksCursor(t *testing.T) {
+	svc := newTestServer(t, nil)
+	submitTestJob(t, svc, "job", 5)
+	// 部分Task失败，查询条件只选中其中一部分Task
+	svc.state.Lock()
+	for _, id := range []string{"job.0.1", "job.0.2", "job.0.4", "job.1.0"} {
+		svc.state.UpdateTaskStatus(id, model.TaskFailed, 0, 1, "", nil, nil)
+	}
+	svc.state.Unlock()
+	queries := map[string]data.TaskQuery{
+		"all":    {},
+		"failed": {States: []model.TaskState{model.TaskFailed}},
+		"group":  {Group: "first"},
+	}
+	for name, q := range queries {
+		svc.state.RLock()
+		all, err := svc.state.FindJobTasks("job", &q)
+		svc.state.RUnlock()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var expected, ids []string
+		for _, task := range all.Tasks {
+			expected = append(expected, task.ID)
+		}
+		for _, limit := range []int{1, 2, 3} {
+			q.Limit, q.Cursor, ids = limit, "", nil
+			for page := 0; ; page++ {
+				if page > len(expected) {
+					t.Fatalf("%s: too many pages with limit %d", name, limit)
+				}
+				svc.state.RLock()
+				result, err := svc.state.FindJobTasks("job", &q)
+				svc.state.RUnlock()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if result.Total != len(expected) || len(result.Tasks) > limit {
+					t.Fatalf("%s: page %d with limit %d has %d of %d tasks", name, page, limit, len(result.Tasks), result.Total)
+				}
+				for _, task := range result.Tasks {
+					ids = append(ids, task.ID)
+				}
+				if len(result.Next) == 0 {
+					break
+				}
+				q.Cursor = result.Next
+			}
+			if !reflect.DeepEqual(ids, expected) {
+				t.Errorf("%s: paged tasks with limit %d: %v, want %v", name, limit, ids, expected)
+			}
+		}
+	}
+
+	svc.state.RLock()
+	defer svc.state.RUnlock()
+	for _, cursor := range []string{"job.x", "other.0.0"} {
+		if _, err := svc.state.FindJobTasks("job", &data.TaskQuery{Cursor: cursor}); err == nil {
+			t.Errorf("illegal cursor %s is accepted", cursor)
+		}
+	}
+}
+
+// nodeTaskIDs 返回索引中分配到节点且未结束的Task
+func nodeTaskIDs(svc *APIServer, node string) []string {
+	svc.state.RLock()
+	defer svc.state.RUnlock()
+	var ids []string
+	for _, task := range svc.state.GetNodeTasks(node) {
+		ids = append(ids, task.ID)
+	}
+	return ids
+}
+
+func TestJobIndexConsistency(t *testing.T) {
+	svc := newTestServer(t, &Config{Offline: -1})
+	createOwnedJob(t, svc, "job1", "alice", map[string]string{"team": "cv"}, 2)
+	createOwnedJob(t, svc, "job2", "bob", map[string]string{"team": "nlp"}, 1)
+	selector := func(s string) model.LabelSelector {
+		selector, err := model.ParseLabelSelector(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return selector
+	}
+	check := func(what string, q data.JobQuery, want ...string) {
+		t.Helper()
+		if ids := findJobIDs(t, svc, q); len(ids) != len(want) || (len(want) > 0 && !reflect.DeepEqual(ids, want)) {
+			t.Errorf("%s: %v, want %v", what, ids, want)
+		}
+	}
+
+	// 修改标签后按旧标签查询不到作业，按新标签可以查询到
+	labels := &model.JobUpdatableProps{Labels: map[string]string{"team": "nlp", "tier": "gold"}}
+	if err := svc.requestModifyJobProps("job1", labels); err != nil {
+		t.Fatal(err)
+	}
+	check("old label", data.JobQuery{Labels: selector("team=cv")})
+	check("new label", data.JobQuery{Labels: selector("team=nlp"), SortBy: model.SortJobBySubmit}, "job2", "job1")
+	check("added label", data.JobQuery{Labels: selector("tier=gold")}, "job1")
+	check("owner", data.JobQuery{Owner: "alice"}, "job1")
+
+	// 节点超时后其上的Task重新排队，不再出现在节点的索引中
+	reg := &message.RegisterNode{Name: "node1", Resources: model.ResourceSet{CPU: model.ResourceCPU{Cores: 8, Frequency: 24000, MinFreq: 3000}, Memory: 16384}}
+	if w := serve(svc.NodeHandler(), "POST", "/nodes", reg); w.Code != http.StatusOK {
+		t.Fatalf("register node: %d %s", w.Code, w.Body.String())
+	}
+	if w := serve(svc.NodeHandler(), "POST", "/heartbeat", &message.Heartbeat{Name: "node1"}); w.Code != http.StatusOK {
+		t.Fatalf("heartbeat: %d %s", w.Code, w.Body.String())
+	}
+	svc.setScheduleFlag()
+	svc.runScheduleCycle()
+	if ids := nodeTaskIDs(svc, "node1"); !reflect.DeepEqual(ids, []string{"job1.0.0", "job1.0.1", "job2.0.0"}) {
+		t.Fatalf("tasks on node1 after scheduling: %v", ids)
+	}
+	svc.requestCheckNodes()
+	if ids := nodeTaskIDs(svc, "node1"); len(ids) != 0 {
+		t.Errorf("tasks on node1 after it is timed out: %v", ids)
+	}
+	check("queued jobs", data.JobQuery{States: []model.JobState{model.JobQueued}, SortBy: model.SortJobBySubmit}, "job2", "job1")
+
+	// 已终止作业中仍在超时节点上运行的Task设为终止状态
+	reg.Name = "node2"
+	if w := serve(svc.NodeHandler(), "POST", "/nodes", reg); w.Code != http.StatusOK {
+		t.Fatalf("register node: %d %s", w.Code, w.Body.String())
+	}
+	serve(svc.NodeHandler(), "POST", "/heartbeat", &message.Heartbeat{Name: "node2"})
+	svc.setScheduleFlag()
+	svc.runScheduleCycle()
+	serve(svc.NodeHandler(), "POST", "/heartbeat", &message.Heartbeat{Name: "node2"})
+	svc.requestUpdateTasks("node2", []*message.TaskReport{{ID: "job2.0.0", State: model.TaskExecuting}})
+	if err := svc.requestTerminateJob("job2"); err != nil {
+		t.Fatal(err)
+	}
+	if ids := nodeTaskIDs(svc, "node2"); !reflect.DeepEqual(ids, []string{"job1.0.0", "job1.0.1", "job2.0.0"}) {
+		t.Fatalf("tasks on node2 after scheduling: %v", ids)
+	}
+	svc.requestCheckNodes()
+	if ids := nodeTaskIDs(svc, "node2"); len(ids) != 0 {
+		t.Errorf("tasks on node2 after it is timed out: %v", ids)
+	}
+	svc.state.RLock()
+	state := svc.state.GetTask("job2.0.0").State
+	svc.state.RUnlock()
+	if state != model.TaskTerminated {
+		t.Errorf("running task of terminated job on the lost node: %v", state)
+	}
+
+	// 批量删除后所有索引中都不再有被删除的作业
+	for _, action := range []string{bulkTerminate, bulkDelete} {
+		req := &message.BulkJobRequest{Action: action, Selector: message.JobSelector{IDs: []string{"job1"}}}
+		if _, err := svc.requestBulkJobs(req, "", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check("all jobs", data.JobQuery{}, "job2")
+	check("owner after delete", data.JobQuery{Owner: "alice"})
+	check("label after delete", data.JobQuery{Labels: selector("tier=gold")})
+	check("submit range after delete", data.JobQuery{SubmitFrom: time.Unix(0, 0)}, "job2")
+	svc.state.RLock()
+	defer svc.state.RUnlock()
+	if task := svc.state.GetTask("job1.0.0"); task != nil {
+		t.Errorf("task of deleted job: %+v", task)
+	}
+}
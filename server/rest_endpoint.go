@@ -1,72 +1,142 @@
 package server
 
 import (
+	"encoding/json"
 	"fmt"
-	"io"
+	"io/ioutil"
 	"log"
+	"mime/multipart"
 	"net/http"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/qianxiaoming/lightsched/data"
 	"github.com/qianxiaoming/lightsched/message"
 	"github.com/qianxiaoming/lightsched/model"
 )
 
-func responseError(code int, format string, err error, c *gin.Context) {
-	str := fmt.Sprintf(format, err)
-	log.Printf(str)
-	if code != 0 {
-		c.String(code, str)
-	} else {
-		c.Writer.WriteString(str)
+// parseTaskParam 解析请求路径参数中的Task编号，格式不正确时返回400
+func parseTaskParam(c *gin.Context, name string) (model.TaskID, bool) {
+	id, err := model.ParseTaskID(c.Param(name))
+	if err != nil {
+		responseError(http.StatusBadRequest, "%v", fmt.Errorf("Invalid task id \"%s\"", c.Param(name)), c)
+		return id, false
 	}
+	return id, true
+}
+
+// taskNotFound 返回Task不存在的404响应
+func taskNotFound(id model.TaskID, c *gin.Context) {
+	responseError(http.StatusNotFound, "%v", fmt.Errorf("Task %s not found", id), c)
+}
+
+// responseAction 返回修改或删除资源成功的应答
+func responseAction(status int, kind string, id string, action string, c *gin.Context) {
+	c.JSON(status, &message.ActionResult{Kind: kind, ID: id, Action: action})
 }
 
 // JobEndpoint 是Job资源对象的RESTful API实现接口
 type JobEndpoint struct{}
 
 func (e JobEndpoint) registerRoute() {
-	// state=Executing&sort=submit/state&offset=0&limit=15
-	apiserver.restRouter.GET(e.restPrefix(), e.getJobs)
-	apiserver.restRouter.GET(e.restPrefix()+"/:id", e.getJob)
-	apiserver.restRouter.POST(e.restPrefix(), e.createJob)
-	apiserver.restRouter.PUT(e.restPrefix()+"/:id/_terminate", e.terminateJob)
-	apiserver.restRouter.PUT(e.restPrefix()+"/:id/_halt", e.haltJob)
-	apiserver.restRouter.PUT(e.restPrefix()+"/:id/_resume", e.resumeJob)
-	apiserver.restRouter.PUT(e.restPrefix()+"/:id", e.modifyJobProps)
-	apiserver.restRouter.DELETE(e.restPrefix()+"/:id", e.deleteJob)
+	// state=Executing,Queued&queue=default&owner=alice&name=train&q=text&labels=k=v,!k2
+	// &submit_from=&submit_to=&finish_from=&finish_to=&sort=submit/state&cursor=&offset=0&limit=15
+	apiserver.restRouter.GET(e.restPrefix(), authorize(PermRead), e.getJobs)
+	apiserver.restRouter.GET(e.restPrefix()+"/:id", authorize(PermRead), e.getJob)
+	apiserver.restRouter.GET(e.restPrefix()+"/:id/outputs", authorize(PermRead), e.getJobOutput)
+	apiserver.restRouter.POST(e.restPrefix(), transferDeadline, audited("job", "id", jobSnapshot), authorize(PermSubmit), e.createJob)
+	apiserver.restRouter.POST(e.restPrefix()+"/_bulk", audited("job", "id", nil), authorize(PermSubmit), e.bulkJobs)
+	apiserver.restRouter.PUT(e.restPrefix()+"/:id/_terminate", audited("job", "id", jobSnapshot), authorizeJob, e.terminateJob)
+	apiserver.restRouter.PUT(e.restPrefix()+"/:id/_halt", audited("job", "id", jobSnapshot), authorizeJob, e.haltJob)
+	apiserver.restRouter.PUT(e.restPrefix()+"/:id/_resume", audited("job", "id", jobSnapshot), authorizeJob, e.resumeJob)
+	apiserver.restRouter.PUT(e.restPrefix()+"/:id/_rerun", audited("job", "id", jobSnapshot), authorizeJob, e.rerunJob)
+	apiserver.restRouter.PUT(e.restPrefix()+"/:id", audited("job", "id", jobSnapshot), authorizeJob, e.modifyJobProps)
+	apiserver.restRouter.DELETE(e.restPrefix()+"/:id", audited("job", "id", jobSnapshot), authorizeJob, e.deleteJob)
 }
 
 func (e JobEndpoint) restPrefix() string {
 	return "/jobs"
 }
 
-func (e JobEndpoint) getJobs(c *gin.Context) {
-	var filterState *model.JobState = nil
+// parseJobQuery 解析查询作业的参数。state可以是逗号分隔的多个状态，时间参数的格式与parseTime相同。
+func parseJobQuery(c *gin.Context) (*data.JobQuery, error) {
+	query := &data.JobQuery{
+		Queue: c.Query("queue"),
+		Owner: c.Query("owner"),
+		Name:  c.Query("name"),
+		Text:  c.Query("q"),
+	}
 	if v := c.Query("state"); len(v) > 0 {
-		state := model.JobStateFromString(v)
-		filterState = &state
+		for _, s := range strings.Split(v, ",") {
+			state := model.JobStateFromString(s)
+			if !strings.EqualFold(model.JobStateToString(state), s) {
+				return nil, fmt.Errorf("illegal job state: %s", s)
+			}
+			query.States = append(query.States, state)
+		}
 	}
-	sortField := model.SortJobByDefault
+	var err error
+	if query.Labels, err = model.ParseLabelSelector(c.Query("labels")); err != nil {
+		return nil, err
+	}
+	times := map[string]*time.Time{
+		"submit_from": &query.SubmitFrom,
+		"submit_to":   &query.SubmitTo,
+		"finish_from": &query.FinishFrom,
+		"finish_to":   &query.FinishTo,
+	}
+	for name, t := range times {
+		if v := c.Query(name); len(v) > 0 {
+			if *t, err = parseTime(v); err != nil {
+				return nil, err
+			}
+		}
+	}
+	query.SortBy = model.SortJobByDefault
 	if v := c.Query("sort"); len(v) > 0 {
 		if v == "state" {
-			sortField = model.SortJobByState
+			query.SortBy = model.SortJobByState
 		} else if v == "submit" {
-			sortField = model.SortJobBySubmit
+			query.SortBy = model.SortJobBySubmit
 		}
 	}
-	offset := 0
+	query.Cursor = c.Query("cursor")
 	if v := c.Query("offset"); len(v) > 0 {
-		offset, _ = strconv.Atoi(v)
+		query.Offset, _ = strconv.Atoi(v)
+	}
+	// limits是旧版本使用的参数名
+	limit := c.Query("limit")
+	if len(limit) == 0 {
+		limit = c.Query("limits")
 	}
-	limits := -1
-	if v := c.Query("limits"); len(v) > 0 {
-		limits, _ = strconv.Atoi(v)
+	if len(limit) > 0 {
+		query.Limit, _ = strconv.Atoi(limit)
 	}
+	return query, nil
+}
 
-	allJobs := apiserver.requestListJobs(filterState, sortField, offset, limits)
-	if allJobs != nil {
+// getJobs 查询作业列表。满足条件的作业总数在X-Total-Count头中返回，按提交时间排序时下一页的游标在X-Next-Cursor头中返回。
+func (e JobEndpoint) getJobs(c *gin.Context) {
+	query, err := parseJobQuery(c)
+	if err != nil {
+		responseError(http.StatusBadRequest, "Invalid query: %v", err, c)
+		return
+	}
+	allJobs, total, next, err := apiserver.requestListJobs(query)
+	if err != nil {
+		responseError(http.StatusBadRequest, "Invalid query: %v", err, c)
+		return
+	}
+	c.Header("X-Total-Count", strconv.Itoa(total))
+	if len(next) > 0 {
+		c.Header("X-Next-Cursor", next)
+	}
+	// 旧API没有作业时返回404，/v1下返回空列表
+	if len(allJobs) > 0 || isVersioned(c) {
 		c.JSON(http.StatusOK, allJobs)
 	} else {
 		c.Status(http.StatusNotFound)
@@ -74,18 +144,73 @@ func (e JobEndpoint) getJobs(c *gin.Context) {
 }
 
 func (e JobEndpoint) getJob(c *gin.Context) {
-	c.Status(http.StatusNotFound)
-	jobInfo := apiserver.requestGetJob(c.Params.ByName("id"))
-	if jobInfo != nil {
+	id := c.Params.ByName("id")
+	if jobInfo := apiserver.requestGetJob(id); jobInfo != nil {
 		c.JSON(http.StatusOK, jobInfo)
+	} else {
+		responseError(http.StatusNotFound, "%v", errNotFound("Job %s not found", id), c)
+	}
+}
+
+func (e JobEndpoint) getJobOutput(c *gin.Context) {
+	id := c.Params.ByName("id")
+	if output := apiserver.requestGetJobOutput(id); output != nil {
+		c.JSON(http.StatusOK, output)
+	} else {
+		responseError(http.StatusNotFound, "%v", errNotFound("Job %s not found", id), c)
+	}
+}
+
+// bindJobSpec 解析提交的作业信息。multipart请求中名为job的字段是作业JSON，其余文件作为作业的输入文件。
+func bindJobSpec(c *gin.Context, spec *model.JobSpec) ([]*multipart.FileHeader, error) {
+	if c.ContentType() != binding.MIMEMultipartPOSTForm {
+		return nil, c.BindJSON(spec)
+	}
+	form, err := c.MultipartForm()
+	if err != nil {
+		return nil, err
 	}
+	var content []byte
+	if v, ok := form.Value["job"]; ok && len(v) > 0 {
+		content = []byte(v[0])
+	} else if fh, ok := form.File["job"]; ok && len(fh) > 0 {
+		f, err := fh[0].Open()
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		if content, err = ioutil.ReadAll(f); err != nil {
+			return nil, err
+		}
+	} else {
+		return nil, fmt.Errorf("no job field found in multipart form")
+	}
+	if err := json.Unmarshal(content, spec); err != nil {
+		return nil, err
+	}
+	files := make([]*multipart.FileHeader, 0, len(form.File))
+	for k, v := range form.File {
+		if k != "job" {
+			files = append(files, v...)
+		}
+	}
+	return files, nil
 }
 
 func (e JobEndpoint) createJob(c *gin.Context) {
 	spec := &model.JobSpec{}
-	if err := c.BindJSON(spec); err == nil {
+	if files, err := bindJobSpec(c, spec); err == nil {
+		if err = authorizeSubmit(c, spec); err != nil {
+			responseError(http.StatusForbidden, "Create job denied: %v", err, c)
+			return
+		}
+		if err = apiserver.checkSecretRefs(spec, requestIdentity(c)); err != nil {
+			responseError(http.StatusUnprocessableEntity, "Create job failed: %v", err, c)
+			return
+		}
 		log.Printf("Request to create job \"%s\"(%s) in queue \"%s\" with %d task group(s)...\n", spec.Name, spec.ID, spec.Queue, len(spec.GroupSpecs))
-		err = apiserver.requestCreateJob(spec)
+		err = apiserver.requestCreateJob(spec, requestUser(c), files)
+		c.Set(auditObjectKey, spec.ID)
 		if err == nil {
 			c.JSON(http.StatusCreated, gin.H{"id": spec.ID})
 		} else {
@@ -96,13 +221,61 @@ func (e JobEndpoint) createJob(c *gin.Context) {
 	}
 }
 
+// bulkJobs 对选中的作业执行批量操作。没有modify_all权限的用户只能操作自己的作业，移动队列时还需要有目标队列的权限。
+func (e JobEndpoint) bulkJobs(c *gin.Context) {
+	req := &message.BulkJobRequest{}
+	if err := c.BindJSON(req); err != nil {
+		responseError(http.StatusBadRequest, "Parse request failed: %v", err, c)
+		return
+	}
+	if ident := requestIdentity(c); ident != nil && req.Action == bulkQueue && !apiserver.auth.PermittedQueue(ident, req.Queue) {
+		responseError(http.StatusForbidden, "Unable to apply bulk operation: %v", fmt.Errorf("user %s is not allowed to use queue %s", ident.User, req.Queue), c)
+		return
+	}
+	owner := ""
+	if !hasPermission(c, PermModifyAll) {
+		owner = requestUser(c)
+	}
+	// 批量操作为每个作业单独记录审计信息，便于按作业查询
+	audit := &model.AuditEntry{
+		Time:     time.Now(),
+		User:     requestUser(c),
+		ClientIP: c.ClientIP(),
+		Method:   c.Request.Method,
+		Endpoint: c.Request.URL.RequestURI(),
+		Kind:     "job",
+		Status:   http.StatusOK,
+	}
+	results, err := apiserver.requestBulkJobs(req, owner, audit)
+	if err != nil {
+		responseError(http.StatusBadRequest, "Unable to apply bulk operation: %v", err, c)
+		return
+	}
+	c.JSON(http.StatusOK, &message.BulkJobResponse{DryRun: req.DryRun, Action: req.Action, Results: results})
+}
+
 func (e JobEndpoint) deleteJob(c *gin.Context) {
 	id := c.Params.ByName("id")
 	if err := apiserver.requestDeleteJob(id); err != nil {
 		responseError(http.StatusBadRequest, "Unable to delete job: %v", err, c)
 		return
 	}
-	c.Status(http.StatusOK)
+	responseAction(http.StatusOK, "job", id, "delete", c)
+}
+
+// rerunJob 重新执行已结束的作业，tasks=failed时只重新执行未成功完成的Task，tasks=all时重新执行所有Task
+func (e JobEndpoint) rerunJob(c *gin.Context) {
+	id := c.Params.ByName("id")
+	tasks := c.DefaultQuery("tasks", "failed")
+	if tasks != "failed" && tasks != "all" {
+		responseError(http.StatusBadRequest, "Invalid query: %v", fmt.Errorf("illegal tasks value: %s", tasks), c)
+		return
+	}
+	if err := apiserver.requestRerunJob(id, tasks == "all"); err != nil {
+		responseError(http.StatusBadRequest, "Unable to rerun job: %v", err, c)
+		return
+	}
+	responseAction(http.StatusOK, "job", id, "rerun", c)
 }
 
 func (e JobEndpoint) haltJob(c *gin.Context) {
@@ -111,7 +284,7 @@ func (e JobEndpoint) haltJob(c *gin.Context) {
 		responseError(http.StatusBadRequest, "Unable to halt job: %v", err, c)
 		return
 	}
-	c.Status(http.StatusOK)
+	responseAction(http.StatusOK, "job", id, "halt", c)
 }
 
 func (e JobEndpoint) resumeJob(c *gin.Context) {
@@ -120,7 +293,7 @@ func (e JobEndpoint) resumeJob(c *gin.Context) {
 		responseError(http.StatusBadRequest, "Unable to resume job: %v", err, c)
 		return
 	}
-	c.Status(http.StatusOK)
+	responseAction(http.StatusOK, "job", id, "resume", c)
 }
 
 func (e JobEndpoint) terminateJob(c *gin.Context) {
@@ -129,16 +302,20 @@ func (e JobEndpoint) terminateJob(c *gin.Context) {
 		responseError(http.StatusBadRequest, "Unable to terminate job: %v", err, c)
 		return
 	}
-	c.Status(http.StatusAccepted)
+	responseAction(http.StatusAccepted, "job", id, "terminate", c)
 }
 
 func (e JobEndpoint) modifyJobProps(c *gin.Context) {
 	id := c.Params.ByName("id")
 	props := &model.JobUpdatableProps{}
 	if err := c.BindJSON(props); err == nil {
+		if ident := requestIdentity(c); ident != nil && len(props.Queue) > 0 && !apiserver.auth.PermittedQueue(ident, props.Queue) {
+			responseError(http.StatusForbidden, "Unable to modify job: %v", fmt.Errorf("user %s is not allowed to use queue %s", ident.User, props.Queue), c)
+			return
+		}
 		err = apiserver.requestModifyJobProps(id, props)
 		if err == nil {
-			c.Status(http.StatusOK)
+			responseAction(http.StatusOK, "job", id, "modify", c)
 		} else {
 			responseError(http.StatusBadRequest, "Unable to modify job: %v", err, c)
 		}
@@ -147,48 +324,148 @@ func (e JobEndpoint) modifyJobProps(c *gin.Context) {
 	}
 }
 
+// parseTaskQuery 解析查询Task的参数，state可以是逗号分隔的多个状态
+func parseTaskQuery(c *gin.Context) (*data.TaskQuery, error) {
+	query := &data.TaskQuery{
+		Group:  c.Query("group"),
+		Node:   c.Query("node"),
+		Cursor: c.Query("cursor"),
+	}
+	if v := c.Query("state"); len(v) > 0 {
+		for _, s := range strings.Split(v, ",") {
+			state, ok := model.TaskStateFromString(s)
+			if !ok {
+				return nil, fmt.Errorf("illegal task state: %s", s)
+			}
+			query.States = append(query.States, state)
+		}
+	}
+	if v := c.Query("exit_code"); len(v) > 0 {
+		code, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("illegal exit code: %s", v)
+		}
+		query.ExitCode = &code
+	}
+	var err error
+	if query.Labels, err = model.ParseLabelSelector(c.Query("labels")); err != nil {
+		return nil, err
+	}
+	if v := c.Query("limit"); len(v) > 0 {
+		query.Limit, _ = strconv.Atoi(v)
+	}
+	return query, nil
+}
+
 // TaskEndpoint 是Task资源对象的RESTful API实现接口
 type TaskEndpoint struct{}
 
 func (e TaskEndpoint) registerRoute() {
-	apiserver.restRouter.GET(e.restPrefix(), func(c *gin.Context) {
+	// jobid=xxx&state=Failed,Aborted&group=name&node=host&exit_code=1&labels=k=v&cursor=&limit=100&summary=true
+	apiserver.restRouter.GET(e.restPrefix(), authorize(PermRead), func(c *gin.Context) {
 		c.Status(http.StatusNotFound)
 		var tasks []*message.TaskStatus
 		if jobid := c.Query("jobid"); len(jobid) > 0 {
-			tasks = apiserver.requestGetJobTasks(jobid)
+			query, err := parseTaskQuery(c)
+			if err != nil {
+				responseError(http.StatusBadRequest, "Invalid query: %v", err, c)
+				return
+			}
+			if summary := c.Query("summary"); summary == "true" || summary == "yes" || summary == "1" {
+				if result, err := apiserver.requestSummarizeJobTasks(jobid, query); err != nil {
+					responseError(http.StatusBadRequest, "Invalid query: %v", err, c)
+				} else if result != nil {
+					c.JSON(http.StatusOK, result)
+				}
+				return
+			}
+			var total int
+			var next string
+			if tasks, total, next, err = apiserver.requestListJobTasks(jobid, query); err != nil {
+				responseError(http.StatusBadRequest, "Invalid query: %v", err, c)
+				return
+			}
+			if tasks != nil {
+				c.Header("X-Total-Count", strconv.Itoa(total))
+				if len(next) > 0 {
+					c.Header("X-Next-Cursor", next)
+				}
+			}
 		} else if ids := c.Query("ids"); len(ids) > 0 {
-			taskIDs := strings.Split(ids, ",")
+			taskIDs := make([]model.TaskID, 0, 8)
+			for _, s := range strings.Split(ids, ",") {
+				id, err := model.ParseTaskID(s)
+				if err != nil {
+					responseError(http.StatusBadRequest, "%v", fmt.Errorf("Invalid task id \"%s\"", s), c)
+					return
+				}
+				taskIDs = append(taskIDs, id)
+			}
 			tasks = apiserver.requestGetTasks(taskIDs)
+			if tasks == nil && isVersioned(c) {
+				tasks = make([]*message.TaskStatus, 0)
+			}
 		}
 		if tasks != nil {
 			c.JSON(http.StatusOK, tasks)
 		}
 	})
-	apiserver.restRouter.GET(e.restPrefix()+"/:id", func(c *gin.Context) {
-		taskid := c.Params.ByName("id")
-		var content interface{}
-		c.Status(http.StatusNotFound)
-		if len(c.Query("status")) > 0 {
-			content = apiserver.requestGetTaskStatus(taskid)
-		} else {
-			content = apiserver.requestGetTask(taskid)
+	apiserver.restRouter.GET(e.restPrefix()+"/:id", authorize(PermRead), func(c *gin.Context) {
+		taskid, ok := parseTaskParam(c, "id")
+		if !ok {
+			return
 		}
-		if content != nil {
+		if len(c.Query("status")) > 0 {
+			if content := apiserver.requestGetTaskStatus(taskid); content != nil {
+				c.JSON(http.StatusOK, content)
+				return
+			}
+		} else if content := apiserver.requestGetTask(taskid); content != nil {
 			c.JSON(http.StatusOK, content)
+			return
 		}
+		taskNotFound(taskid, c)
 	})
-	apiserver.restRouter.GET(e.restPrefix()+"/:id/log", func(c *gin.Context) {
-		taskid := c.Params.ByName("id")
+	apiserver.restRouter.GET(e.restPrefix()+"/:id/log", transferDeadline, authorize(PermRead), func(c *gin.Context) {
+		taskid, ok := parseTaskParam(c, "id")
+		if !ok {
+			return
+		}
 		logfile := apiserver.requestGetTaskLog(taskid)
 		if logfile == nil {
-			c.Status(http.StatusNotFound)
+			taskNotFound(taskid, c)
 		} else {
+			// 支持Range请求，客户端可以只获取新增的日志内容
 			defer logfile.Close()
-			c.Status(http.StatusOK)
-			_, err := io.Copy(c.Writer, logfile)
-			if err != nil {
-				log.Printf("Unable to write all log content for task: %v", err)
+			var modtime time.Time
+			if info, err := logfile.Stat(); err == nil {
+				modtime = info.ModTime()
 			}
+			c.Header("Content-Type", "text/plain; charset=utf-8")
+			http.ServeContent(c.Writer, c.Request, "", modtime, logfile)
+		}
+	})
+	apiserver.restRouter.GET(e.restPrefix()+"/:id/artifacts", authorize(PermRead), func(c *gin.Context) {
+		taskid, ok := parseTaskParam(c, "id")
+		if !ok {
+			return
+		}
+		if artifacts := apiserver.requestListTaskArtifacts(taskid); artifacts != nil {
+			c.JSON(http.StatusOK, artifacts)
+		} else {
+			taskNotFound(taskid, c)
+		}
+	})
+	apiserver.restRouter.GET(e.restPrefix()+"/:id/artifacts/*path", transferDeadline, authorize(PermRead), func(c *gin.Context) {
+		taskid, ok := parseTaskParam(c, "id")
+		if !ok {
+			return
+		}
+		filename := apiserver.requestGetTaskArtifact(taskid, c.Params.ByName("path"))
+		if len(filename) == 0 {
+			c.Status(http.StatusNotFound)
+		} else {
+			c.FileAttachment(filename, filepath.Base(filename))
 		}
 	})
 }
@@ -201,20 +478,20 @@ func (e TaskEndpoint) restPrefix() string {
 type QueueEndpoint struct{}
 
 func (e QueueEndpoint) registerRoute() {
-	apiserver.restRouter.GET(e.restPrefix(), func(c *gin.Context) {
-		// c.Status(http.StatusOK)
-		// queues := apiserver.requestGetQueues()
-		// if queues != nil {
-		// 	c.JSON(http.StatusOK, queues)
-		// }
+	apiserver.restRouter.GET(e.restPrefix(), authorize(PermRead), func(c *gin.Context) {
+		c.JSON(http.StatusOK, apiserver.requestListQueues())
 	})
-	apiserver.restRouter.PUT(e.restPrefix()+"/:name", func(c *gin.Context) {
-		// enabled := c.Query("enable") == "yes" || c.Query("enable") == "true"
-		// c.Status(http.StatusNotFound)
-		// err := apiserver.requestEnableQueue(c.Params.ByName("name"), enabled)
-		// if err == nil {
-		// 	c.Status(http.StatusOK)
-		// }
+	apiserver.restRouter.PUT(e.restPrefix()+"/:name", audited("queue", "name", queueSnapshot), authorize(PermManageQueues), func(c *gin.Context) {
+		enabled := c.Query("enable") == "yes" || c.Query("enable") == "true"
+		if err := apiserver.requestEnableQueue(c.Params.ByName("name"), enabled); err == nil {
+			action := "disable"
+			if enabled {
+				action = "enable"
+			}
+			responseAction(http.StatusOK, "queue", c.Params.ByName("name"), action, c)
+		} else {
+			responseError(http.StatusInternalServerError, "Unable to modify queue: %v", err, c)
+		}
 	})
 }
 
@@ -226,39 +503,113 @@ func (e QueueEndpoint) restPrefix() string {
 type NodeEndpoint struct{}
 
 func (e NodeEndpoint) registerRoute() {
-	apiserver.restRouter.GET(e.restPrefix(), func(c *gin.Context) {
-		c.Status(http.StatusNotFound)
+	apiserver.restRouter.GET(e.restPrefix(), authorize(PermRead), func(c *gin.Context) {
 		allNodes := apiserver.requestListNodes()
-		if allNodes != nil {
+		if isVersioned(c) {
+			// /v1下没有节点时返回空列表，旧API返回404
+			if allNodes == nil {
+				allNodes = make([]*message.NodeInfo, 0)
+			}
+			c.Header("X-Total-Count", strconv.Itoa(len(allNodes)))
+			c.JSON(http.StatusOK, allNodes)
+		} else if allNodes != nil {
 			c.JSON(http.StatusOK, allNodes)
+		} else {
+			c.Status(http.StatusNotFound)
 		}
 	})
-	apiserver.restRouter.GET(e.restPrefix()+"/:name", func(c *gin.Context) {
-		c.Status(http.StatusNotFound)
-		node := apiserver.requestGetNode(c.Params.ByName("name"))
-		if node != nil {
+	apiserver.restRouter.GET(e.restPrefix()+"/:name", authorize(PermRead), func(c *gin.Context) {
+		name := c.Params.ByName("name")
+		if node := apiserver.requestGetNode(name); node != nil {
 			c.JSON(http.StatusOK, node)
+		} else {
+			responseError(http.StatusNotFound, "%v", errNotFound("Node %s not found", name), c)
 		}
 	})
-	apiserver.restRouter.PUT(e.restPrefix()+"/:name/_offline", func(c *gin.Context) {
+	apiserver.restRouter.GET(e.restPrefix()+"/:name/tasks", authorize(PermRead), func(c *gin.Context) {
+		name := c.Params.ByName("name")
+		if tasks := apiserver.requestGetNodeTasks(name); tasks != nil {
+			c.JSON(http.StatusOK, tasks)
+		} else {
+			responseError(http.StatusNotFound, "%v", errNotFound("Node %s not found", name), c)
+		}
+	})
+	apiserver.restRouter.PUT(e.restPrefix()+"/:name/_offline", audited("node", "name", nodeSnapshot), authorize(PermManageNodes), func(c *gin.Context) {
 		kill := c.Query("kill") == "yes"
 		err := apiserver.requestOfflineNode(c.Params.ByName("name"), kill)
 		if err == nil {
-			c.Status(http.StatusOK)
+			responseAction(http.StatusOK, "node", c.Params.ByName("name"), "offline", c)
 		} else {
 			responseError(http.StatusNotFound, "%v", err, c)
 		}
 	})
-	apiserver.restRouter.PUT(e.restPrefix()+"/:name/_online", func(c *gin.Context) {
+	apiserver.restRouter.PUT(e.restPrefix()+"/:name/_online", audited("node", "name", nodeSnapshot), authorize(PermManageNodes), func(c *gin.Context) {
 		err := apiserver.requestOnlineNode(c.Params.ByName("name"))
 		if err == nil {
-			c.Status(http.StatusOK)
+			responseAction(http.StatusOK, "node", c.Params.ByName("name"), "online", c)
 		} else {
 			responseError(http.StatusNotFound, "%v", err, c)
 		}
 	})
+	apiserver.restRouter.POST(e.restPrefix()+"/:name/_token", audited("node", "name", nodeSnapshot), authorize(PermManageNodes), func(c *gin.Context) {
+		token, err := apiserver.requestIssueNodeToken(c.Params.ByName("name"))
+		if err == nil {
+			c.JSON(http.StatusCreated, gin.H{"token": token})
+		} else {
+			responseError(http.StatusInternalServerError, "Unable to issue join token: %v", err, c)
+		}
+	})
+	apiserver.restRouter.DELETE(e.restPrefix()+"/:name/_token", audited("node", "name", nodeSnapshot), authorize(PermManageNodes), func(c *gin.Context) {
+		if err := apiserver.requestRevokeNodeToken(c.Params.ByName("name")); err == nil {
+			responseAction(http.StatusOK, "node", c.Params.ByName("name"), "revoke_token", c)
+		} else {
+			responseError(http.StatusInternalServerError, "Unable to revoke join token: %v", err, c)
+		}
+	})
 }
 
 func (e NodeEndpoint) restPrefix() string {
 	return "/nodes"
 }
+
+// SecretEndpoint 是Secret资源对象的RESTful API实现接口
+type SecretEndpoint struct{}
+
+func (e SecretEndpoint) registerRoute() {
+	apiserver.restRouter.GET(e.restPrefix(), authorize(PermSubmit), func(c *gin.Context) {
+		c.JSON(http.StatusOK, apiserver.requestListSecrets())
+	})
+	apiserver.restRouter.PUT(e.restPrefix()+"/:name", audited("secret", "name", nil), authorize(PermSubmit), func(c *gin.Context) {
+		body := struct {
+			Value string `json:"value"`
+		}{}
+		if err := c.BindJSON(&body); err != nil {
+			responseError(http.StatusBadRequest, "Parse request failed: %v", err, c)
+			return
+		}
+		err := apiserver.requestPutSecret(c.Params.ByName("name"), body.Value, requestIdentity(c))
+		if err == nil {
+			responseAction(http.StatusOK, "secret", c.Params.ByName("name"), "put", c)
+		} else if err == errSecretDenied {
+			responseError(http.StatusForbidden, "Unable to save secret: %v", err, c)
+		} else {
+			responseError(http.StatusInternalServerError, "Unable to save secret: %v", err, c)
+		}
+	})
+	apiserver.restRouter.DELETE(e.restPrefix()+"/:name", audited("secret", "name", nil), authorize(PermSubmit), func(c *gin.Context) {
+		err := apiserver.requestDeleteSecret(c.Params.ByName("name"), requestIdentity(c))
+		if err == nil {
+			responseAction(http.StatusOK, "secret", c.Params.ByName("name"), "delete", c)
+		} else if err == errSecretNotFound {
+			responseError(http.StatusNotFound, "Unable to delete secret: %v", err, c)
+		} else if err == errSecretDenied {
+			responseError(http.StatusForbidden, "Unable to delete secret: %v", err, c)
+		} else {
+			responseError(http.StatusInternalServerError, "Unable to delete secret: %v", err, c)
+		}
+	})
+}
+
+func (e SecretEndpoint) restPrefix() string {
+	return "/secrets"
+}
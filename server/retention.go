@@ -0,0 +1,108 @@
+package server
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/qianxiaoming/lightsched/model"
+)
+
+// RetentionPolicy 是已结束作业的保留策略，值为0时表示不限制
+type RetentionPolicy struct {
+	MaxAgeDays int `json:"max_age_days,omitempty"` // 作业结束后保留的天数
+	MaxJobs    int `json:"max_jobs,omitempty"`     // 保留的已结束作业的最大数量
+}
+
+// RetentionConfig 是自动清理已结束作业的配置
+type RetentionConfig struct {
+	RetentionPolicy                            // 所有队列默认的保留策略
+	Queues          map[string]RetentionPolicy `json:"queues,omitempty"`   // 指定队列的保留策略，覆盖默认策略
+	Interval        int                        `json:"interval,omitempty"` // 清理的间隔，单位分钟
+	Compact         bool                       `json:"compact,omitempty"`  // 清理后是否压缩数据库文件
+}
+
+// enabled 判断是否配置了任何保留策略
+func (conf *RetentionConfig) enabled() bool {
+	if conf.MaxAgeDays > 0 || conf.MaxJobs > 0 {
+		return true
+	}
+	for _, p := range conf.Queues {
+		if p.MaxAgeDays > 0 || p.MaxJobs > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// policy 返回指定队列的保留策略
+func (conf *RetentionConfig) policy(queue string) RetentionPolicy {
+	if p, ok := conf.Queues[queue]; ok {
+		return p
+	}
+	return conf.RetentionPolicy
+}
+
+// expiredJobs 按保留策略确定需要清理的已结束作业，调用者需要持有state的锁。还有Task在节点上运行的
+// 作业不会被清理，否则节点上报的结束状态会被忽略，Task占用的节点资源也无法归还。
+func (svc *APIServer) expiredJobs(now time.Time) []*model.Job {
+	finished := make(map[string][]*model.Job)
+	for _, job := range svc.state.GetAllJobs() {
+		if isJobFinished(job) && runningTask(job) == nil {
+			finished[job.Queue] = append(finished[job.Queue], job)
+		}
+	}
+	expired := make([]*model.Job, 0, 16)
+	for queue, jobs := range finished {
+		policy := svc.config.Retention.policy(queue)
+		// 最近结束的作业排在前面，超过数量限制的作业从后面开始清理
+		sort.Slice(jobs, func(i, j int) bool { return jobs[i].FinishTime.After(jobs[j].FinishTime) })
+		for i, job := range jobs {
+			if (policy.MaxJobs > 0 && i >= policy.MaxJobs) ||
+				(policy.MaxAgeDays > 0 && now.Sub(job.FinishTime) > time.Duration(policy.MaxAgeDays)*24*time.Hour) {
+				expired = append(expired, job)
+			}
+		}
+	}
+	return expired
+}
+
+// deleteJobFiles 删除作业的输入文件、日志和产物，调用者需要持有state的锁
+func (svc *APIServer) deleteJobFiles(jobid string) error {
+	return os.RemoveAll(filepath.Join(svc.config.DataPath, jobid))
+}
+
+// requestSweepJobs 清理超过保留期限的已结束作业。作业的摘要被保存到记账信息中。
+func (svc *APIServer) requestSweepJobs() {
+	svc.state.Lock()
+	defer svc.state.Unlock()
+
+	expired := svc.expiredJobs(time.Now())
+	if len(expired) == 0 {
+		return
+	}
+	log.Printf("Sweeping %d expired job(s)...\n", len(expired))
+	deleted := 0
+	for _, job := range expired {
+		if err := svc.state.ArchiveJob(model.NewJobArchive(job)); err != nil {
+			log.Printf("Unable to archive job %s and keep it: %v\n", job.ID, err)
+			continue
+		}
+		if err := svc.state.DeleteJob(job.ID); err != nil {
+			log.Printf("Unable to delete job %s: %v\n", job.ID, err)
+			continue
+		}
+		if err := svc.deleteJobFiles(job.ID); err != nil {
+			log.Printf("Unable to delete files of job %s: %v\n", job.ID, err)
+		}
+		deleted++
+	}
+	log.Printf("%d job(s) swept\n", deleted)
+	if deleted > 0 && svc.config.Retention.Compact {
+		if err := svc.state.Compact(); err != nil {
+			log.Printf("Failed to compact database file: %v\n", err)
+		}
+	}
+}
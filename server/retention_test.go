@@ -0,0 +1,135 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/qianxiaoming/lightsched/model"
+)
+
+// finishTestJob 将作业及其所有Task设置为已完成并保存，结束时间为指定的时间
+func finishTestJob(t *testing.T, svc *APIServer, id string, finish time.Time) {
+	svc.state.Lock()
+	defer svc.state.Unlock()
+	job := svc.state.GetJob(id)
+	tasks := make([]*model.Task, 0, job.CountTasks())
+	for _, g := range job.Groups {
+		for _, task := range g.Tasks {
+			task.State = model.TaskCompleted
+			tasks = append(tasks, task)
+		}
+	}
+	job.State = model.JobCompleted
+	job.FinishTime = finish
+	svc.usage.trackJob(job)
+	if err := svc.state.SaveJobs([]*model.Job{job}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.state.SaveTasks(tasks); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// expiredIDs 返回按保留策略需要清理的作业编号
+func expiredIDs(svc *APIServer, now time.Time) []string {
+	svc.state.RLock()
+	defer svc.state.RUnlock()
+	ids := make([]string, 0)
+	for _, job := range svc.expiredJobs(now) {
+		ids = append(ids, job.ID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestExpiredJobs(t *testing.T) {
+	svc := newTestServer(t, nil)
+	now := time.Now()
+	for _, id := range []string{"old", "recent", "latest", "queued", "running"} {
+		submitTestJob(t, svc, id, 1)
+	}
+	finishTestJob(t, svc, "old", now.Add(-72*time.Hour))
+	finishTestJob(t, svc, "recent", now.Add(-2*time.Hour))
+	finishTestJob(t, svc, "latest", now.Add(-time.Hour))
+	// 终止的作业还有Task在节点上运行
+	finishTestJob(t, svc, "running", now.Add(-96*time.Hour))
+	svc.state.Lock()
+	running := svc.state.GetJob("running")
+	running.State = model.JobTerminated
+	running.Groups[0].Tasks[0].State = model.TaskExecuting
+	svc.state.Unlock()
+
+	cases := []struct {
+		name      string
+		retention RetentionConfig
+		expired   []string
+	}{
+		{"none", RetentionConfig{}, []string{}},
+		{"age", RetentionConfig{RetentionPolicy: RetentionPolicy{MaxAgeDays: 1}}, []string{"old"}},
+		{"count", RetentionConfig{RetentionPolicy: RetentionPolicy{MaxJobs: 1}}, []string{"old", "recent"}},
+		{"age and count", RetentionConfig{RetentionPolicy: RetentionPolicy{MaxAgeDays: 1, MaxJobs: 2}}, []string{"old"}},
+		{"queue overrides default", RetentionConfig{
+			RetentionPolicy: RetentionPolicy{MaxAgeDays: 1},
+			Queues:          map[string]RetentionPolicy{"default": {MaxJobs: 2}},
+		}, []string{"old"}},
+		{"queue without limits", RetentionConfig{
+			RetentionPolicy: RetentionPolicy{MaxJobs: 1},
+			Queues:          map[string]RetentionPolicy{"default": {}},
+		}, []string{}},
+		{"other queue", RetentionConfig{
+			RetentionPolicy: RetentionPolicy{MaxJobs: 1},
+			Queues:          map[string]RetentionPolicy{"gpu": {}},
+		}, []string{"old", "recent"}},
+	}
+	for _, c := range cases {
+		svc.config.Retention = c.retention
+		if got := expiredIDs(svc, now); !reflect.DeepEqual(got, c.expired) {
+			t.Errorf("%s: expired %v, want %v", c.name, got, c.expired)
+		}
+	}
+}
+
+func TestSweepJobs(t *testing.T) {
+	svc := newTestServer(t, nil)
+	now := time.Now()
+	for _, id := range []string{"old", "latest", "queued"} {
+		submitTestJob(t, svc, id, 2)
+	}
+	finishTestJob(t, svc, "old", now.Add(-2*time.Hour))
+	finishTestJob(t, svc, "latest", now.Add(-time.Hour))
+	svc.config.Retention = RetentionConfig{RetentionPolicy: RetentionPolicy{MaxJobs: 1}, Compact: true}
+	svc.requestSweepJobs()
+
+	if _, err := os.Stat(filepath.Join(svc.config.DataPath, "old")); !os.IsNotExist(err) {
+		t.Errorf("files of swept job are left: %v", err)
+	}
+	// 压缩后重新打开数据库，清理的作业只保留摘要，其它作业不受影响
+	conf := svc.config
+	svc.Close()
+	reopened := NewAPIServerWithConfig(&conf)
+	if err := reopened.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	reopened.state.RLock()
+	defer reopened.state.RUnlock()
+	if reopened.state.GetJob("old") != nil {
+		t.Error("swept job is loaded again")
+	}
+	for _, id := range []string{"latest", "queued"} {
+		if job := reopened.state.GetJob(id); job == nil || job.CountTasks() != 3 {
+			t.Errorf("job %s is not kept: %v", id, job)
+		}
+	}
+	if task := reopened.state.GetTask("latest.0.1"); task == nil || task.State != model.TaskCompleted {
+		t.Errorf("task of kept job: %+v", task)
+	}
+	archives := reopened.state.QueryJobArchives(time.Time{}, time.Time{})
+	if len(archives) != 1 || archives[0].ID != "old" {
+		t.Errorf("archives after sweep: %+v", archives)
+	}
+}
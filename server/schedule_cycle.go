@@ -5,6 +5,7 @@ import (
 	"log"
 	"sort"
 	"sync/atomic"
+	"time"
 
 	"github.com/qianxiaoming/lightsched/message"
 	"github.com/qianxiaoming/lightsched/model"
@@ -19,6 +20,7 @@ type scheduleNode struct {
 
 // scheduleRecord 记录了1个调度结果，即哪个任务调度到哪个节点
 type scheduleRecord struct {
+	job    *model.Job
 	task   *model.Task
 	target *scheduleNode
 }
@@ -31,6 +33,15 @@ func (p taskSlice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
 func (p taskSlice) Len() int           { return len(p) }
 func (p taskSlice) Less(i, j int) bool { return p[i].Resources.GPU.Cards >= p[j].Resources.GPU.Cards }
 
+func containsString(list []string, str string) bool {
+	for _, s := range list {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
+
 // scheduleOneTask 尝试将1个任务调度到某个节点上，若无法调度返回nil
 func scheduleOneTask(svc *APIServer, task *model.Task, nodes []*scheduleNode) *scheduleNode {
 	var target *scheduleNode = nil
@@ -66,6 +77,20 @@ func scheduleOneTask(svc *APIServer, task *model.Task, nodes []*scheduleNode) *s
 		if !passed {
 			continue
 		}
+		// 检查节点是否支持任务要求的容器执行器
+		if len(task.Runtime) > 0 && !containsString(node.node.Runtimes, task.Runtime) {
+			if svc.config.SchedLog {
+				log.Printf("  Task %s failed scheduling to %s because of runtime %s", task.ID, node.node.Name, task.Runtime)
+			}
+			continue
+		}
+		// 检查节点是否允许任务要求的执行用户，不允许时任务在节点上启动就会失败
+		if len(task.RunAs) > 0 && !node.node.AllowRunAs(task.RunAs) {
+			if svc.config.SchedLog {
+				log.Printf("  Task %s failed scheduling to %s because of run_as user %s", task.ID, node.node.Name, task.RunAs)
+			}
+			continue
+		}
 		// 检查资源是否符合并算分
 		node.score = 0.0
 		if ok, res, need, offered := task.Resources.SatisfiedWith(node.available); ok {
@@ -147,13 +172,25 @@ func scheduleCycle(svc *APIServer) []scheduleRecord {
 				sort.Sort(jobTasks[i])
 			}
 			for i := 0; i < len(jobTasks); i++ {
+				job := jobs[maxPriority][i]
 				for _, task := range jobTasks[i] {
+					// 达到用户或队列的执行限制时Task保持排队状态
+					if svc.runLimitReached(job, task) {
+						if task.Reason != limitReason && svc.config.SchedLog {
+							log.Printf("  Task %s is not scheduled because the limit of user %s or queue %s reached", task.ID, job.Owner, job.Queue)
+						}
+						task.Reason = limitReason
+						continue
+					}
 					// 尝试调度task到某个节点上
 					target := scheduleOneTask(svc, task, scheduleNodes)
 					if target != nil {
-						scheduleTable = append(scheduleTable, scheduleRecord{task: task, target: target})
+						task.Reason = ""
+						scheduleTable = append(scheduleTable, scheduleRecord{job: job, task: task, target: target})
 						// 从节点的可用资源中减去Task所需的资源
 						target.available.Consume(task.Resources)
+						// 调度结果在本次周期结束时一定会生效，因此直接计入使用量
+						svc.usage.taskChanged(job, task, model.TaskQueued, model.TaskScheduled)
 					}
 				}
 			}
@@ -180,7 +217,10 @@ func (svc *APIServer) runScheduleCycle() {
 
 	// 执行调度，获得调度结果表
 	log.Printf("Run schedule cycle %d\n", svc.schedCycle)
+	start := time.Now()
 	scheduleTable := scheduleCycle(svc)
+	svc.metrics.cycleSeconds.Observe(time.Since(start).Seconds())
+	svc.metrics.cyclePlaced.Observe(float64(len(scheduleTable)))
 	if len(scheduleTable) == 0 {
 		log.Println("There is no task scheduled in this cycle")
 		return
@@ -191,7 +231,8 @@ func (svc *APIServer) runScheduleCycle() {
 	updates := make([]*model.Task, 0, len(scheduleTable))
 	for _, record := range scheduleTable {
 		record.task.State = model.TaskScheduled
-		record.task.NodeName = record.target.node.Name
+		svc.metrics.queueWait.Observe(time.Since(record.job.SubmitTime).Seconds(), record.job.Queue)
+		svc.state.PlaceTask(record.task, record.target.node.Name)
 		record.target.node.Available.Consume(record.task.Resources)
 		// 缓存调度结果，以便节点拉取调度到自身的Task
 		msg, _ := json.Marshal(record.task)
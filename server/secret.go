@@ -0,0 +1,208 @@
+package server
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/qianxiaoming/lightsched/message"
+	"github.com/qianxiaoming/lightsched/model"
+	"github.com/qianxiaoming/lightsched/util"
+)
+
+// loadSecretKey 加载加密密钥的主密钥。未指定时使用数据目录下的secret.key，文件不存在时自动生成。
+func (svc *APIServer) loadSecretKey() error {
+	path := svc.config.SecretKey
+	if len(path) == 0 {
+		path = filepath.Join(svc.config.DataPath, "secret.key")
+	}
+	if util.PathExists(path) {
+		key, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if len(key) != 32 {
+			return fmt.Errorf("secret key %s must be 32 bytes", path)
+		}
+		svc.secretKey = key
+		return nil
+	}
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, key, 0600); err != nil {
+		return err
+	}
+	log.Printf("Secret key generated in %s\n", path)
+	svc.secretKey = key
+	return nil
+}
+
+// encryptSecret 使用AES-GCM加密密钥值，随机数保存在密文之前
+func (svc *APIServer) encryptSecret(value string) ([]byte, error) {
+	block, err := aes.NewCipher(svc.secretKey)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, []byte(value), nil), nil
+}
+
+// decryptSecret 解密保存的密钥值
+func (svc *APIServer) decryptSecret(data []byte) (string, error) {
+	block, err := aes.NewCipher(svc.secretKey)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	if len(data) < gcm.NonceSize() {
+		return "", errors.New("secret data is corrupted")
+	}
+	plain, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+// canUseSecret 检查用户能否使用或修改密钥。未启用认证时总是允许。
+func (svc *APIServer) canUseSecret(id *Identity, secret *model.Secret) bool {
+	return id == nil || secret.Owner == id.User || svc.auth.Permitted(id, PermModifyAll)
+}
+
+func (svc *APIServer) requestPutSecret(name string, value string, id *Identity) error {
+	svc.state.Lock()
+	defer svc.state.Unlock()
+
+	if old := svc.state.GetSecret(name); old != nil && !svc.canUseSecret(id, old) {
+		return errSecretDenied
+	}
+	data, err := svc.encryptSecret(value)
+	if err != nil {
+		return err
+	}
+	secret := &model.Secret{Name: name, Value: data, CreateTime: time.Now()}
+	if id != nil {
+		secret.Owner = id.User
+	}
+	if err := svc.state.PutSecret(secret); err != nil {
+		return err
+	}
+	log.Printf("Secret %s saved\n", name)
+	return nil
+}
+
+func (svc *APIServer) requestListSecrets() []*message.SecretInfo {
+	svc.state.RLock()
+	defer svc.state.RUnlock()
+
+	secrets := svc.state.GetSecrets()
+	infos := make([]*message.SecretInfo, 0, len(secrets))
+	for _, s := range secrets {
+		infos = append(infos, &message.SecretInfo{
+			Name:       s.Name,
+			Owner:      s.Owner,
+			CreateTime: s.CreateTime.Local().Format("2006-01-02 15:04:05"),
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+func (svc *APIServer) requestDeleteSecret(name string, id *Identity) error {
+	svc.state.Lock()
+	defer svc.state.Unlock()
+
+	secret := svc.state.GetSecret(name)
+	if secret == nil {
+		return errSecretNotFound
+	}
+	if !svc.canUseSecret(id, secret) {
+		return errSecretDenied
+	}
+	if err := svc.state.DeleteSecret(name); err != nil {
+		return err
+	}
+	log.Printf("Secret %s deleted\n", name)
+	return nil
+}
+
+var (
+	errSecretNotFound = errors.New("secret not found")
+	errSecretDenied   = errors.New("secret is owned by another user")
+)
+
+// checkSecretRefs 检查作业引用的密钥是否存在，以及提交者能否使用这些密钥
+func (svc *APIServer) checkSecretRefs(spec *model.JobSpec, id *Identity) error {
+	svc.state.RLock()
+	defer svc.state.RUnlock()
+
+	check := func(refs map[string]string) error {
+		for env, name := range refs {
+			secret := svc.state.GetSecret(name)
+			if secret == nil {
+				return fmt.Errorf("secret %s referenced by %s not found", name, env)
+			}
+			if !svc.canUseSecret(id, secret) {
+				return fmt.Errorf("secret %s referenced by %s is owned by another user", name, env)
+			}
+		}
+		return nil
+	}
+	for _, g := range spec.GroupSpecs {
+		if err := check(g.Secrets); err != nil {
+			return err
+		}
+		for _, t := range g.TaskSpecs {
+			if err := check(t.Secrets); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// requestGetTaskSecret 返回Task引用的密钥值。只有Task所在的节点在Task结束前可以获取。
+func (svc *APIServer) requestGetTaskSecret(taskid string, name string, nodeName string) (string, error) {
+	svc.state.RLock()
+	defer svc.state.RUnlock()
+
+	task := svc.state.GetTask(taskid)
+	if task == nil || task.NodeName != nodeName || model.IsFinishState(task.State) {
+		return "", fmt.Errorf("task %s is not running on node %s", taskid, nodeName)
+	}
+	referenced := false
+	for _, v := range task.Secrets {
+		if v == name {
+			referenced = true
+			break
+		}
+	}
+	if !referenced {
+		return "", fmt.Errorf("secret %s is not referenced by task %s", name, taskid)
+	}
+	secret := svc.state.GetSecret(name)
+	if secret == nil {
+		return "", errSecretNotFound
+	}
+	return svc.decryptSecret(secret.Value)
+}
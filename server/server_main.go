@@ -2,6 +2,7 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -24,14 +25,32 @@ import (
 
 // Config 是API Server的配置信息
 type Config struct {
-	Cluster  string `json:"cluster"`
-	Address  string `json:"address"`
-	RestPort int    `json:"rest"`
-	NodePort int    `json:"node"`
-	Offline  int    `json:"offline"`
-	SchedLog bool   `json:"sched_log"`
-	DataPath string `json:"data_path"`
-	LogPath  string `json:"log_path"`
+	Cluster  string      `json:"cluster"`
+	Address  string      `json:"address"`
+	RestPort int         `json:"rest"`
+	NodePort int         `json:"node"`
+	Offline  int         `json:"offline"`
+	SchedLog bool        `json:"sched_log"`
+	DataPath string      `json:"data_path"`
+	LogPath  string      `json:"log_path"`
+	Auth     AuthConfig  `json:"auth"`
+	Limits   LimitConfig `json:"limits"`
+	// Retention 是自动清理已结束作业的配置
+	Retention RetentionConfig `json:"retention"`
+	// SecretKey 是加密密钥使用的32字节主密钥文件，默认为数据目录下的secret.key
+	SecretKey string `json:"secret_key,omitempty"`
+	// MaxArtifactSize 是节点上传的单个Task结果文件的最大字节数，默认为1GB
+	MaxArtifactSize int64 `json:"max_artifact_size,omitempty"`
+	// 节点认证的配置
+	NodeAuth  bool   `json:"node_auth,omitempty"`  // 是否要求节点认证，仅使用单独签发的token时需要设置
+	JoinToken string `json:"join_token,omitempty"` // 所有节点共享的加入token
+	// 两个HTTP服务端口的TLS配置，未指定证书时使用明文HTTP。收到SIGHUP时重新加载证书。
+	RestCert     string `json:"rest_cert,omitempty"`      // RESTful API端口的证书
+	RestKey      string `json:"rest_key,omitempty"`       // RESTful API端口的私钥
+	RestClientCA string `json:"rest_client_ca,omitempty"` // 校验客户端证书的CA，用于客户端证书认证
+	NodeCert     string `json:"node_cert,omitempty"`      // 节点服务端口的证书
+	NodeKey      string `json:"node_key,omitempty"`       // 节点服务端口的私钥
+	NodeClientCA string `json:"node_client_ca,omitempty"` // 指定时节点必须提供由该CA签发的证书
 }
 
 // HTTPEndpoint 是对不同资源对象提供HTTP API实现的接口
@@ -47,8 +66,17 @@ type APIServer struct {
 	nodes         *data.NodeCache
 	schedFlag     int32
 	schedCycle    int64
-	restRouter    *gin.Engine
+	usage         *usageCounter
+	auth          *Authorizer
+	restTLS       *tlsReloader
+	nodeTLS       *tlsReloader
+	secretKey     []byte
+	metrics       *serverMetrics
+	restRouter    *gin.RouterGroup
 	nodeRouter    *gin.Engine
+	restEngine    *gin.Engine
+	nodeEngine    *gin.Engine
+	openAPI       []byte
 	restEndpoints map[string]HTTPEndpoint
 	nodeEndpoints map[string]HTTPEndpoint
 }
@@ -74,6 +102,34 @@ func NewAPIServer(confPath string) *APIServer {
 		log.Println("No configuration file found and default setting will be used")
 	}
 
+	apiserver = NewAPIServerWithConfig(conf)
+	if conf == nil {
+		b, _ := json.MarshalIndent(apiserver.config, "", "  ")
+		if err := ioutil.WriteFile(constant.APISeverConfigFile, b, 0666); err != nil {
+			log.Printf("Unable to write config file %s: %v\n", constant.APISeverConfigFile, err)
+		}
+	}
+
+	// 配置日志信息
+	if len(apiserver.config.LogPath) > 0 {
+		if err := util.MakeDirAll(apiserver.config.LogPath); err != nil {
+			log.Printf("Cannot create log directory %s: %v\n", apiserver.config.LogPath, err)
+		} else {
+			filename := filepath.Join(apiserver.config.LogPath, constant.APISeverLogFile)
+			if logFile, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0766); err != nil {
+				log.Printf("Cannot open log file %s: %v\n", filename, err)
+			} else {
+				log.SetOutput(io.MultiWriter(os.Stdout, logFile))
+				log.SetFlags(log.LstdFlags | log.LUTC)
+			}
+		}
+	}
+	return apiserver
+}
+
+// NewAPIServerWithConfig 使用指定的配置创建API Server实例，conf为nil或其中未设置的项使用默认值。
+// 与NewAPIServer不同，它不会读写配置文件和设置日志输出。
+func NewAPIServerWithConfig(conf *Config) *APIServer {
 	// 生成默认配置
 	dataPath, _ := filepath.Abs("cluster")
 	logPath, _ := filepath.Abs("log")
@@ -87,6 +143,8 @@ func NewAPIServer(confPath string) *APIServer {
 			SchedLog: false,
 			DataPath: dataPath,
 			LogPath:  logPath,
+
+			MaxArtifactSize: constant.DefaultMaxArtifactSize,
 		},
 		state:         data.NewStateStore(),
 		nodes:         data.NewNodeCache(),
@@ -95,12 +153,7 @@ func NewAPIServer(confPath string) *APIServer {
 		restEndpoints: make(map[string]HTTPEndpoint),
 		nodeEndpoints: make(map[string]HTTPEndpoint),
 	}
-	if conf == nil {
-		b, _ := json.MarshalIndent(apiserver.config, "", "  ")
-		if err := ioutil.WriteFile(constant.APISeverConfigFile, b, 0666); err != nil {
-			log.Printf("Unable to write config file %s: %v\n", constant.APISeverConfigFile, err)
-		}
-	} else {
+	if conf != nil {
 		if len(conf.Cluster) != 0 {
 			apiserver.config.Cluster = conf.Cluster
 		}
@@ -123,68 +176,130 @@ func NewAPIServer(confPath string) *APIServer {
 		if len(conf.LogPath) != 0 {
 			apiserver.config.LogPath = conf.LogPath
 		}
-	}
-
-	// 配置日志信息
-	if len(apiserver.config.LogPath) > 0 {
-		if err := util.MakeDirAll(apiserver.config.LogPath); err != nil {
-			log.Printf("Cannot create log directory %s: %v\n", apiserver.config.LogPath, err)
-		} else {
-			filename := filepath.Join(apiserver.config.LogPath, constant.APISeverLogFile)
-			if logFile, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0766); err != nil {
-				log.Printf("Cannot open log file %s: %v\n", filename, err)
-			} else {
-				log.SetOutput(io.MultiWriter(os.Stdout, logFile))
-				log.SetFlags(log.LstdFlags | log.LUTC)
-			}
+		apiserver.config.Auth = conf.Auth
+		apiserver.config.SecretKey = conf.SecretKey
+		if conf.MaxArtifactSize > 0 {
+			apiserver.config.MaxArtifactSize = conf.MaxArtifactSize
+		}
+		apiserver.config.Limits = conf.Limits
+		apiserver.config.Retention = conf.Retention
+		if apiserver.config.Retention.Interval <= 0 {
+			apiserver.config.Retention.Interval = 60
 		}
+		apiserver.config.NodeAuth = conf.NodeAuth
+		apiserver.config.JoinToken = conf.JoinToken
+		apiserver.config.RestCert = conf.RestCert
+		apiserver.config.RestKey = conf.RestKey
+		apiserver.config.RestClientCA = conf.RestClientCA
+		apiserver.config.NodeCert = conf.NodeCert
+		apiserver.config.NodeKey = conf.NodeKey
+		apiserver.config.NodeClientCA = conf.NodeClientCA
 	}
+
 	return apiserver
 }
 
-// Run 是API Server的主运行逻辑，返回时服务即结束运行
-func (svc *APIServer) Run() int {
+// Init 打开状态数据库并初始化认证、证书和HTTP路由，之后可以通过RestHandler和NodeHandler处理请求。
+// 调用者在服务结束时需要调用Close关闭数据库。
+func (svc *APIServer) Init() error {
 	log.Printf("Light Scheduler API Server is starting up with cluster id \"%s\"...\n", svc.config.Cluster)
 	if err := svc.state.InitState(svc.config.DataPath); err != nil {
-		log.Printf("Failed to initialize state data: %v\n", err)
+		return fmt.Errorf("failed to initialize state data: %v", err)
+	}
+	svc.usage = svc.countUsage()
+	if err := svc.loadSecretKey(); err != nil {
+		svc.Close()
+		return fmt.Errorf("failed to load secret key: %v", err)
+	}
+	var err error
+	if svc.auth, err = NewAuthorizer(&svc.config.Auth); err != nil {
+		svc.Close()
+		return fmt.Errorf("failed to initialize authentication: %v", err)
+	}
+	if svc.auth == nil {
+		log.Println("WARNING: No authentication configured and RESTful API is open to everyone")
+	}
+	if !svc.nodeAuthEnabled() {
+		log.Println("WARNING: No node authentication configured and any node can join the cluster")
+	}
+	if svc.restTLS, err = newTLSReloader("RESTful API", svc.config.RestCert, svc.config.RestKey, svc.config.RestClientCA, tls.VerifyClientCertIfGiven); err != nil {
+		svc.Close()
+		return fmt.Errorf("failed to load TLS certificate for RESTful API: %v", err)
+	}
+	if svc.nodeTLS, err = newTLSReloader("Node", svc.config.NodeCert, svc.config.NodeKey, svc.config.NodeClientCA, tls.RequireAndVerifyClientCert); err != nil {
+		svc.Close()
+		return fmt.Errorf("failed to load TLS certificate for Node service: %v", err)
+	}
+
+	svc.metrics = newServerMetrics(svc)
+
+	gin.SetMode(gin.ReleaseMode)
+	// 对内节点服务的路由
+	svc.nodeEngine = gin.New()
+	svc.nodeEngine.Use(gin.Recovery(), requestDeadline, svc.metrics.instrument("node"))
+	svc.registerNodeEndpoint(svc.nodeEngine)
+
+	// 对外RESTful API服务的路由
+	svc.restEngine = gin.New()
+	svc.restEngine.Use(gin.Recovery(), requestDeadline, requestID, svc.metrics.instrument("rest"))
+	svc.restEngine.Static("/portal", "./html")
+	svc.restEngine.StaticFile("/favicon.ico", "./html/favicon.ico")
+	// 认证中间件只作用于之后注册的API路径，静态页面不需要认证
+	svc.restEngine.Use(svc.authenticate)
+	svc.registerRestEndpoint(svc.restEngine)
+	return nil
+}
+
+// RestHandler 返回对外RESTful API服务的请求处理器
+func (svc *APIServer) RestHandler() http.Handler {
+	return svc.restEngine
+}
+
+// NodeHandler 返回对内节点服务的请求处理器
+func (svc *APIServer) NodeHandler() http.Handler {
+	return svc.nodeEngine
+}
+
+// Close 关闭状态数据库
+func (svc *APIServer) Close() {
+	svc.state.ClearState()
+}
+
+// Run 是API Server的主运行逻辑，返回时服务即结束运行
+func (svc *APIServer) Run() int {
+	if err := svc.Init(); err != nil {
+		log.Printf("Failed to start API Server: %v\n", err)
 		return 1
 	}
-	defer svc.state.ClearState()
+	defer svc.Close()
 
 	var wg sync.WaitGroup
-	gin.SetMode(gin.ReleaseMode)
 	// 启动对内节点的HTTP服务
-	nodeEngine := gin.New()
-	nodeEngine.Use(gin.Recovery())
-	svc.registerNodeEndpoint(nodeEngine)
 	httpNode := &http.Server{
-		Addr:         fmt.Sprintf("%s:%d", svc.config.Address, svc.config.NodePort),
-		Handler:      nodeEngine,
-		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 10 * time.Second,
+		Addr:              fmt.Sprintf("%s:%d", svc.config.Address, svc.config.NodePort),
+		Handler:           svc.nodeEngine,
+		ReadHeaderTimeout: requestReadTimeout,
+		IdleTimeout:       time.Minute,
+		ConnContext:       saveConn,
 	}
 	go util.WaitForStop(&wg, func() {
 		log.Printf("Start Node HTTP Service on \"%v\"\n", httpNode.Addr)
-		if err := httpNode.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		if err := listenAndServe(httpNode, svc.nodeTLS); err != nil && err != http.ErrServerClosed {
 			log.Fatalf("Cannot listen on %s:%d: %s\n", svc.config.Address, svc.config.NodePort, err)
 		}
 	})
 
 	// 启动对外的RESTful API服务
-	restEngine := gin.New()
-	restEngine.Use(gin.Recovery())
-	svc.registerRestEndpoint(restEngine)
-	restEngine.Static("/portal", "./html")
-	restEngine.StaticFile("/favicon.ico", "./html/favicon.ico")
 	httpRest := &http.Server{
-		Addr:         fmt.Sprintf("%s:%d", svc.config.Address, svc.config.RestPort),
-		Handler:      restEngine,
-		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 10 * time.Second,
+		Addr:              fmt.Sprintf("%s:%d", svc.config.Address, svc.config.RestPort),
+		Handler:           svc.restEngine,
+		ReadHeaderTimeout: requestReadTimeout,
+		IdleTimeout:       time.Minute,
+		ConnContext:       saveConn,
 	}
 	go util.WaitForStop(&wg, func() {
 		log.Printf("Start RESTful API Service on \"%v\"\n", httpRest.Addr)
-		if err := httpRest.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		if err := listenAndServe(httpRest, svc.restTLS); err != nil && err != http.ErrServerClosed {
 			log.Fatalf("Cannot listen on %s:%d: %s\n", svc.config.Address, svc.config.RestPort, err)
 		}
 	})
@@ -192,17 +307,31 @@ func (svc *APIServer) Run() int {
 	// 启动定时器并等待系统中断信号
 	timerSched := time.NewTimer(time.Second)
 	timerNode := time.NewTimer(time.Second * time.Duration(svc.config.Offline+1))
-	quit := make(chan os.Signal)
+	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	// 配置了保留策略时定期清理已结束的作业
+	var sweep <-chan time.Time
+	if svc.config.Retention.enabled() {
+		ticker := time.NewTicker(time.Minute * time.Duration(svc.config.Retention.Interval))
+		defer ticker.Stop()
+		sweep = ticker.C
+	}
 
 	stopped := false
 	for !stopped {
 		select {
 		case <-quit:
 			stopped = true
+		case <-reload:
+			log.Println("Reloading TLS certificates...")
+			svc.reloadCertificates()
 		case <-timerSched.C:
 			svc.runScheduleCycle()
 			timerSched.Reset(time.Second)
+		case <-sweep:
+			svc.requestSweepJobs()
 		case <-timerNode.C:
 			svc.requestCheckNodes()
 			timerNode.Reset(time.Second * time.Duration(svc.config.Offline+1))
@@ -227,10 +356,25 @@ func (svc *APIServer) setScheduleFlag() {
 	atomic.AddInt32(&svc.schedFlag, 1)
 }
 
-func (svc *APIServer) registerRestEndpoint(router *gin.Engine) {
+func (svc *APIServer) registerRestEndpoint(engine *gin.Engine) {
+	// 所有API同时注册在/v1前缀和根路径下。/v1下的API出错时返回统一的错误应答，
+	// 根路径下的旧API保持原有的应答格式，供门户页面和旧版本客户端使用。
+	v1 := engine.Group(apiVersionPrefix, completeError)
+	svc.registerRestRoutes(v1)
+	svc.registerRestRoutes(&engine.RouterGroup)
+	v1.GET("/openapi.json", svc.serveOpenAPI)
+	engine.NoRoute(func(c *gin.Context) {
+		if isVersioned(c) {
+			c.JSON(http.StatusNotFound, newErrorResponse(c, http.StatusNotFound, "Resource not found", nil))
+		}
+	})
+	svc.loadOpenAPI(engine.Routes())
+}
+
+func (svc *APIServer) registerRestRoutes(router *gin.RouterGroup) {
 	svc.restRouter = router
 	// 绑定系统级API路径实现
-	svc.restRouter.GET("/cluster", func(c *gin.Context) {
+	svc.restRouter.GET("/cluster", authorize(PermRead), func(c *gin.Context) {
 		c.JSON(200, gin.H{
 			"id":    svc.config.Cluster,
 			"cycle": svc.schedCycle,
@@ -250,6 +394,14 @@ func (svc *APIServer) registerRestEndpoint(router *gin.Engine) {
 	registerEndpoint(&QueueEndpoint{})
 	// 绑定/nodes相关路径处理
 	registerEndpoint(&NodeEndpoint{})
+	// 绑定/secrets相关路径处理
+	registerEndpoint(&SecretEndpoint{})
+	// 绑定/audit相关路径处理
+	registerEndpoint(&AuditEndpoint{})
+	// 绑定/accounting相关路径处理
+	registerEndpoint(&AccountingEndpoint{})
+	// 绑定/metrics相关路径处理
+	registerEndpoint(&MetricsEndpoint{})
 }
 
 func (svc *APIServer) registerNodeEndpoint(router *gin.Engine) {
@@ -264,4 +416,7 @@ func (svc *APIServer) registerNodeEndpoint(router *gin.Engine) {
 	registerEndpoint(&HeartbeatEndpoint{})
 	registerEndpoint(&NodeRegisterEndpoint{})
 	registerEndpoint(&TaskLogEndpoint{})
+	registerEndpoint(&TaskArtifactEndpoint{})
+	registerEndpoint(&JobInputEndpoint{})
+	registerEndpoint(&TaskSecretEndpoint{})
 }
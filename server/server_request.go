@@ -1,36 +1,78 @@
 package server
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
+	"mime/multipart"
 	"os"
 	"path/filepath"
 	"time"
 
 	"github.com/qianxiaoming/lightsched/constant"
+	"github.com/qianxiaoming/lightsched/data"
 	"github.com/qianxiaoming/lightsched/message"
 	"github.com/qianxiaoming/lightsched/model"
 	"github.com/qianxiaoming/lightsched/util"
 )
 
-func (svc *APIServer) requestCreateJob(spec *model.JobSpec) error {
+func (svc *APIServer) requestCreateJob(spec *model.JobSpec, owner string, files []*multipart.FileHeader) error {
 	// 如果没有指定作业编号和队列则指定默认值
 	if len(spec.ID) == 0 {
 		spec.ID = util.GenerateUUID()
+	} else if err := model.ValidateJobID(spec.ID); err != nil {
+		return errInvalid("%v", err)
 	}
 	if len(spec.Queue) == 0 {
 		spec.Queue = constant.DefaultQueueName
 	}
+	// 执行器设置错误的任务不会被任何节点调度，提交时直接拒绝
+	for _, g := range spec.GroupSpecs {
+		if err := model.ValidateRuntime(g.Runtime, g.Image); err != nil {
+			return errInvalid("Invalid runtime of task group %s: %v", g.Name, err)
+		}
+	}
+
+	// 写入输入文件前先检查作业编号是否冲突，避免覆盖或删除已有作业的输入文件
+	svc.state.RLock()
+	exists := svc.state.GetJob(spec.ID) != nil
+	svc.state.RUnlock()
+	if exists {
+		return errConflict("Job ID \"%s\" conflicted with others", spec.ID)
+	}
+
+	// 保存随作业上传的输入文件，并将任务的输入文件指向它们
+	if err := svc.saveJobInputs(spec, files); err != nil {
+		return err
+	}
 
 	// 创建Job对象并生成TaskGroup及Task对象，保存到服务状态数据中
 	job := model.NewJobWithSpec(spec)
+	job.Owner = owner
 	if err := func() error {
 		svc.state.Lock()
 		defer svc.state.Unlock()
-		return svc.state.AddJob(job)
+		if svc.state.GetJob(job.ID) != nil {
+			return errConflict("Job ID \"%s\" conflicted with others", job.ID)
+		}
+		if svc.state.GetJobQueue(job.Queue) == nil {
+			return errInvalid("Invalid queue name \"%s\"", job.Queue)
+		}
+		if err := svc.checkSubmitLimits(job); err != nil {
+			return err
+		}
+		if err := svc.state.AddJob(job); err != nil {
+			return err
+		}
+		svc.usage.trackJob(job)
+		return nil
 	}(); err != nil {
+		if len(files) > 0 {
+			os.RemoveAll(svc.jobInputPath(spec.ID))
+		}
 		return err
 	}
 
@@ -51,7 +93,107 @@ func (svc *APIServer) requestCreateJob(spec *model.JobSpec) error {
 	return nil
 }
 
-func (svc *APIServer) requestRegisterNode(ip string, req *message.RegisterNode) error {
+func (svc *APIServer) jobInputPath(jobid string) string {
+	return filepath.Join(svc.config.DataPath, jobid, "inputs")
+}
+
+func (svc *APIServer) saveJobInputs(spec *model.JobSpec, files []*multipart.FileHeader) error {
+	hashes := make(map[string]string, len(files))
+	if len(files) > 0 {
+		dir := svc.jobInputPath(spec.ID)
+		if util.PathExists(dir) {
+			return errConflict("Job ID \"%s\" conflicted with others", spec.ID)
+		}
+		for _, fh := range files {
+			hash, err := saveInputFile(dir, fh)
+			if err != nil {
+				os.RemoveAll(dir)
+				return fmt.Errorf("Unable to save input file %s: %v", fh.Filename, err)
+			}
+			hashes[util.UniformPath(fh.Filename)] = hash
+		}
+		log.Printf("%d input file(s) of job %s saved\n", len(files), spec.ID)
+	}
+
+	resolve := func(inputs []*model.InputFile) error {
+		for _, input := range inputs {
+			if len(input.Source) == 0 {
+				input.Source = input.Path
+			}
+			if !input.IsUploaded() {
+				continue
+			}
+			name := util.UniformPath(input.Source)
+			hash, ok := hashes[name]
+			if !ok {
+				return errInvalid("input file %s is not uploaded with the job", input.Source)
+			}
+			input.Source = fmt.Sprintf("/jobs/%s/inputs/%s", spec.ID, name)
+			input.Hash = hash
+		}
+		return nil
+	}
+	for _, g := range spec.GroupSpecs {
+		err := resolve(g.Inputs)
+		for _, t := range g.TaskSpecs {
+			if err == nil {
+				err = resolve(t.Inputs)
+			}
+		}
+		if err != nil {
+			// 只删除本次请求创建的输入目录
+			if len(files) > 0 {
+				os.RemoveAll(svc.jobInputPath(spec.ID))
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// saveInputFile 将上传的文件保存到指定目录下并返回其内容的SHA256值
+func saveInputFile(dir string, fh *multipart.FileHeader) (string, error) {
+	filename, err := util.SafeJoin(dir, fh.Filename)
+	if err != nil {
+		return "", err
+	}
+	if err := util.MakeDirAll(filepath.Dir(filename)); err != nil {
+		return "", err
+	}
+	src, err := fh.Open()
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(filename, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0666)
+	if err != nil {
+		return "", err
+	}
+	defer dst.Close()
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(dst, h), src); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (svc *APIServer) requestGetJobInput(jobid string, path string) string {
+	// SafeJoin只保证path不会超出作业的输入目录，作业编号本身也需要校验
+	if model.ValidateJobID(jobid) != nil {
+		return ""
+	}
+	filename, err := util.SafeJoin(svc.jobInputPath(jobid), path)
+	if err != nil {
+		return ""
+	}
+	if info, err := os.Stat(filename); err != nil || !info.Mode().IsRegular() {
+		return ""
+	}
+	return filename
+}
+
+// requestRegisterNode 注册节点。replace为false时只有同名节点不存在或已经超时才能注册。
+func (svc *APIServer) requestRegisterNode(ip string, req *message.RegisterNode, secret string, replace bool) error {
 	if len(req.Name) == 0 {
 		return fmt.Errorf("the name of the node is empty")
 	}
@@ -63,14 +205,21 @@ func (svc *APIServer) requestRegisterNode(ip string, req *message.RegisterNode)
 		Online:    time.Now(),
 		Labels:    req.Labels,
 		Taints:    nil,
+		Runtimes:  req.Runtimes,
+		Images:    req.Images,
+		RunAs:     req.RunAs,
 		Resources: (&req.Resources).Clone(),
 		Reserved:  model.DefaultResourceSet,
 		Available: (&req.Resources).Clone(),
+		Secret:    secret,
 	}
 	node.Available.Consume(node.Reserved)
 
 	svc.nodes.Lock()
 	defer svc.nodes.Unlock()
+	if existing := svc.nodes.GetNode(req.Name); existing != nil && existing.State != model.NodeUnknown && !replace {
+		return errConflict("Node %s is still online and cannot be replaced with the shared join token", req.Name)
+	}
 	svc.nodes.AddNode(node)
 
 	// 标记任务调度状态
@@ -78,7 +227,7 @@ func (svc *APIServer) requestRegisterNode(ip string, req *message.RegisterNode)
 	return nil
 }
 
-func (svc *APIServer) requestUpdateTasks(updates []*message.TaskReport) {
+func (svc *APIServer) requestUpdateTasks(nodeName string, updates []*message.TaskReport) {
 	svc.state.Lock()
 	defer svc.state.Unlock()
 	svc.nodes.Lock()
@@ -87,7 +236,19 @@ func (svc *APIServer) requestUpdateTasks(updates []*message.TaskReport) {
 	reschedule := false
 	// 更新Task及对应Job的状态
 	for _, update := range updates {
-		task := svc.state.UpdateTaskStatus(update.ID, update.State, update.Progress, update.ExitCode, update.Error)
+		// 只接受Task所在节点上报的状态
+		task := svc.state.GetTask(update.ID)
+		if task == nil || task.NodeName != nodeName {
+			log.Printf("Ignore status of task %s reported by node %s\n", update.ID, nodeName)
+			continue
+		}
+		taskid, _ := model.ParseTaskID(update.ID)
+		job := svc.state.GetJob(taskid.Job)
+		from, finished := task.State, isJobFinished(job)
+		task = svc.state.UpdateTaskStatus(update.ID, update.State, update.Progress, update.ExitCode, update.Error, update.Output, update.Usage)
+		if task != nil {
+			svc.usage.taskUpdated(job, task, from, finished)
+		}
 		if task != nil && model.IsFinishState(update.State) {
 			reschedule = true
 			// 归还Task消耗的节点资源
@@ -108,6 +269,9 @@ func (svc *APIServer) requestTerminateJob(id string) error {
 	defer svc.state.Unlock()
 
 	job := svc.state.GetJob(id)
+	if job == nil {
+		return errNotFound("Job %s not found", id)
+	}
 	if job.State == model.JobTerminated || job.State == model.JobCompleted || job.State == model.JobFailed {
 		log.Printf("Job %s is already in finished state\n", id)
 		return nil
@@ -116,6 +280,7 @@ func (svc *APIServer) requestTerminateJob(id string) error {
 	if err := svc.state.SetJobState(id, model.JobTerminated); err != nil {
 		return err
 	}
+	svc.usage.trackJob(job)
 
 	// 确定所有运行此Job的节点名字
 	nodes := make(map[string]bool)
@@ -132,26 +297,43 @@ func (svc *APIServer) requestTerminateJob(id string) error {
 	svc.nodes.Lock()
 	defer svc.nodes.Unlock()
 	for name := range nodes {
-		svc.nodes.AppendNodeMessage(name, message.KindTerminateJob, id, nil)
+		svc.terminateDroppedTasks(svc.nodes.AppendNodeMessage(name, message.KindTerminateJob, id, nil))
 	}
 	log.Println("Job terminated")
 	return nil
 }
 
-func (svc *APIServer) requestListJobs(filterState *model.JobState, sortField model.JobSortField, offset, limits int) []*message.JobInfo {
+// terminateDroppedTasks 将还未发送给节点就被取消的Task设置为终止状态。节点不会上报这些Task
+// 的状态，它们占用的资源已经在取消调度消息时归还。调用者需要持有state和nodes的锁。
+func (svc *APIServer) terminateDroppedTasks(ids []string) {
+	for _, id := range ids {
+		task := svc.state.GetTask(id)
+		if task == nil || task.State != model.TaskScheduled {
+			continue
+		}
+		taskid, _ := model.ParseTaskID(id)
+		job := svc.state.GetJob(taskid.Job)
+		from, finished := task.State, isJobFinished(job)
+		task = svc.state.UpdateTaskStatus(id, model.TaskTerminated, task.Progress, 0, "", nil, nil)
+		if task != nil {
+			svc.usage.taskUpdated(job, task, from, finished)
+		}
+	}
+}
+
+func (svc *APIServer) requestListJobs(query *data.JobQuery) ([]*message.JobInfo, int, string, error) {
 	svc.state.RLock()
 	defer svc.state.RUnlock()
 
-	allJobs := svc.state.QueryJobs(filterState, sortField, offset, limits)
-	if allJobs == nil || len(allJobs) == 0 {
-		return nil
+	result, err := svc.state.FindJobs(query)
+	if err != nil {
+		return nil, 0, "", err
 	}
-
-	infos := make([]*message.JobInfo, 0, len(allJobs))
-	for _, j := range allJobs {
+	infos := make([]*message.JobInfo, 0, len(result.Jobs))
+	for _, j := range result.Jobs {
 		infos = append(infos, message.NewJobInfo(j))
 	}
-	return infos
+	return infos, result.Total, result.Next, nil
 }
 
 func (svc *APIServer) requestGetJob(jobid string) *message.JobInfo {
@@ -168,12 +350,19 @@ func (svc *APIServer) requestDeleteJob(jobid string) error {
 	svc.state.Lock()
 	defer svc.state.Unlock()
 
+	job := svc.state.GetJob(jobid)
+	if job == nil {
+		return errNotFound("Job %s not found", jobid)
+	}
+	if job.State == model.JobExecuting || job.State == model.JobHalted {
+		return errConflict("Cannot delete executing or halted jobs")
+	}
 	log.Printf("Deleting Job %s...\n", jobid)
 	if err := svc.state.DeleteJob(jobid); err != nil {
 		return err
 	}
-	dir := filepath.Join(svc.config.DataPath, jobid)
-	if err := os.RemoveAll(dir); err != nil {
+	svc.usage.untrackJob(jobid)
+	if err := svc.deleteJobFiles(jobid); err != nil {
 		return err
 	}
 	log.Println("Job deleted")
@@ -184,6 +373,9 @@ func (svc *APIServer) requestHaltJob(jobid string) error {
 	svc.state.Lock()
 	defer svc.state.Unlock()
 
+	if svc.state.GetJob(jobid) == nil {
+		return errNotFound("Job %s not found", jobid)
+	}
 	if err := svc.state.SetJobState(jobid, model.JobHalted); err != nil {
 		return err
 	}
@@ -196,12 +388,16 @@ func (svc *APIServer) requestResumeJob(jobid string) error {
 	defer svc.state.Unlock()
 
 	job := svc.state.GetJob(jobid)
+	if job == nil {
+		return errNotFound("Job %s not found", jobid)
+	}
 	job.State = model.JobQueued
 	if job.RefreshState() {
 		if err := svc.state.SetJobState(jobid, job.State); err != nil {
 			return err
 		}
 	}
+	svc.usage.trackJob(job)
 	log.Printf("Job %s resumed\n", jobid)
 	svc.setScheduleFlag()
 	return nil
@@ -213,7 +409,24 @@ func (svc *APIServer) requestModifyJobProps(jobid string, props *model.JobUpdata
 
 	var job *model.Job
 	if job = svc.state.GetJob(jobid); job == nil {
-		return fmt.Errorf("Job %s not found", jobid)
+		return errNotFound("Job %s not found", jobid)
+	}
+	if len(props.Queue) != 0 && props.Queue != job.Queue {
+		if isJobFinished(job) {
+			return errConflict("Cannot move finished job %s to another queue", jobid)
+		}
+		if svc.state.GetJobQueue(props.Queue) == nil {
+			return errInvalid("Invalid queue name \"%s\"", props.Queue)
+		}
+		if err := svc.checkMoveLimits(job, props.Queue); err != nil {
+			return err
+		}
+		if err := svc.state.MoveJob(job, props.Queue); err != nil {
+			return err
+		}
+		svc.usage.trackJob(job)
+		log.Printf("Job %s moved to queue %s\n", jobid, props.Queue)
+		svc.setScheduleFlag()
 	}
 	if len(props.Name) != 0 {
 		job.Name = props.Name
@@ -225,11 +438,94 @@ func (svc *APIServer) requestModifyJobProps(jobid string, props *model.JobUpdata
 		job.MaxErrors = *props.MaxErrors
 	}
 	if props.Labels != nil {
-		job.Labels = props.Labels // TODO 要修改Task的Labels
+		svc.state.SetJobLabels(job, props.Labels) // TODO 要修改Task的Labels
 	}
 	if props.Taints != nil {
 		job.Taints = props.Taints // TODO 要修改Task的Labels
 	}
+	return svc.state.SaveJobs([]*model.Job{job}, nil)
+}
+
+// requestRerunJob 将已结束作业中的Task恢复为排队状态重新执行。all为false时只重新执行未成功完成的Task。
+func (svc *APIServer) requestRerunJob(jobid string, all bool) error {
+	svc.state.Lock()
+	defer svc.state.Unlock()
+
+	job := svc.state.GetJob(jobid)
+	if job == nil {
+		return errNotFound("Job %s not found", jobid)
+	}
+	if !isJobFinished(job) {
+		return errConflict("Job %s is not finished", jobid)
+	}
+	// 终止的作业中可能还有Task在节点上运行，等节点上报其结束后才能重新执行
+	if t := runningTask(job); t != nil {
+		return errConflict("Task %s of job %s is still running on node %s", t.ID, jobid, t.NodeName)
+	}
+	tasks := make([]*model.Task, 0, job.CountTasks())
+	for _, g := range job.Groups {
+		for _, t := range g.Tasks {
+			if all || t.State != model.TaskCompleted {
+				svc.state.ReleaseTask(t)
+				t.Reset()
+				tasks = append(tasks, t)
+			}
+		}
+	}
+	if len(tasks) == 0 {
+		return errConflict("No task of job %s needs to rerun", jobid)
+	}
+	job.State = model.JobQueued
+	job.ExecTime = time.Time{}
+	job.FinishTime = time.Time{}
+	job.RefreshState()
+	svc.usage.trackJob(job)
+	if err := svc.state.SaveJobs([]*model.Job{job}, nil); err != nil {
+		return err
+	}
+	if err := svc.state.SaveTasks(tasks); err != nil {
+		return err
+	}
+	log.Printf("Job %s requeued with %d task(s) to rerun\n", jobid, len(tasks))
+	svc.setScheduleFlag()
+	return nil
+}
+
+// requestListQueues 返回所有作业队列及其中未结束的作业数
+func (svc *APIServer) requestListQueues() []*message.JobQueueInfo {
+	svc.state.RLock()
+	defer svc.state.RUnlock()
+
+	queues := svc.state.GetJobQueues()
+	infos := make([]*message.JobQueueInfo, 0, len(queues))
+	for _, q := range queues {
+		infos = append(infos, &message.JobQueueInfo{Name: q.Name, Enabled: q.Enabled, Priority: q.Priority, Jobs: svc.usage.pendingJobs(q.Name)})
+	}
+	return infos
+}
+
+// requestEnableQueue 启用或禁用作业队列，禁用的队列中的作业不会被调度
+func (svc *APIServer) requestEnableQueue(name string, enabled bool) error {
+	svc.state.Lock()
+	defer svc.state.Unlock()
+
+	queue := svc.state.GetJobQueue(name)
+	if queue == nil {
+		return errNotFound("Queue %s not found", name)
+	}
+	if queue.Enabled == enabled {
+		return nil
+	}
+	queue.Enabled = enabled
+	if err := svc.state.SaveJobQueue(queue); err != nil {
+		return err
+	}
+	if enabled {
+		log.Printf("Queue %s enabled\n", name)
+		svc.setScheduleFlag()
+	} else {
+		log.Printf("Queue %s disabled\n", name)
+	}
 	return nil
 }
 
@@ -251,9 +547,13 @@ func (svc *APIServer) requestListNodes() []*message.NodeInfo {
 			Online:    n.Online.Local().Format("2006-01-02 15:04:05"),
 			Labels:    util.CloneMap(n.Labels),
 			Taints:    util.CloneMap(n.Taints),
+			Runtimes:  n.Runtimes,
+			Images:    n.Images,
+			RunAs:     n.RunAs,
 			Resources: n.Resources.Clone(),
 			Reserved:  n.Reserved.Clone(),
 			Available: n.Available.Clone(),
+			Scratch:   svc.nodes.GetScratchUsage(n.Name),
 		}
 		infos = append(infos, info)
 	}
@@ -276,9 +576,13 @@ func (svc *APIServer) requestGetNode(name string) *message.NodeInfo {
 		Online:    n.Online.Local().Format("2006-01-02 15:04:05"),
 		Labels:    util.CloneMap(n.Labels),
 		Taints:    util.CloneMap(n.Taints),
+		Runtimes:  n.Runtimes,
+		Images:    n.Images,
+		RunAs:     n.RunAs,
 		Resources: n.Resources.Clone(),
 		Reserved:  n.Reserved.Clone(),
 		Available: n.Available.Clone(),
+		Scratch:   svc.nodes.GetScratchUsage(n.Name),
 	}
 }
 
@@ -288,7 +592,7 @@ func (svc *APIServer) requestOnlineNode(name string) error {
 
 	n := svc.nodes.GetNode(name)
 	if n == nil {
-		return fmt.Errorf("Node %s not found", name)
+		return errNotFound("Node %s not found", name)
 	}
 	n.State = model.NodeOnline
 	log.Printf("Node %s is in ONLINE state now\n", name)
@@ -302,7 +606,7 @@ func (svc *APIServer) requestOfflineNode(name string, kill bool) error {
 
 	n := svc.nodes.GetNode(name)
 	if n == nil {
-		return fmt.Errorf("Node %s not found", name)
+		return errNotFound("Node %s not found", name)
 	}
 
 	n.State = model.NodeOffline
@@ -314,31 +618,73 @@ func (svc *APIServer) requestOfflineNode(name string, kill bool) error {
 	return nil
 }
 
-func (svc *APIServer) requestGetTask(id string) *message.TaskInfo {
+// requestIssueNodeToken 为节点签发单独的加入token，已有的token将被替换
+func (svc *APIServer) requestIssueNodeToken(name string) (string, error) {
+	token := util.GenerateUUID()
+	svc.state.Lock()
+	defer svc.state.Unlock()
+	if err := svc.state.SetNodeToken(name, hashToken(token)); err != nil {
+		return "", err
+	}
+	log.Printf("Join token issued for node %s\n", name)
+	return token, nil
+}
+
+// requestRevokeNodeToken 撤销节点的加入token，节点当前的会话同时失效
+func (svc *APIServer) requestRevokeNodeToken(name string) error {
+	if err := func() error {
+		svc.state.Lock()
+		defer svc.state.Unlock()
+		return svc.state.DeleteNodeToken(name)
+	}(); err != nil {
+		return err
+	}
+	svc.nodes.Lock()
+	defer svc.nodes.Unlock()
+	if n := svc.nodes.GetNode(name); n != nil {
+		n.Secret = ""
+	}
+	log.Printf("Join token of node %s revoked\n", name)
+	return nil
+}
+
+// lookupTask 返回指定编号的Task，作业或Task不存在时返回nil。调用者需持有state锁。
+func (svc *APIServer) lookupTask(id model.TaskID) *model.Task {
+	if job := svc.state.GetJob(id.Job); job != nil {
+		return job.GetTask(id)
+	}
+	return nil
+}
+
+func (svc *APIServer) requestGetTask(id model.TaskID) *message.TaskInfo {
 	svc.state.RLock()
 	defer svc.state.RUnlock()
 
-	jobid, groupid, taskid := model.ParseTaskID(id)
-	job := svc.state.GetJob(jobid)
-	if job == nil {
-		return nil
+	if task := svc.lookupTask(id); task != nil {
+		return message.NewTaskInfo(task)
 	}
-	task := job.Groups[groupid].Tasks[taskid]
-	return message.NewTaskInfo(task)
+	return nil
+}
+
+func (svc *APIServer) requestGetJobOutput(jobid string) *message.JobOutput {
+	svc.state.RLock()
+	defer svc.state.RUnlock()
+
+	if job := svc.state.GetJob(jobid); job != nil {
+		return message.NewJobOutput(job)
+	}
+	return nil
 }
 
-func (svc *APIServer) requestGetTasks(ids []string) []*message.TaskStatus {
+func (svc *APIServer) requestGetTasks(ids []model.TaskID) []*message.TaskStatus {
 	svc.state.RLock()
 	defer svc.state.RUnlock()
 
 	infos := make([]*message.TaskStatus, 0, len(ids))
 	for _, id := range ids {
-		jobid, groupid, taskid := model.ParseTaskID(id)
-		job := svc.state.GetJob(jobid)
-		if job == nil {
-			continue
+		if task := svc.lookupTask(id); task != nil {
+			infos = append(infos, message.NewTaskStatus(task))
 		}
-		infos = append(infos, message.NewTaskStatus(job.Groups[groupid].Tasks[taskid]))
 	}
 	if len(infos) == 0 {
 		return nil
@@ -346,46 +692,79 @@ func (svc *APIServer) requestGetTasks(ids []string) []*message.TaskStatus {
 	return infos
 }
 
-func (svc *APIServer) requestGetJobTasks(id string) []*message.TaskStatus {
+func (svc *APIServer) requestListJobTasks(jobid string, query *data.TaskQuery) ([]*message.TaskStatus, int, string, error) {
 	svc.state.RLock()
 	defer svc.state.RUnlock()
 
-	job := svc.state.GetJob(id)
-	if job == nil {
-		return nil
+	result, err := svc.state.FindJobTasks(jobid, query)
+	if result == nil || err != nil {
+		return nil, 0, "", err
 	}
-	infos := make([]*message.TaskStatus, 0, job.CountTasks())
-	for _, group := range job.Groups {
-		for _, task := range group.Tasks {
-			infos = append(infos, message.NewTaskStatus(task))
+	infos := make([]*message.TaskStatus, 0, len(result.Tasks))
+	for _, task := range result.Tasks {
+		infos = append(infos, message.NewTaskStatus(task))
+	}
+	return infos, result.Total, result.Next, nil
+}
+
+func (svc *APIServer) requestSummarizeJobTasks(jobid string, query *data.TaskQuery) (*message.TaskSummary, error) {
+	svc.state.RLock()
+	defer svc.state.RUnlock()
+
+	query.Cursor = ""
+	query.Limit = 0
+	result, err := svc.state.FindJobTasks(jobid, query)
+	if result == nil || err != nil {
+		return nil, err
+	}
+	summary := &message.TaskSummary{Total: result.Total, States: make(map[string]int), Groups: make(map[string]map[string]int)}
+	for _, task := range result.Tasks {
+		state := model.TaskStateToString(task.State)
+		summary.States[state]++
+		if _, ok := summary.Groups[task.Group]; !ok {
+			summary.Groups[task.Group] = make(map[string]int)
 		}
+		summary.Groups[task.Group][state]++
+	}
+	return summary, nil
+}
+
+// requestGetNodeTasks 返回分配到节点且未结束的Task，节点不存在时返回nil
+func (svc *APIServer) requestGetNodeTasks(name string) []*message.TaskStatus {
+	svc.nodes.RLock()
+	node := svc.nodes.GetNode(name)
+	svc.nodes.RUnlock()
+	if node == nil {
+		return nil
+	}
+	svc.state.RLock()
+	defer svc.state.RUnlock()
+	tasks := svc.state.GetNodeTasks(name)
+	infos := make([]*message.TaskStatus, 0, len(tasks))
+	for _, task := range tasks {
+		infos = append(infos, message.NewTaskStatus(task))
 	}
 	return infos
 }
 
-func (svc *APIServer) requestGetTaskStatus(id string) *message.TaskStatus {
+func (svc *APIServer) requestGetTaskStatus(id model.TaskID) *message.TaskStatus {
 	svc.state.RLock()
 	defer svc.state.RUnlock()
 
-	jobid, groupid, taskid := model.ParseTaskID(id)
-	job := svc.state.GetJob(jobid)
-	if job == nil {
-		return nil
+	if task := svc.lookupTask(id); task != nil {
+		return message.NewTaskStatus(task)
 	}
-	task := job.Groups[groupid].Tasks[taskid]
-	return message.NewTaskStatus(task)
+	return nil
 }
 
-func (svc *APIServer) requestGetTaskLog(id string) io.ReadCloser {
+func (svc *APIServer) requestGetTaskLog(id model.TaskID) *os.File {
 	svc.state.RLock()
 	defer svc.state.RUnlock()
 
-	jobid, groupid, taskid := model.ParseTaskID(id)
-	job := svc.state.GetJob(jobid)
-	if job == nil {
+	if svc.lookupTask(id) == nil {
 		return nil
 	}
-	filename := filepath.Join(svc.config.DataPath, jobid, fmt.Sprintf("%d.%d.log", groupid, taskid))
+	filename := svc.taskLogPath(id)
 	file, err := os.OpenFile(filename, os.O_RDONLY, 0666)
 	if err != nil {
 		log.Printf("Unable to open log file %s: %v\n", filename, err)
@@ -394,6 +773,86 @@ func (svc *APIServer) requestGetTaskLog(id string) io.ReadCloser {
 	return file
 }
 
+func (svc *APIServer) taskLogPath(id model.TaskID) string {
+	return filepath.Join(svc.config.DataPath, id.Job, fmt.Sprintf("%d.%d.log", id.Group, id.Index))
+}
+
+func (svc *APIServer) taskArtifactPath(id model.TaskID) string {
+	return filepath.Join(svc.config.DataPath, id.Job, fmt.Sprintf("%d.%d", id.Group, id.Index))
+}
+
+func (svc *APIServer) requestSaveTaskArtifact(id model.TaskID, path string, content io.Reader) error {
+	svc.state.RLock()
+	task := svc.lookupTask(id)
+	svc.state.RUnlock()
+	if task == nil {
+		return errNotFound("Task %s not found", id)
+	}
+
+	filename, err := util.SafeJoin(svc.taskArtifactPath(id), path)
+	if err != nil {
+		return err
+	}
+	if err := util.MakeDirAll(filepath.Dir(filename)); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0666)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, content)
+	file.Close()
+	if err != nil {
+		// 不保留不完整的结果文件
+		os.Remove(filename)
+		return err
+	}
+	log.Printf("Artifact %s of task %s saved\n", path, id)
+	return nil
+}
+
+func (svc *APIServer) requestListTaskArtifacts(id model.TaskID) []*message.ArtifactInfo {
+	svc.state.RLock()
+	defer svc.state.RUnlock()
+
+	if svc.lookupTask(id) == nil {
+		return nil
+	}
+	artifacts := make([]*message.ArtifactInfo, 0, 8)
+	dir := svc.taskArtifactPath(id)
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		rel, _ := filepath.Rel(dir, path)
+		artifacts = append(artifacts, &message.ArtifactInfo{
+			Path:    filepath.ToSlash(rel),
+			Size:    info.Size(),
+			ModTime: info.ModTime().Local().Format("2006-01-02 15:04:05"),
+		})
+		return nil
+	})
+	return artifacts
+}
+
+func (svc *APIServer) requestGetTaskArtifact(id model.TaskID, path string) string {
+	svc.state.RLock()
+	defer svc.state.RUnlock()
+
+	if svc.lookupTask(id) == nil {
+		return ""
+	}
+	filename, err := util.SafeJoin(svc.taskArtifactPath(id), path)
+	if err != nil {
+		return ""
+	}
+	// 只提供普通文件，不提供目录等其它类型的文件
+	if info, err := os.Stat(filename); err != nil || !info.Mode().IsRegular() {
+		return ""
+	}
+	return filename
+}
+
 func (svc *APIServer) requestCheckNodes() {
 	svc.state.Lock()
 	defer svc.state.Unlock()
@@ -405,21 +864,33 @@ func (svc *APIServer) requestCheckNodes() {
 	if nodes == nil {
 		return
 	}
+	for name := range nodes {
+		svc.metrics.missedBeats.Inc(name)
+	}
 
-	// 遍历Task，将所有分配给超时节点并未完成的任务设为Queued状态
+	// 遍历Task，将所有分配给超时节点并未完成的任务设为Queued状态。Task已调度但还未开始执行时
+	// 作业仍是排队状态，因此检查所有作业；已终止作业中的这些Task直接设为终止状态。
 	var tasks []*model.Task
 	jobs := svc.state.GetAllJobs()
 	for _, job := range jobs {
-		if job.State != model.JobExecuting {
-			continue
-		}
+		finished := isJobFinished(job)
 		for _, group := range job.Groups {
 			for _, task := range group.Tasks {
 				if model.IsFinishState(task.State) || task.State == model.TaskQueued {
 					continue
 				}
-				if _, ok := nodes[task.NodeName]; ok {
+				if _, ok := nodes[task.NodeName]; !ok {
+					continue
+				}
+				if finished {
+					from := task.State
+					if task = svc.state.UpdateTaskStatus(task.ID, model.TaskTerminated, task.Progress, 0, "Node is unreachable", nil, nil); task != nil {
+						svc.usage.taskUpdated(job, task, from, true)
+					}
+				} else {
 					log.Printf("Task %s was scheduled to node %s and reschedule it now\n", task.ID, task.NodeName)
+					svc.state.ReleaseTask(task)
+					svc.usage.taskChanged(job, task, task.State, model.TaskQueued)
 					task.State = model.TaskQueued
 					task.NodeName = ""
 					task.Progress = 0
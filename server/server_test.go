@@ -0,0 +1,509 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/qianxiaoming/lightsched/message"
+	"github.com/qianxiaoming/lightsched/model"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(ioutil.Discard)
+	os.Exit(m.Run())
+}
+
+// newTestServer 在临时目录中创建并初始化API Server，测试结束时关闭数据库并删除目录
+func newTestServer(t *testing.T, conf *Config) *APIServer {
+	dir, err := ioutil.TempDir("", "lightsched-server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf == nil {
+		conf = &Config{}
+	}
+	conf.DataPath = dir
+	svc := NewAPIServerWithConfig(conf)
+	if err := svc.Init(); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		svc.Close()
+		os.RemoveAll(dir)
+	})
+	return svc
+}
+
+// serve 向处理器发送请求，body不为nil时以JSON格式发送
+func serve(h http.Handler, method string, path string, body interface{}) *httptest.ResponseRecorder {
+	var req *http.Request
+	if body != nil {
+		b, _ := json.Marshal(body)
+		req = httptest.NewRequest(method, path, bytes.NewReader(b))
+		req.Header.Set("Content-Type", "application/json")
+	} else {
+		req = httptest.NewRequest(method, path, nil)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+// submitTestJob 提交1个包含2个任务组的作业，第1组有tasks个Task，第2组有1个Task
+func submitTestJob(t *testing.T, svc *APIServer, id string, tasks int) {
+	first := &model.TaskGroupSpec{Name: "first", Command: "run"}
+	for i := 0; i < tasks; i++ {
+		first.TaskSpecs = append(first.TaskSpecs, &model.TaskSpec{Name: "t"})
+	}
+	second := &model.TaskGroupSpec{Name: "second", Command: "run", TaskSpecs: []*model.TaskSpec{{Name: "t"}}}
+	spec := &model.JobSpec{ID: id, Name: id, GroupSpecs: []*model.TaskGroupSpec{first, second}}
+	if w := serve(svc.RestHandler(), "POST", "/v1/jobs", spec); w.Code != http.StatusCreated {
+		t.Fatalf("submit job %s: %d %s", id, w.Code, w.Body.String())
+	}
+}
+
+func TestTaskIDHandlers(t *testing.T) {
+	svc := newTestServer(t, nil)
+	submitTestJob(t, svc, "job", 3)
+	h := svc.RestHandler()
+	cases := []struct {
+		path string
+		code int
+	}{
+		{"/v1/tasks/job.0.2", http.StatusOK},
+		{"/v1/tasks/job.1.0?status=1", http.StatusOK},
+		{"/v1/tasks/abc.9.9", http.StatusNotFound},
+		{"/v1/tasks/job.0.3", http.StatusNotFound},
+		{"/v1/tasks/job.2.0", http.StatusNotFound},
+		{"/v1/tasks/job.1.1?status=1", http.StatusNotFound},
+		{"/v1/tasks/job.999999999.999999999", http.StatusNotFound},
+		{"/v1/tasks/job.0.99999999999999999999", http.StatusBadRequest},
+		{"/v1/tasks/job.-1.0", http.StatusBadRequest},
+		{"/v1/tasks/job.01.0", http.StatusBadRequest},
+		{"/v1/tasks/job.0", http.StatusBadRequest},
+		{"/v1/tasks/job.0.0.0", http.StatusBadRequest},
+		{"/v1/tasks/..", http.StatusBadRequest},
+		{"/v1/tasks/job.0.x/log", http.StatusBadRequest},
+		{"/v1/tasks/job.5.0/log", http.StatusNotFound},
+		{"/v1/tasks/job.0.9/artifacts", http.StatusNotFound},
+		{"/v1/tasks/abc/artifacts", http.StatusBadRequest},
+		{"/v1/tasks/job.0.9/artifacts/out.txt", http.StatusNotFound},
+		{"/v1/tasks/job.a.0/artifacts/out.txt", http.StatusBadRequest},
+		{"/tasks/abc.9.9", http.StatusNotFound},
+		{"/tasks/abc", http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		if w := serve(h, "GET", c.path, nil); w.Code != c.code {
+			t.Errorf("GET %s = %d, want %d: %s", c.path, w.Code, c.code, w.Body.String())
+		}
+	}
+}
+
+func TestTaskListHandlers(t *testing.T) {
+	svc := newTestServer(t, nil)
+	submitTestJob(t, svc, "job", 3)
+	h := svc.RestHandler()
+	var tasks []*message.TaskStatus
+
+	// 按ids查询时忽略不存在的Task，编号格式不正确时返回400
+	w := serve(h, "GET", "/v1/tasks?ids=job.0.1,abc.9.9,job.7.0,job.0.3", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET tasks by ids = %d: %s", w.Code, w.Body.String())
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &tasks); err != nil || len(tasks) != 1 || tasks[0].ID != "job.0.1" {
+		t.Errorf("tasks by ids: %v %s", err, w.Body.String())
+	}
+	for _, ids := range []string{"job.0.1,abc", "job.0.1,", "job.0.-1", ",,,"} {
+		if w := serve(h, "GET", "/v1/tasks?ids="+ids, nil); w.Code != http.StatusBadRequest {
+			t.Errorf("GET tasks?ids=%s = %d, want 400", ids, w.Code)
+		}
+	}
+
+	// 使用游标分页
+	w = serve(h, "GET", "/v1/tasks?jobid=job&limit=2", nil)
+	if w.Code != http.StatusOK || w.Header().Get("X-Next-Cursor") != "job.0.1" || w.Header().Get("X-Total-Count") != "4" {
+		t.Fatalf("first page = %d %v: %s", w.Code, w.Header(), w.Body.String())
+	}
+	w = serve(h, "GET", "/v1/tasks?jobid=job&limit=2&cursor=job.0.1", nil)
+	tasks = nil
+	if err := json.Unmarshal(w.Body.Bytes(), &tasks); err != nil || len(tasks) != 2 || tasks[0].ID != "job.0.2" || tasks[1].ID != "job.1.0" {
+		t.Errorf("second page: %v %s", err, w.Body.String())
+	}
+	if next := w.Header().Get("X-Next-Cursor"); len(next) > 0 {
+		t.Errorf("last page has next cursor %s", next)
+	}
+	// 游标越界时返回空的结果，格式不正确或属于其它作业时返回400
+	w = serve(h, "GET", "/v1/tasks?jobid=job&cursor=job.9.9", nil)
+	tasks = nil
+	if json.Unmarshal(w.Body.Bytes(), &tasks); len(tasks) != 0 {
+		t.Errorf("tasks after out of range cursor: %s", w.Body.String())
+	}
+	for _, cursor := range []string{"abc.0.0", "job.0", "job.x.1", "job.0.-1", "job.0.99999999999999999999"} {
+		if w := serve(h, "GET", "/v1/tasks?jobid=job&cursor="+cursor, nil); w.Code != http.StatusBadRequest {
+			t.Errorf("GET tasks with cursor %s = %d, want 400", cursor, w.Code)
+		}
+	}
+}
+
+func TestHeartbeatMalformedReports(t *testing.T) {
+	svc := newTestServer(t, nil)
+	submitTestJob(t, svc, "job", 2)
+	reg := &message.RegisterNode{Name: "node1", Resources: model.ResourceSet{CPU: model.ResourceCPU{Cores: 4}, Memory: 4096}}
+	if w := serve(svc.NodeHandler(), "POST", "/nodes", reg); w.Code != http.StatusOK {
+		t.Fatalf("register node: %d %s", w.Code, w.Body.String())
+	}
+	// 将job.0.0分配给node1
+	svc.state.Lock()
+	task := svc.state.GetTask("job.0.0")
+	svc.usage.taskChanged(svc.state.GetJob("job"), task, task.State, model.TaskExecuting)
+	task.State = model.TaskExecuting
+	task.NodeName = "node1"
+	svc.state.Unlock()
+
+	reports := []*message.TaskReport{
+		{ID: "", State: model.TaskCompleted},
+		{ID: "abc.9.9", State: model.TaskCompleted},
+		{ID: "job", State: model.TaskCompleted},
+		{ID: "job.0.-1", State: model.TaskCompleted},
+		{ID: "job.0.99999999999999999999", State: model.TaskCompleted},
+		{ID: "job.9.0", State: model.TaskCompleted},
+		{ID: "job.0.9", State: model.TaskCompleted},
+		{ID: "job.0.1", State: model.TaskCompleted}, // 不属于该节点
+		{ID: "job.0.0", State: model.TaskExecuting, Progress: 50},
+	}
+	hb := &message.Heartbeat{Name: "node1", Payload: reports}
+	if w := serve(svc.NodeHandler(), "POST", "/heartbeat", hb); w.Code != http.StatusOK {
+		t.Fatalf("heartbeat = %d: %s", w.Code, w.Body.String())
+	}
+	// 心跳中的状态是异步更新的，等待最后一个合法的报告生效
+	svc.state.RLock()
+	defer svc.state.RUnlock()
+	for i := 0; svc.state.GetTask("job.0.0").Progress != 50; i++ {
+		if i == 500 {
+			t.Fatal("status of job.0.0 is not updated")
+		}
+		svc.state.RUnlock()
+		time.Sleep(10 * time.Millisecond)
+		svc.state.RLock()
+	}
+	if task := svc.state.GetTask("job.0.0"); task.State != model.TaskExecuting {
+		t.Errorf("reported task: state %v", task.State)
+	}
+	if task := svc.state.GetTask("job.0.1"); task.State != model.TaskQueued {
+		t.Errorf("task of another node is updated to %v", task.State)
+	}
+	if job := svc.state.GetJob("job"); isJobFinished(job) {
+		t.Errorf("job state is %v", job.State)
+	}
+	// 直接更新不存在或格式不正确的Task时返回nil
+	for _, r := range reports[:7] {
+		if task := svc.state.UpdateTaskStatus(r.ID, r.State, 0, 0, "", nil, nil); task != nil {
+			t.Errorf("UpdateTaskStatus(%q) = %s", r.ID, task.ID)
+		}
+	}
+}
+
+func TestEmptyLists(t *testing.T) {
+	svc := newTestServer(t, nil)
+	h := svc.RestHandler()
+	for _, path := range []string{"/v1/jobs", "/v1/jobs?state=Executing", "/v1/nodes", "/v1/tasks?ids=abc.0.0"} {
+		w := serve(h, "GET", path, nil)
+		if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != "[]" {
+			t.Errorf("GET %s = %d %s, want 200 []", path, w.Code, w.Body.String())
+		}
+		if !strings.HasPrefix(path, "/v1/tasks") && w.Header().Get("X-Total-Count") != "0" {
+			t.Errorf("GET %s: X-Total-Count is %q", path, w.Header().Get("X-Total-Count"))
+		}
+	}
+	// 旧API保持原有的行为
+	for _, path := range []string{"/jobs", "/nodes", "/tasks?ids=abc.0.0"} {
+		if w := serve(h, "GET", path, nil); w.Code != http.StatusNotFound {
+			t.Errorf("GET %s = %d, want 404", path, w.Code)
+		}
+	}
+}
+
+func TestActionResponses(t *testing.T) {
+	svc := newTestServer(t, nil)
+	submitTestJob(t, svc, "job", 1)
+	h := svc.RestHandler()
+	cases := []struct {
+		method string
+		path   string
+		body   interface{}
+		code   int
+		result message.ActionResult
+	}{
+		{"PUT", "/v1/jobs/job", &model.JobUpdatableProps{Name: "renamed"}, http.StatusOK, message.ActionResult{Kind: "job", ID: "job", Action: "modify"}},
+		{"PUT", "/v1/jobs/job/_halt", nil, http.StatusOK, message.ActionResult{Kind: "job", ID: "job", Action: "halt"}},
+		{"PUT", "/v1/jobs/job/_resume", nil, http.StatusOK, message.ActionResult{Kind: "job", ID: "job", Action: "resume"}},
+		{"PUT", "/v1/jobs/job/_terminate", nil, http.StatusAccepted, message.ActionResult{Kind: "job", ID: "job", Action: "terminate"}},
+		{"PUT", "/v1/jobs/job/_rerun", nil, http.StatusOK, message.ActionResult{Kind: "job", ID: "job", Action: "rerun"}},
+		{"PUT", "/v1/queues/default?enable=false", nil, http.StatusOK, message.ActionResult{Kind: "queue", ID: "default", Action: "disable"}},
+		{"PUT", "/v1/queues/default?enable=true", nil, http.StatusOK, message.ActionResult{Kind: "queue", ID: "default", Action: "enable"}},
+		{"PUT", "/v1/secrets/token", map[string]string{"value": "s3cr3t"}, http.StatusOK, message.ActionResult{Kind: "secret", ID: "token", Action: "put"}},
+		{"DELETE", "/v1/secrets/token", nil, http.StatusOK, message.ActionResult{Kind: "secret", ID: "token", Action: "delete"}},
+		{"PUT", "/v1/jobs/job/_terminate", nil, http.StatusAccepted, message.ActionResult{Kind: "job", ID: "job", Action: "terminate"}},
+		{"DELETE", "/v1/jobs/job", nil, http.StatusOK, message.ActionResult{Kind: "job", ID: "job", Action: "delete"}},
+	}
+	for _, c := range cases {
+		w := serve(h, c.method, c.path, c.body)
+		var result message.ActionResult
+		if w.Code != c.code || json.Unmarshal(w.Body.Bytes(), &result) != nil || result != c.result {
+			t.Errorf("%s %s = %d %s, want %d %+v", c.method, c.path, w.Code, w.Body.String(), c.code, c.result)
+		}
+	}
+}
+
+func TestQueueHandlers(t *testing.T) {
+	svc := newTestServer(t, nil)
+	submitTestJob(t, svc, "job1", 1)
+	submitTestJob(t, svc, "job2", 1)
+	h := svc.RestHandler()
+	if w := serve(h, "PUT", "/v1/jobs/job2/_terminate", nil); w.Code != http.StatusAccepted {
+		t.Fatalf("terminate job2 = %d", w.Code)
+	}
+
+	// 队列列表中只统计未结束的作业
+	var queues []*message.JobQueueInfo
+	w := serve(h, "GET", "/v1/queues", nil)
+	if err := json.Unmarshal(w.Body.Bytes(), &queues); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("GET queues = %d %s", w.Code, w.Body.String())
+	}
+	want := message.JobQueueInfo{Name: "default", Enabled: true, Priority: 1000, Jobs: 1}
+	if len(queues) != 1 || *queues[0] != want {
+		t.Fatalf("queues: %s", w.Body.String())
+	}
+
+	// 禁用的队列不参与调度
+	if w := serve(h, "PUT", "/v1/queues/default?enable=false", nil); w.Code != http.StatusOK {
+		t.Fatalf("disable queue = %d %s", w.Code, w.Body.String())
+	}
+	svc.state.RLock()
+	schedulable := len(svc.state.GetSchedulableQueues())
+	svc.state.RUnlock()
+	if schedulable != 0 {
+		t.Errorf("disabled queue is schedulable")
+	}
+	queues = nil
+	json.Unmarshal(serve(h, "GET", "/v1/queues", nil).Body.Bytes(), &queues)
+	if len(queues) != 1 || queues[0].Enabled {
+		t.Errorf("queue is not disabled: %+v", queues)
+	}
+	if w := serve(h, "PUT", "/v1/queues/default?enable=true", nil); w.Code != http.StatusOK {
+		t.Fatalf("enable queue = %d %s", w.Code, w.Body.String())
+	}
+	svc.state.RLock()
+	schedulable = len(svc.state.GetSchedulableQueues())
+	svc.state.RUnlock()
+	if schedulable != 1 {
+		t.Errorf("enabled queue is not schedulable")
+	}
+	if w := serve(h, "PUT", "/v1/queues/missing?enable=true", nil); w.Code != http.StatusNotFound {
+		t.Errorf("enable missing queue = %d, want 404", w.Code)
+	}
+}
+
+func TestTaskLogRange(t *testing.T) {
+	svc := newTestServer(t, nil)
+	submitTestJob(t, svc, "job", 1)
+	content := "line 1\nline 2\n"
+	req := httptest.NewRequest("POST", "/tasks/job.0.0/log", strings.NewReader(content))
+	w := httptest.NewRecorder()
+	svc.NodeHandler().ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("upload log = %d %s", w.Code, w.Body.String())
+	}
+
+	get := func(rng string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/v1/tasks/job.0.0/log", nil)
+		if len(rng) > 0 {
+			req.Header.Set("Range", rng)
+		}
+		w := httptest.NewRecorder()
+		svc.RestHandler().ServeHTTP(w, req)
+		return w
+	}
+	if w := get(""); w.Code != http.StatusOK || w.Body.String() != content || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		t.Errorf("full log = %d %q %s", w.Code, w.Body.String(), w.Header().Get("Content-Type"))
+	}
+	if w := get("bytes=7-"); w.Code != http.StatusPartialContent || w.Body.String() != "line 2\n" {
+		t.Errorf("log from offset 7 = %d %q", w.Code, w.Body.String())
+	}
+	// 没有新增内容时返回416，客户端据此判断日志没有变化
+	if w := get("bytes=14-"); w.Code != http.StatusRequestedRangeNotSatisfiable {
+		t.Errorf("log from the end = %d %q", w.Code, w.Body.String())
+	}
+	if w := serve(svc.RestHandler(), "GET", "/v1/tasks/job.0.1/log", nil); w.Code != http.StatusNotFound {
+		t.Errorf("log of missing task = %d", w.Code)
+	}
+}
+
+func TestTaskLogAppend(t *testing.T) {
+	svc := newTestServer(t, nil)
+	submitTestJob(t, svc, "job", 1)
+	upload := func(query string, content string) int {
+		w := httptest.NewRecorder()
+		svc.NodeHandler().ServeHTTP(w, httptest.NewRequest("POST", "/tasks/job.0.0/log"+query, strings.NewReader(content)))
+		return w.Code
+	}
+	content := func() string {
+		return serve(svc.RestHandler(), "GET", "/v1/tasks/job.0.0/log", nil).Body.String()
+	}
+	if code := upload("?offset=0", "line 1\n"); code != http.StatusOK {
+		t.Fatalf("upload first part = %d", code)
+	}
+	if code := upload("?offset=7", "line 2\n"); code != http.StatusOK || content() != "line 1\nline 2\n" {
+		t.Fatalf("append = %d %q", code, content())
+	}
+	// 上传中断后从相同的位置重新上传，之前写入的部分内容被覆盖
+	if code := upload("?offset=7", "line 2\nline 3\n"); code != http.StatusOK || content() != "line 1\nline 2\nline 3\n" {
+		t.Fatalf("upload again = %d %q", code, content())
+	}
+	if code := upload("?offset=100", "line 4\n"); code != http.StatusConflict {
+		t.Errorf("upload beyond the end = %d", code)
+	}
+	if code := upload("?offset=-1", "line 4\n"); code != http.StatusBadRequest {
+		t.Errorf("upload with negative offset = %d", code)
+	}
+	// 新的执行从头上传时覆盖之前的日志
+	if code := upload("?offset=0", "again\n"); code != http.StatusOK || content() != "again\n" {
+		t.Errorf("upload from the beginning = %d %q", code, content())
+	}
+}
+
+// submitWithInputs 以multipart格式提交作业，files是上传的输入文件名及内容
+func submitWithInputs(svc *APIServer, spec *model.JobSpec, files map[string]string) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	b, _ := json.Marshal(spec)
+	mw.WriteField("job", string(b))
+	for name, content := range files {
+		fw, _ := mw.CreateFormFile(name, name)
+		fw.Write([]byte(content))
+	}
+	mw.Close()
+	req := httptest.NewRequest("POST", "/v1/jobs", &buf)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	svc.RestHandler().ServeHTTP(w, req)
+	return w
+}
+
+func TestJobInputsConflict(t *testing.T) {
+	svc := newTestServer(t, nil)
+	newSpec := func(source string) *model.JobSpec {
+		group := &model.TaskGroupSpec{Name: "g", Command: "run", TaskSpecs: []*model.TaskSpec{{Name: "t"}},
+			Inputs: []*model.InputFile{{Path: "data.txt", Source: source}}}
+		return &model.JobSpec{ID: "job", Name: "job", GroupSpecs: []*model.TaskGroupSpec{group}}
+	}
+	if w := submitWithInputs(svc, newSpec("data.txt"), map[string]string{"data.txt": "hello"}); w.Code != http.StatusCreated {
+		t.Fatalf("submit job with inputs: %d %s", w.Code, w.Body.String())
+	}
+	input := svc.requestGetJobInput("job", "data.txt")
+	if len(input) == 0 {
+		t.Fatal("input file of the job is not saved")
+	}
+
+	// 重复的作业编号，无论是否上传文件都不能删除已有作业的输入文件
+	if w := serve(svc.RestHandler(), "POST", "/v1/jobs", newSpec("missing.txt")); w.Code != http.StatusConflict {
+		t.Errorf("resubmit without uploads = %d, want 409", w.Code)
+	}
+	if w := submitWithInputs(svc, newSpec("missing.txt"), map[string]string{"other.txt": "x"}); w.Code != http.StatusConflict {
+		t.Errorf("resubmit with uploads = %d, want 409", w.Code)
+	}
+	if content, err := ioutil.ReadFile(input); err != nil || string(content) != "hello" {
+		t.Errorf("input file of the existing job is changed: %q %v", content, err)
+	}
+
+	// 引用了未上传文件的新作业被拒绝，并删除本次上传的文件
+	spec := newSpec("missing.txt")
+	spec.ID = "other"
+	if w := submitWithInputs(svc, spec, map[string]string{"data.txt": "x"}); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("submit with missing input = %d, want 422", w.Code)
+	}
+	if _, err := os.Stat(svc.jobInputPath("other")); !os.IsNotExist(err) {
+		t.Errorf("inputs of the rejected job are left: %v", err)
+	}
+}
+
+func TestRerunAfterTerminate(t *testing.T) {
+	svc := newTestServer(t, nil)
+	submitTestJob(t, svc, "job", 1)
+	reg := &message.RegisterNode{Name: "node1", Resources: model.ResourceSet{CPU: model.ResourceCPU{Cores: 8, Frequency: 24000, MinFreq: 3000}, Memory: 16384}}
+	if w := serve(svc.NodeHandler(), "POST", "/nodes", reg); w.Code != http.StatusOK {
+		t.Fatalf("register node: %d %s", w.Code, w.Body.String())
+	}
+	available := func() model.ResourceSet {
+		svc.nodes.RLock()
+		defer svc.nodes.RUnlock()
+		return *svc.nodes.GetNode("node1").Available.Clone()
+	}
+	taskOf := func(id string) model.Task {
+		svc.state.RLock()
+		defer svc.state.RUnlock()
+		return *svc.state.GetTask(id)
+	}
+	schedule := func() {
+		svc.setScheduleFlag()
+		svc.runScheduleCycle()
+		for _, id := range []string{"job.0.0", "job.1.0"} {
+			if task := taskOf(id); task.State != model.TaskScheduled || task.NodeName != "node1" {
+				t.Fatalf("task %s is not scheduled: %v on %q", id, task.State, task.NodeName)
+			}
+		}
+	}
+	idle := available()
+	h := svc.RestHandler()
+
+	// 节点还未取走调度消息时终止，Task直接终止并归还资源
+	schedule()
+	if w := serve(h, "PUT", "/v1/jobs/job/_terminate", nil); w.Code != http.StatusAccepted {
+		t.Fatalf("terminate job = %d", w.Code)
+	}
+	if task := taskOf("job.0.0"); task.State != model.TaskTerminated {
+		t.Errorf("undispatched task after terminate: %v", task.State)
+	}
+	if got := available(); !reflect.DeepEqual(got, idle) {
+		t.Errorf("available resources after terminate: %+v, want %+v", got, idle)
+	}
+	if w := serve(h, "PUT", "/v1/jobs/job/_rerun", nil); w.Code != http.StatusOK {
+		t.Fatalf("rerun undispatched job = %d: %s", w.Code, w.Body.String())
+	}
+
+	// 节点已经开始执行时终止，上报Task结束之前不能重新执行，否则节点的资源无法归还
+	schedule()
+	if w := serve(svc.NodeHandler(), "POST", "/heartbeat", &message.Heartbeat{Name: "node1"}); w.Code != http.StatusOK {
+		t.Fatalf("heartbeat = %d", w.Code)
+	}
+	if w := serve(h, "PUT", "/v1/jobs/job/_terminate", nil); w.Code != http.StatusAccepted {
+		t.Fatalf("terminate job = %d", w.Code)
+	}
+	if w := serve(h, "PUT", "/v1/jobs/job/_rerun", nil); w.Code != http.StatusConflict {
+		t.Fatalf("rerun with running tasks = %d, want 409", w.Code)
+	}
+	svc.requestUpdateTasks("node1", []*message.TaskReport{
+		{ID: "job.0.0", State: model.TaskTerminated},
+		{ID: "job.1.0", State: model.TaskTerminated},
+	})
+	if got := available(); !reflect.DeepEqual(got, idle) {
+		t.Errorf("available resources after tasks reported: %+v, want %+v", got, idle)
+	}
+	if w := serve(h, "PUT", "/v1/jobs/job/_rerun", nil); w.Code != http.StatusOK {
+		t.Fatalf("rerun after tasks terminated = %d: %s", w.Code, w.Body.String())
+	}
+	if task := taskOf("job.0.0"); task.State != model.TaskQueued || len(task.NodeName) != 0 {
+		t.Errorf("task after rerun: %v on %q", task.State, task.NodeName)
+	}
+}
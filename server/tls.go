@@ -0,0 +1,100 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+)
+
+// tlsReloader 保存一个HTTP服务端口的证书配置，可以在运行时重新加载证书
+type tlsReloader struct {
+	sync.RWMutex
+	name       string
+	certFile   string
+	keyFile    string
+	caFile     string
+	clientAuth tls.ClientAuthType
+	config     *tls.Config
+}
+
+// newTLSReloader 加载证书并创建tlsReloader。未指定证书时返回nil，表示使用明文HTTP。
+func newTLSReloader(name, certFile, keyFile, caFile string, clientAuth tls.ClientAuthType) (*tlsReloader, error) {
+	if len(certFile) == 0 {
+		return nil, nil
+	}
+	r := &tlsReloader{name: name, certFile: certFile, keyFile: keyFile, caFile: caFile, clientAuth: clientAuth}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// reload 重新读取证书、私钥和客户端CA文件。加载失败时继续使用原有的证书。
+func (r *tlsReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if len(r.caFile) > 0 {
+		pem, err := ioutil.ReadFile(r.caFile)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificate found in %s", r.caFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = r.clientAuth
+	}
+	r.Lock()
+	r.config = config
+	r.Unlock()
+	log.Printf("TLS certificate of %s service loaded from %s\n", r.name, r.certFile)
+	return nil
+}
+
+// serverConfig 返回HTTP服务使用的TLS配置，每次握手时使用最新加载的证书
+func (r *tlsReloader) serverConfig() *tls.Config {
+	current := func() *tls.Config {
+		r.RLock()
+		defer r.RUnlock()
+		return r.config
+	}
+	return &tls.Config{
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return &current().Certificates[0], nil
+		},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return current(), nil
+		},
+	}
+}
+
+// listenAndServe 启动HTTP服务，配置了证书时使用HTTPS
+func listenAndServe(srv *http.Server, r *tlsReloader) error {
+	if r == nil {
+		return srv.ListenAndServe()
+	}
+	srv.TLSConfig = r.serverConfig()
+	return srv.ListenAndServeTLS("", "")
+}
+
+// reloadCertificates 重新加载所有HTTP服务的证书
+func (svc *APIServer) reloadCertificates() {
+	for _, r := range []*tlsReloader{svc.restTLS, svc.nodeTLS} {
+		if r != nil {
+			if err := r.reload(); err != nil {
+				log.Printf("Unable to reload TLS certificate of %s service: %v\n", r.name, err)
+			}
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package util
 
 import (
+	"fmt"
 	"log"
 	"os"
 	"path"
@@ -39,6 +40,14 @@ func UniformPath(p string) string {
 	return p
 }
 
+// NativePath 将路径转换为当前平台的格式：Windows上统一使用反斜杠，其它平台保持原样
+func NativePath(p string) string {
+	if len(p) == 0 {
+		return p
+	}
+	return filepath.Clean(filepath.FromSlash(p))
+}
+
 // GetCurrentPath 用于获取程序的当前路径
 func GetCurrentPath() string {
 	dir, err := filepath.Abs(filepath.Dir(os.Args[0]))
@@ -47,3 +56,12 @@ func GetCurrentPath() string {
 	}
 	return strings.Replace(dir, "\\", "/", -1)
 }
+
+// SafeJoin 将相对路径连接到基础目录下。相对路径会先以根目录为基准规范化，因此结果不会超出基础目录。
+func SafeJoin(base string, rel string) (string, error) {
+	rel = strings.TrimPrefix(UniformPath("/"+rel), "/")
+	if len(rel) == 0 {
+		return "", fmt.Errorf("empty path")
+	}
+	return filepath.Join(base, filepath.FromSlash(rel)), nil
+}
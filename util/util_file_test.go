@@ -0,0 +1,68 @@
+package util
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNativePath(t *testing.T) {
+	cases := map[string]string{
+		"":               "",
+		"bin/app":        "bin/app",
+		"./bin//app":     "bin/app",
+		"bin/../app":     "app",
+		"/opt/app/":      "/opt/app",
+		"a/./b/./c":      "a/b/c",
+		"../outside/app": "../outside/app",
+	}
+	for p, want := range cases {
+		if s := NativePath(p); s != filepath.FromSlash(want) {
+			t.Errorf("NativePath(%q) = %q, want %q", p, s, filepath.FromSlash(want))
+		}
+	}
+}
+
+func TestUniformPath(t *testing.T) {
+	cases := map[string]string{
+		`a\b\c`:     "a/b/c",
+		"a/b/":      "a/b",
+		`C:\dir\..`: "C:",
+		"/a/../../": "",
+	}
+	for p, want := range cases {
+		if s := UniformPath(p); s != want {
+			t.Errorf("UniformPath(%q) = %q, want %q", p, s, want)
+		}
+	}
+}
+
+func TestSafeJoin(t *testing.T) {
+	base := filepath.FromSlash("/data/job")
+	cases := map[string]string{
+		"out.txt":          "out.txt",
+		"dir/out.txt":      "dir/out.txt",
+		"/abs/out.txt":     "abs/out.txt",
+		"../../etc/passwd": "etc/passwd",
+		`..\..\secret`:     "secret",
+		"a/../../b":        "b",
+	}
+	for rel, want := range cases {
+		s, err := SafeJoin(base, rel)
+		if err != nil {
+			t.Errorf("SafeJoin(%q) returns error: %v", rel, err)
+			continue
+		}
+		if s != filepath.Join(base, filepath.FromSlash(want)) {
+			t.Errorf("SafeJoin(%q) = %q, want %q", rel, s, filepath.Join(base, filepath.FromSlash(want)))
+		}
+		if !strings.HasPrefix(s, base+string(filepath.Separator)) {
+			t.Errorf("SafeJoin(%q) = %q escapes the base directory", rel, s)
+		}
+	}
+	for _, rel := range []string{"", "/", "..", "a/.."} {
+		if s, err := SafeJoin(base, rel); err == nil {
+			t.Errorf("SafeJoin(%q) = %q, want error", rel, s)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package util
 
 import (
+	"fmt"
 	"strconv"
 	"strings"
 )
@@ -67,3 +68,116 @@ func ParseValueAndUnit(str string) (val float64, unit string) {
 	v, _ := strconv.ParseFloat(str[:pos], 64)
 	return v, strings.ToLower(str[pos:])
 }
+
+// SplitPosixArgs 按照POSIX shell的引号和转义规则将命令行字符串拆分为参数列表
+func SplitPosixArgs(str string) ([]string, error) {
+	args := make([]string, 0, 8)
+	var sb strings.Builder
+	inArg := false
+	runes := []rune(str)
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			if inArg {
+				args = append(args, sb.String())
+				sb.Reset()
+				inArg = false
+			}
+		case c == '\\':
+			if i+1 == len(runes) {
+				return nil, fmt.Errorf("trailing backslash in \"%s\"", str)
+			}
+			inArg = true
+			i++
+			sb.WriteRune(runes[i])
+		case c == '\'':
+			inArg = true
+			end := i + 1
+			for end < len(runes) && runes[end] != '\'' {
+				end++
+			}
+			if end == len(runes) {
+				return nil, fmt.Errorf("unterminated single quote in \"%s\"", str)
+			}
+			sb.WriteString(string(runes[i+1 : end]))
+			i = end
+		case c == '"':
+			inArg = true
+			i++
+			for ; i < len(runes) && runes[i] != '"'; i++ {
+				// 双引号内的反斜杠仅转义特定字符
+				if runes[i] == '\\' && i+1 < len(runes) && strings.ContainsRune("\"\\$`\n", runes[i+1]) {
+					i++
+				}
+				sb.WriteRune(runes[i])
+			}
+			if i == len(runes) {
+				return nil, fmt.Errorf("unterminated double quote in \"%s\"", str)
+			}
+		default:
+			inArg = true
+			sb.WriteRune(c)
+		}
+	}
+	if inArg {
+		args = append(args, sb.String())
+	}
+	return args, nil
+}
+
+// SplitWindowsArgs 按照Windows命令行（CommandLineToArgvW）的规则将命令行字符串拆分为参数列表
+func SplitWindowsArgs(str string) []string {
+	args := make([]string, 0, 8)
+	var sb strings.Builder
+	inArg := false
+	inQuote := false
+	backslashes := 0
+	for _, c := range str {
+		switch {
+		case c == '\\':
+			inArg = true
+			backslashes++
+			continue
+		case c == '"':
+			inArg = true
+			// 2n个反斜杠加引号表示n个反斜杠并切换引号状态，2n+1个表示n个反斜杠加1个引号
+			sb.WriteString(strings.Repeat("\\", backslashes/2))
+			if backslashes%2 == 1 {
+				sb.WriteRune('"')
+			} else {
+				inQuote = !inQuote
+			}
+		case (c == ' ' || c == '\t') && !inQuote:
+			sb.WriteString(strings.Repeat("\\", backslashes))
+			if inArg {
+				args = append(args, sb.String())
+				sb.Reset()
+				inArg = false
+			}
+		default:
+			inArg = true
+			sb.WriteString(strings.Repeat("\\", backslashes))
+			sb.WriteRune(c)
+		}
+		backslashes = 0
+	}
+	sb.WriteString(strings.Repeat("\\", backslashes))
+	if inArg {
+		args = append(args, sb.String())
+	}
+	return args
+}
+
+// QuotePosixArg 在需要时使用单引号包围参数，使其可以安全地传给POSIX shell
+func QuotePosixArg(arg string) string {
+	if len(arg) == 0 {
+		return "''"
+	}
+	if strings.IndexFunc(arg, func(c rune) bool {
+		return !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("-_./=:,+@%", c))
+	}) == -1 {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
@@ -0,0 +1,115 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitPosixArgs(t *testing.T) {
+	cases := []struct {
+		line string
+		args []string
+	}{
+		{"", []string{}},
+		{"   ", []string{}},
+		{"a b  c", []string{"a", "b", "c"}},
+		{"\ta\nb\r\n", []string{"a", "b"}},
+		{`'a b' c`, []string{"a b", "c"}},
+		{`"a b" c`, []string{"a b", "c"}},
+		{`''`, []string{""}},
+		{`"" x`, []string{"", "x"}},
+		{`a'b c'd`, []string{"ab cd"}},
+		{`'a\b'`, []string{`a\b`}},
+		{`"a\"b"`, []string{`a"b`}},
+		{`"a\\b"`, []string{`a\b`}},
+		{`"a\$b"`, []string{`a$b`}},
+		{`"a\nb"`, []string{`a\nb`}},
+		{`a\ b`, []string{"a b"}},
+		{`\'x\'`, []string{"'x'"}},
+		{`--name="hello world" -v`, []string{"--name=hello world", "-v"}},
+		{`'it'\''s'`, []string{"it's"}},
+		{"中文 '参数 1'", []string{"中文", "参数 1"}},
+	}
+	for _, c := range cases {
+		args, err := SplitPosixArgs(c.line)
+		if err != nil {
+			t.Errorf("SplitPosixArgs(%q) returns error: %v", c.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(args, c.args) {
+			t.Errorf("SplitPosixArgs(%q) = %q, want %q", c.line, args, c.args)
+		}
+	}
+}
+
+func TestSplitPosixArgsErrors(t *testing.T) {
+	for _, line := range []string{`'abc`, `a 'b`, `"abc`, `"abc\"`, `"abc\`, `abc\`, `\`} {
+		if args, err := SplitPosixArgs(line); err == nil {
+			t.Errorf("SplitPosixArgs(%q) = %q, want error", line, args)
+		}
+	}
+}
+
+func TestQuotePosixArg(t *testing.T) {
+	cases := map[string]string{
+		"":          "''",
+		"abc":       "abc",
+		"a/b.c=1":   "a/b.c=1",
+		"a b":       "'a b'",
+		"it's":      `'it'\''s'`,
+		"$HOME":     "'$HOME'",
+		`a"b`:       `'a"b'`,
+		"line\nbrk": "'line\nbrk'",
+	}
+	for arg, quoted := range cases {
+		if s := QuotePosixArg(arg); s != quoted {
+			t.Errorf("QuotePosixArg(%q) = %q, want %q", arg, s, quoted)
+		}
+	}
+}
+
+func TestQuotePosixArgRoundTrip(t *testing.T) {
+	args := []string{"", "plain", "with space", "it's", `back\slash`, `"double"`, "$(rm -rf /)", "tab\there", "多字节"}
+	line := ""
+	for i, arg := range args {
+		if i > 0 {
+			line += " "
+		}
+		line += QuotePosixArg(arg)
+	}
+	split, err := SplitPosixArgs(line)
+	if err != nil {
+		t.Fatalf("SplitPosixArgs(%q) returns error: %v", line, err)
+	}
+	if !reflect.DeepEqual(split, args) {
+		t.Errorf("SplitPosixArgs(%q) = %q, want %q", line, split, args)
+	}
+}
+
+func TestSplitWindowsArgs(t *testing.T) {
+	cases := []struct {
+		line string
+		args []string
+	}{
+		{"", []string{}},
+		{"a b  c", []string{"a", "b", "c"}},
+		{`"a b" c`, []string{"a b", "c"}},
+		{`""`, []string{""}},
+		{`a"b c"d`, []string{"ab cd"}},
+		{`C:\dir\file.txt`, []string{`C:\dir\file.txt`}},
+		{`"C:\Program Files\app"`, []string{`C:\Program Files\app`}},
+		{`a\\b`, []string{`a\\b`}},
+		{`a\"b`, []string{`a"b`}},
+		{`a\\"b c"`, []string{`a\b c`}},
+		{`a\\\"b`, []string{`a\"b`}},
+		{`"dir\\" x`, []string{`dir\`, "x"}},
+		{`trailing\`, []string{`trailing\`}},
+		{`'single' quotes`, []string{"'single'", "quotes"}},
+		{`"unterminated arg`, []string{"unterminated arg"}},
+	}
+	for _, c := range cases {
+		if args := SplitWindowsArgs(c.line); !reflect.DeepEqual(args, c.args) {
+			t.Errorf("SplitWindowsArgs(%q) = %q, want %q", c.line, args, c.args)
+		}
+	}
+}